@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/go-sql-driver/mysql v1.7.1
 	github.com/pingcap/tiup v1.12.3
+	github.com/spf13/cobra v1.6.1
 )
 
 require (
@@ -26,7 +27,6 @@ require (
 	github.com/r3labs/diff/v2 v2.15.1 // indirect
 	github.com/rogpeppe/go-internal v1.10.0 // indirect
 	github.com/sergi/go-diff v1.3.1 // indirect
-	github.com/spf13/cobra v1.6.1 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/vmihailenco/msgpack v4.0.4+incompatible // indirect
 	go.uber.org/atomic v1.10.0 // indirect
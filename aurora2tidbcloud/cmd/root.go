@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -28,6 +29,7 @@ func init() {
     4. Call API to export parquet format data from snapshot to S3
     5. Call TiDB Cloud openapi to create the objects from dumpling ddl
     6. Call TiDB Cloud openapi to import parquet data from S3 to database
+The output of every completed step is kept in the state file, a rerun resumes from the failed step.
 `,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			return nil
@@ -50,7 +52,7 @@ func makeCmdMain() {
 		Use:   "run",
 		Short: "Run the script to migrate the data from Aurora to TiDB Cloud",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := app.Run(gOpt); err != nil {
+			if err := withClients(func(clients *app.Clients) error { return app.Run(gOpt, clients) }); err != nil {
 				fmt.Println(color.RedString("Error: %v", err))
 			}
 		},
@@ -59,6 +61,9 @@ func makeCmdMain() {
 	cmdMain.PersistentFlags().Int32Var(&gOpt.Opt02, "int32-opt", 0, "The int32 option")
 	cmdMain.PersistentFlags().BoolVar(&gOpt.Opt03, "bool-opt", true, "The bool option")
 	cmdMain.PersistentFlags().StringVar(&gOpt.ConfigFile, "config-file", "configs/config.toml", "The config file for the app")
+	cmdMain.PersistentFlags().StringVar(&gOpt.StateFile, "state-file", "aurora2tidbcloud-state.json", "The state file keeping the output of the completed steps")
+	cmdMain.PersistentFlags().StringVar(&gOpt.FromStep, "from-step", "", fmt.Sprintf("Run from the step even if it is completed, one of: %s", strings.Join(app.MigrationStepNames(), ", ")))
+	cmdMain.PersistentFlags().StringVar(&gOpt.OnlyStep, "only-step", "", "Run the step alone even if it is completed")
 
 	rootCmd.AddCommand(cmdMain)
 
@@ -69,21 +74,21 @@ func makeCmdMain() {
 // S3 bucket: from dumpling
 // Export role:
 // Import role:
+// Snapshot: tagged with binlog File/Position
+// Export task:
+// Import task: TiDB Cloud import from the S3 bucket
 func makeCmdList() {
 	cmdList := &cobra.Command{
 		Use:   "list",
 		Short: "List all the aws created resources",
 		Run: func(cmd *cobra.Command, args []string) {
-			log.Printf("Listing all the aws resources")
-			// if err := app.Run(gOpt); err != nil {
-			// 	fmt.Println(color.RedString("Error: %v", err))
-			// }
+			if err := withClients(func(clients *app.Clients) error { return app.List(gOpt, clients) }); err != nil {
+				fmt.Println(color.RedString("Error: %v", err))
+			}
 		},
 	}
-	// cmdMain.PersistentFlags().StringVar(&gOpt.Opt01, "string-opt", "", "The string option")
-	// cmdMain.PersistentFlags().Int32Var(&gOpt.Opt02, "int32-opt", 0, "The int32 option")
-	// cmdMain.PersistentFlags().BoolVar(&gOpt.Opt03, "bool-opt", true, "The bool option")
-	// cmdMain.PersistentFlags().StringVar(&gOpt.ConfigFile, "config-file", "configs/config.toml", "The config file for the app")
+	cmdList.PersistentFlags().StringVar(&gOpt.ConfigFile, "config-file", "configs/config.toml", "The config file for the app")
+	cmdList.PersistentFlags().StringVar(&gOpt.Output, "output", "table", "The output format: table or json")
 
 	rootCmd.AddCommand(cmdList)
 }
@@ -92,21 +97,31 @@ func makeCmdDelete() {
 	cmdDelete := &cobra.Command{
 		Use:   "clean",
 		Short: "Clean all the aws resources",
+		Long: fmt.Sprintf(`Clean the aws resources created by the tool in the order: %s.
+Only the resources tagged with %s=%s are deleted. A failed deletion does not stop the clean,
+the summary lists the outcome of every resource.`, strings.Join(app.CleanResourceTypes, ", "), app.OWNER_TAG_KEY, app.MODULE_NAME),
 		Run: func(cmd *cobra.Command, args []string) {
-			log.Printf("Cleaning all the aws resources")
-			if err := app.Clean(gOpt); err != nil {
+			if err := withClients(func(clients *app.Clients) error { return app.Clean(gOpt, clients) }); err != nil {
 				fmt.Println(color.RedString("Error: %v", err))
 			}
 		},
 	}
-	// cmdMain.PersistentFlags().StringVar(&gOpt.Opt01, "string-opt", "", "The string option")
-	// cmdMain.PersistentFlags().Int32Var(&gOpt.Opt02, "int32-opt", 0, "The int32 option")
-	// cmdMain.PersistentFlags().BoolVar(&gOpt.Opt03, "bool-opt", true, "The bool option")
-	// cmdMain.PersistentFlags().StringVar(&gOpt.ConfigFile, "config-file", "configs/config.toml", "The config file for the app")
+	cmdDelete.PersistentFlags().StringVar(&gOpt.ConfigFile, "config-file", "configs/config.toml", "The config file for the app, used by snapshot and export-task")
+	cmdDelete.PersistentFlags().BoolVar(&gOpt.DryRun, "dry-run", false, "List the resources to delete without deleting them")
+	cmdDelete.PersistentFlags().StringSliceVar(&gOpt.CleanResources, "resources", app.DefaultCleanResources, fmt.Sprintf("The resource types to clean: %s or %s", strings.Join(app.CleanResourceTypes, ", "), app.CLEAN_ALL))
 
 	rootCmd.AddCommand(cmdDelete)
 }
 
+// withClients calls the command with the clients of the default aws config
+func withClients(command func(clients *app.Clients) error) error {
+	clients, err := app.NewClients()
+	if err != nil {
+		return err
+	}
+	return command(clients)
+}
+
 func Execute() {
 
 	if err := rootCmd.Execute(); err != nil {
@@ -2,64 +2,353 @@ package app
 
 import (
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
 	"github.com/luyomo/cheatsheet/aurora2tidbcloud/internal/app/configs"
 	cfapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/cloudformation"
-	iamapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/iam"
 	s3apilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/s3"
-	"log"
 )
 
-func Clean(gOpt configs.Options) error {
-	log.Printf("Starting to clean all the aws resources \n")
+// The resource types selected by clean --resources
+const (
+	CLEAN_EXPORT_TASK = "export-task" // Cancel the running export tasks and delete their data
+	CLEAN_ROLE        = "role"        // Import and export roles with their policies
+	CLEAN_BUCKET      = "bucket"      // Empty the dump bucket
+	CLEAN_SNAPSHOT    = "snapshot"    // Cluster snapshots tagged with the binlog position
+	CLEAN_STACK       = "stack"       // Dumpling and binlog lambda stacks
+	CLEAN_ALL         = "all"
+)
+
+// CleanResourceTypes are the resource types in the deletion order. The bucket is emptied
+// before the stack owning it is destroyed.
+var CleanResourceTypes = []string{CLEAN_EXPORT_TASK, CLEAN_ROLE, CLEAN_BUCKET, CLEAN_SNAPSHOT, CLEAN_STACK}
+
+// DefaultCleanResources are the resource types cleaned without --resources. The snapshots and
+// export tasks are kept unless they are selected.
+var DefaultCleanResources = []string{CLEAN_ROLE, CLEAN_BUCKET, CLEAN_STACK}
+
+// The status of the clean of one resource
+const (
+	CLEAN_DELETED   = "DELETED"
+	CLEAN_DRY_RUN   = "WOULD_DELETE"
+	CLEAN_NOT_OWNED = "SKIPPED_NOT_OWNED"
+	CLEAN_NOT_FOUND = "NOT_FOUND"
+	CLEAN_NOTHING   = "NOTHING_TO_DO"
+	CLEAN_FAILED    = "FAILED"
+)
+
+// CleanResult is the outcome of the clean of one resource
+type CleanResult struct {
+	Type   string
+	Name   string
+	Status string
+	Detail string
+}
+
+// cleaner runs the deletions, or only reports them in dry run, and keeps going after a failure
+type cleaner struct {
+	dryRun  bool
+	results []CleanResult
+}
 
-	// 01. Clean role of import
-	iamapi, err := iamapilib.NewIAMAPI(nil)
+// delete deletes the resource if it carries the ownership tags
+func (c *cleaner) delete(resourceType, name string, tags map[string]string, detail string, deleteFunc func() error) {
+	result := CleanResult{Type: resourceType, Name: name, Detail: detail}
+	switch {
+	case !isOwned(tags):
+		result.Status = CLEAN_NOT_OWNED
+		result.Detail = fmt.Sprintf("no tag %s=%s", OWNER_TAG_KEY, MODULE_NAME)
+	case c.dryRun:
+		result.Status = CLEAN_DRY_RUN
+	default:
+		log.Printf("Deleting %s %s \n", resourceType, name)
+		if err := deleteFunc(); err != nil {
+			result.Status = CLEAN_FAILED
+			result.Detail = err.Error()
+		} else {
+			result.Status = CLEAN_DELETED
+		}
+	}
+	c.results = append(c.results, result)
+}
+
+// report records a resource which is not deleted
+func (c *cleaner) report(resourceType, name, status, detail string) {
+	c.results = append(c.results, CleanResult{Type: resourceType, Name: name, Status: status, Detail: detail})
+}
+
+// failed records a resource type which could not be listed
+func (c *cleaner) failed(resourceType string, err error) {
+	c.report(resourceType, "-", CLEAN_FAILED, err.Error())
+}
+
+// ParseCleanResources validates the resource types of --resources, all for every type
+func ParseCleanResources(resources []string) (map[string]bool, error) {
+	selected := map[string]bool{}
+	for _, resource := range resources {
+		resource = strings.TrimSpace(resource)
+		switch {
+		case resource == CLEAN_ALL:
+			for _, resourceType := range CleanResourceTypes {
+				selected[resourceType] = true
+			}
+		case containsString(CleanResourceTypes, resource):
+			selected[resource] = true
+		default:
+			return nil, fmt.Errorf("unknown resource type %s, use %s or %s", resource, strings.Join(CleanResourceTypes, ", "), CLEAN_ALL)
+		}
+	}
+	return selected, nil
+}
+
+// Clean deletes the selected aws resources created by the tool in the order of
+// CleanResourceTypes. A resource without the ownership tag OWNER_TAG_KEY=MODULE_NAME is never
+// deleted. A failed deletion does not stop the clean, the summary lists all the resources.
+func Clean(gOpt configs.Options, clients *Clients) error {
+	resources := gOpt.CleanResources
+	if len(resources) == 0 {
+		resources = DefaultCleanResources
+	}
+	selected, err := ParseCleanResources(resources)
 	if err != nil {
 		return err
 	}
 
-	if err := iamapi.DeleteRole(IMPORT_ROLE, aws.String(MODULE_NAME)); err != nil {
-		return err
+	// The snapshots belong to the Aurora cluster of the config
+	var config *configs.Config
+	if selected[CLEAN_SNAPSHOT] || selected[CLEAN_EXPORT_TASK] {
+		if config, err = configs.ReadConfigFile(gOpt.ConfigFile); err != nil {
+			return err
+		}
 	}
 
-	// 02. Clean role of export
-	if err := iamapi.DeleteRole(EXPORT_ROLE, aws.String(MODULE_NAME)); err != nil {
-		return err
+	if gOpt.DryRun {
+		log.Printf("Dry run, listing the aws resources to clean \n")
+	} else {
+		log.Printf("Starting to clean the aws resources: %s \n", strings.Join(resources, ","))
+	}
+	c := &cleaner{dryRun: gOpt.DryRun}
+
+	cfapi := clients.cfAPI(nil)
+	s3api := clients.s3API(nil)
+
+	for _, resourceType := range CleanResourceTypes {
+		if !selected[resourceType] {
+			continue
+		}
+		switch resourceType {
+		case CLEAN_EXPORT_TASK:
+			cleanExportTasks(c, config, s3api, clients)
+		case CLEAN_ROLE:
+			cleanRoles(c, clients)
+		case CLEAN_BUCKET:
+			cleanBucket(c, cfapi, s3api)
+		case CLEAN_SNAPSHOT:
+			cleanSnapshots(c, config, clients)
+		case CLEAN_STACK:
+			cleanStacks(c, cfapi, selected[CLEAN_BUCKET])
+		}
 	}
 
-	// 04. Empty the bucket
+	printCleanResults(os.Stdout, c.results)
+	failed := 0
+	for _, result := range c.results {
+		if result.Status == CLEAN_FAILED {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to clean %d resource(s)", failed)
+	}
+	return nil
+}
 
-	cfapi, err := cfapilib.NewCFAPI(nil)
+// cleanExportTasks cancels the running export tasks of the tagged snapshots and deletes the
+// data they exported
+func cleanExportTasks(c *cleaner, config *configs.Config, s3api *s3apilib.S3API, clients *Clients) {
+	rdsapi := clients.rdsAPI(nil)
+	snapshots, err := rdsapi.ListSnapshotsByBinlog(config.SourceDB.AuroraClusterName)
 	if err != nil {
-		return err
+		c.failed(CLEAN_EXPORT_TASK, err)
+		return
+	}
+	// The export tasks have no tag, they are owned through their snapshot
+	snapshotTags := map[string]map[string]string{}
+	for _, snapshot := range snapshots {
+		snapshotTags[aws.ToString(snapshot.DBClusterSnapshotArn)] = rdsTags(snapshot.TagList)
+	}
+
+	exportTasks, err := rdsapi.ListExportTasks(MODULE_NAME)
+	if err != nil {
+		c.failed(CLEAN_EXPORT_TASK, err)
+		return
+	}
+	for _, exportTask := range exportTasks {
+		exportTask := exportTask
+		taskId := aws.ToString(exportTask.ExportTaskIdentifier)
+		status := aws.ToString(exportTask.Status)
+		s3Prefix := strings.Trim(fmt.Sprintf("%s/%s/", strings.Trim(aws.ToString(exportTask.S3Prefix), "/"), taskId), "/") + "/"
+		detail := fmt.Sprintf("%s, s3://%s/%s", status, aws.ToString(exportTask.S3Bucket), s3Prefix)
+		c.delete(CLEAN_EXPORT_TASK, taskId, snapshotTags[aws.ToString(exportTask.SourceArn)], detail, func() error {
+			if status == "STARTING" || status == "IN_PROGRESS" {
+				if err := rdsapi.CancelExportTask(taskId); err != nil {
+					return err
+				}
+			}
+			return s3api.DeleteObject(aws.ToString(exportTask.S3Bucket), s3Prefix)
+		})
 	}
+}
+
+func cleanRoles(c *cleaner, clients *Clients) {
+	iamapi := clients.iamAPI(nil)
+
+	for _, roleName := range []string{IMPORT_ROLE, EXPORT_ROLE} {
+		roleName := roleName
+		roles, err := iamapi.GetRole(MODULE_NAME, roleName)
+		if err != nil {
+			c.report(CLEAN_ROLE, roleName, CLEAN_FAILED, err.Error())
+			continue
+		}
+		if roles == nil {
+			c.report(CLEAN_ROLE, roleName, CLEAN_NOT_FOUND, "")
+			continue
+		}
+		tags, err := iamapi.GetRoleTags(roleName)
+		if err != nil {
+			c.report(CLEAN_ROLE, roleName, CLEAN_FAILED, err.Error())
+			continue
+		}
+		c.delete(CLEAN_ROLE, roleName, tags, aws.ToString((*roles)[0].Arn), func() error {
+			return iamapi.DeleteRole(roleName, aws.String(MODULE_NAME))
+		})
+	}
+}
+
+// cleanBucket empties the bucket of the dumpling stack, the stack deletes the bucket
+func cleanBucket(c *cleaner, cfapi *cfapilib.CloudformationAPI, s3api *s3apilib.S3API) {
 	s3arn, err := cfapi.GetStackResource(STACKNAME_DUMPLING, "S3Bucket")
 	if err != nil {
-		return err
+		c.failed(CLEAN_BUCKET, err)
+		return
+	}
+	if s3arn == nil {
+		c.report(CLEAN_BUCKET, "-", CLEAN_NOT_FOUND, fmt.Sprintf("no stack %s", STACKNAME_DUMPLING))
+		return
+	}
+	fmt.Printf("The s3 bucket are : <%#v> \n\n\n", *s3arn)
+
+	tags, err := s3api.GetBucketTags(*s3arn)
+	if err != nil {
+		c.report(CLEAN_BUCKET, *s3arn, CLEAN_FAILED, err.Error())
+		return
+	}
+	objects, err := s3api.ListObjects(*s3arn, "")
+	if err != nil {
+		c.report(CLEAN_BUCKET, *s3arn, CLEAN_FAILED, err.Error())
+		return
+	}
+	if len(objects) == 0 {
+		c.report(CLEAN_BUCKET, *s3arn, CLEAN_NOTHING, "empty")
+		return
+	}
+	c.delete(CLEAN_BUCKET, *s3arn, tags, fmt.Sprintf("%d object(s)", len(objects)), func() error {
+		return s3api.DeleteObject(*s3arn, "")
+	})
+}
+
+func cleanSnapshots(c *cleaner, config *configs.Config, clients *Clients) {
+	rdsapi := clients.rdsAPI(nil)
+	snapshots, err := rdsapi.ListSnapshotsByBinlog(config.SourceDB.AuroraClusterName)
+	if err != nil {
+		c.failed(CLEAN_SNAPSHOT, err)
+		return
 	}
-	if s3arn != nil {
-		fmt.Printf("The s3 bucket are : <%#v> \n\n\n", *s3arn)
+	for _, snapshot := range snapshots {
+		snapshotId := aws.ToString(snapshot.DBClusterSnapshotIdentifier)
+		tags := rdsTags(snapshot.TagList)
+		c.delete(CLEAN_SNAPSHOT, snapshotId, tags, fmt.Sprintf("binlog %s:%s, %d GiB", tags["File"], tags["Position"], snapshot.AllocatedStorage), func() error {
+			return rdsapi.DeleteSnapshot(snapshotId)
+		})
 	}
+}
 
-	if s3arn != nil {
-		s3api, err := s3apilib.NewS3API(nil)
+// cleanStacks destroys the dumpling stack then the binlog stack. The dumpling stack is kept if
+// its bucket was selected and could not be emptied, the stack deletion would fail on it.
+func cleanStacks(c *cleaner, cfapi *cfapilib.CloudformationAPI, bucketSelected bool) {
+	for _, stackName := range []string{STACKNAME_DUMPLING, STACKNAME_BINLOG} {
+		stackName := stackName
+		tags, err := cfapi.GetStackTags(stackName)
 		if err != nil {
-			return err
+			c.report(CLEAN_STACK, stackName, CLEAN_FAILED, err.Error())
+			continue
 		}
+		if tags == nil {
+			c.report(CLEAN_STACK, stackName, CLEAN_NOT_FOUND, "")
+			continue
+		}
+		if stackName == STACKNAME_DUMPLING && bucketSelected && !c.bucketCleaned() {
+			c.report(CLEAN_STACK, stackName, CLEAN_FAILED, "the bucket of the stack was not emptied")
+			continue
+		}
+		c.delete(CLEAN_STACK, stackName, tags, "", func() error {
+			return cfapi.DestroyStack(stackName)
+		})
+	}
+}
 
-		if err := s3api.DeleteObject(*s3arn, ""); err != nil {
-			return err
+// bucketCleaned reports whether the bucket is empty or was emptied, in dry run whether it
+// would be
+func (c *cleaner) bucketCleaned() bool {
+	for _, result := range c.results {
+		if result.Type == CLEAN_BUCKET {
+			return result.Status == CLEAN_DELETED || result.Status == CLEAN_DRY_RUN || result.Status == CLEAN_NOTHING || result.Status == CLEAN_NOT_FOUND
 		}
 	}
-	// 05. Remove cloudformation for binlog
-	if err := cfapi.DestroyStack(STACKNAME_DUMPLING); err != nil {
-		return err
+	return true
+}
+
+func printCleanResults(w io.Writer, results []CleanResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tSTATUS\tDETAIL")
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Type, result.Name, result.Status, valueOrDash(result.Detail))
 	}
-	// 06. Remove cloudformation for dumpling
-	if err := cfapi.DestroyStack(STACKNAME_BINLOG); err != nil {
-		return err
+	tw.Flush()
+
+	summary := []string{}
+	for _, status := range []string{CLEAN_DELETED, CLEAN_DRY_RUN, CLEAN_NOT_OWNED, CLEAN_NOT_FOUND, CLEAN_NOTHING, CLEAN_FAILED} {
+		if counts[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+		}
 	}
+	if len(summary) == 0 {
+		summary = append(summary, "nothing to clean")
+	}
+	fmt.Fprintf(w, "Summary: %s \n", strings.Join(summary, ", "))
+}
 
-	return nil
+func rdsTags(tagList []rdstypes.Tag) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range tagList {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags
+}
+
+func containsString(values []string, value string) bool {
+	for _, theValue := range values {
+		if theValue == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func Test_cleanDryRun(t *testing.T) {
+	cloud := newTestCloud(t)
+	state := cloud.migrate(t)
+
+	options := cloud.options
+	options.DryRun = true
+	options.CleanResources = []string{CLEAN_ALL}
+	if err := Clean(options, cloud.clients()); err != nil {
+		t.Fatalf("Clean() error = %v", err)
+	}
+	if len(cloud.StackNames()) != 2 || len(cloud.RoleNames()) != 2 || len(cloud.SnapshotIds()) != 1 {
+		t.Errorf("dry run deleted stacks %v, roles %v or snapshots %v", cloud.StackNames(), cloud.RoleNames(), cloud.SnapshotIds())
+	}
+	if got := cloud.Objects(state.S3Bucket, ""); len(got) != 4 {
+		t.Errorf("dry run deleted objects, left %v", got)
+	}
+	for _, operation := range []string{"DeleteStack", "DeleteRole", "DeleteObjects", "DeleteDBClusterSnapshot"} {
+		if cloud.Calls(operation) != 0 {
+			t.Errorf("dry run called %s", operation)
+		}
+	}
+}
+
+func Test_cleanAll(t *testing.T) {
+	cloud := newTestCloud(t)
+	state := cloud.migrate(t)
+
+	options := cloud.options
+	options.CleanResources = []string{CLEAN_ALL}
+	if err := Clean(options, cloud.clients()); err != nil {
+		t.Fatalf("Clean() error = %v", err)
+	}
+	if len(cloud.StackNames()) != 0 || len(cloud.RoleNames()) != 0 || len(cloud.PolicyNames()) != 0 || len(cloud.SnapshotIds()) != 0 {
+		t.Errorf("left stacks %v, roles %v, policies %v, snapshots %v", cloud.StackNames(), cloud.RoleNames(), cloud.PolicyNames(), cloud.SnapshotIds())
+	}
+	// The stack deleted the bucket once it was emptied
+	if got := cloud.Objects(state.S3Bucket, ""); got != nil {
+		t.Errorf("bucket %s left with %v", state.S3Bucket, got)
+	}
+
+	// A second clean finds nothing
+	if err := Clean(options, cloud.clients()); err != nil {
+		t.Errorf("second Clean() error = %v", err)
+	}
+}
+
+func Test_cleanSkipsNotOwned(t *testing.T) {
+	cloud := newTestCloud(t)
+
+	// A role of the same name created by hand has no ownership tag
+	if _, err := cloud.IAM().CreateRole(context.TODO(), &iam.CreateRoleInput{
+		RoleName: aws.String(IMPORT_ROLE),
+		Path:     aws.String("/" + MODULE_NAME + "/"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cleaner{}
+	cleanRoles(c, cloud.clients())
+	statuses := map[string]string{}
+	for _, result := range c.results {
+		statuses[result.Name] = result.Status
+	}
+	if statuses[IMPORT_ROLE] != CLEAN_NOT_OWNED || statuses[EXPORT_ROLE] != CLEAN_NOT_FOUND {
+		t.Errorf("statuses = %v", statuses)
+	}
+	if got := cloud.RoleNames(); len(got) != 1 || cloud.Calls("DeleteRole") != 0 {
+		t.Errorf("roles = %v after %d deletion(s), want the role kept", got, cloud.Calls("DeleteRole"))
+	}
+}
+
+func Test_cleanContinuesAfterFailure(t *testing.T) {
+	cloud := newTestCloud(t)
+	cloud.migrate(t)
+
+	cloud.Fail("DeleteRole", errors.New("AccessDenied: not authorized to perform iam:DeleteRole"))
+	err := Clean(cloud.options, cloud.clients())
+	if err == nil || !strings.Contains(err.Error(), "failed to clean 1 resource(s)") {
+		t.Fatalf("Clean() error = %v, want 1 failure", err)
+	}
+	// The other role, the bucket and the stacks are still cleaned
+	if got := cloud.RoleNames(); len(got) != 1 {
+		t.Errorf("roles = %v, want the role failing to delete", got)
+	}
+	if got := cloud.StackNames(); len(got) != 0 {
+		t.Errorf("stacks = %v, want none", got)
+	}
+	// The snapshots are kept by default
+	if got := cloud.SnapshotIds(); len(got) != 1 {
+		t.Errorf("snapshots = %v, want the snapshot kept", got)
+	}
+}
+
+func Test_parseCleanResources(t *testing.T) {
+	selected, err := ParseCleanResources([]string{CLEAN_ROLE, " " + CLEAN_SNAPSHOT})
+	if err != nil || len(selected) != 2 || !selected[CLEAN_SNAPSHOT] {
+		t.Errorf("ParseCleanResources() = %v, %v", selected, err)
+	}
+	if selected, _ := ParseCleanResources([]string{CLEAN_ALL}); len(selected) != len(CleanResourceTypes) {
+		t.Errorf("ParseCleanResources(all) = %v", selected)
+	}
+	if _, err := ParseCleanResources([]string{"vpc"}); err == nil {
+		t.Errorf("ParseCleanResources() of unknown type want error")
+	}
+}
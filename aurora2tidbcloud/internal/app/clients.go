@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	cfapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/cloudformation"
+	iamapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/iam"
+	kmsapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/kms"
+	rdsapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/rds"
+	s3apilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/s3"
+	"github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/tidbcloud"
+)
+
+// LambdaClient is the part of the lambda client invoking the functions of the stacks
+type LambdaClient interface {
+	Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error)
+}
+
+// TiDBCloudAPI is the part of tidbcloud.TiDBCloudAPI used by run and list
+type TiDBCloudAPI interface {
+	GetImportTaskRoleInfo() (*string, *string, error)
+	StartImportTask(tidbName, s3Dir, importRoleArn, dataSourceType *string) (*string, error)
+	WaitImportTask(importTaskId *string) error
+	ListImportTasks() ([]tidbcloud.ImportTask, error)
+}
+
+// Clients are the aws and TiDB Cloud clients used by run, list and clean. The tests replace
+// them by the in-memory fake of pkg/aws/fake.
+type Clients struct {
+	CloudFormation cfapilib.CloudformationClient
+	IAM            iamapilib.IAMClient
+	KMS            kmsapilib.KmsClient
+	RDS            rdsapilib.RdsClient
+	S3             s3apilib.S3Client
+	Lambda         LambdaClient
+	// TiDBCloud connects to the cluster of the project
+	TiDBCloud func(projectId, clusterName string) (TiDBCloudAPI, error)
+}
+
+// NewClients returns the clients of the default aws config and of the TiDB Cloud api keys
+func NewClients() (*Clients, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Clients{
+		CloudFormation: cloudformation.NewFromConfig(cfg),
+		IAM:            iam.NewFromConfig(cfg),
+		KMS:            kms.NewFromConfig(cfg),
+		RDS:            rds.NewFromConfig(cfg),
+		S3:             s3.NewFromConfig(cfg),
+		Lambda:         lambda.NewFromConfig(cfg),
+		TiDBCloud: func(projectId, clusterName string) (TiDBCloudAPI, error) {
+			tidbcloudApi, err := tidbcloud.NewTiDBCloudAPI(projectId, clusterName, nil)
+			if err != nil {
+				return nil, err
+			}
+			return tidbcloudApi, nil
+		},
+	}, nil
+}
+
+func (c *Clients) cfAPI(mapArgs *map[string]string) *cfapilib.CloudformationAPI {
+	return cfapilib.NewCFAPIWithClient(c.CloudFormation, mapArgs)
+}
+
+func (c *Clients) iamAPI(mapArgs *map[string]string) *iamapilib.IAMAPI {
+	return iamapilib.NewIAMAPIWithClient(c.IAM, mapArgs)
+}
+
+func (c *Clients) kmsAPI(mapArgs *map[string]string) *kmsapilib.KmsAPI {
+	return kmsapilib.NewKmsAPIWithClient(c.KMS, mapArgs)
+}
+
+func (c *Clients) rdsAPI(mapArgs *map[string]string) *rdsapilib.RdsAPI {
+	return rdsapilib.NewRdsAPIWithClient(c.RDS, mapArgs)
+}
+
+func (c *Clients) s3API(mapArgs *map[string]string) *s3apilib.S3API {
+	return s3apilib.NewS3APIWithClient(c.S3, mapArgs)
+}
@@ -6,4 +6,13 @@ type Options struct {
     Opt03 bool
 
     ConfigFile string
+
+    StateFile string // The file keeping the output of the completed steps
+    FromStep  string // Run the step and all the following ones
+    OnlyStep  string // Run the step alone
+
+    Output string // The output format of list: table or json
+
+    DryRun         bool     // Report the resources clean would delete without deleting them
+    CleanResources []string // The resource types to clean
 }
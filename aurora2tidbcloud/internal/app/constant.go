@@ -21,4 +21,15 @@ const (
 	STACKNAME_DUMPLING     = "aurora2tidbcloud-ddl-dumpling" // Stack name for lambda to dump ddl to s3 by dumpling
 	LAMBDA_FUNCTION_DDL    = "https://jay-data.s3.amazonaws.com/lambda/cloudformation/mysqldump-to-s3.yaml"
 	LAMBDA_FUNCTION_BINLOG = "https://jay-data.s3.amazonaws.com/lambda/cloudformation/mysqlBinglogInfo.yaml"
+	OWNER_TAG_KEY          = "Cluster" // Tag set to MODULE_NAME on the aws resources created by the tool, clean deletes only the tagged ones
 )
+
+// ownerArgs makes the aws apis tag the created resources with OWNER_TAG_KEY=MODULE_NAME
+func ownerArgs() *map[string]string {
+	return &map[string]string{"clusterType": MODULE_NAME}
+}
+
+// isOwned reports whether the resource tags mark it as created by the tool
+func isOwned(tags map[string]string) bool {
+	return tags[OWNER_TAG_KEY] == MODULE_NAME
+}
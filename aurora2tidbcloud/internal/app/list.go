@@ -0,0 +1,379 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/luyomo/cheatsheet/aurora2tidbcloud/internal/app/configs"
+	cfapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/cloudformation"
+)
+
+// The resource types reported by list
+const (
+	RESOURCE_STACK       = "cloudformation-stack"
+	RESOURCE_ROLE        = "iam-role"
+	RESOURCE_POLICY      = "iam-policy"
+	RESOURCE_BUCKET      = "s3-bucket"
+	RESOURCE_S3_PREFIX   = "s3-prefix"
+	RESOURCE_SNAPSHOT    = "rds-snapshot"
+	RESOURCE_EXPORT_TASK = "rds-export-task"
+	RESOURCE_IMPORT_TASK = "tidbcloud-import-task"
+)
+
+// STATUS_LIST_FAILED is the status of the entry reporting a resource type that could not be listed
+const STATUS_LIST_FAILED = "LIST_FAILED"
+
+// Resource is one resource created by the tool
+type Resource struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// CostDriver is what the resource is billed on, empty if free
+	CostDriver string `json:"cost_driver,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// Inventory is the output of list
+type Inventory struct {
+	Resources []Resource `json:"resources"`
+}
+
+// List reports all the resources created by the tool: the stacks, the roles and policies under
+// the MODULE_NAME path, the bucket, the snapshots, the export tasks and the import tasks.
+// A resource type which can not be listed is reported as LIST_FAILED, the others are still listed.
+func List(gOpt configs.Options, clients *Clients) error {
+	config, err := configs.ReadConfigFile(gOpt.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	inventory := Inventory{Resources: []Resource{}}
+	add := func(resourceType string, resources []Resource, err error) {
+		if err != nil {
+			inventory.Resources = append(inventory.Resources, Resource{Type: resourceType, Name: "-", Status: STATUS_LIST_FAILED, Detail: err.Error()})
+			return
+		}
+		inventory.Resources = append(inventory.Resources, resources...)
+	}
+
+	/* ****************************************************************** */
+	// 001. Cloudformation stacks and S3 bucket
+	/* ****************************************************************** */
+	cfapi := clients.cfAPI(nil)
+	stacks, err := listStacks(cfapi)
+	add(RESOURCE_STACK, stacks, err)
+
+	bucketName, err := cfapi.GetStackResource(STACKNAME_DUMPLING, "S3Bucket")
+	if err != nil {
+		add(RESOURCE_BUCKET, nil, err)
+	} else if bucketName != nil {
+		s3Resources, err := listBucket(*bucketName, config.BucketInfo.S3Key, clients)
+		add(RESOURCE_BUCKET, s3Resources, err)
+	}
+
+	/* ****************************************************************** */
+	// 002. IAM roles and policies
+	/* ****************************************************************** */
+	iamResources, err := listIAM(clients)
+	add(RESOURCE_ROLE, iamResources, err)
+
+	/* ****************************************************************** */
+	// 003. Snapshots and export tasks
+	/* ****************************************************************** */
+	rdsResources, err := listRDS(config.SourceDB.AuroraClusterName, clients)
+	add(RESOURCE_SNAPSHOT, rdsResources, err)
+
+	/* ****************************************************************** */
+	// 004. TiDB Cloud import tasks reading the bucket
+	/* ****************************************************************** */
+	if bucketName != nil {
+		importResources, err := listImportTasks(&config.TiDBCloud, *bucketName, clients)
+		add(RESOURCE_IMPORT_TASK, importResources, err)
+	}
+
+	switch gOpt.Output {
+	case "json":
+		content, err := json.MarshalIndent(inventory, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	case "", "table":
+		inventory.Print(os.Stdout)
+	default:
+		return fmt.Errorf("unknown output format %s, use table or json", gOpt.Output)
+	}
+	return nil
+}
+
+// Print writes the inventory as a table
+func (i Inventory) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tSTATUS\tCREATED\tCOST DRIVER\tDETAIL")
+	for _, resource := range i.Resources {
+		created := "-"
+		if resource.CreatedAt != nil {
+			created = resource.CreatedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", resource.Type, resource.Name, resource.Status, created, valueOrDash(resource.CostDriver), valueOrDash(resource.Detail))
+	}
+	tw.Flush()
+}
+
+func listStacks(cfapi *cfapilib.CloudformationAPI) ([]Resource, error) {
+	resources := []Resource{}
+	for _, stackName := range []string{STACKNAME_BINLOG, STACKNAME_DUMPLING} {
+		stack, err := cfapi.GetStack(stackName)
+		if err != nil {
+			return nil, err
+		}
+		if stack == nil {
+			continue
+		}
+		stackResources, err := cfapi.ListStackResources(stackName)
+		if err != nil {
+			return nil, err
+		}
+
+		functions := 0
+		logicalIds := []string{}
+		for _, stackResource := range stackResources {
+			if aws.ToString(stackResource.ResourceType) == "AWS::Lambda::Function" {
+				functions++
+			}
+			logicalIds = append(logicalIds, fmt.Sprintf("%s(%s)", aws.ToString(stackResource.LogicalResourceId), aws.ToString(stackResource.ResourceType)))
+		}
+		resources = append(resources, Resource{
+			Type:       RESOURCE_STACK,
+			Name:       stackName,
+			Status:     string(stack.StackStatus),
+			CreatedAt:  stack.CreationTime,
+			CostDriver: fmt.Sprintf("%d lambda function(s), billed per invocation", functions),
+			Detail:     strings.Join(logicalIds, ", "),
+		})
+	}
+	return resources, nil
+}
+
+// listBucket reports the bucket and its objects summarized by prefix
+func listBucket(bucketName, s3Key string, clients *Clients) ([]Resource, error) {
+	s3api := clients.s3API(nil)
+	bucket, err := s3api.GetBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == nil {
+		return []Resource{{Type: RESOURCE_BUCKET, Name: bucketName, Status: "NOT_FOUND"}}, nil
+	}
+	objects, err := s3api.ListObjects(bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	type prefixSummary struct {
+		objects   int
+		size      int64
+		createdAt *time.Time
+	}
+	prefixes := map[string]*prefixSummary{}
+	totalSize := int64(0)
+	for _, object := range objects {
+		prefix := s3PrefixOf(aws.ToString(object.Key), s3Key)
+		summary, ok := prefixes[prefix]
+		if !ok {
+			summary = &prefixSummary{}
+			prefixes[prefix] = summary
+		}
+		summary.objects++
+		summary.size += object.Size
+		totalSize += object.Size
+		if object.LastModified != nil && (summary.createdAt == nil || object.LastModified.Before(*summary.createdAt)) {
+			summary.createdAt = object.LastModified
+		}
+	}
+
+	resources := []Resource{{
+		Type:       RESOURCE_BUCKET,
+		Name:       bucketName,
+		Status:     "AVAILABLE",
+		CreatedAt:  bucket.CreationDate,
+		CostDriver: fmt.Sprintf("%s stored", formatBytes(totalSize)),
+		Detail:     fmt.Sprintf("%d object(s)", len(objects)),
+	}}
+	names := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		names = append(names, prefix)
+	}
+	sort.Strings(names)
+	for _, prefix := range names {
+		summary := prefixes[prefix]
+		resources = append(resources, Resource{
+			Type:       RESOURCE_S3_PREFIX,
+			Name:       fmt.Sprintf("s3://%s/%s", bucketName, prefix),
+			Status:     "AVAILABLE",
+			CreatedAt:  summary.createdAt,
+			CostDriver: fmt.Sprintf("%s stored", formatBytes(summary.size)),
+			Detail:     fmt.Sprintf("%d object(s)", summary.objects),
+		})
+	}
+	return resources, nil
+}
+
+// s3PrefixOf returns the prefix the object is reported under: <s3Key>/<dir>/ for the ddl and
+// data exported under the S3 key, the first directory for the other objects
+func s3PrefixOf(key, s3Key string) string {
+	s3Key = strings.Trim(s3Key, "/")
+	if s3Key != "" && strings.HasPrefix(key, s3Key+"/") {
+		rest := strings.TrimPrefix(key, s3Key+"/")
+		if idx := strings.Index(rest, "/"); idx >= 0 {
+			return s3Key + "/" + rest[:idx] + "/"
+		}
+		return s3Key + "/"
+	}
+	if idx := strings.Index(key, "/"); idx >= 0 {
+		return key[:idx+1]
+	}
+	return ""
+}
+
+func listIAM(clients *Clients) ([]Resource, error) {
+	iamapi := clients.iamAPI(nil)
+
+	resources := []Resource{}
+	roles, err := iamapi.ListRoles(MODULE_NAME)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		resources = append(resources, Resource{
+			Type:      RESOURCE_ROLE,
+			Name:      aws.ToString(role.RoleName),
+			Status:    "ACTIVE",
+			CreatedAt: role.CreateDate,
+			Detail:    aws.ToString(role.Arn),
+		})
+	}
+
+	policies, err := iamapi.ListPolicies(MODULE_NAME)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		resources = append(resources, Resource{
+			Type:      RESOURCE_POLICY,
+			Name:      aws.ToString(policy.PolicyName),
+			Status:    "ACTIVE",
+			CreatedAt: policy.CreateDate,
+			Detail:    fmt.Sprintf("attached to %d role(s)", aws.ToInt32(policy.AttachmentCount)),
+		})
+	}
+	return resources, nil
+}
+
+func listRDS(clusterName string, clients *Clients) ([]Resource, error) {
+	rdsapi := clients.rdsAPI(nil)
+
+	resources := []Resource{}
+	snapshots, err := rdsapi.ListSnapshotsByBinlog(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		binlog := rdsTags(snapshot.TagList)
+		resources = append(resources, Resource{
+			Type:       RESOURCE_SNAPSHOT,
+			Name:       aws.ToString(snapshot.DBClusterSnapshotIdentifier),
+			Status:     aws.ToString(snapshot.Status),
+			CreatedAt:  snapshot.SnapshotCreateTime,
+			CostDriver: fmt.Sprintf("%d GiB snapshot storage", snapshot.AllocatedStorage),
+			Detail:     fmt.Sprintf("binlog %s:%s", binlog["File"], binlog["Position"]),
+		})
+	}
+
+	exportTasks, err := rdsapi.ListExportTasks(MODULE_NAME)
+	if err != nil {
+		return nil, err
+	}
+	for _, exportTask := range exportTasks {
+		status := aws.ToString(exportTask.Status)
+		if status != "COMPLETE" {
+			status = fmt.Sprintf("%s(%d%%)", status, exportTask.PercentProgress)
+		}
+		resources = append(resources, Resource{
+			Type:       RESOURCE_EXPORT_TASK,
+			Name:       aws.ToString(exportTask.ExportTaskIdentifier),
+			Status:     status,
+			CreatedAt:  exportTask.TaskStartTime,
+			CostDriver: fmt.Sprintf("%d GB extracted", exportTask.TotalExtractedDataInGB),
+			Detail:     fmt.Sprintf("s3://%s/%s", aws.ToString(exportTask.S3Bucket), aws.ToString(exportTask.S3Prefix)),
+		})
+	}
+	return resources, nil
+}
+
+// listImportTasks reports the import tasks of the TiDB Cloud cluster reading the bucket
+func listImportTasks(tidbCloud *configs.TiDBCloud, bucketName string, clients *Clients) ([]Resource, error) {
+	tidbcloudApi, err := clients.TiDBCloud(tidbCloud.ProjectID, tidbCloud.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+	importTasks, err := tidbcloudApi.ListImportTasks()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []Resource{}
+	for _, importTask := range importTasks {
+		if !strings.HasPrefix(importTask.SourceUri, fmt.Sprintf("s3://%s/", bucketName)) {
+			continue
+		}
+		resource := Resource{
+			Type:   RESOURCE_IMPORT_TASK,
+			Name:   importTask.Id,
+			Status: importTask.Phase,
+			Detail: importTask.SourceUri,
+		}
+		if seconds, err := strconv.ParseInt(importTask.CreateTimestamp, 10, 64); err == nil {
+			createdAt := time.Unix(seconds, 0)
+			resource.CreatedAt = &createdAt
+		}
+		if size, err := strconv.ParseInt(importTask.SourceSizeBytes, 10, 64); err == nil {
+			resource.CostDriver = fmt.Sprintf("%s imported", formatBytes(size))
+		}
+		if importTask.ErrorMessage != "" {
+			resource.Detail += ": " + importTask.ErrorMessage
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func formatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value, idx := float64(size), 0
+	for value >= 1024 && idx < len(units)-1 {
+		value /= 1024
+		idx++
+	}
+	if idx == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[idx])
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
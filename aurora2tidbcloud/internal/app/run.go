@@ -3,29 +3,25 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/luyomo/cheatsheet/aurora2tidbcloud/internal/app/configs"
-	cfapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/cloudformation"
-	iamapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/iam"
-	kmsapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/kms"
-	rdsapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/rds"
 	"github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/tidbcloud"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/aws/smithy-go/ptr"
 
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
 )
 
 // Summary: Migrate the data from Aurora to TiDB Cloud
-// 001. Create lambda function: dumpling
-// 002. Create lambda function: binlog position
-func Run(gOpt configs.Options) error {
+// The migration runs as the steps of MigrationSteps. The output of every completed step is
+// saved in the state file so that a rerun resumes from the first incomplete step.
+func Run(gOpt configs.Options, clients *Clients) error {
 	/* ****************************************************************** */
 	// 001. Config read
 	/* ****************************************************************** */
@@ -36,37 +32,152 @@ func Run(gOpt configs.Options) error {
 	fmt.Printf("The configs are : %#v \n", config)
 
 	/* ****************************************************************** */
-	// 002. Get binlog position
+	// 002. State read
 	/* ****************************************************************** */
-	binlogFile, binlogPos, err := fetchBinlogInfo(config)
+	state, err := LoadState(gOpt.StateFile, config.SourceDB.AuroraClusterName)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("binlog file: %s, position: %d \n\n\n", *binlogFile, *binlogPos)
+	fmt.Printf("The state file is: %s, completed steps: %d \n\n\n", gOpt.StateFile, len(state.Completed))
 
 	/* ****************************************************************** */
-	// 002. Backup DDL
+	// 003. Steps execution
 	/* ****************************************************************** */
-	s3arn, err := exportDDL(config)
-	if err != nil {
-		return err
-	}
-
-	kmsArn, err := getKMSArn()
-	if err != nil {
-		return err
-	}
-
-	_, err = dataExport(&config.SourceDB.AuroraClusterName, binlogFile, binlogPos, s3arn, &config.BucketInfo.S3Key, kmsArn)
-	if err != nil {
-		return err
-	}
+	return RunSteps(MigrationSteps(config, clients), state, StepOptions{FromStep: gOpt.FromStep, OnlyStep: gOpt.OnlyStep}, func(state *State) error {
+		return state.Save(gOpt.StateFile)
+	})
+}
 
-	if err := importData2TiDBCloud(s3arn, &config.BucketInfo.S3Key, kmsArn, &config.TiDBCloud); err != nil {
-		return err
+// MigrationSteps returns the steps of the migration in order
+// 001. binlog: Fetch the binlog position by lambda function
+// 002. ddl-export: Export the DDL to S3 by dumpling lambda function
+// 003. kms: Get the KMS key used by the export
+// 004. snapshot: Take the Aurora snapshot tagged with the binlog position
+// 005. export-role: Create the role used by the snapshot export
+// 006. data-export: Export the snapshot to S3 as parquet
+// 007. import-role: Create the role used by TiDB Cloud to read S3
+// 008. ddl-import: Import the DDL into TiDB Cloud
+// 009. data-import: Import the parquet data into TiDB Cloud
+func MigrationSteps(config *configs.Config, clients *Clients) []Step {
+	return []Step{
+		{
+			Name:        "binlog",
+			Description: "Fetch the binlog position",
+			Run: func(state *State) error {
+				binlogFile, binlogPos, err := fetchBinlogInfo(config, clients)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("binlog file: %s, position: %d \n\n\n", *binlogFile, *binlogPos)
+				state.BinlogFile, state.BinlogPos = *binlogFile, *binlogPos
+				return nil
+			},
+		},
+		{
+			Name:        "ddl-export",
+			Description: "Export the DDL to S3",
+			Run: func(state *State) error {
+				s3arn, err := exportDDL(config, clients)
+				if err != nil {
+					return err
+				}
+				state.S3Bucket = *s3arn
+				return nil
+			},
+		},
+		{
+			Name:        "kms",
+			Description: "Get the KMS key for the export",
+			Run: func(state *State) error {
+				kmsArn, err := getKMSArn(clients)
+				if err != nil {
+					return err
+				}
+				state.KMSArn = *kmsArn
+				return nil
+			},
+		},
+		{
+			Name:        "snapshot",
+			Description: "Take the Aurora snapshot",
+			Needs: func(state *State) error {
+				return requireState(map[string]string{"binlog": state.BinlogFile})
+			},
+			Run: func(state *State) error {
+				snapshotArn, err := takeSnapshot(config, state, clients)
+				if err != nil {
+					return err
+				}
+				state.SnapshotArn = *snapshotArn
+				return nil
+			},
+		},
+		{
+			Name:        "export-role",
+			Description: "Create the role to export the snapshot to S3",
+			Needs: func(state *State) error {
+				return requireState(map[string]string{"ddl-export": state.S3Bucket, "kms": state.KMSArn})
+			},
+			Run: func(state *State) error {
+				roleArn, err := createExportRole(config, state, clients)
+				if err != nil {
+					return err
+				}
+				state.ExportRoleArn = *roleArn
+				return nil
+			},
+		},
+		{
+			Name:        "data-export",
+			Description: "Export the snapshot data to S3",
+			Needs: func(state *State) error {
+				return requireState(map[string]string{"snapshot": state.SnapshotArn, "export-role": state.ExportRoleArn, "ddl-export": state.S3Bucket, "kms": state.KMSArn})
+			},
+			Run: func(state *State) error {
+				return dataExport(config, state, clients)
+			},
+		},
+		{
+			Name:        "import-role",
+			Description: "Create the role for TiDB Cloud to access S3",
+			Needs: func(state *State) error {
+				return requireState(map[string]string{"ddl-export": state.S3Bucket, "kms": state.KMSArn})
+			},
+			Run: func(state *State) error {
+				roleArn, err := createImportRole(config, state, clients)
+				if err != nil {
+					return err
+				}
+				state.ImportRoleArn = *roleArn
+				return nil
+			},
+		},
+		{
+			Name:        "ddl-import",
+			Description: "Import the DDL into TiDB Cloud",
+			Needs: func(state *State) error {
+				return requireState(map[string]string{"ddl-export": state.S3Bucket, "import-role": state.ImportRoleArn})
+			},
+			Run: func(state *State) error {
+				return importData2TiDBCloud(config, state, "ddl-import", "ddl", "SQL", clients)
+			},
+		},
+		{
+			Name:        "data-import",
+			Description: "Import the snapshot data into TiDB Cloud",
+			Needs: func(state *State) error {
+				return requireState(map[string]string{"data-export": state.ExportTaskID, "import-role": state.ImportRoleArn})
+			},
+			Run: func(state *State) error {
+				return importData2TiDBCloud(config, state, "data-import", "data", "AURORA_SNAPSHOT", clients)
+			},
+		},
 	}
+}
 
-	return nil
+// MigrationStepNames returns the names of the migration steps in order
+func MigrationStepNames() []string {
+	return StepNames(MigrationSteps(nil, nil))
 }
 
 // Input:
@@ -78,18 +189,8 @@ func Run(gOpt configs.Options) error {
 // 02. Get lambda function
 // 03. Get S3 Bucket
 // 04. Make a call to run the dumpling
-func exportDDL(configs *configs.Config) (*string, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-
-	client := lambda.NewFromConfig(cfg)
-
-	cfapi, err := cfapilib.NewCFAPI(nil)
-	if err != nil {
-		return nil, err
-	}
+func exportDDL(configs *configs.Config, clients *Clients) (*string, error) {
+	cfapi := clients.cfAPI(ownerArgs())
 
 	// ctx := context.WithValue(context.Background(), "clusterName", "aurora2tidbcloud-ddl") // The name is used for stackname of ddl export
 	// ctx = context.WithValue(ctx, "clusterType", "aurora2tidbcloud")                       // The clusterType is used for tags
@@ -132,7 +233,7 @@ func exportDDL(configs *configs.Config) (*string, error) {
 	}
 
 	fmt.Printf("The db connection: <%#v> \n\n\n", string(dbConn))
-	output, err := client.Invoke(context.TODO(), &lambda.InvokeInput{FunctionName: lambdaDDLExport,
+	output, err := clients.Lambda.Invoke(context.TODO(), &lambda.InvokeInput{FunctionName: lambdaDDLExport,
 		InvocationType: lambdatypes.InvocationTypeRequestResponse,
 		Payload:        dbConn})
 	if err != nil {
@@ -143,18 +244,8 @@ func exportDDL(configs *configs.Config) (*string, error) {
 	return s3arn, nil
 }
 
-func fetchBinlogInfo(configs *configs.Config) (*string, *int64, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, nil, err
-	}
-
-	client := lambda.NewFromConfig(cfg)
-
-	cfapi, err := cfapilib.NewCFAPI(nil)
-	if err != nil {
-		return nil, nil, err
-	}
+func fetchBinlogInfo(configs *configs.Config, clients *Clients) (*string, *int64, error) {
+	cfapi := clients.cfAPI(ownerArgs())
 
 	dbConn, err := json.Marshal(configs)
 	if err != nil {
@@ -192,7 +283,7 @@ func fetchBinlogInfo(configs *configs.Config) (*string, *int64, error) {
 	// 006. Call lambda function to fetch binlog position
 	/* ****************************************************************** */
 
-	output, err := client.Invoke(context.TODO(), &lambda.InvokeInput{FunctionName: lambdaFetchBinlogPos,
+	output, err := clients.Lambda.Invoke(context.TODO(), &lambda.InvokeInput{FunctionName: lambdaFetchBinlogPos,
 		InvocationType: lambdatypes.InvocationTypeRequestResponse,
 		Payload:        dbConn})
 	if err != nil {
@@ -208,56 +299,52 @@ func fetchBinlogInfo(configs *configs.Config) (*string, *int64, error) {
 	return aws.String(binlogPos[2].(string)), aws.Int64(int64(binlogPos[3].(float64))), nil
 }
 
-func dataExport(auroraClusterName, binlogFile *string, binlogPos *int64, s3arn, s3key, kmsArn *string) (*string, error) {
+func takeSnapshot(config *configs.Config, state *State, clients *Clients) (*string, error) {
 	/* ****************************************************************** */
 	// 003. snapshot taken
 	/* ****************************************************************** */
-	rdsapi, err := rdsapilib.NewRdsAPI(nil)
-	if err != nil {
-		return nil, err
-	}
+	rdsapi := clients.rdsAPI(ownerArgs())
 
-	snapshotArn, err := rdsapi.RDSSnapshotTaken(*auroraClusterName, *binlogFile, *binlogPos)
+	snapshotArn, err := rdsapi.RDSSnapshotTaken(config.SourceDB.AuroraClusterName, state.BinlogFile, state.BinlogPos)
 	if err != nil {
 		return nil, err
 	}
 	fmt.Printf("The snap: <%#v> \n\n\n", *snapshotArn)
+	return snapshotArn, nil
+}
 
+func createExportRole(config *configs.Config, state *State, clients *Clients) (*string, error) {
 	/* ****************************************************************** */
 	// 004. Role for export preparation
 	/* ****************************************************************** */
+	iamapi := clients.iamAPI(ownerArgs())
 
-	iamapi, err := iamapilib.NewIAMAPI(nil)
-	if err != nil {
-		return nil, err
-	}
-
-	roleArn, err := iamapi.CreateRole4S3ByRDS(EXPORT_ROLE, aws.String(MODULE_NAME), fmt.Sprintf("s3://%s/%s", *s3arn, *s3key), kmsArn, nil)
-	if err != nil {
-		return nil, err
-	}
+	return iamapi.CreateRole4S3ByRDS(EXPORT_ROLE, aws.String(MODULE_NAME), fmt.Sprintf("s3://%s/%s", state.S3Bucket, config.BucketInfo.S3Key), aws.String(state.KMSArn), nil)
+}
 
+func dataExport(config *configs.Config, state *State, clients *Clients) error {
 	/* ****************************************************************** */
 	// 006. Data export to S3
 	/* ****************************************************************** */
-	exportTask, err := rdsapi.ExportSnapshot2S3(MODULE_NAME, snapshotArn, kmsArn, roleArn, fmt.Sprintf("s3://%s/%s/data", *s3arn, *s3key))
+	rdsapi := clients.rdsAPI(nil)
+
+	exportTask, err := rdsapi.ExportSnapshot2S3(MODULE_NAME, aws.String(state.SnapshotArn), aws.String(state.KMSArn), aws.String(state.ExportRoleArn), fmt.Sprintf("s3://%s/%s/data", state.S3Bucket, config.BucketInfo.S3Key))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	fmt.Printf("The export task is <%#v> \n\n\n", exportTask)
 
-	return exportTask.S3Prefix, nil
+	state.ExportTaskID = aws.ToString(exportTask.ExportTaskIdentifier)
+	state.ExportS3Prefix = aws.ToString(exportTask.S3Prefix)
+	return nil
 }
 
-func getKMSArn() (*string, error) {
+func getKMSArn(clients *Clients) (*string, error) {
 	/* ****************************************************************** */
 	// 005. KMS preparation
 	/* ****************************************************************** */
 	// 01. Create KMS if it does not exist
-	kmsapi, err := kmsapilib.NewKmsAPI(nil)
-	if err != nil {
-		return nil, err
-	}
+	kmsapi := clients.kmsAPI(nil)
 	kmsArn, err := kmsapi.GetKMSKeyByName("jay-labmda-aurora2tidbcloud")
 	if err != nil {
 		return nil, err
@@ -266,47 +353,68 @@ func getKMSArn() (*string, error) {
 	return kmsArn, nil
 }
 
-func importData2TiDBCloud(s3arn, s3prefix, kmsArn *string, tidbCloud *configs.TiDBCloud) error {
+func createImportRole(config *configs.Config, state *State, clients *Clients) (*string, error) {
 	/* ****************************************************************** */
 	// 006. Role for import preparation
 	/* ****************************************************************** */
-	tidbcloudApi, err := tidbcloud.NewTiDBCloudAPI(string(tidbCloud.ProjectID), tidbCloud.ClusterName, nil)
+	tidbcloudApi, err := clients.TiDBCloud(config.TiDBCloud.ProjectID, config.TiDBCloud.ClusterName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	accountId, externalId, err := tidbcloudApi.GetImportTaskRoleInfo()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	fmt.Printf("account id: %s  externalId: %s \n\n\n", *accountId, *externalId)
 
-	iamapi, err := iamapilib.NewIAMAPI(nil)
-	if err != nil {
-		return err
-	}
+	iamapi := clients.iamAPI(ownerArgs())
 
-	importRoleArn, err := iamapi.CreateRole4S3External(IMPORT_ROLE, aws.String(MODULE_NAME), kmsArn, accountId, externalId, fmt.Sprintf("s3://%s/", *s3arn), nil)
+	importRoleArn, err := iamapi.CreateRole4S3External(IMPORT_ROLE, aws.String(MODULE_NAME), aws.String(state.KMSArn), accountId, externalId, fmt.Sprintf("s3://%s/", state.S3Bucket), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	fmt.Printf("The import role is: %s \n\n\n", *importRoleArn)
-	fmt.Printf("The prefix is: %s \n\n\n", *s3prefix)
+	return importRoleArn, nil
+}
 
+// Import the S3 directory <S3Key>/<dir> into TiDB Cloud, dataSourceType: SQL for the DDL and
+// AURORA_SNAPSHOT for the data
+func importData2TiDBCloud(config *configs.Config, state *State, stepName, dir, dataSourceType string, clients *Clients) error {
 	/* ****************************************************************** */
-	// 007. Schema ddl creation
+	// 007. Schema ddl creation / 008. Data import
 	/* ****************************************************************** */
-	if err := tidbcloudApi.StartImportTask(ptr.String(tidbCloud.ClusterName), ptr.String(fmt.Sprintf("s3://%s/%s/ddl", *s3arn, *s3prefix)), importRoleArn, ptr.String("SQL")); err != nil {
+	tidbcloudApi, err := clients.TiDBCloud(config.TiDBCloud.ProjectID, config.TiDBCloud.ClusterName)
+	if err != nil {
 		return err
 	}
-	// s3: //aurora2tidbcloud-ddl-data/lambda/data/
-
-	/* ****************************************************************** */
-	// 008. Data import
-	/* ****************************************************************** */
-	fmt.Printf("Completed to generate the DDL \n\n\n")
 	// s3://aurora2tidbcloud-ddl-data/test/arrora2tidbcloud-20230816112919/
-	if err := tidbcloudApi.StartImportTask(ptr.String(tidbCloud.ClusterName), ptr.String(fmt.Sprintf("s3://%s/%s/data", *s3arn, *s3prefix)), importRoleArn, ptr.String("AURORA_SNAPSHOT")); err != nil {
+	s3Dir := fmt.Sprintf("s3://%s/%s/%s", state.S3Bucket, config.BucketInfo.S3Key, dir)
+	fmt.Printf("The import source is: %s \n\n\n", s3Dir)
+	// The task created by the interrupted run of the step is waited for instead of importing
+	// the data twice. A completed step run again by --from-step/--only-step imports again.
+	importTaskID, ok := state.ImportTaskIDs[stepName]
+	if _, completed := state.Completed[stepName]; completed || !ok {
+		newTaskID, err := tidbcloudApi.StartImportTask(ptr.String(config.TiDBCloud.ClusterName), ptr.String(s3Dir), aws.String(state.ImportRoleArn), ptr.String(dataSourceType))
+		if err != nil {
+			return err
+		}
+		importTaskID = *newTaskID
+		state.ImportTaskIDs[stepName] = importTaskID
+		if err := state.Checkpoint(); err != nil {
+			return err
+		}
+		fmt.Printf("Started the import task %s from %s \n\n\n", importTaskID, s3Dir)
+	} else {
+		fmt.Printf("Waiting for the import task %s started by the previous run \n\n\n", importTaskID)
+	}
+
+	if err := tidbcloudApi.WaitImportTask(ptr.String(importTaskID)); err != nil {
+		if errors.Is(err, tidbcloud.ErrImportTaskFailed) {
+			// The rerun starts a new task
+			delete(state.ImportTaskIDs, stepName)
+		}
 		return err
 	}
+	fmt.Printf("Completed the import task %s from %s \n\n\n", importTaskID, s3Dir)
 	return nil
 }
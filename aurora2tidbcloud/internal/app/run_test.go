@@ -0,0 +1,282 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luyomo/cheatsheet/aurora2tidbcloud/internal/app/configs"
+	awscommon "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws"
+	"github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/fake"
+)
+
+const testConfig = `
+[source_db]
+host = "aurora.cluster.local"
+port = 3306
+user = "admin"
+password = "secret"
+db = "test"
+aurora_cluster_name = "aurora-test"
+
+[lambdavpc]
+vpcid = "vpc-0001"
+security_group_id = "sg-0001"
+subnets = [ "subnet-0001", "subnet-0002" ]
+
+[bucket_info]
+s3key = "migration"
+
+[tidbcloud]
+project_id = "1234"
+cluster_name = "tidb-test"
+`
+
+// testCloud is the fake cloud of the test with the templates of the lambda stacks, the kms key
+// and the aurora cluster
+type testCloud struct {
+	*fake.Cloud
+	options configs.Options
+	// ddlBuckets are the buckets the dumpling lambda wrote into
+	ddlBuckets []string
+}
+
+func newTestCloud(t *testing.T) *testCloud {
+	t.Helper()
+
+	interval := awscommon.DefaultInterval
+	awscommon.DefaultInterval = time.Millisecond
+	t.Cleanup(func() { awscommon.DefaultInterval = interval })
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(configFile, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cloud := &testCloud{
+		Cloud:   fake.New(),
+		options: configs.Options{ConfigFile: configFile, StateFile: filepath.Join(dir, "state.json")},
+	}
+	cloud.AddTemplate(LAMBDA_FUNCTION_BINLOG, fake.Template{Resources: []fake.TemplateResource{
+		{LogicalId: "ddlExport", Type: fake.LAMBDA_FUNCTION, Handler: func(payload []byte) ([]byte, error) {
+			return []byte(`[1, "aurora-test", "mysql-bin.000002", 4567]`), nil
+		}},
+	}})
+	cloud.AddTemplate(LAMBDA_FUNCTION_DDL, fake.Template{Resources: []fake.TemplateResource{
+		{LogicalId: "S3Bucket", Type: fake.S3_BUCKET},
+		{LogicalId: "ddlExport", Type: fake.LAMBDA_FUNCTION, Handler: func(payload []byte) ([]byte, error) {
+			// The dumpling lambda dumps the schema under the S3 key of the payload
+			var config configs.Config
+			if err := json.Unmarshal(payload, &config); err != nil {
+				return nil, err
+			}
+			cloud.ddlBuckets = append(cloud.ddlBuckets, config.BucketInfo.BucketName)
+			for _, file := range []string{"test-schema-create.sql", "test.orders-schema.sql"} {
+				if err := cloud.PutObject(config.BucketInfo.BucketName, fmt.Sprintf("%s/%s", config.BucketInfo.S3Key, file), 256); err != nil {
+					return nil, err
+				}
+			}
+			return []byte(`"dumped"`), nil
+		}},
+	}})
+	cloud.AddKey("jay-labmda-aurora2tidbcloud", nil)
+	cloud.AddCluster("aurora-test", []string{"test.orders", "test.customers"})
+	return cloud
+}
+
+// clients returns the clients calling the fake cloud
+func (c *testCloud) clients() *Clients {
+	return &Clients{
+		CloudFormation: c.CloudFormation(),
+		IAM:            c.IAM(),
+		KMS:            c.KMS(),
+		RDS:            c.RDS(),
+		S3:             c.S3(),
+		Lambda:         c.Lambda(),
+		TiDBCloud: func(projectId, clusterName string) (TiDBCloudAPI, error) {
+			return c.TiDBCloud(projectId, clusterName), nil
+		},
+	}
+}
+
+// migrate runs the whole migration against the fake cloud
+func (c *testCloud) migrate(t *testing.T) *State {
+	t.Helper()
+	if err := Run(c.options, c.clients()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	state, err := LoadState(c.options.StateFile, "aurora-test")
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	return state
+}
+
+func Test_runMigration(t *testing.T) {
+	cloud := newTestCloud(t)
+	state := cloud.migrate(t)
+
+	if len(state.Completed) != len(MigrationStepNames()) {
+		t.Errorf("completed = %v, want all the steps", state.Completed)
+	}
+	if state.BinlogFile != "mysql-bin.000002" || state.BinlogPos != 4567 {
+		t.Errorf("binlog = %s:%d, want mysql-bin.000002:4567", state.BinlogFile, state.BinlogPos)
+	}
+	if got := cloud.StackNames(); len(got) != 2 {
+		t.Errorf("stacks = %v, want the binlog and dumpling stacks", got)
+	}
+	if got := cloud.RoleNames(); strings.Join(got, ",") != EXPORT_ROLE+","+IMPORT_ROLE {
+		t.Errorf("roles = %v", got)
+	}
+	if got := cloud.ExportTaskStatus(state.ExportTaskID); got != "COMPLETE" {
+		t.Errorf("export task %s = %s, want COMPLETE", state.ExportTaskID, got)
+	}
+
+	// The snapshot is tagged with the binlog position and the owner
+	snapshots, err := cloud.clients().rdsAPI(nil).ListSnapshotsByBinlog("aurora-test")
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("snapshots = %v, %v, want 1", snapshots, err)
+	}
+	if tags := rdsTags(snapshots[0].TagList); tags["Position"] != "4567" || !isOwned(tags) {
+		t.Errorf("snapshot tags = %v", tags)
+	}
+
+	// The ddl and the exported tables are imported from the bucket of the dumpling stack
+	if len(cloud.ddlBuckets) != 1 || cloud.ddlBuckets[0] != state.S3Bucket {
+		t.Errorf("dumpling lambda wrote into %v, want %s", cloud.ddlBuckets, state.S3Bucket)
+	}
+	data := cloud.Objects(state.S3Bucket, fmt.Sprintf("migration/data/%s/test/", state.ExportTaskID))
+	if len(data) != 2 {
+		t.Errorf("exported objects = %v, want one per table", data)
+	}
+	sources := cloud.ImportSources()
+	want := []string{fmt.Sprintf("s3://%s/migration/ddl", state.S3Bucket), fmt.Sprintf("s3://%s/migration/data", state.S3Bucket)}
+	if strings.Join(sources, ",") != strings.Join(want, ",") {
+		t.Errorf("import sources = %v, want %v", sources, want)
+	}
+	if len(state.ImportTaskIDs) != 2 {
+		t.Errorf("import tasks = %v, want ddl-import and data-import", state.ImportTaskIDs)
+	}
+}
+
+func Test_runMigrationResume(t *testing.T) {
+	cloud := newTestCloud(t)
+
+	// The ddl import succeeds, the data import fails after the export completed
+	cloud.Fail("StartImportTask", nil)
+	cloud.Fail("StartImportTask", errors.New("Failed to import data<500>: internal error"))
+	err := Run(cloud.options, cloud.clients())
+	if err == nil || !strings.Contains(err.Error(), "step data-import failed") {
+		t.Fatalf("Run() error = %v, want data-import failure", err)
+	}
+	state, err := LoadState(cloud.options.StateFile, "aurora-test")
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if _, ok := state.Completed["data-import"]; ok || len(state.Completed) != len(MigrationStepNames())-1 {
+		t.Errorf("completed = %v, want all the steps but data-import", state.Completed)
+	}
+
+	// The rerun imports the data without taking the snapshot or exporting it again
+	calls := map[string]int{}
+	for _, operation := range []string{"CreateStack", "Invoke", "CreateDBClusterSnapshot", "StartExportTask", "CreateRole"} {
+		calls[operation] = cloud.Calls(operation)
+	}
+	state = cloud.migrate(t)
+	for operation, count := range calls {
+		if got := cloud.Calls(operation); got != count {
+			t.Errorf("%s called %d more time(s) by the resume", operation, got-count)
+		}
+	}
+	if len(state.Completed) != len(MigrationStepNames()) || len(cloud.ImportSources()) != 2 {
+		t.Errorf("completed = %v with imports %v", state.Completed, cloud.ImportSources())
+	}
+
+	// Nothing runs once all the steps are completed
+	imports := cloud.Calls("StartImportTask")
+	cloud.migrate(t)
+	if got := cloud.Calls("StartImportTask"); got != imports {
+		t.Errorf("completed migration imported again")
+	}
+}
+
+func Test_runMigrationImportInterrupted(t *testing.T) {
+	cloud := newTestCloud(t)
+
+	// The data import task is created, the run is interrupted while waiting for it
+	cloud.Fail("WaitImportTask", nil)
+	cloud.Fail("WaitImportTask", errors.New("connection reset by peer"))
+	if err := Run(cloud.options, cloud.clients()); err == nil || !strings.Contains(err.Error(), "step data-import failed") {
+		t.Fatalf("Run() error = %v, want data-import failure", err)
+	}
+	state, err := LoadState(cloud.options.StateFile, "aurora-test")
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	taskID := state.ImportTaskIDs["data-import"]
+	if taskID == "" {
+		t.Fatalf("import tasks = %v, want the data import task saved once created", state.ImportTaskIDs)
+	}
+
+	// The rerun waits for the recorded task instead of importing the data again
+	state = cloud.migrate(t)
+	if got := cloud.Calls("StartImportTask"); got != 2 {
+		t.Errorf("StartImportTask called %d time(s), want 2", got)
+	}
+	if state.ImportTaskIDs["data-import"] != taskID {
+		t.Errorf("data import task = %s, want %s", state.ImportTaskIDs["data-import"], taskID)
+	}
+}
+
+func Test_runMigrationImportTaskFailed(t *testing.T) {
+	cloud := newTestCloud(t)
+
+	cloud.Fail("WaitImportTask", nil)
+	cloud.Fail("WaitImportTask", errors.New("connection reset by peer"))
+	if err := Run(cloud.options, cloud.clients()); err == nil {
+		t.Fatalf("Run() error = nil, want data-import failure")
+	}
+	state, err := LoadState(cloud.options.StateFile, "aurora-test")
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+
+	// The recorded task failed meanwhile, it is forgotten and the next run imports again
+	cloud.SetImportTaskPhase(state.ImportTaskIDs["data-import"], "FAILED", "the file is corrupted")
+	if err := Run(cloud.options, cloud.clients()); err == nil || !strings.Contains(err.Error(), "the file is corrupted") {
+		t.Fatalf("Run() error = %v, want the import task failure", err)
+	}
+	if state, err = LoadState(cloud.options.StateFile, "aurora-test"); err != nil || state.ImportTaskIDs["data-import"] != "" {
+		t.Fatalf("import tasks = %v, %v, want the failed task forgotten", state.ImportTaskIDs, err)
+	}
+	state = cloud.migrate(t)
+	if got := cloud.Calls("StartImportTask"); got != 3 {
+		t.Errorf("StartImportTask called %d time(s), want 3", got)
+	}
+	if len(state.Completed) != len(MigrationStepNames()) {
+		t.Errorf("completed = %v, want all the steps", state.Completed)
+	}
+}
+
+func Test_runMigrationFailedExport(t *testing.T) {
+	cloud := newTestCloud(t)
+
+	// The export fails to start, the rerun exports the same snapshot
+	cloud.Fail("StartExportTask", errors.New("InvalidExportSourceState: the snapshot is being deleted"))
+	if err := Run(cloud.options, cloud.clients()); err == nil || !strings.Contains(err.Error(), "step data-export failed") {
+		t.Fatalf("Run() error = %v, want data-export failure", err)
+	}
+	state := cloud.migrate(t)
+	if state.ExportTaskID == "" || cloud.ExportTaskStatus(state.ExportTaskID) != "COMPLETE" {
+		t.Errorf("export task %s = %s, want COMPLETE", state.ExportTaskID, cloud.ExportTaskStatus(state.ExportTaskID))
+	}
+	if got := cloud.Calls("CreateDBClusterSnapshot"); got != 1 {
+		t.Errorf("CreateDBClusterSnapshot called %d time(s), want 1", got)
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is the output of the completed steps. It is saved after every step so that a rerun
+// resumes after the last completed one instead of exporting the snapshot again.
+type State struct {
+	// ClusterName is the Aurora cluster the state belongs to
+	ClusterName string `json:"cluster_name"`
+	// Completed are the names of the completed steps with their completion time
+	Completed map[string]time.Time `json:"completed"`
+
+	BinlogFile     string `json:"binlog_file,omitempty"`
+	BinlogPos      int64  `json:"binlog_pos,omitempty"`
+	S3Bucket       string `json:"s3_bucket,omitempty"`
+	KMSArn         string `json:"kms_arn,omitempty"`
+	SnapshotArn    string `json:"snapshot_arn,omitempty"`
+	ExportRoleArn  string `json:"export_role_arn,omitempty"`
+	ExportTaskID   string `json:"export_task_id,omitempty"`
+	ExportS3Prefix string `json:"export_s3_prefix,omitempty"`
+	ImportRoleArn  string `json:"import_role_arn,omitempty"`
+	// ImportTaskIDs are the TiDB Cloud import tasks indexed by the step name. The id is saved
+	// as soon as the task is created, a rerun of the incomplete step waits for the same task.
+	ImportTaskIDs map[string]string `json:"import_task_ids,omitempty"`
+
+	// save is set by RunSteps for Checkpoint
+	save func(*State) error
+}
+
+// NewState returns the empty state of the cluster
+func NewState(clusterName string) *State {
+	return &State{ClusterName: clusterName, Completed: map[string]time.Time{}, ImportTaskIDs: map[string]string{}}
+}
+
+// LoadState reads the state file, a new state if the file does not exist
+func LoadState(path, clusterName string) (*State, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewState(clusterName), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file(%s): %v", path, err)
+	}
+
+	state := NewState(clusterName)
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file(%s): %v", path, err)
+	}
+	if state.ClusterName != clusterName {
+		return nil, fmt.Errorf("state file(%s) belongs to cluster %s, not %s. Remove it or use --state-file", path, state.ClusterName, clusterName)
+	}
+	if state.Completed == nil {
+		state.Completed = map[string]time.Time{}
+	}
+	if state.ImportTaskIDs == nil {
+		state.ImportTaskIDs = map[string]string{}
+	}
+	return state, nil
+}
+
+// Save writes the state through a temporary file so that an interrupted write keeps the
+// previous version
+func (s *State) Save(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(content, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Checkpoint saves the state in the middle of a step, e.g. right after a long running task is
+// created so that a rerun does not create it again
+func (s *State) Checkpoint() error {
+	if s.save == nil {
+		return nil
+	}
+	return s.save(s)
+}
+
+// invalidate marks the step incomplete and forgets the import task it created
+func (s *State) invalidate(stepName string) {
+	delete(s.Completed, stepName)
+	delete(s.ImportTaskIDs, stepName)
+}
+
+// outputs returns the step outputs of the state, the completion marks excluded
+func (s *State) outputs() string {
+	theState := *s
+	theState.Completed = nil
+	content, _ := json.Marshal(theState)
+	return string(content)
+}
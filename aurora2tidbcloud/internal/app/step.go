@@ -0,0 +1,147 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Step is one checkpointed step of the migration
+type Step struct {
+	Name        string
+	Description string
+	// Needs returns an error if the state misses the output of a previous step
+	Needs func(state *State) error
+	// Run executes the step and records its output in the state
+	Run func(state *State) error
+}
+
+// StepOptions select the steps to execute. By default the steps after the last completed one
+// are executed.
+type StepOptions struct {
+	// FromStep executes the step and all the following ones, completed or not
+	FromStep string
+	// OnlyStep executes the step alone, completed or not
+	OnlyStep string
+}
+
+// StepNames returns the names of the steps in order
+func StepNames(steps []Step) []string {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func stepIndex(steps []Step, name string) (int, error) {
+	for idx, step := range steps {
+		if step.Name == name {
+			return idx, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown step %s, the steps are: %s", name, strings.Join(StepNames(steps), ", "))
+}
+
+// selectSteps returns the indexes of the steps to execute
+func selectSteps(steps []Step, state *State, opts StepOptions) ([]int, error) {
+	if opts.FromStep != "" && opts.OnlyStep != "" {
+		return nil, fmt.Errorf("--from-step and --only-step can not be used together")
+	}
+
+	if opts.OnlyStep != "" {
+		idx, err := stepIndex(steps, opts.OnlyStep)
+		if err != nil {
+			return nil, err
+		}
+		return []int{idx}, nil
+	}
+
+	start := 0
+	if opts.FromStep != "" {
+		idx, err := stepIndex(steps, opts.FromStep)
+		if err != nil {
+			return nil, err
+		}
+		start = idx
+	} else {
+		// Resume from the first incomplete step
+		for start < len(steps) {
+			if _, ok := state.Completed[steps[start].Name]; !ok {
+				break
+			}
+			start++
+		}
+	}
+
+	indexes := []int{}
+	for idx := start; idx < len(steps); idx++ {
+		indexes = append(indexes, idx)
+	}
+	return indexes, nil
+}
+
+// RunSteps executes the selected steps in order and saves the state after every completed
+// step. A rerun step whose output changed marks the following steps incomplete, their output
+// was derived from the previous one.
+func RunSteps(steps []Step, state *State, opts StepOptions, save func(*State) error) error {
+	indexes, err := selectSteps(steps, state, opts)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		fmt.Printf("All the steps are completed, use --from-step or --only-step to run them again \n")
+		return nil
+	}
+
+	state.save = save
+	defer func() { state.save = nil }()
+	for _, idx := range indexes {
+		step := steps[idx]
+		if step.Needs != nil {
+			if err := step.Needs(state); err != nil {
+				return fmt.Errorf("step %s can not run: %v", step.Name, err)
+			}
+		}
+
+		fmt.Printf("Step %d/%d %s: %s \n", idx+1, len(steps), step.Name, step.Description)
+		before := state.outputs()
+		if err := step.Run(state); err != nil {
+			// The outputs of the failed step are kept for the investigation, it stays incomplete
+			if saveErr := save(state); saveErr != nil {
+				fmt.Printf("Failed to save state: %v \n", saveErr)
+			}
+			return fmt.Errorf("step %s failed: %v", step.Name, err)
+		}
+
+		state.Completed[step.Name] = time.Now()
+		if state.outputs() != before {
+			for _, later := range steps[idx+1:] {
+				if _, ok := state.Completed[later.Name]; ok {
+					fmt.Printf("Step %s changed its output, %s must run again \n", step.Name, later.Name)
+					state.invalidate(later.Name)
+				}
+			}
+		}
+		if err := save(state); err != nil {
+			return fmt.Errorf("failed to save state after step %s: %v", step.Name, err)
+		}
+	}
+	return nil
+}
+
+// requireState returns an error naming the step producing the missing values
+func requireState(values map[string]string) error {
+	missing := []string{}
+	for producer, value := range values {
+		if value == "" {
+			missing = append(missing, producer)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("run the step(s) %s first", strings.Join(missing, ", "))
+	}
+	return nil
+}
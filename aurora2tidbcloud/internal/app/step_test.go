@@ -0,0 +1,158 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSteps returns three steps recording their runs, b derives its output from a
+func testSteps(runs *[]string) []Step {
+	return []Step{
+		{Name: "a", Run: func(state *State) error {
+			*runs = append(*runs, "a")
+			state.BinlogFile = "mysql-bin.000001"
+			return nil
+		}},
+		{Name: "b", Needs: func(state *State) error {
+			return requireState(map[string]string{"a": state.BinlogFile})
+		}, Run: func(state *State) error {
+			*runs = append(*runs, "b")
+			state.SnapshotArn = "arn:" + state.BinlogFile
+			return nil
+		}},
+		{Name: "c", Run: func(state *State) error {
+			*runs = append(*runs, "c")
+			return nil
+		}},
+	}
+}
+
+func Test_runStepsResume(t *testing.T) {
+	var runs []string
+	state := NewState("cluster")
+	saves := 0
+	save := func(*State) error { saves++; return nil }
+
+	if err := RunSteps(testSteps(&runs), state, StepOptions{}, save); err != nil {
+		t.Fatalf("RunSteps() error = %v", err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(runs, want) || saves != 3 {
+		t.Errorf("first run = %v with %d saves, want %v with 3 saves", runs, saves, want)
+	}
+
+	// Everything completed, nothing runs again
+	runs = nil
+	if err := RunSteps(testSteps(&runs), state, StepOptions{}, save); err != nil || len(runs) != 0 {
+		t.Errorf("rerun = %v, %v, want nothing", runs, err)
+	}
+
+	// Resume from the first incomplete step
+	delete(state.Completed, "c")
+	runs = nil
+	if err := RunSteps(testSteps(&runs), state, StepOptions{}, save); err != nil || !reflect.DeepEqual(runs, []string{"c"}) {
+		t.Errorf("resume = %v, %v, want [c]", runs, err)
+	}
+}
+
+func Test_runStepsSelection(t *testing.T) {
+	var runs []string
+	state := NewState("cluster")
+	save := func(*State) error { return nil }
+	if err := RunSteps(testSteps(&runs), state, StepOptions{}, save); err != nil {
+		t.Fatalf("RunSteps() error = %v", err)
+	}
+
+	runs = nil
+	if err := RunSteps(testSteps(&runs), state, StepOptions{FromStep: "b"}, save); err != nil || !reflect.DeepEqual(runs, []string{"b", "c"}) {
+		t.Errorf("--from-step b = %v, %v, want [b c]", runs, err)
+	}
+	runs = nil
+	if err := RunSteps(testSteps(&runs), state, StepOptions{OnlyStep: "b"}, save); err != nil || !reflect.DeepEqual(runs, []string{"b"}) {
+		t.Errorf("--only-step b = %v, %v, want [b]", runs, err)
+	}
+
+	if err := RunSteps(testSteps(&runs), state, StepOptions{FromStep: "a", OnlyStep: "b"}, save); err == nil {
+		t.Errorf("--from-step with --only-step want error")
+	}
+	if err := RunSteps(testSteps(&runs), state, StepOptions{OnlyStep: "x"}, save); err == nil || !strings.Contains(err.Error(), "a, b, c") {
+		t.Errorf("unknown step error = %v, want the step names", err)
+	}
+}
+
+func Test_runStepsInvalidatesLaterSteps(t *testing.T) {
+	var runs []string
+	state := NewState("cluster")
+	save := func(*State) error { return nil }
+	if err := RunSteps(testSteps(&runs), state, StepOptions{}, save); err != nil {
+		t.Fatalf("RunSteps() error = %v", err)
+	}
+
+	// a with the same output keeps b and c completed
+	if err := RunSteps(testSteps(&runs), state, StepOptions{OnlyStep: "a"}, save); err != nil {
+		t.Fatalf("RunSteps() error = %v", err)
+	}
+	if len(state.Completed) != 3 {
+		t.Errorf("completed = %v, want the 3 steps", state.Completed)
+	}
+
+	// a with a new binlog position makes b and c incomplete
+	state.BinlogFile = "mysql-bin.000000"
+	state.Completed["a"] = time.Time{}
+	if err := RunSteps(testSteps(&runs), state, StepOptions{OnlyStep: "a"}, save); err != nil {
+		t.Fatalf("RunSteps() error = %v", err)
+	}
+	if _, ok := state.Completed["b"]; ok || len(state.Completed) != 1 {
+		t.Errorf("completed = %v, want only a", state.Completed)
+	}
+}
+
+func Test_runStepsFailure(t *testing.T) {
+	state := NewState("cluster")
+	saves := 0
+	save := func(*State) error { saves++; return nil }
+
+	// b needs the output of a
+	var runs []string
+	if err := RunSteps(testSteps(&runs), state, StepOptions{OnlyStep: "b"}, save); err == nil || !strings.Contains(err.Error(), "run the step(s) a first") {
+		t.Errorf("b without a error = %v", err)
+	}
+
+	steps := []Step{{Name: "a", Run: func(state *State) error {
+		state.BinlogFile = "mysql-bin.000001"
+		return errors.New("lambda timed out")
+	}}}
+	if err := RunSteps(steps, state, StepOptions{}, save); err == nil || !strings.Contains(err.Error(), "lambda timed out") {
+		t.Errorf("failed step error = %v", err)
+	}
+	if _, ok := state.Completed["a"]; ok || saves != 1 {
+		t.Errorf("failed step completed = %v with %d saves, want incomplete and saved", ok, saves)
+	}
+}
+
+func Test_loadState(t *testing.T) {
+	path := t.TempDir() + "/state.json"
+	state, err := LoadState(path, "cluster")
+	if err != nil || len(state.Completed) != 0 {
+		t.Fatalf("LoadState() of missing file = %v, %v", state, err)
+	}
+
+	state.BinlogFile, state.BinlogPos = "mysql-bin.000002", 4567
+	state.Completed["binlog"] = time.Now()
+	if err := state.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	reloaded, err := LoadState(path, "cluster")
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if reloaded.BinlogPos != 4567 || len(reloaded.Completed) != 1 {
+		t.Errorf("reloaded state = %+v", reloaded)
+	}
+
+	if _, err := LoadState(path, "other"); err == nil {
+		t.Errorf("LoadState() of another cluster want error")
+	}
+}
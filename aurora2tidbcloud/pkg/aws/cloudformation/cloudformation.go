@@ -22,7 +22,6 @@ import (
 	// "time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 
@@ -39,27 +38,31 @@ func MapTag() *map[string]string {
 	}
 }
 
+// CloudformationClient is the part of the cloudformation client called by CloudformationAPI
+type CloudformationClient interface {
+	CreateStack(ctx context.Context, params *cloudformation.CreateStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackOutput, error)
+	DeleteStack(ctx context.Context, params *cloudformation.DeleteStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackOutput, error)
+	DescribeStackResource(ctx context.Context, params *cloudformation.DescribeStackResourceInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackResourceOutput, error)
+	DescribeStacks(ctx context.Context, params *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error)
+	ListStacks(ctx context.Context, params *cloudformation.ListStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStacksOutput, error)
+	ListStackResources(ctx context.Context, params *cloudformation.ListStackResourcesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackResourcesOutput, error)
+}
+
 type CloudformationAPI struct {
-	client *cloudformation.Client
+	client CloudformationClient
 
 	mapArgs *map[string]string
 }
 
-func NewCFAPI(mapArgs *map[string]string) (*CloudformationAPI, error) {
-	cfApi := CloudformationAPI{}
+// NewCFAPIWithClient returns the api calling the given client, the in-memory fake in the tests
+func NewCFAPIWithClient(client CloudformationClient, mapArgs *map[string]string) *CloudformationAPI {
+	cfApi := CloudformationAPI{client: client}
 
 	if mapArgs != nil {
 		cfApi.mapArgs = mapArgs
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-
-	cfApi.client = cloudformation.NewFromConfig(cfg)
-
-	return &cfApi, nil
+	return &cfApi
 }
 
 func (e *CloudformationAPI) DestroyStack(stackName string) error {
@@ -81,6 +84,9 @@ func (e *CloudformationAPI) DestroyStack(stackName string) error {
 			if stack == nil {
 				return true, nil
 			}
+			if stack.StackStatus == types.StackStatusDeleteFailed {
+				return false, errors.New(fmt.Sprintf("Failed to delete stack %s: %s", stackName, aws.ToString(stack.StackStatusReason)))
+			}
 			return false, nil
 		}); err != nil {
 			return err
@@ -120,12 +126,60 @@ func (e *CloudformationAPI) GetStack(stackName string) (*types.StackSummary, err
 	return nil, nil
 }
 
+// Return: the resources of the stack, nil if the stack does not exist
+func (e *CloudformationAPI) ListStackResources(stackName string) ([]types.StackResourceSummary, error) {
+	stack, err := e.GetStack(stackName)
+	if err != nil {
+		return nil, err
+	}
+	if stack == nil {
+		return nil, nil
+	}
+
+	var resources []types.StackResourceSummary
+	paginator := cloudformation.NewListStackResourcesPaginator(e.client, &cloudformation.ListStackResourcesInput{StackName: aws.String(stackName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.StackResourceSummaries...)
+	}
+	return resources, nil
+}
+
+// Return: the tags of the stack, nil if the stack does not exist
+func (e *CloudformationAPI) GetStackTags(stackName string) (map[string]string, error) {
+	stack, err := e.GetStack(stackName)
+	if err != nil {
+		return nil, err
+	}
+	if stack == nil {
+		return nil, nil
+	}
+
+	resp, err := e.client.DescribeStacks(context.TODO(), &cloudformation.DescribeStacksInput{StackName: stack.StackId})
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, theStack := range resp.Stacks {
+		for _, tag := range theStack.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
 func (e *CloudformationAPI) CreateStack(stackName, fileUrl string, parameters *[]types.Parameter, tags *[]types.Tag) error {
 	stack, err := e.GetStack(stackName)
 	if err != nil {
 		return err
 	}
 	if stack == nil {
+		if tags == nil {
+			tags = e.makeTags()
+		}
 		parsedS3Dir, err := url.Parse(fileUrl)
 		if err != nil {
 			return err
@@ -215,3 +269,18 @@ func (e *CloudformationAPI) CreateStack(stackName, fileUrl string, parameters *[
 // 		}
 // 	}
 // }
+
+func (e *CloudformationAPI) makeTags() *[]types.Tag {
+	var tags []types.Tag
+	if e.mapArgs == nil {
+		return &tags
+	}
+
+	for key, tagName := range *(MapTag()) {
+		if tagValue, ok := (*e.mapArgs)[key]; ok {
+			tags = append(tags, types.Tag{Key: aws.String(tagName), Value: aws.String(tagValue)})
+		}
+	}
+
+	return &tags
+}
@@ -18,13 +18,20 @@ import (
 	"time"
 )
 
+// The poll interval and timeout of WaitUntilResouceAvailable when 0 is given. The tests against
+// the in-memory fake shorten the interval.
+var (
+	DefaultInterval = 60 * time.Second
+	DefaultTimeout  = 60 * time.Minute
+)
+
 func WaitUntilResouceAvailable(_interval, _timeout time.Duration, expectNum int, _readResource func() (bool, error)) error {
 	if _interval == 0 {
-		_interval = 60 * time.Second
+		_interval = DefaultInterval
 	}
 
 	if _timeout == 0 {
-		_timeout = 60 * time.Minute
+		_timeout = DefaultTimeout
 	}
 
 	timeout := time.After(_timeout)
@@ -0,0 +1,298 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// The resource types a template creates
+const (
+	LAMBDA_FUNCTION = "AWS::Lambda::Function"
+	S3_BUCKET       = "AWS::S3::Bucket"
+)
+
+// LambdaHandler answers the invocations of a fake lambda function
+type LambdaHandler func(payload []byte) ([]byte, error)
+
+// TemplateResource is a resource created by a template
+type TemplateResource struct {
+	LogicalId string
+	// Type is LAMBDA_FUNCTION, S3_BUCKET or any type without side effect
+	Type string
+	// Handler answers the invocations of the LAMBDA_FUNCTION
+	Handler LambdaHandler
+}
+
+// Template is the content of a template url
+type Template struct {
+	Resources []TemplateResource
+}
+
+type stackResource struct {
+	logicalId  string
+	physicalId string
+	resType    string
+}
+
+type stack struct {
+	id        string
+	name      string
+	status    types.StackStatus
+	reason    string
+	createdAt *time.Time
+	tags      map[string]string
+	resources []stackResource
+	pending   int
+}
+
+// AddTemplate registers the stack template served at the url
+func (c *Cloud) AddTemplate(url string, template Template) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.templates[url] = template
+}
+
+// StackNames returns the names of the stacks not deleted
+func (c *Cloud) StackNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := []string{}
+	for _, theStack := range c.stacks {
+		if theStack.status != types.StackStatusDeleteComplete {
+			names = append(names, theStack.name)
+		}
+	}
+	return names
+}
+
+// CloudFormation is the fake cloudformation client
+type CloudFormation struct {
+	cloud *Cloud
+}
+
+// CloudFormation returns the cloudformation client of the cloud
+func (c *Cloud) CloudFormation() *CloudFormation {
+	return &CloudFormation{cloud: c}
+}
+
+// liveStack returns the stack not deleted by name or id, the caller holds the lock
+func (c *Cloud) liveStack(nameOrId string) *stack {
+	for _, theStack := range c.stacks {
+		if (theStack.name == nameOrId || theStack.id == nameOrId) && theStack.status != types.StackStatusDeleteComplete {
+			return theStack
+		}
+	}
+	return nil
+}
+
+// advanceStacks moves the stacks in progress one step, the caller holds the lock
+func (c *Cloud) advanceStacks() {
+	for _, theStack := range c.stacks {
+		if theStack.status != types.StackStatusCreateInProgress && theStack.status != types.StackStatusDeleteInProgress {
+			continue
+		}
+		if theStack.pending > 0 {
+			theStack.pending--
+			continue
+		}
+		if theStack.status == types.StackStatusCreateInProgress {
+			theStack.status = types.StackStatusCreateComplete
+			continue
+		}
+
+		// Like cloudformation, a bucket with objects fails the deletion
+		for _, resource := range theStack.resources {
+			if theBucket, ok := c.buckets[resource.physicalId]; ok && resource.resType == S3_BUCKET && len(theBucket.objects) > 0 {
+				theStack.status = types.StackStatusDeleteFailed
+				theStack.reason = fmt.Sprintf("The bucket you tried to delete is not empty: %s", resource.physicalId)
+				break
+			}
+		}
+		if theStack.status == types.StackStatusDeleteFailed {
+			continue
+		}
+		for _, resource := range theStack.resources {
+			switch resource.resType {
+			case S3_BUCKET:
+				delete(c.buckets, resource.physicalId)
+			case LAMBDA_FUNCTION:
+				delete(c.functions, resource.physicalId)
+			}
+		}
+		theStack.status = types.StackStatusDeleteComplete
+	}
+}
+
+func (f *CloudFormation) CreateStack(ctx context.Context, params *cloudformation.CreateStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("CreateStack"); err != nil {
+		return nil, err
+	}
+
+	stackName := aws.ToString(params.StackName)
+	if c.liveStack(stackName) != nil {
+		return nil, apiError("AlreadyExistsException", "Stack [%s] already exists", stackName)
+	}
+	template, ok := c.templates[aws.ToString(params.TemplateURL)]
+	if !ok {
+		return nil, apiError("ValidationError", "TemplateURL must be a supported URL: %s", aws.ToString(params.TemplateURL))
+	}
+
+	id := c.nextId()
+	theStack := &stack{
+		id:        fmt.Sprintf("arn:aws:cloudformation:%s:%s:stack/%s/%d", REGION, ACCOUNT_ID, stackName, id),
+		name:      stackName,
+		status:    types.StackStatusCreateInProgress,
+		createdAt: now(),
+		tags:      map[string]string{},
+		pending:   c.Steps,
+	}
+	for _, tag := range params.Tags {
+		theStack.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	// The resources exist from the creation, the stack completes after Steps reads
+	for _, resource := range template.Resources {
+		physicalId := strings.ToLower(fmt.Sprintf("%s-%s-%d", stackName, resource.LogicalId, id))
+		switch resource.Type {
+		case S3_BUCKET:
+			bucketTags := copyTags(theStack.tags)
+			bucketTags["aws:cloudformation:stack-name"] = stackName
+			c.buckets[physicalId] = &bucket{createdAt: now(), tags: bucketTags, objects: map[string]*object{}}
+		case LAMBDA_FUNCTION:
+			c.functions[physicalId] = resource.Handler
+		}
+		theStack.resources = append(theStack.resources, stackResource{logicalId: resource.LogicalId, physicalId: physicalId, resType: resource.Type})
+	}
+	c.stacks = append(c.stacks, theStack)
+
+	return &cloudformation.CreateStackOutput{StackId: aws.String(theStack.id)}, nil
+}
+
+func (f *CloudFormation) DeleteStack(ctx context.Context, params *cloudformation.DeleteStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteStack"); err != nil {
+		return nil, err
+	}
+
+	// Deleting a missing stack succeeds like cloudformation
+	if theStack := c.liveStack(aws.ToString(params.StackName)); theStack != nil {
+		theStack.status = types.StackStatusDeleteInProgress
+		theStack.reason = ""
+		theStack.pending = c.Steps
+	}
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func (f *CloudFormation) DescribeStackResource(ctx context.Context, params *cloudformation.DescribeStackResourceInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackResourceOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DescribeStackResource"); err != nil {
+		return nil, err
+	}
+
+	theStack := c.liveStack(aws.ToString(params.StackName))
+	if theStack == nil {
+		return nil, apiError("ValidationError", "Stack '%s' does not exist", aws.ToString(params.StackName))
+	}
+	for _, resource := range theStack.resources {
+		if resource.logicalId == aws.ToString(params.LogicalResourceId) {
+			return &cloudformation.DescribeStackResourceOutput{StackResourceDetail: &types.StackResourceDetail{
+				LogicalResourceId:    aws.String(resource.logicalId),
+				PhysicalResourceId:   aws.String(resource.physicalId),
+				ResourceType:         aws.String(resource.resType),
+				ResourceStatus:       types.ResourceStatusCreateComplete,
+				LastUpdatedTimestamp: theStack.createdAt,
+				StackName:            aws.String(theStack.name),
+				StackId:              aws.String(theStack.id),
+			}}, nil
+		}
+	}
+	return nil, apiError("ValidationError", "Resource %s does not exist for stack %s", aws.ToString(params.LogicalResourceId), theStack.name)
+}
+
+func (f *CloudFormation) DescribeStacks(ctx context.Context, params *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DescribeStacks"); err != nil {
+		return nil, err
+	}
+
+	output := &cloudformation.DescribeStacksOutput{}
+	for _, theStack := range c.stacks {
+		if params.StackName != nil && aws.ToString(params.StackName) != theStack.id && aws.ToString(params.StackName) != theStack.name {
+			continue
+		}
+		if params.StackName != nil && aws.ToString(params.StackName) == theStack.name && theStack.status == types.StackStatusDeleteComplete {
+			continue
+		}
+		theSummary := types.Stack{
+			StackId:           aws.String(theStack.id),
+			StackName:         aws.String(theStack.name),
+			StackStatus:       theStack.status,
+			StackStatusReason: aws.String(theStack.reason),
+			CreationTime:      theStack.createdAt,
+		}
+		for _, key := range sortedKeys(theStack.tags) {
+			theSummary.Tags = append(theSummary.Tags, types.Tag{Key: aws.String(key), Value: aws.String(theStack.tags[key])})
+		}
+		output.Stacks = append(output.Stacks, theSummary)
+	}
+	if params.StackName != nil && len(output.Stacks) == 0 {
+		return nil, apiError("ValidationError", "Stack with id %s does not exist", aws.ToString(params.StackName))
+	}
+	return output, nil
+}
+
+func (f *CloudFormation) ListStacks(ctx context.Context, params *cloudformation.ListStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStacksOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListStacks"); err != nil {
+		return nil, err
+	}
+
+	c.advanceStacks()
+	output := &cloudformation.ListStacksOutput{}
+	for _, theStack := range c.stacks {
+		output.StackSummaries = append(output.StackSummaries, types.StackSummary{
+			StackId:           aws.String(theStack.id),
+			StackName:         aws.String(theStack.name),
+			StackStatus:       theStack.status,
+			StackStatusReason: aws.String(theStack.reason),
+			CreationTime:      theStack.createdAt,
+		})
+	}
+	return output, nil
+}
+
+func (f *CloudFormation) ListStackResources(ctx context.Context, params *cloudformation.ListStackResourcesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackResourcesOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListStackResources"); err != nil {
+		return nil, err
+	}
+
+	theStack := c.liveStack(aws.ToString(params.StackName))
+	if theStack == nil {
+		return nil, apiError("ValidationError", "Stack with id %s does not exist", aws.ToString(params.StackName))
+	}
+	output := &cloudformation.ListStackResourcesOutput{}
+	for _, resource := range theStack.resources {
+		output.StackResourceSummaries = append(output.StackResourceSummaries, types.StackResourceSummary{
+			LogicalResourceId:    aws.String(resource.logicalId),
+			PhysicalResourceId:   aws.String(resource.physicalId),
+			ResourceType:         aws.String(resource.resType),
+			ResourceStatus:       types.ResourceStatusCreateComplete,
+			LastUpdatedTimestamp: theStack.createdAt,
+		})
+	}
+	return output, nil
+}
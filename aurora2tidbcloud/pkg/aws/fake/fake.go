@@ -0,0 +1,164 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fake is an in-memory AWS and TiDB Cloud for the offline tests. One Cloud keeps the
+// state shared by the fake clients: the stacks create their lambda functions and buckets, the
+// export tasks write their data into the buckets and the import tasks read it.
+//
+// The stacks, snapshots and export tasks stay in progress for Steps reads of their describe
+// call, then complete like the real services.
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+
+	cfapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/cloudformation"
+	iamapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/iam"
+	kmsapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/kms"
+	rdsapilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/rds"
+	s3apilib "github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/aws/s3"
+)
+
+// The fake clients replace the clients of the aws apis
+var (
+	_ cfapilib.CloudformationClient = (*CloudFormation)(nil)
+	_ iamapilib.IAMClient           = (*IAM)(nil)
+	_ kmsapilib.KmsClient           = (*KMS)(nil)
+	_ rdsapilib.RdsClient           = (*RDS)(nil)
+	_ s3apilib.S3Client             = (*S3)(nil)
+)
+
+const (
+	// ACCOUNT_ID is the account of the fake resources
+	ACCOUNT_ID = "123456789012"
+	// REGION is the region of the fake resources
+	REGION = "us-east-1"
+)
+
+// Cloud is the state of the fake services
+type Cloud struct {
+	mu sync.Mutex
+
+	// Steps is the number of describe calls a stack, snapshot or export task stays in progress
+	Steps int
+
+	seq         int
+	calls       map[string]int
+	failures    map[string][]error
+	templates   map[string]Template
+	stacks      []*stack
+	functions   map[string]LambdaHandler
+	buckets     map[string]*bucket
+	policies    map[string]*policy
+	roles       map[string]*role
+	keys        []*key
+	clusters    map[string][]string
+	snapshots   []*snapshot
+	exportTasks []*exportTask
+	importTasks []*importTask
+}
+
+// New returns an empty cloud
+func New() *Cloud {
+	return &Cloud{
+		Steps:     2,
+		calls:     map[string]int{},
+		failures:  map[string][]error{},
+		templates: map[string]Template{},
+		functions: map[string]LambdaHandler{},
+		buckets:   map[string]*bucket{},
+		policies:  map[string]*policy{},
+		roles:     map[string]*role{},
+		clusters:  map[string][]string{},
+	}
+}
+
+// Fail makes the next call of the operation, e.g. StartExportTask, return the error. Several
+// errors fail the following calls in order, a nil error lets the call succeed.
+func (c *Cloud) Fail(operation string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures[operation] = append(c.failures[operation], err)
+}
+
+// Calls returns the number of calls of the operation
+func (c *Cloud) Calls(operation string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[operation]
+}
+
+// call counts the operation and returns the injected failure, the caller holds the lock
+func (c *Cloud) call(operation string) error {
+	c.calls[operation]++
+	if errs := c.failures[operation]; len(errs) > 0 {
+		c.failures[operation] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// begin locks the cloud for the operation, the returned error is the injected failure
+func (c *Cloud) begin(operation string) error {
+	c.mu.Lock()
+	return c.call(operation)
+}
+
+func (c *Cloud) nextId() int {
+	c.seq++
+	return c.seq
+}
+
+func apiError(code, format string, args ...interface{}) error {
+	return &smithy.GenericAPIError{Code: code, Message: fmt.Sprintf(format, args...), Fault: smithy.FaultClient}
+}
+
+func now() *time.Time {
+	theTime := time.Now()
+	return &theTime
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	theTags := map[string]string{}
+	for key, value := range tags {
+		theTags[key] = value
+	}
+	return theTags
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitS3Url returns the bucket and the key of s3://bucket/key
+func splitS3Url(s3Url string) (string, string, error) {
+	if !strings.HasPrefix(s3Url, "s3://") {
+		return "", "", fmt.Errorf("invalid s3 url %s", s3Url)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s3Url, "s3://"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,327 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+type policy struct {
+	name      string
+	path      string
+	arn       string
+	document  string
+	createdAt *time.Time
+	tags      map[string]string
+}
+
+type role struct {
+	name      string
+	path      string
+	arn       string
+	createdAt *time.Time
+	tags      map[string]string
+	// attached are the arns of the attached policies
+	attached []string
+}
+
+// RoleNames returns the names of the roles
+func (c *Cloud) RoleNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := []string{}
+	for name := range c.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PolicyNames returns the names of the customer managed policies
+func (c *Cloud) PolicyNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := []string{}
+	for _, thePolicy := range c.policies {
+		names = append(names, thePolicy.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IAM is the fake iam client
+type IAM struct {
+	cloud *Cloud
+}
+
+// IAM returns the iam client of the cloud
+func (c *Cloud) IAM() *IAM {
+	return &IAM{cloud: c}
+}
+
+func iamTags(tags []types.Tag) map[string]string {
+	theTags := map[string]string{}
+	for _, tag := range tags {
+		theTags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return theTags
+}
+
+func iamPath(path *string) string {
+	if path == nil {
+		return "/"
+	}
+	return *path
+}
+
+func (f *IAM) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("AttachRolePolicy"); err != nil {
+		return nil, err
+	}
+
+	theRole, ok := c.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, apiError("NoSuchEntity", "The role with name %s cannot be found.", aws.ToString(params.RoleName))
+	}
+	if _, ok := c.policies[aws.ToString(params.PolicyArn)]; !ok {
+		return nil, apiError("NoSuchEntity", "Policy %s does not exist or is not attachable.", aws.ToString(params.PolicyArn))
+	}
+	for _, policyArn := range theRole.attached {
+		if policyArn == aws.ToString(params.PolicyArn) {
+			return &iam.AttachRolePolicyOutput{}, nil
+		}
+	}
+	theRole.attached = append(theRole.attached, aws.ToString(params.PolicyArn))
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (f *IAM) CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("CreatePolicy"); err != nil {
+		return nil, err
+	}
+
+	path := iamPath(params.Path)
+	policyArn := fmt.Sprintf("arn:aws:iam::%s:policy%s%s", ACCOUNT_ID, path, aws.ToString(params.PolicyName))
+	if _, ok := c.policies[policyArn]; ok {
+		return nil, apiError("EntityAlreadyExists", "A policy called %s already exists.", aws.ToString(params.PolicyName))
+	}
+	thePolicy := &policy{
+		name:      aws.ToString(params.PolicyName),
+		path:      path,
+		arn:       policyArn,
+		document:  aws.ToString(params.PolicyDocument),
+		createdAt: now(),
+		tags:      iamTags(params.Tags),
+	}
+	c.policies[policyArn] = thePolicy
+	return &iam.CreatePolicyOutput{Policy: c.policyOf(thePolicy)}, nil
+}
+
+// policyOf returns the policy as the api does, the caller holds the lock
+func (c *Cloud) policyOf(thePolicy *policy) *types.Policy {
+	attachments := int32(0)
+	for _, theRole := range c.roles {
+		for _, policyArn := range theRole.attached {
+			if policyArn == thePolicy.arn {
+				attachments++
+			}
+		}
+	}
+	return &types.Policy{
+		PolicyName:      aws.String(thePolicy.name),
+		Arn:             aws.String(thePolicy.arn),
+		Path:            aws.String(thePolicy.path),
+		AttachmentCount: aws.Int32(attachments),
+		CreateDate:      thePolicy.createdAt,
+	}
+}
+
+func (f *IAM) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("CreateRole"); err != nil {
+		return nil, err
+	}
+
+	roleName := aws.ToString(params.RoleName)
+	if _, ok := c.roles[roleName]; ok {
+		return nil, apiError("EntityAlreadyExists", "Role with name %s already exists.", roleName)
+	}
+	path := iamPath(params.Path)
+	theRole := &role{
+		name:      roleName,
+		path:      path,
+		arn:       fmt.Sprintf("arn:aws:iam::%s:role%s%s", ACCOUNT_ID, path, roleName),
+		createdAt: now(),
+		tags:      iamTags(params.Tags),
+	}
+	c.roles[roleName] = theRole
+	return &iam.CreateRoleOutput{Role: roleOf(theRole)}, nil
+}
+
+func roleOf(theRole *role) *types.Role {
+	return &types.Role{
+		RoleName:   aws.String(theRole.name),
+		Arn:        aws.String(theRole.arn),
+		Path:       aws.String(theRole.path),
+		CreateDate: theRole.createdAt,
+	}
+}
+
+func (f *IAM) DeletePolicy(ctx context.Context, params *iam.DeletePolicyInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DeletePolicy"); err != nil {
+		return nil, err
+	}
+
+	policyArn := aws.ToString(params.PolicyArn)
+	if _, ok := c.policies[policyArn]; !ok {
+		return nil, apiError("NoSuchEntity", "Policy %s was not found.", policyArn)
+	}
+	for _, theRole := range c.roles {
+		for _, attachedArn := range theRole.attached {
+			if attachedArn == policyArn {
+				return nil, apiError("DeleteConflict", "Cannot delete a policy attached to entities.")
+			}
+		}
+	}
+	delete(c.policies, policyArn)
+	return &iam.DeletePolicyOutput{}, nil
+}
+
+func (f *IAM) DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteRole"); err != nil {
+		return nil, err
+	}
+
+	theRole, ok := c.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, apiError("NoSuchEntity", "The role with name %s cannot be found.", aws.ToString(params.RoleName))
+	}
+	if len(theRole.attached) > 0 {
+		return nil, apiError("DeleteConflict", "Cannot delete entity, must detach all policies first.")
+	}
+	delete(c.roles, theRole.name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (f *IAM) DetachRolePolicy(ctx context.Context, params *iam.DetachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DetachRolePolicy"); err != nil {
+		return nil, err
+	}
+
+	theRole, ok := c.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, apiError("NoSuchEntity", "The role with name %s cannot be found.", aws.ToString(params.RoleName))
+	}
+	for idx, policyArn := range theRole.attached {
+		if policyArn == aws.ToString(params.PolicyArn) {
+			theRole.attached = append(theRole.attached[:idx], theRole.attached[idx+1:]...)
+			return &iam.DetachRolePolicyOutput{}, nil
+		}
+	}
+	return nil, apiError("NoSuchEntity", "Policy %s was not found.", aws.ToString(params.PolicyArn))
+}
+
+func (f *IAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListAttachedRolePolicies"); err != nil {
+		return nil, err
+	}
+
+	theRole, ok := c.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, apiError("NoSuchEntity", "The role with name %s cannot be found.", aws.ToString(params.RoleName))
+	}
+	output := &iam.ListAttachedRolePoliciesOutput{}
+	for _, policyArn := range theRole.attached {
+		output.AttachedPolicies = append(output.AttachedPolicies, types.AttachedPolicy{
+			PolicyArn:  aws.String(policyArn),
+			PolicyName: aws.String(c.policies[policyArn].name),
+		})
+	}
+	return output, nil
+}
+
+func (f *IAM) ListPolicies(ctx context.Context, params *iam.ListPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListPoliciesOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListPolicies"); err != nil {
+		return nil, err
+	}
+
+	// Only the customer managed policies exist in the fake, whatever the scope
+	output := &iam.ListPoliciesOutput{}
+	for _, policyArn := range sortedPolicyArns(c.policies) {
+		thePolicy := c.policies[policyArn]
+		if strings.HasPrefix(thePolicy.path, iamPath(params.PathPrefix)) {
+			output.Policies = append(output.Policies, *c.policyOf(thePolicy))
+		}
+	}
+	return output, nil
+}
+
+func sortedPolicyArns(policies map[string]*policy) []string {
+	arns := make([]string, 0, len(policies))
+	for policyArn := range policies {
+		arns = append(arns, policyArn)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+func (f *IAM) ListRoleTags(ctx context.Context, params *iam.ListRoleTagsInput, optFns ...func(*iam.Options)) (*iam.ListRoleTagsOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListRoleTags"); err != nil {
+		return nil, err
+	}
+
+	theRole, ok := c.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, apiError("NoSuchEntity", "The role with name %s cannot be found.", aws.ToString(params.RoleName))
+	}
+	output := &iam.ListRoleTagsOutput{}
+	for _, key := range sortedKeys(theRole.tags) {
+		output.Tags = append(output.Tags, types.Tag{Key: aws.String(key), Value: aws.String(theRole.tags[key])})
+	}
+	return output, nil
+}
+
+func (f *IAM) ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListRoles"); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.roles))
+	for name := range c.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output := &iam.ListRolesOutput{}
+	for _, name := range names {
+		if theRole := c.roles[name]; strings.HasPrefix(theRole.path, iamPath(params.PathPrefix)) {
+			output.Roles = append(output.Roles, *roleOf(theRole))
+		}
+	}
+	return output, nil
+}
@@ -0,0 +1,105 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+type key struct {
+	id    string
+	arn   string
+	alias string
+	tags  map[string]string
+}
+
+// AddKey creates the kms key with the alias and returns its arn
+func (c *Cloud) AddKey(alias string, tags map[string]string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := fmt.Sprintf("00000000-0000-0000-0000-%012d", c.nextId())
+	theKey := &key{
+		id:    id,
+		arn:   fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", REGION, ACCOUNT_ID, id),
+		alias: alias,
+		tags:  copyTags(tags),
+	}
+	c.keys = append(c.keys, theKey)
+	return theKey.arn
+}
+
+// KMS is the fake kms client
+type KMS struct {
+	cloud *Cloud
+}
+
+// KMS returns the kms client of the cloud
+func (c *Cloud) KMS() *KMS {
+	return &KMS{cloud: c}
+}
+
+// keyOf returns the key by id or arn, the caller holds the lock
+func (c *Cloud) keyOf(keyId string) (*key, error) {
+	for _, theKey := range c.keys {
+		if theKey.id == keyId || theKey.arn == keyId {
+			return theKey, nil
+		}
+	}
+	return nil, apiError("NotFoundException", "Key '%s' does not exist", keyId)
+}
+
+func (f *KMS) ListAliases(ctx context.Context, params *kms.ListAliasesInput, optFns ...func(*kms.Options)) (*kms.ListAliasesOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListAliases"); err != nil {
+		return nil, err
+	}
+
+	output := &kms.ListAliasesOutput{}
+	for _, theKey := range c.keys {
+		if theKey.alias == "" || (params.KeyId != nil && aws.ToString(params.KeyId) != theKey.id && aws.ToString(params.KeyId) != theKey.arn) {
+			continue
+		}
+		output.Aliases = append(output.Aliases, types.AliasListEntry{
+			AliasName:   aws.String(fmt.Sprintf("alias/%s", theKey.alias)),
+			AliasArn:    aws.String(fmt.Sprintf("arn:aws:kms:%s:%s:alias/%s", REGION, ACCOUNT_ID, theKey.alias)),
+			TargetKeyId: aws.String(theKey.id),
+		})
+	}
+	return output, nil
+}
+
+func (f *KMS) ListKeys(ctx context.Context, params *kms.ListKeysInput, optFns ...func(*kms.Options)) (*kms.ListKeysOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListKeys"); err != nil {
+		return nil, err
+	}
+
+	output := &kms.ListKeysOutput{}
+	for _, theKey := range c.keys {
+		output.Keys = append(output.Keys, types.KeyListEntry{KeyId: aws.String(theKey.id), KeyArn: aws.String(theKey.arn)})
+	}
+	return output, nil
+}
+
+func (f *KMS) ListResourceTags(ctx context.Context, params *kms.ListResourceTagsInput, optFns ...func(*kms.Options)) (*kms.ListResourceTagsOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListResourceTags"); err != nil {
+		return nil, err
+	}
+
+	theKey, err := c.keyOf(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	output := &kms.ListResourceTagsOutput{}
+	for _, tagKey := range sortedKeys(theKey.tags) {
+		output.Tags = append(output.Tags, types.Tag{TagKey: aws.String(tagKey), TagValue: aws.String(theKey.tags[tagKey])})
+	}
+	return output, nil
+}
@@ -0,0 +1,43 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+)
+
+// Lambda is the fake lambda client invoking the handlers of the templates
+type Lambda struct {
+	cloud *Cloud
+}
+
+// Lambda returns the lambda client of the cloud
+func (c *Cloud) Lambda() *Lambda {
+	return &Lambda{cloud: c}
+}
+
+func (f *Lambda) Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
+	c := f.cloud
+	if err := c.begin("Invoke"); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	handler, ok := c.functions[aws.ToString(params.FunctionName)]
+	// The handler runs unlocked, it may write into the buckets of the cloud
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, apiError("ResourceNotFoundException", "Function not found: %s", aws.ToString(params.FunctionName))
+	}
+	if handler == nil {
+		return &lambda.InvokeOutput{StatusCode: 200, Payload: []byte("null")}, nil
+	}
+
+	payload, err := handler(params.Payload)
+	if err != nil {
+		// Like lambda, the error of the function is in the payload, not in the call
+		return &lambda.InvokeOutput{StatusCode: 200, FunctionError: aws.String("Unhandled"), Payload: []byte(err.Error())}, nil
+	}
+	return &lambda.InvokeOutput{StatusCode: 200, Payload: payload}, nil
+}
@@ -0,0 +1,353 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+// The size of the snapshots and of the parquet files exported per table
+const (
+	SNAPSHOT_STORAGE_GIB = 10
+	PARQUET_SIZE         = 1024 * 1024
+)
+
+type snapshot struct {
+	id        string
+	arn       string
+	cluster   string
+	status    string
+	createdAt *time.Time
+	tags      []types.Tag
+	pending   int
+}
+
+type exportTask struct {
+	id         string
+	sourceArn  string
+	roleArn    string
+	kmsKeyId   string
+	bucket     string
+	prefix     string
+	status     string
+	startedAt  *time.Time
+	finishedAt *time.Time
+	pending    int
+}
+
+// AddCluster creates the aurora cluster, the export of its snapshots writes one parquet file
+// per table, e.g. test.orders
+func (c *Cloud) AddCluster(name string, tables []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clusters[name] = append([]string{}, tables...)
+}
+
+// SnapshotIds returns the identifiers of the snapshots
+func (c *Cloud) SnapshotIds() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := []string{}
+	for _, theSnapshot := range c.snapshots {
+		ids = append(ids, theSnapshot.id)
+	}
+	return ids
+}
+
+// ExportTaskStatus returns the status of the export task, empty if it does not exist
+func (c *Cloud) ExportTaskStatus(taskId string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, theTask := range c.exportTasks {
+		if theTask.id == taskId {
+			return theTask.status
+		}
+	}
+	return ""
+}
+
+// RDS is the fake rds client
+type RDS struct {
+	cloud *Cloud
+}
+
+// RDS returns the rds client of the cloud
+func (c *Cloud) RDS() *RDS {
+	return &RDS{cloud: c}
+}
+
+// snapshotOf returns the snapshot by identifier or arn, the caller holds the lock
+func (c *Cloud) snapshotOf(id string) *snapshot {
+	for _, theSnapshot := range c.snapshots {
+		if theSnapshot.id == id || theSnapshot.arn == id {
+			return theSnapshot
+		}
+	}
+	return nil
+}
+
+// advanceSnapshots moves the snapshots being created one step, the caller holds the lock
+func (c *Cloud) advanceSnapshots() {
+	for _, theSnapshot := range c.snapshots {
+		if theSnapshot.status != "creating" {
+			continue
+		}
+		if theSnapshot.pending > 0 {
+			theSnapshot.pending--
+			continue
+		}
+		theSnapshot.status = "available"
+	}
+}
+
+// advanceExportTasks moves the running export tasks one step, the caller holds the lock. The
+// completed task writes the tables of the cluster under <prefix>/<task id>/.
+func (c *Cloud) advanceExportTasks() {
+	for _, theTask := range c.exportTasks {
+		switch theTask.status {
+		case "CANCELING":
+			theTask.status, theTask.finishedAt = "CANCELED", now()
+			continue
+		case "STARTING", "IN_PROGRESS":
+		default:
+			continue
+		}
+		if theTask.pending > 0 {
+			theTask.pending--
+			theTask.status = "IN_PROGRESS"
+			continue
+		}
+
+		theSnapshot := c.snapshotOf(theTask.sourceArn)
+		if theSnapshot == nil {
+			theTask.status, theTask.finishedAt = "FAILED", now()
+			continue
+		}
+		for _, table := range c.clusters[theSnapshot.cluster] {
+			database := strings.SplitN(table, ".", 2)[0]
+			key := strings.TrimLeft(fmt.Sprintf("%s/%s/%s/%s/1/part-00000.gz.parquet", theTask.prefix, theTask.id, database, table), "/")
+			if err := c.putObject(theTask.bucket, key, PARQUET_SIZE); err != nil {
+				theTask.status, theTask.finishedAt = "FAILED", now()
+				break
+			}
+		}
+		if theTask.status != "FAILED" {
+			theTask.status, theTask.finishedAt = "COMPLETE", now()
+		}
+	}
+}
+
+func (t *exportTask) exportTaskOf() types.ExportTask {
+	theTask := types.ExportTask{
+		ExportTaskIdentifier: aws.String(t.id),
+		SourceArn:            aws.String(t.sourceArn),
+		IamRoleArn:           aws.String(t.roleArn),
+		KmsKeyId:             aws.String(t.kmsKeyId),
+		S3Bucket:             aws.String(t.bucket),
+		S3Prefix:             aws.String(t.prefix),
+		Status:               aws.String(t.status),
+		TaskStartTime:        t.startedAt,
+		TaskEndTime:          t.finishedAt,
+	}
+	if t.status == "COMPLETE" {
+		theTask.PercentProgress, theTask.TotalExtractedDataInGB = 100, 1
+	}
+	if t.status == "FAILED" {
+		theTask.FailureCause = aws.String("The export of the snapshot failed")
+	}
+	return theTask
+}
+
+func (f *RDS) CancelExportTask(ctx context.Context, params *rds.CancelExportTaskInput, optFns ...func(*rds.Options)) (*rds.CancelExportTaskOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("CancelExportTask"); err != nil {
+		return nil, err
+	}
+
+	for _, theTask := range c.exportTasks {
+		if theTask.id != aws.ToString(params.ExportTaskIdentifier) {
+			continue
+		}
+		if theTask.status != "STARTING" && theTask.status != "IN_PROGRESS" {
+			return nil, apiError("InvalidExportTaskStateFault", "Export task %s is %s", theTask.id, theTask.status)
+		}
+		theTask.status = "CANCELING"
+		exported := theTask.exportTaskOf()
+		return &rds.CancelExportTaskOutput{ExportTaskIdentifier: exported.ExportTaskIdentifier, SourceArn: exported.SourceArn, Status: exported.Status}, nil
+	}
+	return nil, apiError("ExportTaskNotFound", "Export task %s not found", aws.ToString(params.ExportTaskIdentifier))
+}
+
+func (f *RDS) CreateDBClusterSnapshot(ctx context.Context, params *rds.CreateDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.CreateDBClusterSnapshotOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("CreateDBClusterSnapshot"); err != nil {
+		return nil, err
+	}
+
+	clusterName := aws.ToString(params.DBClusterIdentifier)
+	if _, ok := c.clusters[clusterName]; !ok {
+		return nil, apiError("DBClusterNotFoundFault", "DBCluster %s not found.", clusterName)
+	}
+	snapshotId := aws.ToString(params.DBClusterSnapshotIdentifier)
+	if c.snapshotOf(snapshotId) != nil {
+		return nil, apiError("DBClusterSnapshotAlreadyExistsFault", "Cannot create the snapshot because a snapshot with the identifier %s already exists.", snapshotId)
+	}
+	theSnapshot := &snapshot{
+		id:        snapshotId,
+		arn:       fmt.Sprintf("arn:aws:rds:%s:%s:cluster-snapshot:%s", REGION, ACCOUNT_ID, snapshotId),
+		cluster:   clusterName,
+		status:    "creating",
+		createdAt: now(),
+		tags:      append([]types.Tag{}, params.Tags...),
+		pending:   c.Steps,
+	}
+	c.snapshots = append(c.snapshots, theSnapshot)
+	return &rds.CreateDBClusterSnapshotOutput{DBClusterSnapshot: theSnapshot.snapshotOf()}, nil
+}
+
+func (s *snapshot) snapshotOf() *types.DBClusterSnapshot {
+	return &types.DBClusterSnapshot{
+		DBClusterSnapshotIdentifier: aws.String(s.id),
+		DBClusterSnapshotArn:        aws.String(s.arn),
+		DBClusterIdentifier:         aws.String(s.cluster),
+		SnapshotType:                aws.String("manual"),
+		Status:                      aws.String(s.status),
+		SnapshotCreateTime:          s.createdAt,
+		AllocatedStorage:            SNAPSHOT_STORAGE_GIB,
+		TagList:                     append([]types.Tag{}, s.tags...),
+	}
+}
+
+func (f *RDS) DeleteDBClusterSnapshot(ctx context.Context, params *rds.DeleteDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.DeleteDBClusterSnapshotOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteDBClusterSnapshot"); err != nil {
+		return nil, err
+	}
+
+	for idx, theSnapshot := range c.snapshots {
+		if theSnapshot.id == aws.ToString(params.DBClusterSnapshotIdentifier) {
+			c.snapshots = append(c.snapshots[:idx], c.snapshots[idx+1:]...)
+			return &rds.DeleteDBClusterSnapshotOutput{DBClusterSnapshot: theSnapshot.snapshotOf()}, nil
+		}
+	}
+	return nil, apiError("DBClusterSnapshotNotFoundFault", "DBClusterSnapshot %s not found.", aws.ToString(params.DBClusterSnapshotIdentifier))
+}
+
+func (f *RDS) DescribeDBClusterSnapshots(ctx context.Context, params *rds.DescribeDBClusterSnapshotsInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClusterSnapshotsOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DescribeDBClusterSnapshots"); err != nil {
+		return nil, err
+	}
+
+	c.advanceSnapshots()
+	output := &rds.DescribeDBClusterSnapshotsOutput{}
+	for _, theSnapshot := range c.snapshots {
+		if params.DBClusterIdentifier != nil && aws.ToString(params.DBClusterIdentifier) != theSnapshot.cluster {
+			continue
+		}
+		if params.DBClusterSnapshotIdentifier != nil && aws.ToString(params.DBClusterSnapshotIdentifier) != theSnapshot.id {
+			continue
+		}
+		// All the snapshots of the fake are manual
+		if params.SnapshotType != nil && aws.ToString(params.SnapshotType) != "manual" {
+			continue
+		}
+		output.DBClusterSnapshots = append(output.DBClusterSnapshots, *theSnapshot.snapshotOf())
+	}
+	return output, nil
+}
+
+func (f *RDS) DescribeExportTasks(ctx context.Context, params *rds.DescribeExportTasksInput, optFns ...func(*rds.Options)) (*rds.DescribeExportTasksOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DescribeExportTasks"); err != nil {
+		return nil, err
+	}
+
+	c.advanceExportTasks()
+	output := &rds.DescribeExportTasksOutput{}
+	for _, theTask := range c.exportTasks {
+		if params.SourceArn != nil && aws.ToString(params.SourceArn) != theTask.sourceArn {
+			continue
+		}
+		if params.ExportTaskIdentifier != nil && aws.ToString(params.ExportTaskIdentifier) != theTask.id {
+			continue
+		}
+		output.ExportTasks = append(output.ExportTasks, theTask.exportTaskOf())
+	}
+	return output, nil
+}
+
+func (f *RDS) StartExportTask(ctx context.Context, params *rds.StartExportTaskInput, optFns ...func(*rds.Options)) (*rds.StartExportTaskOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("StartExportTask"); err != nil {
+		return nil, err
+	}
+
+	taskId := aws.ToString(params.ExportTaskIdentifier)
+	for _, theTask := range c.exportTasks {
+		if theTask.id == taskId {
+			return nil, apiError("ExportTaskAlreadyExists", "Export task %s already exists", taskId)
+		}
+	}
+	theSnapshot := c.snapshotOf(aws.ToString(params.SourceArn))
+	if theSnapshot == nil {
+		return nil, apiError("DBClusterSnapshotNotFoundFault", "DBClusterSnapshot %s not found.", aws.ToString(params.SourceArn))
+	}
+	if theSnapshot.status != "available" {
+		return nil, apiError("InvalidExportSourceState", "The snapshot %s is %s", theSnapshot.id, theSnapshot.status)
+	}
+	if !c.hasRoleArn(aws.ToString(params.IamRoleArn)) {
+		return nil, apiError("IamRoleNotFound", "The IAM role %s is not found", aws.ToString(params.IamRoleArn))
+	}
+	if _, err := c.keyOf(aws.ToString(params.KmsKeyId)); err != nil {
+		return nil, apiError("KMSKeyNotAccessibleFault", "The KMS key %s is not accessible", aws.ToString(params.KmsKeyId))
+	}
+	if _, err := c.bucketOf(aws.ToString(params.S3BucketName)); err != nil {
+		return nil, apiError("InvalidS3BucketFault", "The S3 bucket %s does not exist", aws.ToString(params.S3BucketName))
+	}
+
+	theTask := &exportTask{
+		id:        taskId,
+		sourceArn: theSnapshot.arn,
+		roleArn:   aws.ToString(params.IamRoleArn),
+		kmsKeyId:  aws.ToString(params.KmsKeyId),
+		bucket:    aws.ToString(params.S3BucketName),
+		prefix:    aws.ToString(params.S3Prefix),
+		status:    "STARTING",
+		startedAt: now(),
+		pending:   c.Steps,
+	}
+	c.exportTasks = append(c.exportTasks, theTask)
+	exported := theTask.exportTaskOf()
+	return &rds.StartExportTaskOutput{
+		ExportTaskIdentifier: exported.ExportTaskIdentifier,
+		SourceArn:            exported.SourceArn,
+		IamRoleArn:           exported.IamRoleArn,
+		KmsKeyId:             exported.KmsKeyId,
+		S3Bucket:             exported.S3Bucket,
+		S3Prefix:             exported.S3Prefix,
+		Status:               exported.Status,
+		TaskStartTime:        exported.TaskStartTime,
+	}, nil
+}
+
+// hasRoleArn reports whether the role exists, the caller holds the lock
+func (c *Cloud) hasRoleArn(roleArn string) bool {
+	for _, theRole := range c.roles {
+		if theRole.arn == roleArn {
+			return true
+		}
+	}
+	return false
+}
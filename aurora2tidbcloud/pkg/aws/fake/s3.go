@@ -0,0 +1,222 @@
+package fake
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3_MAX_KEYS is the page size of ListObjectsV2
+const S3_MAX_KEYS = 1000
+
+type object struct {
+	size       int64
+	modifiedAt *time.Time
+}
+
+type bucket struct {
+	createdAt *time.Time
+	tags      map[string]string
+	objects   map[string]*object
+}
+
+// AddBucket creates the bucket outside of any stack
+func (c *Cloud) AddBucket(name string, tags map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buckets[name] = &bucket{createdAt: now(), tags: copyTags(tags), objects: map[string]*object{}}
+}
+
+// PutObject writes the object into the bucket
+func (c *Cloud) PutObject(bucketName, key string, size int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.putObject(bucketName, key, size)
+}
+
+// putObject writes the object, the caller holds the lock
+func (c *Cloud) putObject(bucketName, key string, size int64) error {
+	theBucket, ok := c.buckets[bucketName]
+	if !ok {
+		return apiError("NoSuchBucket", "The specified bucket does not exist: %s", bucketName)
+	}
+	theBucket.objects[key] = &object{size: size, modifiedAt: now()}
+	return nil
+}
+
+// Objects returns the sorted keys of the bucket under the prefix, nil if the bucket does not exist
+func (c *Cloud) Objects(bucketName, prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	theBucket, ok := c.buckets[bucketName]
+	if !ok {
+		return nil
+	}
+	return theBucket.keys(prefix)
+}
+
+// BucketNames returns the names of the buckets
+func (c *Cloud) BucketNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := []string{}
+	for name := range c.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *bucket) keys(prefix string) []string {
+	keys := []string{}
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// S3 is the fake s3 client
+type S3 struct {
+	cloud *Cloud
+}
+
+// S3 returns the s3 client of the cloud
+func (c *Cloud) S3() *S3 {
+	return &S3{cloud: c}
+}
+
+// bucketOf returns the bucket by name, the caller holds the lock
+func (c *Cloud) bucketOf(bucketName string) (*bucket, error) {
+	theBucket, ok := c.buckets[bucketName]
+	if !ok {
+		return nil, apiError("NoSuchBucket", "The specified bucket does not exist: %s", bucketName)
+	}
+	return theBucket, nil
+}
+
+func (f *S3) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("DeleteObjects"); err != nil {
+		return nil, err
+	}
+
+	theBucket, err := c.bucketOf(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.DeleteObjectsOutput{}
+	for _, objectId := range params.Delete.Objects {
+		// Deleting a missing key succeeds like s3
+		delete(theBucket.objects, aws.ToString(objectId.Key))
+		output.Deleted = append(output.Deleted, types.DeletedObject{Key: objectId.Key})
+	}
+	return output, nil
+}
+
+func (f *S3) GetBucketTagging(ctx context.Context, params *s3.GetBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.GetBucketTaggingOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("GetBucketTagging"); err != nil {
+		return nil, err
+	}
+
+	theBucket, err := c.bucketOf(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if len(theBucket.tags) == 0 {
+		return nil, apiError("NoSuchTagSet", "The TagSet does not exist")
+	}
+	output := &s3.GetBucketTaggingOutput{}
+	for _, key := range sortedKeys(theBucket.tags) {
+		output.TagSet = append(output.TagSet, types.Tag{Key: aws.String(key), Value: aws.String(theBucket.tags[key])})
+	}
+	return output, nil
+}
+
+func (f *S3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("GetObject"); err != nil {
+		return nil, err
+	}
+
+	theBucket, err := c.bucketOf(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	theObject, ok := theBucket.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, apiError("NoSuchKey", "The specified key does not exist.")
+	}
+	return &s3.GetObjectOutput{ContentLength: theObject.size, LastModified: theObject.modifiedAt}, nil
+}
+
+func (f *S3) ListBuckets(ctx context.Context, params *s3.ListBucketsInput, optFns ...func(*s3.Options)) (*s3.ListBucketsOutput, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListBuckets"); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.buckets))
+	for name := range c.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	output := &s3.ListBucketsOutput{}
+	for _, name := range names {
+		output.Buckets = append(output.Buckets, types.Bucket{Name: aws.String(name), CreationDate: c.buckets[name].createdAt})
+	}
+	return output, nil
+}
+
+func (f *S3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c := f.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListObjectsV2"); err != nil {
+		return nil, err
+	}
+
+	theBucket, err := c.bucketOf(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	// The continuation token is the index of the first key of the page
+	start := 0
+	if params.ContinuationToken != nil {
+		if start, err = strconv.Atoi(*params.ContinuationToken); err != nil {
+			return nil, apiError("InvalidArgument", "The continuation token provided is incorrect")
+		}
+	}
+	keys := theBucket.keys(aws.ToString(params.Prefix))
+	if start > len(keys) {
+		start = len(keys)
+	}
+	end := start + S3_MAX_KEYS
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	output := &s3.ListObjectsV2Output{Name: params.Bucket, Prefix: params.Prefix, KeyCount: int32(end - start)}
+	for _, key := range keys[start:end] {
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key), Size: theBucket.objects[key].size, LastModified: theBucket.objects[key].modifiedAt})
+	}
+	if end < len(keys) {
+		output.IsTruncated = true
+		output.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
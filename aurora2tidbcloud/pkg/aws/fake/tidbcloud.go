@@ -0,0 +1,162 @@
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/luyomo/cheatsheet/aurora2tidbcloud/pkg/tidbcloud"
+)
+
+// The aws account and external id TiDB Cloud assumes the import role with
+const (
+	TIDBCLOUD_ACCOUNT_ID  = "arn:aws:iam::380838443567:root"
+	TIDBCLOUD_EXTERNAL_ID = "fake-external-id"
+)
+
+type importTask struct {
+	id          string
+	projectId   string
+	clusterName string
+	name        string
+	sourceUri   string
+	sourceType  string
+	sizeBytes   int64
+	createdAt   time.Time
+	phase       string
+	message     string
+}
+
+// TiDBCloud is the fake TiDB Cloud api of one cluster, the import tasks complete at once unless
+// SetImportTaskPhase changes them
+type TiDBCloud struct {
+	cloud       *Cloud
+	projectId   string
+	clusterName string
+}
+
+// TiDBCloud returns the TiDB Cloud api of the cluster of the project
+func (c *Cloud) TiDBCloud(projectId, clusterName string) *TiDBCloud {
+	return &TiDBCloud{cloud: c, projectId: projectId, clusterName: clusterName}
+}
+
+// SetImportTaskPhase changes the phase of the import task, e.g. to FAILED with the error message
+func (c *Cloud) SetImportTaskPhase(id, phase, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, theTask := range c.importTasks {
+		if theTask.id == id {
+			theTask.phase, theTask.message = phase, message
+		}
+	}
+}
+
+// ImportSources returns the s3 urls of the import tasks in order
+func (c *Cloud) ImportSources() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sources := []string{}
+	for _, theTask := range c.importTasks {
+		sources = append(sources, theTask.sourceUri)
+	}
+	return sources
+}
+
+func (t *TiDBCloud) GetImportTaskRoleInfo() (*string, *string, error) {
+	c := t.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("GetImportTaskRoleInfo"); err != nil {
+		return nil, nil, err
+	}
+
+	accountId, externalId := TIDBCLOUD_ACCOUNT_ID, TIDBCLOUD_EXTERNAL_ID
+	return &accountId, &externalId, nil
+}
+
+// StartImportTask imports the objects under the s3 directory by the role, it fails if the role
+// does not exist or the directory is empty
+func (t *TiDBCloud) StartImportTask(tidbName, s3Dir, importRoleArn, dataSourceType *string) (*string, error) {
+	c := t.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("StartImportTask"); err != nil {
+		return nil, err
+	}
+
+	if !c.hasRoleArn(*importRoleArn) {
+		return nil, errors.New(fmt.Sprintf("Failed to import data<400>: the role %s can not be assumed", *importRoleArn))
+	}
+	bucketName, prefix, err := splitS3Url(*s3Dir)
+	if err != nil {
+		return nil, err
+	}
+	theBucket, err := c.bucketOf(bucketName)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to import data<400>: %v", err))
+	}
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	keys := theBucket.keys(prefix)
+	if len(keys) == 0 {
+		return nil, errors.New(fmt.Sprintf("Failed to import data<400>: no file found in %s", *s3Dir))
+	}
+
+	theTask := &importTask{
+		id:          fmt.Sprintf("%d", c.nextId()),
+		projectId:   t.projectId,
+		clusterName: t.clusterName,
+		name:        *tidbName,
+		sourceUri:   *s3Dir,
+		sourceType:  *dataSourceType,
+		createdAt:   time.Now(),
+		phase:       "COMPLETED",
+	}
+	for _, key := range keys {
+		theTask.sizeBytes += theBucket.objects[key].size
+	}
+	c.importTasks = append(c.importTasks, theTask)
+	return &theTask.id, nil
+}
+
+// WaitImportTask returns at once as the tasks complete at once, an error if the task failed
+func (t *TiDBCloud) WaitImportTask(importTaskId *string) error {
+	c := t.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("WaitImportTask"); err != nil {
+		return err
+	}
+
+	for _, theTask := range c.importTasks {
+		if theTask.id != *importTaskId || theTask.projectId != t.projectId || theTask.clusterName != t.clusterName {
+			continue
+		}
+		if theTask.phase != "COMPLETED" {
+			return fmt.Errorf("%w: %s %s, %s", tidbcloud.ErrImportTaskFailed, theTask.id, theTask.phase, theTask.message)
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Failed to get import task %s<404>: not found", *importTaskId))
+}
+
+func (t *TiDBCloud) ListImportTasks() ([]tidbcloud.ImportTask, error) {
+	c := t.cloud
+	defer c.mu.Unlock()
+	if err := c.begin("ListImportTasks"); err != nil {
+		return nil, err
+	}
+
+	var importTasks []tidbcloud.ImportTask
+	for _, theTask := range c.importTasks {
+		if theTask.projectId != t.projectId || theTask.clusterName != t.clusterName {
+			continue
+		}
+		importTasks = append(importTasks, tidbcloud.ImportTask{
+			Id:              theTask.id,
+			Name:            theTask.name,
+			Phase:           "COMPLETED",
+			SourceUri:       theTask.sourceUri,
+			SourceSizeBytes: fmt.Sprintf("%d", theTask.sizeBytes),
+			CreateTimestamp: fmt.Sprintf("%d", theTask.createdAt.Unix()),
+		})
+	}
+	return importTasks, nil
+}
@@ -22,7 +22,6 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 )
@@ -37,27 +36,35 @@ func MapTag() *map[string]string {
 	}
 }
 
+// IAMClient is the part of the iam client called by IAMAPI
+type IAMClient interface {
+	AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error)
+	CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error)
+	CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error)
+	DeletePolicy(ctx context.Context, params *iam.DeletePolicyInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyOutput, error)
+	DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error)
+	DetachRolePolicy(ctx context.Context, params *iam.DetachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error)
+	ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error)
+	ListPolicies(ctx context.Context, params *iam.ListPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListPoliciesOutput, error)
+	ListRoleTags(ctx context.Context, params *iam.ListRoleTagsInput, optFns ...func(*iam.Options)) (*iam.ListRoleTagsOutput, error)
+	ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error)
+}
+
 type IAMAPI struct {
-	client *iam.Client
+	client IAMClient
 
 	mapArgs *map[string]string
 }
 
-func NewIAMAPI(mapArgs *map[string]string) (*IAMAPI, error) {
-	iamapi := IAMAPI{}
+// NewIAMAPIWithClient returns the api calling the given client, the in-memory fake in the tests
+func NewIAMAPIWithClient(client IAMClient, mapArgs *map[string]string) *IAMAPI {
+	iamapi := IAMAPI{client: client}
 
 	if mapArgs != nil {
 		iamapi.mapArgs = mapArgs
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-
-	iamapi.client = iam.NewFromConfig(cfg)
-
-	return &iamapi, nil
+	return &iamapi
 }
 
 // Return: (RolwArn, error)
@@ -104,7 +111,11 @@ func (b *IAMAPI) CreateRole4S3ByRDS(roleName string, path *string, s3backupDir s
     ]
 }`, parsedS3Dir.Host, strings.Trim(parsedS3Dir.Host, "/"), *kmsArn)
 
-	policyArn, err := b.createPolicy(roleName, policy, path, nil)
+	if tags == nil {
+		tags = b.makeTags()
+	}
+
+	policyArn, err := b.createPolicy(roleName, policy, path, tags)
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +135,7 @@ func (b *IAMAPI) CreateRole4S3ByRDS(roleName string, path *string, s3backupDir s
 	  ]
 	}`
 
-	roleArn, err := b.createRole(roleName, assumeRolePolicyDocument, path, nil)
+	roleArn, err := b.createRole(roleName, assumeRolePolicyDocument, path, tags)
 	if err != nil {
 		return nil, err
 	}
@@ -180,7 +191,11 @@ func (b *IAMAPI) CreateRole4S3External(roleName string, path, kmsArn, accountId,
     ]
 }`, parsedS3Url.Host, parsedS3Url.Host, *kmsArn)
 
-	policyArn, err := b.createPolicy(roleName, importPolicy, path, nil)
+	if tags == nil {
+		tags = b.makeTags()
+	}
+
+	policyArn, err := b.createPolicy(roleName, importPolicy, path, tags)
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +220,7 @@ func (b *IAMAPI) CreateRole4S3External(roleName string, path, kmsArn, accountId,
     ]
 }`, *accountId, *externalId)
 
-	roleArn, err := b.createRole(roleName, importAssumeRolePolicyDocument, path, nil)
+	roleArn, err := b.createRole(roleName, importAssumeRolePolicyDocument, path, tags)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +233,7 @@ func (b *IAMAPI) CreateRole4S3External(roleName string, path, kmsArn, accountId,
 	}); err != nil {
 		return nil, err
 	}
-	fmt.Printf("The role is : <%#s> \n\n\n", *roleArn)
+	fmt.Printf("The role is : <%s> \n\n\n", *roleArn)
 
 	return roleArn, nil
 }
@@ -400,6 +415,51 @@ func (b *IAMAPI) GetRole(pathPrefix, roleName string) (*[]types.Role, error) {
 
 }
 
+// Return: the roles under the path
+func (b *IAMAPI) ListRoles(pathPrefix string) ([]types.Role, error) {
+	var roles []types.Role
+	paginator := iam.NewListRolesPaginator(b.client, &iam.ListRolesInput{PathPrefix: aws.String(fmt.Sprintf("/%s/", pathPrefix))})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, page.Roles...)
+	}
+	return roles, nil
+}
+
+// Return: the customer managed policies under the path
+func (b *IAMAPI) ListPolicies(pathPrefix string) ([]types.Policy, error) {
+	var policies []types.Policy
+	paginator := iam.NewListPoliciesPaginator(b.client, &iam.ListPoliciesInput{
+		PathPrefix: aws.String(fmt.Sprintf("/%s/", pathPrefix)),
+		Scope:      types.PolicyScopeTypeLocal,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, page.Policies...)
+	}
+	return policies, nil
+}
+
+// Return: the tags of the role
+func (b *IAMAPI) GetRoleTags(roleName string) (map[string]string, error) {
+	resp, err := b.client.ListRoleTags(context.TODO(), &iam.ListRoleTagsInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	for _, tag := range resp.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
 func (c *IAMAPI) makeTags() *[]types.Tag {
 	var tags []types.Tag
 	if c.mapArgs == nil {
@@ -19,7 +19,6 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 )
@@ -34,27 +33,28 @@ func MapTag() *map[string]string {
 	}
 }
 
+// KmsClient is the part of the kms client called by KmsAPI
+type KmsClient interface {
+	ListAliases(ctx context.Context, params *kms.ListAliasesInput, optFns ...func(*kms.Options)) (*kms.ListAliasesOutput, error)
+	ListKeys(ctx context.Context, params *kms.ListKeysInput, optFns ...func(*kms.Options)) (*kms.ListKeysOutput, error)
+	ListResourceTags(ctx context.Context, params *kms.ListResourceTagsInput, optFns ...func(*kms.Options)) (*kms.ListResourceTagsOutput, error)
+}
+
 type KmsAPI struct {
-	client *kms.Client
+	client KmsClient
 
 	mapArgs *map[string]string
 }
 
-func NewKmsAPI(mapArgs *map[string]string) (*KmsAPI, error) {
-	kmsapi := KmsAPI{}
+// NewKmsAPIWithClient returns the api calling the given client, the in-memory fake in the tests
+func NewKmsAPIWithClient(client KmsClient, mapArgs *map[string]string) *KmsAPI {
+	kmsapi := KmsAPI{client: client}
 
 	if mapArgs != nil {
 		kmsapi.mapArgs = mapArgs
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-
-	kmsapi.client = kms.NewFromConfig(cfg)
-
-	return &kmsapi, nil
+	return &kmsapi
 }
 
 // return: (aliasArn, error)
@@ -24,7 +24,6 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
 	"github.com/aws/aws-sdk-go-v2/service/rds/types"
 
@@ -41,55 +40,57 @@ func MapTag() *map[string]string {
 	}
 }
 
+// RdsClient is the part of the rds client called by RdsAPI
+type RdsClient interface {
+	CancelExportTask(ctx context.Context, params *rds.CancelExportTaskInput, optFns ...func(*rds.Options)) (*rds.CancelExportTaskOutput, error)
+	CreateDBClusterSnapshot(ctx context.Context, params *rds.CreateDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.CreateDBClusterSnapshotOutput, error)
+	DeleteDBClusterSnapshot(ctx context.Context, params *rds.DeleteDBClusterSnapshotInput, optFns ...func(*rds.Options)) (*rds.DeleteDBClusterSnapshotOutput, error)
+	DescribeDBClusterSnapshots(ctx context.Context, params *rds.DescribeDBClusterSnapshotsInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClusterSnapshotsOutput, error)
+	DescribeExportTasks(ctx context.Context, params *rds.DescribeExportTasksInput, optFns ...func(*rds.Options)) (*rds.DescribeExportTasksOutput, error)
+	StartExportTask(ctx context.Context, params *rds.StartExportTaskInput, optFns ...func(*rds.Options)) (*rds.StartExportTaskOutput, error)
+}
+
 type RdsAPI struct {
-	client *rds.Client
+	client RdsClient
 
 	mapArgs *map[string]string
 }
 
-func NewRdsAPI(mapArgs *map[string]string) (*RdsAPI, error) {
-	rdsapi := RdsAPI{}
+// NewRdsAPIWithClient returns the api calling the given client, the in-memory fake in the tests
+func NewRdsAPIWithClient(client RdsClient, mapArgs *map[string]string) *RdsAPI {
+	rdsapi := RdsAPI{client: client}
 
 	if mapArgs != nil {
 		rdsapi.mapArgs = mapArgs
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-
-	rdsapi.client = rds.NewFromConfig(cfg)
-
-	return &rdsapi, nil
+	return &rdsapi
 }
 
 func (r *RdsAPI) ExportSnapshot2S3(taskName string, snapshotArn, kmsId, roleArn *string, s3Url string) (*types.ExportTask, error) {
-	// Check the snapshot exist
+	// Reuse the export task of the snapshot unless it failed or was canceled
 	exportTask, err := r.GetLatestExportTaskBySnapshot(snapshotArn)
 	if err != nil {
 		return nil, err
 	}
-	if exportTask != nil {
-		return exportTask, nil
-	}
-
-	parsedS3Dir, err := url.Parse(s3Url)
-	if err != nil {
-		return nil, err
-	}
-
-	taskIdentifier := fmt.Sprintf("%s-%s", taskName, time.Now().Format("20060102150405"))
+	if exportTask == nil || exportTaskFailed(exportTask) {
+		parsedS3Dir, err := url.Parse(s3Url)
+		if err != nil {
+			return nil, err
+		}
 
-	if _, err := r.client.StartExportTask(context.TODO(), &rds.StartExportTaskInput{
-		ExportTaskIdentifier: aws.String(taskIdentifier),
-		IamRoleArn:           roleArn,
-		KmsKeyId:             kmsId,
-		S3BucketName:         aws.String(parsedS3Dir.Host),
-		S3Prefix:             aws.String(strings.Trim(parsedS3Dir.Path, "/")),
-		SourceArn:            snapshotArn,
-	}); err != nil {
-		return nil, err
+		taskIdentifier := fmt.Sprintf("%s-%s", taskName, time.Now().Format("20060102150405"))
+
+		if _, err := r.client.StartExportTask(context.TODO(), &rds.StartExportTaskInput{
+			ExportTaskIdentifier: aws.String(taskIdentifier),
+			IamRoleArn:           roleArn,
+			KmsKeyId:             kmsId,
+			S3BucketName:         aws.String(parsedS3Dir.Host),
+			S3Prefix:             aws.String(strings.Trim(parsedS3Dir.Path, "/")),
+			SourceArn:            snapshotArn,
+		}); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := awscommon.WaitUntilResouceAvailable(0, 0, 1, func() (bool, error) {
@@ -104,6 +105,9 @@ func (r *RdsAPI) ExportSnapshot2S3(taskName string, snapshotArn, kmsId, roleArn
 		if exportTask == nil {
 			return false, errors.New(fmt.Sprintf("No task created[%s]", taskName))
 		}
+		if exportTaskFailed(exportTask) {
+			return false, errors.New(fmt.Sprintf("Export task %s is %s: %s", *exportTask.ExportTaskIdentifier, *exportTask.Status, aws.ToString(exportTask.FailureCause)))
+		}
 		// https://github.com/aws/aws-sdk-go-v2/blob/main/service/rds/types/types.go
 		if *exportTask.Status == "COMPLETE" {
 			return true, nil
@@ -121,6 +125,15 @@ func (r *RdsAPI) ExportSnapshot2S3(taskName string, snapshotArn, kmsId, roleArn
 	return exportTask, nil
 }
 
+// exportTaskFailed reports whether the export task ended without the data
+func exportTaskFailed(exportTask *types.ExportTask) bool {
+	switch *exportTask.Status {
+	case "FAILED", "CANCELED", "CANCELING":
+		return true
+	}
+	return false
+}
+
 func (r *RdsAPI) GetLatestSnapshot(clusterName string) (*string, error) {
 	rdsSnapshot, err := r.client.DescribeDBClusterSnapshots(context.TODO(), &rds.DescribeDBClusterSnapshotsInput{
 		DBClusterIdentifier: aws.String(clusterName),
@@ -190,6 +203,73 @@ func (r *RdsAPI) GetLatestExportTaskBySnapshot(snapshotArn *string) (*types.Expo
 	return &taskResp.ExportTasks[0], nil
 }
 
+// Return: the manual snapshots of the cluster tagged with the binlog File/Position by RDSSnapshotTaken
+func (r *RdsAPI) ListSnapshotsByBinlog(clusterName string) ([]types.DBClusterSnapshot, error) {
+	var snapshots []types.DBClusterSnapshot
+	paginator := rds.NewDescribeDBClusterSnapshotsPaginator(r.client, &rds.DescribeDBClusterSnapshotsInput{
+		DBClusterIdentifier: aws.String(clusterName),
+		SnapshotType:        aws.String("manual"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, snapshot := range page.DBClusterSnapshots {
+			hasFile, hasPosition := false, false
+			for _, tag := range snapshot.TagList {
+				switch *tag.Key {
+				case "File":
+					hasFile = true
+				case "Position":
+					hasPosition = true
+				}
+			}
+			if hasFile && hasPosition {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// Return: the export tasks whose identifier starts with <taskName>-, the identifier used by ExportSnapshot2S3
+func (r *RdsAPI) ListExportTasks(taskName string) ([]types.ExportTask, error) {
+	var exportTasks []types.ExportTask
+	paginator := rds.NewDescribeExportTasksPaginator(r.client, &rds.DescribeExportTasksInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, exportTask := range page.ExportTasks {
+			if strings.HasPrefix(aws.ToString(exportTask.ExportTaskIdentifier), taskName+"-") {
+				exportTasks = append(exportTasks, exportTask)
+			}
+		}
+	}
+	return exportTasks, nil
+}
+
+func (r *RdsAPI) DeleteSnapshot(snapshotIdentifier string) error {
+	if _, err := r.client.DeleteDBClusterSnapshot(context.TODO(), &rds.DeleteDBClusterSnapshotInput{
+		DBClusterSnapshotIdentifier: aws.String(snapshotIdentifier),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Cancel the export task, the data exported so far is kept in S3
+func (r *RdsAPI) CancelExportTask(exportTaskIdentifier string) error {
+	if _, err := r.client.CancelExportTask(context.TODO(), &rds.CancelExportTaskInput{
+		ExportTaskIdentifier: aws.String(exportTaskIdentifier),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (r *RdsAPI) RDSSnapshotTaken(clusterName, binlogFile string, binlogPos int64) (*string, error) {
 
 	snapshot, err := r.GetSnapshotByBinlog(clusterName, binlogFile, binlogPos)
@@ -203,6 +283,7 @@ func (r *RdsAPI) RDSSnapshotTaken(clusterName, binlogFile string, binlogPos int6
 	var tags []types.Tag
 	tags = append(tags, types.Tag{Key: aws.String("File"), Value: aws.String(binlogFile)})
 	tags = append(tags, types.Tag{Key: aws.String("Position"), Value: aws.String(fmt.Sprintf("%d", binlogPos))})
+	tags = append(tags, *r.makeTags()...)
 
 	backupName := fmt.Sprintf("%s-%s-%d", clusterName, strings.ReplaceAll(binlogFile, ".", "-"), binlogPos)
 
@@ -234,3 +315,18 @@ func (r *RdsAPI) RDSSnapshotTaken(clusterName, binlogFile string, binlogPos int6
 
 	return createdSnapshot.DBClusterSnapshot.DBClusterSnapshotArn, nil
 }
+
+func (r *RdsAPI) makeTags() *[]types.Tag {
+	var tags []types.Tag
+	if r.mapArgs == nil {
+		return &tags
+	}
+
+	for key, tagName := range *(MapTag()) {
+		if tagValue, ok := (*r.mapArgs)[key]; ok {
+			tags = append(tags, types.Tag{Key: aws.String(tagName), Value: aws.String(tagValue)})
+		}
+	}
+
+	return &tags
+}
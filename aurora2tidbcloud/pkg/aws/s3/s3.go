@@ -15,12 +15,13 @@ package s3
 
 import (
 	"context"
-	// "fmt"
+	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	// "github.com/luyomo/OhMyTiUP/pkg/utils"
 	// "go.uber.org/zap"
 )
@@ -35,27 +36,30 @@ func MapTag() *map[string]string {
 	}
 }
 
+// S3Client is the part of the s3 client called by S3API
+type S3Client interface {
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	GetBucketTagging(ctx context.Context, params *s3.GetBucketTaggingInput, optFns ...func(*s3.Options)) (*s3.GetBucketTaggingOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	ListBuckets(ctx context.Context, params *s3.ListBucketsInput, optFns ...func(*s3.Options)) (*s3.ListBucketsOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
 type S3API struct {
-	client *s3.Client
+	client S3Client
 
 	mapArgs *map[string]string
 }
 
-func NewS3API(mapArgs *map[string]string) (*S3API, error) {
-	s3api := S3API{}
+// NewS3APIWithClient returns the api calling the given client, the in-memory fake in the tests
+func NewS3APIWithClient(client S3Client, mapArgs *map[string]string) *S3API {
+	s3api := S3API{client: client}
 
 	if mapArgs != nil {
 		s3api.mapArgs = mapArgs
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-
-	s3api.client = s3.NewFromConfig(cfg)
-
-	return &s3api, nil
+	return &s3api
 }
 
 func (c *S3API) GetObject(bucket, key string) error {
@@ -70,33 +74,88 @@ func (c *S3API) GetObject(bucket, key string) error {
 	return nil
 }
 
+// Delete all the objects under the prefix, 1000 objects(one listed page) per call
 func (c *S3API) DeleteObject(bucket, prefix string) error {
-
-	objects, err := c.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
 		Prefix: aws.String(prefix),
 	})
-	if err != nil {
-		return err
+	for paginator.HasMorePages() {
+		objects, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		var objectIds []types.ObjectIdentifier
+		for _, file := range objects.Contents {
+			objectIds = append(objectIds, types.ObjectIdentifier{Key: file.Key})
+		}
+
+		if len(objectIds) == 0 {
+			continue
+		}
+
+		resp, err := c.client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objectIds},
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.Errors) > 0 {
+			return errors.New(fmt.Sprintf("Failed to delete %d object(s), first: %s %s", len(resp.Errors), aws.ToString(resp.Errors[0].Key), aws.ToString(resp.Errors[0].Message)))
+		}
 	}
 
-	var objectIds []types.ObjectIdentifier
-	for _, file := range objects.Contents {
-		objectIds = append(objectIds, types.ObjectIdentifier{Key: file.Key})
+	return nil
+}
+
+// Return: the tags of the bucket, empty if the bucket has no tag
+func (c *S3API) GetBucketTags(bucket string) (map[string]string, error) {
+	tags := map[string]string{}
+	resp, err := c.client.GetBucketTagging(context.TODO(), &s3.GetBucketTaggingInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchTagSet" {
+			return tags, nil
+		}
+		return nil, err
 	}
+	for _, tag := range resp.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
 
-	if len(objectIds) == 0 {
-		return nil
+// Return: the bucket, nil if it does not exist in the account
+func (c *S3API) GetBucket(bucket string) (*types.Bucket, error) {
+	resp, err := c.client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
 	}
+	for _, theBucket := range resp.Buckets {
+		if *theBucket.Name == bucket {
+			return &theBucket, nil
+		}
+	}
+	return nil, nil
+}
 
-	if _, err := c.client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+// Return: all the objects under the prefix
+func (c *S3API) ListObjects(bucket, prefix string) ([]types.Object, error) {
+	var objects []types.Object
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Delete: &types.Delete{Objects: objectIds},
-	}); err != nil {
-		return err
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
 	}
-
-	return nil
+	return objects, nil
 }
 
 func (c *S3API) makeTags() *[]types.Tag {
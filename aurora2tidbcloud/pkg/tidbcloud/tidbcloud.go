@@ -88,7 +88,7 @@ func (t *TiDBCloudAPI) GetClusterId() (*string, error) {
 	case 500:
 		return nil, errors.New(fmt.Sprintf("Failed to import data<500>: %s, detail:%#v", *response.JSON500.Message, *response.JSON500.Details))
 	default:
-		return nil, errors.New(fmt.Sprintf("Failed to import data<%d>: %s", statusCode, *response))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<%d>: %s", statusCode, string(response.Body)))
 	}
 
 	for _, item := range response.JSON200.Items {
@@ -116,10 +116,11 @@ func (t *TiDBCloudAPI) getClusterInfo() (*string, *string, error) {
 }
 
 // Type: AURORA_SNAPSHOT/SQL
-func (t *TiDBCloudAPI) StartImportTask(tidbName, s3Dir, importRoleArn, dataSourceType *string) error {
+// Return: the id of the import task as soon as it is created, WaitImportTask waits for it
+func (t *TiDBCloudAPI) StartImportTask(tidbName, s3Dir, importRoleArn, dataSourceType *string) (*string, error) {
 	clusterId, err := t.GetClusterId()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Search for the valid S3 backup to import.
@@ -139,27 +140,61 @@ func (t *TiDBCloudAPI) StartImportTask(tidbName, s3Dir, importRoleArn, dataSourc
 
 	resImport, err := t.client.CreateImportTaskWithResponse(context.Background(), t.projectId, *clusterId, createImportTaskJSONRequestBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	statusCode := resImport.StatusCode()
 	switch statusCode {
 	case 200:
 	case 400:
-		return errors.New(fmt.Sprintf("Failed to import data<400>: %s, detail:%#v", *resImport.JSON400.Message, *resImport.JSON400.Details))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<400>: %s, detail:%#v", *resImport.JSON400.Message, *resImport.JSON400.Details))
 	case 403:
-		return errors.New(fmt.Sprintf("Failed to import data<403>: %s, detail:%#v", *resImport.JSON403.Message, *resImport.JSON403.Details))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<403>: %s, detail:%#v", *resImport.JSON403.Message, *resImport.JSON403.Details))
 	case 404:
-		return errors.New(fmt.Sprintf("Failed to import data<404>: %s, detail:%#v", *resImport.JSON404.Message, *resImport.JSON404.Details))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<404>: %s, detail:%#v", *resImport.JSON404.Message, *resImport.JSON404.Details))
 	case 429:
-		return errors.New(fmt.Sprintf("Failed to import data<429>: %s, detail:%#v", *resImport.JSON429.Message, *resImport.JSON429.Details))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<429>: %s, detail:%#v", *resImport.JSON429.Message, *resImport.JSON429.Details))
 	case 500:
-		return errors.New(fmt.Sprintf("Failed to import data<500>: %s, detail:%#v", *resImport.JSON500.Message, *resImport.JSON500.Details))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<500>: %s, detail:%#v", *resImport.JSON500.Message, *resImport.JSON500.Details))
 	default:
-		return errors.New(fmt.Sprintf("Failed to import data<%d>: %s", statusCode, *resImport))
+		return nil, errors.New(fmt.Sprintf("Failed to import data<%d>: %s", statusCode, string(resImport.Body)))
 	}
 
-	if err := awscommon.WaitUntilResouceAvailable(0, 0, 1, func() (bool, error) {
+	return &resImport.JSON200.Id, nil
+}
+
+// ErrImportTaskFailed is returned by WaitImportTask if the import task failed or was canceled
+var ErrImportTaskFailed = errors.New("import task failed")
+
+// WaitImportTask waits until the import task is completed and the cluster is available again
+func (t *TiDBCloudAPI) WaitImportTask(importTaskId *string) error {
+	clusterId, err := t.GetClusterId()
+	if err != nil {
+		return err
+	}
+	if clusterId == nil {
+		return errors.New(fmt.Sprintf("No cluster found[%s]", t.clusterName))
+	}
+
+	return awscommon.WaitUntilResouceAvailable(0, 0, 1, func() (bool, error) {
+		response, err := t.client.GetImportTaskWithResponse(context.Background(), t.projectId, *clusterId, *importTaskId)
+		if err != nil {
+			return false, err
+		}
+		if response.JSON200 == nil {
+			return false, errors.New(fmt.Sprintf("Failed to get import task %s<%d>: %s", *importTaskId, response.StatusCode(), string(response.Body)))
+		}
+		if response.JSON200.Status == nil {
+			return false, nil
+		}
+		switch phase := fmt.Sprintf("%v", response.JSON200.Status.Phase); phase {
+		case "COMPLETED":
+		case "FAILED", "CANCELED":
+			return false, fmt.Errorf("%w: %s %s, %s", ErrImportTaskFailed, *importTaskId, phase, ptr.ToString(response.JSON200.Status.ErrorMessage))
+		default:
+			return false, nil
+		}
+
 		_, status, err := t.getClusterInfo()
 		if err != nil {
 			return false, err
@@ -167,14 +202,60 @@ func (t *TiDBCloudAPI) StartImportTask(tidbName, s3Dir, importRoleArn, dataSourc
 		if status == nil {
 			return false, errors.New(fmt.Sprintf("No cluster found[%s]", t.clusterName))
 		}
-		if *status == "AVAILABLE" {
-			return true, nil
+		return *status == "AVAILABLE", nil
+	})
+}
+
+// ImportTask is the summary of a TiDB Cloud import task
+type ImportTask struct {
+	Id              string
+	Name            string
+	Phase           string
+	SourceUri       string
+	SourceSizeBytes string
+	CreateTimestamp string
+	ErrorMessage    string
+}
+
+// Return: the import tasks of the cluster, none if the cluster does not exist
+func (t *TiDBCloudAPI) ListImportTasks() ([]ImportTask, error) {
+	if t.clusterId == "" {
+		return nil, nil
+	}
+
+	var importTasks []ImportTask
+	pageSize := int64(100)
+	for page := int64(1); ; page++ {
+		response, err := t.client.ListImportTasksWithResponse(context.Background(), t.projectId, t.clusterId, &tidbcloud.ListImportTasksParams{Page: &page, PageSize: &pageSize})
+		if err != nil {
+			return nil, err
+		}
+		if response.JSON200 == nil {
+			return nil, errors.New(fmt.Sprintf("Failed to list import tasks<%d>: %s", response.StatusCode(), string(response.Body)))
+		}
+
+		for _, item := range response.JSON200.Items {
+			importTask := ImportTask{}
+			if item.Metadata != nil {
+				importTask.Id = item.Metadata.Id
+				importTask.Name = ptr.ToString(item.Metadata.Name)
+				importTask.CreateTimestamp = item.Metadata.CreateTimestamp
+			}
+			if item.Spec != nil {
+				importTask.SourceUri = item.Spec.Source.Uri
+			}
+			if item.Status != nil {
+				importTask.Phase = fmt.Sprintf("%v", item.Status.Phase)
+				importTask.SourceSizeBytes = ptr.ToString(item.Status.SourceTotalSizeBytes)
+				importTask.ErrorMessage = ptr.ToString(item.Status.ErrorMessage)
+			}
+			importTasks = append(importTasks, importTask)
+		}
+
+		if len(response.JSON200.Items) == 0 || int64(len(importTasks)) >= response.JSON200.Total {
+			return importTasks, nil
 		}
-		return false, nil
-	}); err != nil {
-		return err
 	}
-	return nil
 }
 
 func (t *TiDBCloudAPI) GetImportTaskRoleInfo() (*string, *string, error) {
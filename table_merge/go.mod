@@ -6,6 +6,8 @@ toolchain go1.24.8
 
 require (
 	github.com/go-sql-driver/mysql v1.9.3
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/pingcap/tidb v1.1.0-beta.0.20250415080739-a02630cc24cf
 	github.com/sashabaranov/go-openai v1.41.1
 	github.com/spf13/cobra v1.10.1
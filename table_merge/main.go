@@ -1,59 +1,80 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/md5"
-	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
-	openai "github.com/sashabaranov/go-openai"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 
 	_ "github.com/go-sql-driver/mysql"
-	selector "github.com/pingcap/tidb/pkg/util/table-rule-selector"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/audit"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/drift"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/render"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/rules"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/server"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/syncdiff"
 )
 
-type DBConnInfo struct {
-	Name     string   `yaml:"Name"`
-	Host     string   `yaml:"Host"`
-	Port     int      `yaml:"Port"`
-	User     string   `yaml:"User"`
-	Password string   `yaml:"Password"`
-	DBs      []string `yaml:"DBs"`
-}
-
-type TableInfo struct {
-	MD5Columns          string
-	MD5ColumnsWithTypes string
-	SrcRegex            string
-	SrcTableInfo        []string
-	DestTableInfo       []string
-	DestHasSource       bool
-	DestHasSchema       bool
-	DestHasTableName    bool
-	MaxID               int64
-}
-
 var (
 	opsType    string
 	strTpl     string
-	srcDBInfo  DBConnInfo
-	destDBInfo DBConnInfo
+	srcDBInfo  config.DBConnInfo
+	destDBInfo config.DBConnInfo
 	outputFile string
 	outputErr  string
 	configFile string
 	llmProduct string
 	logLevel   string
+	applyDDL   bool
+
+	secretKeyFile  string
+	dumplingPreset string
+	// syncDiffShards overrides SyncDiff.Shards of the config
+	syncDiffShards int
+	// dmMaxSources and dmMaxRoutes override DMTask.MaxSources and DMTask.MaxRoutes of the config
+	dmMaxSources int
+	dmMaxRoutes  int
+
+	// llmRecordFile is the JSONL file every regex conversation is appended to
+	llmRecordFile string
+	// llmReplayFile is the JSONL recording answered instead of the LLM
+	llmReplayFile string
+
+	planFiles  bool
+	applyFiles bool
+	// artifacts collects the generated files for --plan and --apply
+	artifacts *render.Artifacts
+
+	// serveListen is the address of the API server
+	serveListen string
+	// serveAllowPasswordRefs resolves the password references of the submitted configs
+	serveAllowPasswordRefs bool
+
+	// driftSnapshot is the mapping snapshot compared by drift, Output/dm-toolkit-mapping.json if empty
+	driftSnapshot string
+	driftSave     bool
+	driftJSON     bool
+
+	auditJSON bool
+
+	statusJSON   bool
+	statusPhase  string
+	statusValue  string
+	statusDetail string
 )
 
 var rootCmd = &cobra.Command{
@@ -72,12 +93,18 @@ func init() {
 
 	// Add the --config flag to the root command.
 	rootCmd.PersistentFlags().StringVarP(&strTpl, "template", "t", "", "template command for dumpling")
+	rootCmd.PersistentFlags().StringVar(&dumplingPreset, "dumpling-preset", "", "Dumpling template preset(csv-with-header, sql, compressed, consistent-snapshot)")
+	rootCmd.PersistentFlags().IntVar(&syncDiffShards, "sync-diff-shards", 0, "Split the sync-diff config into balanced shards run by parallel inspectors(generateSyncDiffconfig)")
+	rootCmd.PersistentFlags().IntVar(&dmMaxSources, "dm-max-sources", 0, "Split the DM task so that a task has at most N source instances(generateDMConfig)")
+	rootCmd.PersistentFlags().IntVar(&dmMaxRoutes, "dm-max-routes", 0, "Split the DM task so that a task has at most N routes(generateDMConfig)")
 
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Config file")
 	rootCmd.PersistentFlags().StringVarP(&llmProduct, "llm", "a", "", "LLM product(openai,deepseek)")
+	rootCmd.PersistentFlags().StringVar(&llmRecordFile, "llm-record", "", "Append every LLM conversation to the JSONL file")
+	rootCmd.PersistentFlags().StringVar(&llmReplayFile, "llm-replay", "", "Answer the LLM conversations from the JSONL recording without network")
 
 	// Define flags for source and destination databases
-	rootCmd.PersistentFlags().StringVar(&opsType, "ops-type", "", "OPS type[sourceAnalyze, generateDumpling, generateSyncDiffconfig, generateMapping, generateDMConfig]")
+	rootCmd.PersistentFlags().StringVar(&opsType, "ops-type", "", "OPS type[sourceAnalyze, generateDumpling, generateSyncDiffconfig, generateMapping, generateDMConfig, generateLightningConfig, generateDDL, generateTiCDCReverse]")
 
 	rootCmd.PersistentFlags().StringVar(&srcDBInfo.Host, "src-host", "", "Source database host")
 	rootCmd.PersistentFlags().IntVar(&srcDBInfo.Port, "src-port", 4000, "Source database port")
@@ -93,7 +120,274 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&outputFile, "output", "", "Output file path")
 	rootCmd.PersistentFlags().StringVar(&outputFile, "error-file", "", "Output file path for failed mapping tables")
+	rootCmd.AddCommand(encryptCmd)
+	templateCmd.AddCommand(templateFieldsCmd, templatePresetsCmd)
+	rootCmd.AddCommand(templateCmd)
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the state as JSON")
+	statusSetCmd.Flags().StringVar(&statusPhase, "phase", "", "Phase to update(dump, import, dm-task, sync-diff)")
+	statusSetCmd.Flags().StringVar(&statusValue, "status", "", "New status(pending, generated, running, done, failed, skipped)")
+	statusSetCmd.Flags().StringVar(&statusDetail, "detail", "", "Note kept with the status, e.g. the error")
+	statusCmd.AddCommand(statusSetCmd)
+	rootCmd.AddCommand(statusCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8080", "Address of the API server")
+	serveCmd.Flags().BoolVar(&serveAllowPasswordRefs, "allow-password-refs", false, "Resolve the env:, file: and enc: passwords of the submitted configs on the server")
+	rootCmd.AddCommand(serveCmd)
+	driftCmd.Flags().StringVar(&driftSnapshot, "snapshot", "", "Mapping snapshot to compare with, <Output>/dm-toolkit-mapping.json if empty")
+	driftCmd.Flags().BoolVar(&driftSave, "save", false, "Save the current mapping as the snapshot instead of comparing")
+	driftCmd.Flags().BoolVar(&driftJSON, "json", false, "Print the drift as JSON")
+	rootCmd.AddCommand(driftCmd)
+	auditCmd.Flags().BoolVar(&auditJSON, "json", false, "Print the issues as JSON")
+	rootCmd.AddCommand(auditCmd)
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&secretKeyFile, "secret-key", config.DefaultSecretKeyFile(), "Local key file to decrypt the enc: passwords")
+	rootCmd.PersistentFlags().BoolVar(&applyDDL, "apply-ddl", false, "Apply the generated DDL to the destination database(generateDDL)")
+	rootCmd.PersistentFlags().BoolVar(&planFiles, "plan", false, "Render the generated files into memory and show the diff against the existing files")
+	rootCmd.PersistentFlags().BoolVar(&applyFiles, "apply", false, "Write the generated files, the previous versions are kept in <Output>/backup/<timestamp>")
+}
+
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt a password read from stdin to the enc: form used in the config",
+	Run: func(cmd *cobra.Command, args []string) {
+		plain, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read password from stdin: %v", err)
+		}
+		key, err := config.LoadSecretKey(secretKeyFile, true)
+		if err != nil {
+			log.Fatalf("Failed to load secret key: %v", err)
+		}
+		encrypted, err := config.EncryptSecret(strings.TrimRight(string(plain), "\r\n"), key)
+		if err != nil {
+			log.Fatalf("Failed to encrypt password: %v", err)
+		}
+		fmt.Println(encrypted)
+		os.Exit(0)
+	},
+}
+
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Show the dumpling template fields and presets",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		os.Exit(0)
+	},
+}
+
+var templateFieldsCmd = &cobra.Command{
+	Use:   "fields",
+	Short: "List the fields available to the dumpling template",
+	Run: func(cmd *cobra.Command, args []string) {
+		render.PrintDumplingTemplateFields(os.Stdout)
+		os.Exit(0)
+	},
+}
+
+var templatePresetsCmd = &cobra.Command{
+	Use:   "presets",
+	Short: "List the embedded dumpling template presets",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := render.PrintDumplingPresets(os.Stdout); err != nil {
+			log.Fatalf("Failed to list dumpling presets: %v", err)
+		}
+		os.Exit(0)
+	},
+}
+
+// loadStatusLedger reads the state of the output directory of the config
+func loadStatusLedger() *stateLedger {
+	var cfg config.Config
+	if configFile != "" {
+		var err error
+		cfg, err = loadConfig(configFile)
+		if err != nil {
+			log.Fatalf("Failed to read config file: %v", err)
+		}
+	}
+	ledger, err := loadState(statePath(cfg.Output))
+	if err != nil {
+		log.Fatalf("Failed to load migration state: %v", err)
+	}
+	return ledger
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the migration progress of every destination table",
+	Run: func(cmd *cobra.Command, args []string) {
+		ledger := loadStatusLedger()
+		if statusJSON {
+			content, err := json.MarshalIndent(ledger, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode migration state: %v", err)
+			}
+			fmt.Println(string(content))
+			os.Exit(0)
+		}
+		if len(ledger.Tables) == 0 {
+			fmt.Printf("No migration state in %s, run one of the generators first. \n", ledger.path)
+			os.Exit(0)
+		}
+		ledger.print(os.Stdout)
+		os.Exit(0)
+	},
+}
+
+var statusSetCmd = &cobra.Command{
+	Use:   "set [dest table...]",
+	Short: "Set the status of a phase, the tables are instance.schema.table or schema.table, all if none",
+	Run: func(cmd *cobra.Command, args []string) {
+		ledger := loadStatusLedger()
+		now := time.Now()
+		updated, err := ledger.setPhase(args, statusPhase, statusValue, statusDetail, now)
+		if err != nil {
+			log.Fatalf("Failed to set status: %v", err)
+		}
+		if err := ledger.save(now); err != nil {
+			log.Fatalf("Failed to save migration state: %v", err)
+		}
+		fmt.Printf("Set %s to %s for %d table(s). \n", statusPhase, statusValue, updated)
+		os.Exit(0)
+	},
+}
+
+// driftExitCode is the exit code of drift when the schema changed since the snapshot
+const driftExitCode = 2
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Compare the current table definitions with the mapping snapshot, exit 2 on drift",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initLog(); err != nil {
+			log.Fatalf("Failed to initialize logger: %v", err)
+		}
+		if configFile == "" {
+			log.Fatalf("No config file, set --config")
+		}
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			log.Fatalf("Failed to read config file: %v", err)
+		}
+		snapshotPath := driftSnapshot
+		if snapshotPath == "" {
+			snapshotPath = drift.SnapshotPath(cfg.Output)
+		}
+
+		tableStructure, err := mapping.Build(cfg, mapping.Options{})
+		if err != nil {
+			log.Fatalf("Failed to fetch table definition: %v", err)
+		}
+
+		if driftSave {
+			// The routes of the snapshot decide whether a new table is covered
+			if err := rules.New(rules.Options{LLMProduct: llmProduct, RecordFile: llmRecordFile, ReplayFile: llmReplayFile}).AssignSrcRegex(tableStructure); err != nil {
+				log.Fatalf("Failed to generate the routes: %v", err)
+			}
+			if err := drift.Save(snapshotPath, tableStructure, time.Now()); err != nil {
+				log.Fatalf("Failed to save mapping snapshot: %v", err)
+			}
+			fmt.Printf("Saved the mapping of %d group(s) to %s \n", len(tableStructure), snapshotPath)
+			os.Exit(0)
+		}
+
+		snapshot, err := drift.Load(snapshotPath)
+		if err != nil {
+			log.Fatalf("Failed to load mapping snapshot: %v", err)
+		}
+		report := drift.Compare(snapshot, tableStructure)
+		slog.Info("compared mapping with snapshot", "snapshot", snapshotPath, "changeCount", len(report.Changes), "drift", report.HasDrift())
+		if driftJSON {
+			content, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode drift: %v", err)
+			}
+			fmt.Println(string(content))
+		} else {
+			report.Print(os.Stdout)
+		}
+		if report.HasDrift() {
+			os.Exit(driftExitCode)
+		}
+		os.Exit(0)
+	},
+}
+
+// auditExitCode is the exit code of audit when a difference blocks the migration
+const auditExitCode = 2
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Compare the charsets, collations, sql_mode and lower_case_table_names of the sources and the destination, exit 2 on a blocking difference",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initLog(); err != nil {
+			log.Fatalf("Failed to initialize logger: %v", err)
+		}
+		if configFile == "" {
+			log.Fatalf("No config file, set --config")
+		}
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			log.Fatalf("Failed to read config file: %v", err)
+		}
+
+		tableStructure, err := mapping.Build(cfg, mapping.Options{})
+		if err != nil {
+			log.Fatalf("Failed to fetch table definition: %v", err)
+		}
+		report, err := audit.Run(cfg, tableStructure, audit.Options{})
+		if err != nil {
+			log.Fatalf("Failed to audit charsets: %v", err)
+		}
+		slog.Info("audited charsets", "issueCount", len(report.Issues), "blockingCount", report.Blocking())
+		if auditJSON {
+			content, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to encode audit: %v", err)
+			}
+			fmt.Println(string(content))
+		} else {
+			report.Print(os.Stdout)
+		}
+		if report.Blocking() > 0 {
+			os.Exit(auditExitCode)
+		}
+		os.Exit(0)
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the mapping, the generated files and the sync-diff summary parsing over a JSON API",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initLog(); err != nil {
+			log.Fatalf("Failed to initialize logger: %v", err)
+		}
+		s := server.New(server.Options{
+			SecretKeyFile:     secretKeyFile,
+			AllowPasswordRefs: serveAllowPasswordRefs,
+			Rules:             rules.Options{LLMProduct: llmProduct, RecordFile: llmRecordFile, ReplayFile: llmReplayFile},
+		})
+		httpServer := &http.Server{Addr: serveListen, Handler: s.Handler()}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			slog.Info("shutting down API server")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+		}()
+
+		slog.Info("starting API server", "listen", serveListen)
+		fmt.Printf("Serving the API on %s \n", serveListen)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to serve the API: %v", err)
+		}
+		s.Wait()
+		os.Exit(0)
+	},
 }
 
 func main() {
@@ -111,15 +405,46 @@ func main() {
 		os.Exit(1)
 	}
 
-	var config Config
+	var cfg config.Config
 	if configFile != "" {
 		slog.Info("reading config file", "configFile", configFile)
-		config, err = readConfig(configFile)
+		cfg, err = loadConfig(configFile)
 		if err != nil {
 			slog.Error("failed to read config file", "error", err, "configFile", configFile)
 			log.Fatalf("Failed to read config file: %v", err)
 		}
-		slog.Debug("config loaded", "config", fmt.Sprintf("%#v", config))
+		slog.Debug("config loaded", "config", fmt.Sprintf("%#v", cfg.Redacted()))
+	}
+
+	artifacts = render.NewArtifacts(cfg.Output, planFiles, applyFiles)
+	artifacts.Scope = opsType
+	if syncDiffShards > 0 {
+		cfg.SyncDiff.Shards = syncDiffShards
+	}
+	if dmMaxSources > 0 {
+		cfg.DMTask.MaxSources = dmMaxSources
+	}
+	if dmMaxRoutes > 0 {
+		cfg.DMTask.MaxRoutes = dmMaxRoutes
+	}
+
+	// Resolve and validate the dumpling template before anything is generated
+	if dumplingPreset != "" {
+		cfg.DumplingPreset = dumplingPreset
+	}
+	cfg.Template, err = render.ResolveDumplingTemplate(strTpl, cfg)
+	if err != nil {
+		slog.Error("failed to resolve dumpling template", "error", err)
+		log.Fatalf("Failed to resolve dumpling template: %v", err)
+	}
+	var tmpl *template.Template
+	if cfg.Template != "" {
+		slog.Debug("parsing template", "template", cfg.Template)
+		tmpl, err = render.ParseDumplingTemplate(cfg.Template)
+		if err != nil {
+			slog.Error("invalid dumpling template", "error", err, "template", cfg.Template)
+			log.Fatalf("Invalid dumpling template: %v", err)
+		}
 	}
 
 	if opsType == "" {
@@ -129,45 +454,38 @@ func main() {
 	}
 	slog.Info("ops type", "opsType", opsType)
 
-	tableStructure := []TableInfo{}
+	tableStructure, err := mapping.Build(cfg, mapping.Options{})
+	if err != nil {
+		fmt.Printf("Failed to fetch table definition: %v \n", err)
+		return
+	}
 
-	/*
-			Fetch source database table definitions and create a mapping where:
-		    - Key: MD5 hash of consolidated column definitions
-		    - Value: List of table names sharing the same column structure
-	*/
-	for _, sourceDB := range config.SourceDB {
-		slog.Info("fetching source table definitions", "sourceDB", sourceDB.Name)
-		err := fetch_table_def("source", &tableStructure, sourceDB)
-		if err != nil {
-			slog.Error("failed to fetch source table definitions", "error", err, "sourceDB", sourceDB.Name)
-			fmt.Printf("Failed to fetch table definition: %v \n", err)
-			return
+	// The statistics plan the exports, the toolkit works without them
+	if opsType == "sourceAnalyze" || opsType == "generateDumpling" || (opsType == "generateSyncDiffconfig" && cfg.SyncDiff.Shards > 1) {
+		if err := mapping.AssignSizes(cfg, tableStructure, mapping.Options{}); err != nil {
+			slog.Warn("table sizes not available, the exports are not planned", "error", err)
 		}
-		slog.Debug("fetched source tables", "sourceDB", sourceDB.Name, "totalTables", len(tableStructure))
 	}
 
-	/*
-			Similarly, fetch destination database table definitions and create a mapping where:
-		    - Key: MD5 hash of consolidated column definitions
-		    - Value: List of table names sharing the same column structure
-	*/
-	slog.Info("fetching destination table definitions", "destDB", config.DestDB.Name)
-	err = fetch_table_def("dest", &tableStructure, config.DestDB)
-	if err != nil {
-		slog.Error("failed to fetch destination table definitions", "error", err, "destDB", config.DestDB.Name)
-		fmt.Printf("Failed to fetch table definition: %v \n", err)
-		return
+	// The dumpling SELECT and the DM column mapping shift the primary keys by the same offsets
+	if opsType == "generateDumpling" || opsType == "generateDMConfig" {
+		if err := mapping.AssignPKOffsets(cfg, tableStructure, mapping.Options{}); err != nil {
+			slog.Error("failed to assign pk offsets", "error", err)
+			log.Fatalf("Failed to assign pk offsets: %v", err)
+		}
 	}
-	slog.Debug("fetched destination tables", "destDB", config.DestDB.Name, "totalTables", len(tableStructure))
 
-	slog.Debug("parsing template", "template", config.Template)
-	tmpl := template.Must(template.New("dumpling").Parse(config.Template))
+	// The analysis only reads the databases, the generators record the mapping in the state
+	if opsType != "sourceAnalyze" {
+		updateState(cfg.Output, func(ledger *stateLedger, now time.Time) {
+			ledger.recordMapping(tableStructure, now)
+		})
+	}
 
 	// Open the output file for writing if specified.
 	// Create file handlers for all the source db which will be used to output the dumpling command.
 	mapWriter := make(map[string]*os.File)
-	for _, db := range config.SourceDB {
+	for _, db := range cfg.SourceDB {
 		// Open output file for writing if specified
 		var outputWriter *os.File
 		if outputFile != "" {
@@ -201,85 +519,6 @@ func main() {
 		errorWriter = os.Stdout
 	}
 
-	// Convert the tableInfo like source: ["TableA, TableB01, TableB02"]  dest: ["TableA, TableB"]
-	// to Source: [TableA], Dest: [TableA]
-	//   and Source: [TableB01, TableB02], Dest: [TableB]
-	// If both source and dest has multiple tables, separate those table with same name.
-	// Multiple to multiple can not be handle. Use the name format to make the mapping between the source and destination.
-	convertedTableStructure := []TableInfo{}
-	for _, tableInfo := range tableStructure {
-		// Skip if one to one
-		if len(tableInfo.SrcTableInfo) <= 1 || len(tableInfo.DestTableInfo) <= 1 {
-			convertedTableStructure = append(convertedTableStructure, tableInfo)
-			continue
-		}
-
-		// If multiple to multiple, separate them as one-to-one mapping and many-to-one mapping
-		if len(tableInfo.SrcTableInfo) > 1 && len(tableInfo.DestTableInfo) > 1 {
-			foundTable := []string{}
-			// If the table name is same, then we will separate them as one-to-one mapping
-			for _, srcTable := range tableInfo.SrcTableInfo {
-				for _, destTable := range tableInfo.DestTableInfo {
-					if (strings.Split(srcTable, "."))[2] == (strings.Split(destTable, "."))[2] {
-						slog.Debug("matched same table name", "srcTable", srcTable, "destTable", destTable)
-						convertedTableStructure = append(convertedTableStructure, TableInfo{
-							MD5Columns:          tableInfo.MD5Columns,
-							MD5ColumnsWithTypes: tableInfo.MD5ColumnsWithTypes,
-							SrcTableInfo:        []string{srcTable},
-							DestTableInfo:       []string{destTable},
-						})
-						foundTable = append(foundTable, srcTable)
-					}
-				}
-			}
-
-			// If the table name is not same, then we will separate them as many-to-one mapping
-			tmpSrcTable := []string{}
-			tmpDestTable := []string{}
-			for _, srcTable := range tableInfo.SrcTableInfo {
-				isFound := false
-				for _, foundSrc := range foundTable {
-					if srcTable == foundSrc {
-						isFound = true
-						break
-					}
-				}
-				if !isFound {
-					tmpSrcTable = append(tmpSrcTable, srcTable)
-				}
-			}
-
-			// Find the dest table that has the same base name as the srcTable that was found
-			for _, destTable := range tableInfo.DestTableInfo {
-				isFound := false
-				for _, foundSrc := range foundTable {
-					// Check against the base name of the srcTable that was found
-					if (strings.Split(destTable, "."))[2] == (strings.Split(foundSrc, "."))[2] {
-						isFound = true
-						break
-					}
-				}
-				if !isFound {
-					tmpDestTable = append(tmpDestTable, destTable)
-				}
-			}
-
-			// If there are remaining src and dest tables, add them to the convertedTableStructure
-			if len(tmpSrcTable) > 0 && len(tmpDestTable) > 0 {
-				slog.Debug("remaining many-to-many tables after name matching", "srcCount", len(tmpSrcTable), "destCount", len(tmpDestTable))
-				convertedTableStructure = append(convertedTableStructure, TableInfo{
-					MD5Columns:          tableInfo.MD5Columns,
-					MD5ColumnsWithTypes: tableInfo.MD5ColumnsWithTypes,
-					SrcTableInfo:        tmpSrcTable,
-					DestTableInfo:       tmpDestTable,
-				})
-			}
-		}
-	}
-
-	tableStructure = convertedTableStructure
-	slog.Info("table structure conversion completed", "finalTableCount", len(tableStructure))
-
 	if opsType == "sourceAnalyze" {
 		slog.Info("starting sourceAnalyze operation",
 			"totalTableStructures", len(tableStructure),
@@ -361,280 +600,134 @@ func main() {
 			}
 		}
 		slog.Debug("pattern 04 scan complete")
+
+		fmt.Printf("\n\n---------- Lossy column types \n")
+		for idx, table := range tableStructure {
+			for _, issue := range table.TypeIssues {
+				slog.Warn("lossy column type detected", "index", idx, "issue", issue)
+				fmt.Printf("idx: %d, %s \n", idx, issue)
+			}
+		}
+		fmt.Printf("\n\n---------- Table sizes \n")
+		mapping.PrintSizes(os.Stdout, tableStructure, cfg.Workload)
 		slog.Info("sourceAnalyze operation finished")
 		return
 	}
 
 	if opsType == "generateDumpling" {
-		// Ensure config.Output is set; fallback to current directory if empty
-		outputDir := config.Output
-		if outputDir == "" {
-			outputDir = "."
+		if tmpl == nil {
+			slog.Error("no dumpling template configured")
+			log.Fatalf("No dumpling template, set Template or DumplingPreset in the config, or --template/--dumpling-preset")
 		}
-
-		// Open a single output file for all dumpling commands
-		dumplingPath := fmt.Sprintf("%s/dumpling.sh", outputDir)
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			slog.Error("failed to create output directory", "error", err, "outputDir", outputDir)
-			log.Fatalf("Failed to create output directory: %v", err)
-		}
-		dumplingFile, err := os.Create(dumplingPath)
+		dumplingPath, err := render.RenderDumpling(&cfg, tableStructure, tmpl, artifacts)
 		if err != nil {
-			slog.Error("failed to create dumpling.sh", "error", err, "dumplingPath", dumplingPath)
-			log.Fatalf("Failed to create dumpling.sh: %v", err)
+			slog.Error("failed to render dumpling.sh", "error", err)
+			log.Fatalf("Failed to render dumpling.sh: %v", err)
 		}
-		defer dumplingFile.Close()
-
-		// Write shell header and environment-variable template to dumpling.sh
-		header := `#!/bin/bash
 
-export DBHOST=
-export DBPORT=
-export DBUSER=
-export DBPASSWORD=
-export DUMPLING_OUTPUT=
+		updateState(cfg.Output, func(ledger *stateLedger, now time.Time) {
+			ledger.markGenerated(tableStructure, phaseDump, now)
+		})
+		// Record the pk offsets the dump was taken with. The mapping snapshot is left to
+		// generateDMConfig, the routes of this mapping are not synthesized.
+		if !artifacts.PlanOnly() && len(cfg.PKOffset.Tables) > 0 {
+			if err := drift.SavePKOffsets(drift.PKOffsetsPath(cfg.Output), tableStructure, time.Now()); err != nil {
+				slog.Warn("failed to save pk offsets", "error", err)
+			}
+		}
+		slog.Info("generateDumpling operation finished", "dumplingPath", dumplingPath)
+	}
 
-`
-		if _, err := dumplingFile.WriteString(header); err != nil {
-			slog.Error("failed to write header to dumpling.sh", "error", err)
-			log.Fatalf("Failed to write header to dumpling.sh: %v", err)
+	if opsType == "generateLightningConfig" {
+		slog.Info("starting lightning config generation", "tableStructureCount", len(tableStructure))
+		if err := render.RenderLightningConfig(&cfg, &tableStructure, artifacts); err != nil {
+			slog.Error("failed to render lightning config", "error", err, "tableStructureCount", len(tableStructure))
+			fmt.Printf("Error rendering lightning config: %v\n", err)
+			return
 		}
+		updateState(cfg.Output, func(ledger *stateLedger, now time.Time) {
+			ledger.markGenerated(tableStructure, phaseImport, now)
+		})
+		slog.Info("completed lightning config generation", "sourceDBCount", len(cfg.SourceDB), "output", cfg.Output)
+	}
 
-		slog.Info("starting generateDumpling operation",
-			"totalTableStructures", len(tableStructure),
-			"description", "generating dumpling commands for table mappings",
-			"outputPath", dumplingPath)
-		for _, tableInfo := range tableStructure {
-			// Case 1: One-to-one mapping
-			if len(tableInfo.SrcTableInfo) == 1 && len(tableInfo.DestTableInfo) == 1 {
-				srcTable := tableInfo.SrcTableInfo[0]
-				srcParts := strings.Split(tableInfo.SrcTableInfo[0], ".")
-				destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
-
-				sourceData := fetchDumpingSourceData(srcParts[0], srcParts[1], srcParts[2],
-					tableInfo.DestHasSource, tableInfo.DestHasSchema, tableInfo.DestHasTableName)
-
-				dbName := strings.Split(srcTable, ".")[0]
-				data := struct {
-					SrcTable       string
-					DestTable      string
-					SrcSchemaName  string
-					SrcTableName   string
-					DestSchemaName string
-					DestTableName  string
-					InstanceName   string
-					SourceData     string
-				}{
-					SrcTable:       fmt.Sprintf("%s.%s", srcParts[1], srcParts[2]),
-					DestTable:      fmt.Sprintf("%s.%s.{{.Index}}", destParts[1], destParts[2]),
-					SrcSchemaName:  srcParts[1],
-					SrcTableName:   srcParts[2],
-					DestSchemaName: destParts[1],
-					DestTableName:  destParts[2],
-					InstanceName:   dbName,
-					SourceData:     sourceData,
-				}
+	if opsType == "generateDDL" {
+		slog.Info("starting destination DDL generation", "tableStructureCount", len(tableStructure), "applyDDL", applyDDL)
+		files, skipped, err := render.GenerateDestDDL(&cfg, &tableStructure, artifacts)
+		if err != nil {
+			slog.Error("failed to generate destination DDL", "error", err)
+			fmt.Printf("Error generating destination DDL: %v\n", err)
+			return
+		}
+		for _, skip := range skipped {
+			fmt.Printf("Skipped: destination table %s is already used by %s \n", skip.DestTable, skip.UsedBy)
+		}
+		for _, file := range files {
+			fmt.Printf("Generated: %s \n", file)
+		}
 
-				var buf bytes.Buffer
-				if err := tmpl.Execute(&buf, data); err != nil {
-					slog.Error("template execution failed",
-						"case", "one-to-one",
-						"srcTable", srcTable,
-						"error", err)
-					log.Printf("Error executing template: %v", err)
-				} else {
-					slog.Debug("dumpling command generated",
-						"case", "one-to-one",
-						"srcTable", srcTable,
-						"destTable", tableInfo.DestTableInfo[0],
-						"dbName", dbName)
-					if _, werr := fmt.Fprintf(dumplingFile, "%s\n", buf.String()); werr != nil {
-						slog.Error("failed to write dumpling command to file", "error", werr, "dumplingPath", dumplingPath)
-					}
-				}
+		if applyDDL && artifacts.PlanOnly() {
+			fmt.Printf("DDL not applied in plan mode, run with --apply to apply it. \n")
+		} else if applyDDL {
+			if err := render.ApplyDestDDL(&cfg, files, artifacts); err != nil {
+				slog.Error("failed to apply destination DDL", "error", err)
+				fmt.Printf("Error applying destination DDL: %v\n", err)
+				return
 			}
 
-			// Case 2: Many-to-many mapping with same table names and count
-			if len(tableInfo.SrcTableInfo) > 1 && len(tableInfo.DestTableInfo) > 1 &&
-				len(tableInfo.SrcTableInfo) == len(tableInfo.DestTableInfo) {
-				slog.Debug("processing many-to-many mapping with same count",
-					"srcCount", len(tableInfo.SrcTableInfo),
-					"destCount", len(tableInfo.DestTableInfo))
-
-				// Match tables by comparing table names after the schema
-				for i := 0; i < len(tableInfo.SrcTableInfo); i++ {
-					srcParts := strings.Split(tableInfo.SrcTableInfo[i], ".")
-					srcTableName := srcParts[len(srcParts)-1]
-					dbName := srcParts[0]
-
-					// Find matching destination table
-					for j := 0; j < len(tableInfo.DestTableInfo); j++ {
-						destParts := strings.Split(tableInfo.DestTableInfo[j], ".")
-						destTableName := destParts[len(destParts)-1]
-
-						if srcTableName == destTableName {
-							data := struct {
-								SrcTable       string
-								DestTable      string
-								SrcSchemaName  string
-								SrcTableName   string
-								DestSchemaName string
-								DestTableName  string
-								InstanceName   string
-							}{
-								SrcTable:       fmt.Sprintf("%s.%s", srcParts[1], srcParts[2]),
-								DestTable:      fmt.Sprintf("%s.%s.{{.Index}}", destParts[1], destParts[2]),
-								SrcSchemaName:  srcParts[1],
-								SrcTableName:   srcParts[2],
-								DestSchemaName: destParts[1],
-								DestTableName:  destParts[2],
-								InstanceName:   dbName,
-							}
-
-							var buf bytes.Buffer
-							if err := tmpl.Execute(&buf, data); err != nil {
-								slog.Error("template execution failed",
-									"case", "many-to-many",
-									"srcTable", tableInfo.SrcTableInfo[i],
-									"destTable", tableInfo.DestTableInfo[j],
-									"error", err)
-								log.Printf("Error executing template: %v", err)
-							} else {
-								slog.Debug("dumpling command generated",
-									"case", "many-to-many",
-									"srcTable", tableInfo.SrcTableInfo[i],
-									"destTable", tableInfo.DestTableInfo[j],
-									"dbName", dbName)
-								if _, werr := fmt.Fprintf(dumplingFile, "%s\n", buf.String()); werr != nil {
-									slog.Error("failed to write dumpling command to file", "error", werr, "dumplingPath", dumplingPath)
-								}
-							}
-							break
-						}
-					}
-				}
+			// Re-run the grouping to confirm every source group has its destination now
+			missing, err := render.VerifyDestCoverage(cfg, mapping.Options{})
+			if err != nil {
+				slog.Error("failed to verify destination coverage", "error", err)
+				fmt.Printf("Error verifying destination coverage: %v\n", err)
+				return
 			}
-
-			// Case 3: Many-to-one consolidation
-			if len(tableInfo.SrcTableInfo) > 1 && len(tableInfo.DestTableInfo) == 1 {
-				slog.Debug("processing many-to-one consolidation",
-					"srcCount", len(tableInfo.SrcTableInfo),
-					"destTable", tableInfo.DestTableInfo[0])
-
-				destTable := tableInfo.DestTableInfo[0]
-				destParts := strings.Split(destTable, ".")
-				for idx, srcTable := range tableInfo.SrcTableInfo {
-					srcParts := strings.Split(srcTable, ".")
-					sourceData := fetchDumpingSourceData(srcParts[0], srcParts[1], srcParts[2],
-						tableInfo.DestHasSource, tableInfo.DestHasSchema, tableInfo.DestHasTableName)
-
-					dbName := srcParts[0]
-					data := struct {
-						SrcTable       string
-						DestTable      string
-						SrcSchemaName  string
-						SrcTableName   string
-						DestSchemaName string
-						DestTableName  string
-						InstanceName   string
-						SourceData     string
-					}{
-						SrcTable:       fmt.Sprintf("%s.%s", srcParts[1], srcParts[2]),
-						DestTable:      fmt.Sprintf("%s.%s.%05d{{.Index}}", destParts[1], destParts[2], idx+1),
-						SrcSchemaName:  srcParts[1],
-						SrcTableName:   srcParts[2],
-						DestSchemaName: destParts[1],
-						DestTableName:  destParts[2],
-						InstanceName:   dbName,
-						SourceData:     sourceData,
-					}
-
-					var buf bytes.Buffer
-					if err := tmpl.Execute(&buf, data); err != nil {
-						slog.Error("template execution failed",
-							"case", "many-to-one",
-							"srcTable", srcTable,
-							"destTable", destTable,
-							"error", err)
-						log.Printf("Error executing template: %v", err)
-					} else {
-						slog.Debug("dumpling command generated",
-							"case", "many-to-one",
-							"srcTable", srcTable,
-							"destTable", destTable,
-							"dbName", dbName,
-							"consolidationIndex", idx+1)
-						if _, werr := fmt.Fprintf(dumplingFile, "%s\n", buf.String()); werr != nil {
-							slog.Error("failed to write dumpling command to file", "error", werr, "dumplingPath", dumplingPath)
-						}
-					}
-				}
-				// TODO: Implement consolidation logic
+			for _, tableInfo := range missing {
+				fmt.Printf("No destination table: %d source table(s), first: %s \n", len(tableInfo.SrcTableInfo), tableInfo.SrcTableInfo[0])
+			}
+			if len(missing) > 0 {
+				slog.Error("source groups without destination after applying DDL", "missingCount", len(missing))
+				log.Fatalf("%d source group(s) still have no destination table", len(missing))
 			}
+			fmt.Printf("All the source groups have the destination table. \n")
 		}
+		slog.Info("completed destination DDL generation", "fileCount", len(files))
+		finishArtifacts()
+		return
+	}
 
-		// Explicitly flush and close the file to ensure all data is written
-		if err := dumplingFile.Sync(); err != nil {
-			slog.Error("failed to sync dumpling.sh to disk", "error", err, "dumplingPath", dumplingPath)
+	if opsType == "generateTiCDCReverse" {
+		slog.Info("starting TiCDC reverse changefeed generation", "tableStructureCount", len(tableStructure))
+		issues, err := render.RenderTiCDCReverse(&cfg, &tableStructure, artifacts)
+		if err != nil {
+			slog.Error("failed to render TiCDC reverse changefeeds", "error", err)
+			fmt.Printf("Error rendering TiCDC reverse changefeeds: %v\n", err)
+			return
 		}
-		if err := dumplingFile.Close(); err != nil {
-			slog.Error("failed to close dumpling.sh", "error", err, "dumplingPath", dumplingPath)
+		for _, issue := range issues {
+			fmt.Printf("Not replicated back: %s from %s, %s \n", issue.DestTable, strings.Join(issue.SrcTables, ","), issue.Reason)
 		}
-
-		slog.Info("generateDumpling operation finished", "dumplingPath", dumplingPath)
+		if len(issues) > 0 {
+			slog.Warn("groups cannot be routed back to their shards", "issueCount", len(issues))
+		}
+		slog.Info("completed TiCDC reverse changefeed generation", "issueCount", len(issues))
+		finishArtifacts()
+		return
 	}
 
 	// Generate the regex for table consolidations
-	mapPatterns := make(map[string]string)
 	if opsType == "generateSyncDiffconfig" || opsType == "generateDMConfig" {
-		slog.Info("starting regex generation for table consolidations", "opsType", opsType, "totalTableStructures", len(tableStructure))
-		for idx := range tableStructure {
-			if len(tableStructure[idx].SrcTableInfo) > 2 {
-				slog.Debug("processing table structure for regex generation",
-					"index", idx,
-					"srcTableCount", len(tableStructure[idx].SrcTableInfo),
-					"destTableCount", len(tableStructure[idx].DestTableInfo),
-					"md5Columns", tableStructure[idx].MD5Columns)
-
-				// Get all source tables except the current one
-				allSourceTables := make([]string, 0)
-				for i := range tableStructure {
-					if i != idx {
-						allSourceTables = append(allSourceTables, tableStructure[i].SrcTableInfo...)
-					}
-				}
-				slog.Debug("prepared exclusion list for regex generation",
-					"currentIndex", idx,
-					"exclusionCount", len(allSourceTables))
-
-				regex, err := generateRegex(tableStructure[idx].SrcTableInfo, allSourceTables, mapPatterns)
-				if err != nil {
-					slog.Error("failed to generate regex for table consolidation",
-						"error", err,
-						"index", idx,
-						"srcTables", tableStructure[idx].SrcTableInfo,
-						"exclusionCount", len(allSourceTables))
-				}
-
-				if regex != nil {
-					tableStructure[idx].SrcRegex = *regex
-					slog.Debug("successfully generated regex",
-						"index", idx,
-						"regex", *regex,
-						"srcTableCount", len(tableStructure[idx].SrcTableInfo))
-				} else {
-					slog.Warn("regex generation returned nil result",
-						"index", idx,
-						"srcTableCount", len(tableStructure[idx].SrcTableInfo))
-				}
-			}
+		synthesizer := rules.New(rules.Options{LLMProduct: llmProduct, RecordFile: llmRecordFile, ReplayFile: llmReplayFile})
+		if err := synthesizer.AssignSrcRegex(tableStructure); err != nil {
+			slog.Error("failed to generate the routes of the merged groups", "error", err)
+			fmt.Printf("Error generating the routes: %v\n", err)
+			return
 		}
-		slog.Info("completed regex generation for table consolidations", "processedCount", len(tableStructure))
 	}
 
 	// Fetch the max id from the target table for incremental diff operations
-	slog.Info("starting max ID retrieval for incremental diff", "incrementalDiffTables", config.IncrementalDiffTables)
-	err = SetMaxID4IncreDiff(config, tableStructure)
+	slog.Info("starting max ID retrieval for incremental diff", "incrementalDiffTables", cfg.IncrementalDiffTables)
+	err = render.SetMaxID4IncreDiff(cfg, tableStructure, artifacts)
 	if err != nil {
 		slog.Error("failed to set max ID for incremental diff", "error", err)
 		return
@@ -649,16 +742,22 @@ export DUMPLING_OUTPUT=
 	if opsType == "generateSyncDiffconfig" {
 		slog.Info("starting sync diff config generation", "tableStructureCount", len(tableStructure))
 
-		var syncDiffOutput *SyncDiffOutput
-		summaryPath := "./output/summary.txt"
-		if _, err := os.Stat(summaryPath); err == nil {
+		var syncDiffOutput *syncdiff.Output
+		summaryPaths := syncDiffSummaryPaths(cfg)
+		summaryPath := strings.Join(summaryPaths, ",")
+		if summariesExist(summaryPaths) {
 			slog.Info("found existing sync diff summary file", "path", summaryPath)
-			syncDiffOutput, err = ParseSyncDiffOutput(summaryPath)
+			syncDiffOutput, err = syncdiff.MergeSummaries(summaryPaths)
 			if err != nil {
 				slog.Error("failed to parse sync diff output", "error", err, "path", summaryPath)
+				fmt.Printf("Error parsing summary: %v\n", err)
 				return
 			}
+			printSyncDiffOutput(syncDiffOutput)
 			slog.Debug("parsed sync diff output", "inconsistentTableCount", len(syncDiffOutput.InconsistentTables))
+			updateState(cfg.Output, func(ledger *stateLedger, now time.Time) {
+				ledger.recordSyncDiff(syncDiffOutput, now)
+			})
 		} else {
 			slog.Debug("no existing sync diff summary file found", "path", summaryPath)
 		}
@@ -669,7 +768,7 @@ export DUMPLING_OUTPUT=
 				"inconsistentCount", len(syncDiffOutput.InconsistentTables),
 				"summaryPath", summaryPath)
 
-			filtered := make([]TableInfo, 0, len(tableStructure))
+			filtered := make([]mapping.TableInfo, 0, len(tableStructure))
 			for _, ti := range tableStructure {
 				for _, failed := range syncDiffOutput.InconsistentTables {
 					// Match by destination table names (stored in ti.DestTableInfo)
@@ -700,7 +799,7 @@ export DUMPLING_OUTPUT=
 				"filteredCount", len(filtered),
 				"originalCount", len(tableStructure),
 				"inconsistentCount", len(syncDiffOutput.InconsistentTables))
-			err = RenderSyncDiffConfig(&config, &filtered)
+			err = render.RenderSyncDiffConfig(&cfg, &filtered, artifacts)
 			if err != nil {
 				slog.Error("failed to render sync diff config with filtered tables",
 					"error", err,
@@ -711,7 +810,7 @@ export DUMPLING_OUTPUT=
 			slog.Info("rendering sync diff config with all table structures",
 				"totalCount", len(tableStructure),
 				"hasInconsistentTables", syncDiffOutput != nil && len(syncDiffOutput.InconsistentTables) > 0)
-			err = RenderSyncDiffConfig(&config, &tableStructure)
+			err = render.RenderSyncDiffConfig(&cfg, &tableStructure, artifacts)
 			if err != nil {
 				slog.Error("failed to render sync diff config",
 					"error", err,
@@ -719,14 +818,17 @@ export DUMPLING_OUTPUT=
 				return
 			}
 		}
+		updateState(cfg.Output, func(ledger *stateLedger, now time.Time) {
+			ledger.markGenerated(tableStructure, phaseSyncDiff, now)
+		})
 		slog.Info("completed sync diff config generation",
 			"opsType", opsType,
-			"configOutputPath", config.Output)
+			"configOutputPath", cfg.Output)
 	}
 
 	if opsType == "generateDMConfig" {
 		slog.Info("starting DM config generation")
-		err := RenderDMSourceConfig(&config)
+		err := render.RenderDMSourceConfig(&cfg, artifacts)
 		if err != nil {
 			slog.Error("failed to render DM source config", "error", err)
 			fmt.Printf("Error rendering DM source config: %v\n", err)
@@ -734,894 +836,29 @@ export DUMPLING_OUTPUT=
 		}
 		slog.Debug("completed DM source config generation")
 
-		err = RenderDMTaskConfig(&config, &tableStructure)
+		err = render.RenderDMTaskConfig(&cfg, &tableStructure, artifacts)
 		if err != nil {
 			slog.Error("failed to render DM task config", "error", err, "tableStructureCount", len(tableStructure))
 			fmt.Printf("Error rendering DM task config: %v\n", err)
 			return
 		}
-		slog.Info("completed DM config generation",
-			"tableStructureCount", len(tableStructure),
-			"sourceDBCount", len(config.SourceDB))
-	}
-
-	// Function already ends here; return is redundant and removed
-}
-
-type RuleResult struct {
-	Rule string `json:"rule"`
-}
-
-func generateGeneralRegex(dataList []string, dataListShouldNotMatch []string) (*string, error) {
-	var client *openai.Client
-	if llmProduct == "" {
-		slog.Warn("llmProduct not configured, returning placeholder regex", "dataList", dataList, "dataListShouldNotMatch", dataListShouldNotMatch)
-		return &[]string{"---------- todo ----------"}[0], nil
-	}
-
-	// Log the LLM product selection for troubleshooting
-	slog.Debug("configuring LLM client", "llmProduct", llmProduct)
-
-	if llmProduct == "deepseek" {
-		config := openai.DefaultConfig(os.Getenv("DEEPSEEK_API_KEY"))
-		config.BaseURL = "https://api.deepseek.com/v1"
-		client = openai.NewClientWithConfig(config)
-		slog.Debug("deepseek client initialized", "baseURL", config.BaseURL)
-	} else {
-		client = openai.NewClient(os.Getenv("OPENAI_API_KEY"))
-		slog.Debug("openai client initialized")
-	}
-
-	// Log the full data list for debugging pattern generation
-	slog.Debug("generating regex pattern", "dataListCount", len(dataList), "shouldNotMatchCount", len(dataListShouldNotMatch), "dataList", strings.Join(dataList, ", "))
-
-	tools := []openai.Tool{
-		{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "rule_is_valid",
-				Description: "Verify if the given rule matches all required names and excludes others. The rule should match the exact database naming pattern.",
-				Parameters: map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"rule": map[string]interface{}{
-							"type":        "string",
-							"description": "The candidate rule to validate.",
-						},
-						"dbs_to_match": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"type": "string",
-							},
-							"description": "List of names that the rule MUST match (e.g., 'db_01', 'db_02').",
-						},
-						"dbs_to_exclude": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"type": "string",
-							},
-							"description": "List of names that the rule MUST NOT match.",
-						},
-					},
-					"required": []string{"rule", "dbs_to_match", "dbs_to_exclude"},
-				},
-			},
-		},
-	}
-
-	// System message for database name regex generation
-	system := openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleSystem,
-		Content: strings.Join([]string{
-			"You are an assistant that generates concise and accurate pattern-matching rules according to the given specification. ",
-		}, "\n"),
-	}
-
-	samplingNum := calculateSampleSize(len(dataList))
-	sampledData := sampleData(dataList, samplingNum)
-
-	// Log sampling details for troubleshooting
-	slog.Debug("sampled data for pattern generation", "originalCount", len(dataList), "sampleSize", samplingNum, "sampledData", strings.Join(sampledData, ", "))
-
-	user := openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser,
-		Content: strings.Join([]string{
-			"Rules must follow the pattern specification below:",
-			"1. Pattern Characters:",
-			"  - '*': Matches zero or more characters (must be the last character)",
-			"  - '?': Matches exactly one character",
-			"  - '[...]': Matches a single character from the specified range",
-			"2. Range Pattern Format:",
-			"  - [a-z]: Matches any single character from 'a' to 'z'",
-			"  - [!a-z]: Matches any single character NOT in range 'a' to 'z'",
-			"  - [abc]: Matches 'a', 'b', or 'c'",
-			"3. Limitations:",
-			"  - '*' can only appear at the end of the pattern",
-			"  - Each '?' matches exactly one character",
-			"  - Range patterns are case-sensitive",
-			"  - Empty patterns are not allowed",
-			"  - Maximum pattern length is not restricted",
-			"4. Pattern Types and Examples:",
-			"  a. Exact Match:",
-			"    - \"abc\" matches exactly \"abc\"",
-			"    - \"abd\" matches exactly \"abd\"",
-			"  b. Single Character Wildcard (?):",
-			"    - \"?bc\" matches \"abc\", \"dbc\"",
-			"    - \"a?c\" matches \"abc\", \"adc\"",
-			"    - \"ab?\" matches \"abc\", \"abd\"",
-			"  c. Multi-Character Wildcard (*):",
-			"    - \"ab*\" matches \"abc\", \"abcd\", \"abcde\"",
-			"    - \"schema*\" matches \"schema1\", \"schema12\"",
-			"    - \"test*\" matches \"test1\", \"test_abc\"",
-			"   Note: '*' must be the last character",
-			"  d. Character Range ([...]):",
-			"    - \"ik[hjkl]\" matches \"ikh\", \"ikj\", \"ikk\", \"ikl\" ",
-			"    - \"ik[f-h]\" matches \"ikf\", \"ikg\", \"ikh\"",
-			"    - \"i[x-z][1-3]\" matches \"ix1\", \"iy2\", \"iz3\"",
-			"  e. Negated Range ([!...]):",
-			"    - \"ik[!zxc]\" matches any \"ik\" followed by any character except 'z', 'x', 'c'",
-			"    - \"ik[!a-ce-g]\" matches any \"ik\" followed by any character not in ranges a-c and e-g",
-			fmt.Sprintf("Create a pattern rule for these sampling values: %s", strings.Join(sampledData, ", ")),
-		}, "\n"),
-	}
-
-	var model string
-	if llmProduct == "deepseek" {
-		model = "deepseek-chat"
-		slog.Debug("selected deepseek model", "model", model)
-	} else {
-		model = openai.GPT3Dot5Turbo
-		slog.Debug("selected openai model", "model", model)
-	}
-	messages := []openai.ChatCompletionMessage{system, user}
-	const maxRounds = 5
-	for round := 1; round <= maxRounds; round++ {
-		slog.Debug("starting LLM conversation round", "round", round, "maxRounds", maxRounds)
-
-		if round == 4 {
-			// Log full conversation history on round 4 for deep debugging
-			slog.Debug("dumping conversation history for debugging", "round", round)
-			for _, message := range messages {
-				slog.Debug("conversation message", "role", message.Role, "content", message.Content)
-			}
-		}
-
-		resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-			Model:       model,
-			Messages:    messages,
-			Temperature: 0.7,
-			Tools:       tools,
+		updateState(cfg.Output, func(ledger *stateLedger, now time.Time) {
+			ledger.markGenerated(tableStructure, phaseDMTask, now)
 		})
-		if err != nil {
-			slog.Error("LLM chat completion failed", "round", round, "error", err, "model", model)
-			log.Fatalf("ChatCompletion error (round %d): %v", round, err)
-		}
-
-		assistant := resp.Choices[0].Message
-		slog.Debug("received LLM response", "round", round, "hasToolCalls", len(assistant.ToolCalls) > 0)
-
-		if len(assistant.ToolCalls) > 0 {
-			// Add the assistant message with tool_calls to history
-			messages = append(messages, assistant)
-
-			for _, tc := range assistant.ToolCalls {
-				if tc.Function.Name != "rule_is_valid" {
-					slog.Debug("skipping non-rule_is_valid tool call", "toolName", tc.Function.Name)
-					continue
-				}
-
-				// Parse arguments
-				var args RuleResult
-				if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
-					// If parsing fails, give the model a helpful error signal
-					slog.Error("failed to parse rule_is_valid arguments", "error", err, "arguments", tc.Function.Arguments)
-					toolContent := ToolReturn{Valid: false, Error: "Bad JSON arguments for rule_is_valid"}
-					contentBytes, _ := json.Marshal(toolContent)
-					messages = append(messages, openai.ChatCompletionMessage{
-						Role:       openai.ChatMessageRoleTool,
-						ToolCallID: tc.ID,
-						Content:    string(contentBytes),
-					})
-					continue
-				}
-
-				// Run your local validator
-				slog.Debug("validating generated rule", "rule", args.Rule, "dataListCount", len(dataList), "shouldNotMatchCount", len(dataListShouldNotMatch))
-				toolContent := rule_is_valid(args.Rule, dataList, dataListShouldNotMatch)
-				slog.Debug("rule validation completed", "rule", args.Rule, "valid", toolContent.Valid, "missedMatches", len(toolContent.MissedMatches), "falsePositives", len(toolContent.FalsePositives))
-
-				if toolContent.Valid {
-					slog.Info("successfully generated valid regex pattern", "rule", toolContent.Rule, "round", round)
-					return &toolContent.Rule, nil
-				}
-				contentBytes, _ := json.Marshal(toolContent)
-
-				messages = append(messages, openai.ChatCompletionMessage{
-					Role:       openai.ChatMessageRoleTool,
-					ToolCallID: tc.ID,
-					Content:    string(contentBytes),
-				})
+		// The routes of the DM task are what drift checks before the cutover
+		if !artifacts.PlanOnly() {
+			if err := drift.Save(drift.SnapshotPath(cfg.Output), tableStructure, time.Now()); err != nil {
+				slog.Warn("failed to save mapping snapshot", "error", err)
 			}
-
-		} else {
-			rule := strings.TrimSpace(assistant.Content)
-			slog.Debug("validating rule from assistant content", "rule", rule)
-			result := rule_is_valid(rule, dataList, dataListShouldNotMatch)
-			if result.Valid {
-				slog.Info("successfully generated valid regex pattern from content", "rule", result.Rule, "round", round)
-				return &rule, nil
-			}
-			slog.Debug("rule validation failed", "rule", rule, "missedMatches", len(result.MissedMatches), "falsePositives", len(result.FalsePositives))
 		}
+		slog.Info("completed DM config generation",
+			"tableStructureCount", len(tableStructure),
+			"sourceDBCount", len(cfg.SourceDB))
 	}
 
-	slog.Error("failed to generate regex after max rounds", "maxRounds", maxRounds, "dataListCount", len(dataList), "shouldNotMatchCount", len(dataListShouldNotMatch))
-	fmt.Println("********** Failed: Stopped after max rounds without a final answer. ")
-
-	return nil, fmt.Errorf("failed to generate regex")
-}
-
-/*
- * This regex generation is used to detect the tables that are in the same structure for sync_diff_inspector which
- * only allow one routes.rule to compare the data between source tables and destination table. The only one regex is required
- * to conver all the source tables while it should not match any other tables.
- */
-func generateRegex(tables []string, tablesShouldNotMatch []string, mapPatterns map[string]string) (*string, error) {
-	slog.Debug("starting regex generation",
-		"inputTableCount", len(tables),
-		"exclusionCount", len(tablesShouldNotMatch),
-		"cacheSize", len(mapPatterns))
-
-	var err error
-
-	dbList, tableList := splitTables(tables)
-	_, tableListExclude := splitTables(tablesShouldNotMatch)
-
-	slog.Debug("split tables into components",
-		"dbList", dbList,
-		"tableList", tableList,
-		"tableListExclude", tableListExclude)
-
-	var dbRegex *string
-	if len(dbList) == 1 {
-		dbRegex = &dbList[0]
-		slog.Debug("using single db name as regex", "db", *dbRegex)
-	} else {
-		// Calculate MD5 of dbList and lookup in mapPatterns
-		dbListStr := strings.Join(dbList, ",")
-		dbListMD5 := fmt.Sprintf("%x", md5.Sum([]byte(dbListStr)))
-		slog.Debug("calculated db list MD5", "md5", dbListMD5, "dbListStr", dbListStr)
-
-		if cachedRegex, ok := mapPatterns[dbListMD5]; ok {
-			dbRegex = &cachedRegex
-			slog.Debug("found cached db regex", "md5", dbListMD5, "regex", *dbRegex)
-		} else {
-			slog.Debug("generating new db regex", "dbList", dbList)
-			dbRegex, err = generateGeneralRegex(dbList, nil)
-			if err != nil {
-				slog.Error("failed to generate db regex", "error", err, "dbList", dbList)
-				return nil, err
-			}
-			if dbRegex != nil {
-				mapPatterns[dbListMD5] = *dbRegex
-				slog.Debug("cached newly generated db regex", "md5", dbListMD5, "regex", *dbRegex)
-			}
-		}
-	}
-
-	var tableRegex *string
-	if len(tableList) == 1 {
-		tableRegex = &tableList[0]
-		slog.Debug("using single table name as regex", "table", *tableRegex)
-	} else {
-		tableListMD5 := fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(tableList, ","))))
-		slog.Debug("calculated table list MD5", "md5", tableListMD5)
-
-		if cachedRegex, ok := mapPatterns[tableListMD5]; ok {
-			tableRegex = &cachedRegex
-			slog.Debug("found cached table regex", "md5", tableListMD5, "regex", *tableRegex)
-		} else {
-			slog.Debug("generating new table regex", "tableList", tableList, "excludeList", tableListExclude)
-			tableRegex, err = generateGeneralRegex(tableList, tableListExclude)
-			if err != nil {
-				slog.Error("failed to generate table regex", "error", err, "tableList", tableList, "excludeList", tableListExclude)
-				return nil, err
-			}
-			if tableRegex != nil {
-				mapPatterns[tableListMD5] = *tableRegex
-				slog.Debug("cached newly generated table regex", "md5", tableListMD5, "regex", *tableRegex)
-			}
-		}
-	}
-
-	if dbRegex == nil || tableRegex == nil {
-		emptyStr := ""
-		slog.Warn("regex generation returned nil component", "dbRegex", dbRegex != nil, "tableRegex", tableRegex != nil)
-		return &emptyStr, nil
-	}
-	regex := fmt.Sprintf("%s.%s", *dbRegex, *tableRegex)
-	slog.Debug("final regex assembled", "regex", regex)
-
-	return &regex, nil
-}
-
-type ToolReturn struct {
-	Rule           string   `json:"rule"`
-	Valid          bool     `json:"valid"`
-	MissedMatches  []string `json:"missed_matches"`
-	FalsePositives []string `json:"false_positives"`
-	Error          string   `json:"error"`
-}
-
-func rule_is_valid(pattern string, tables []string, tablesShouldNotMatch []string) ToolReturn {
-	slog.Debug("starting rule validation",
-		"pattern", pattern,
-		"tablesToMatch", len(tables),
-		"tablesToExclude", len(tablesShouldNotMatch))
-
-	result := ToolReturn{
-		Rule:           pattern,
-		Valid:          true,
-		MissedMatches:  []string{},
-		FalsePositives: []string{},
-		Error:          "",
-	}
-
-	// Create a new trie selector
-	ts := selector.NewTrieSelector()
-
-	// Create a rule for tables
-	// Let's say we want to match all tables that:
-	// - Are in schema "mydb"
-	schema := "mydb"
-
-	// Define a rule (can be any type)
-	rulePattern := struct {
-		Action   string
-		Priority int
-	}{
-		Action:   "verification",
-		Priority: 1,
-	}
-
-	// Insert the rule
-	err := ts.Insert(schema, pattern, rulePattern, selector.Insert)
-	if err != nil {
-		slog.Error("failed to insert rule into trie selector", "error", err, "pattern", pattern, "schema", schema)
-		result.Valid = false
-		result.Error = fmt.Sprintf("Failed to insert rule: %v", err)
-		return result
-	}
-	slog.Debug("rule inserted successfully", "pattern", pattern, "schema", schema)
-
-	// Test all the tables which should match the rule
-	for _, table := range tables {
-		rules := ts.Match(schema, table)
-		if rules == nil {
-			result.MissedMatches = append(result.MissedMatches, table)
-			slog.Debug("table failed to match rule", "table", table, "pattern", pattern)
-			result.Valid = false
-		} else {
-			slog.Debug("table matched rule", "table", table, "pattern", pattern)
-		}
-	}
-
-	// Test all the tables which should not match the rule
-	for _, table := range tablesShouldNotMatch {
-		rules := ts.Match(schema, table)
-		if rules != nil {
-			result.FalsePositives = append(result.FalsePositives, table)
-			slog.Debug("table incorrectly matched rule (false positive)", "table", table, "pattern", pattern, "matchedRules", rules)
-			result.Valid = false
-		} else {
-			slog.Debug("table correctly excluded", "table", table, "pattern", pattern)
-		}
-	}
-
-	if len(result.MissedMatches) > 0 {
-		result.Valid = false
-		result.Error = "The validator reports these missed matches (see tool message). You must refine the rule so that all previously provided names match."
-		slog.Debug("validation failed due to missed matches", "missedCount", len(result.MissedMatches), "missedTables", result.MissedMatches)
-	}
-
-	if len(result.FalsePositives) > 0 {
-		result.Valid = false
-		result.Error = fmt.Sprintf("%s \nThe validator reports false positives (see tool message). You must refine the rule so that all of names are excluded.", result.Error)
-		slog.Debug("validation failed due to false positives", "falsePositiveCount", len(result.FalsePositives), "falsePositiveTables", result.FalsePositives)
-	}
-
-	slog.Debug("rule validation completed", "valid", result.Valid, "pattern", pattern)
-	return result
-}
-func fetch_table_def(tableType string, tableStructure *[]TableInfo, dbInfo DBConnInfo) error {
-	// The Data Source Name (DSN) string
-	// Format: "user:password@tcp(host:port)/database?param=value"
-	// Replace with your actual database credentials
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", dbInfo.User, dbInfo.Password, dbInfo.Host, dbInfo.Port, dbInfo.DBs[0])
-	slog.Debug("building DSN for fetch_table_def", "tableType", tableType, "dbName", dbInfo.Name, "host", dbInfo.Host, "port", dbInfo.Port, "dbCount", len(dbInfo.DBs))
-
-	// 1. Open a database handle
-	// This does not yet establish a connection, but it prepares the database object.
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		slog.Error("failed to open database connection", "error", err, "tableType", tableType, "dbName", dbInfo.Name, "dsn", dsn)
-		return fmt.Errorf("open mysql: %w", err)
-	}
-	// Ensure the connection is closed when the main function exits.
-	defer db.Close()
-
-	// 2. Ping the database to verify the connection
-	// This performs a real check to see if the database is reachable.
-	if err := db.Ping(); err != nil {
-		slog.Error("failed to ping database", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
-		return fmt.Errorf("ping mysql: %w", err)
-	}
-	slog.Debug("successfully connected to database", "tableType", tableType, "dbName", dbInfo.Name)
-
-	// 2. Define the SQL query with placeholders
-	// case when upper(COLUMN_TYPE) IN ('BIGINT', 'INT', 'MEDIUMINT', 'SMALLINT', 'TINYINT') then '0' else NUMERIC_PRECISION end
-	// create table (..., col1 int(2) ...) -> the ddl is converted to create table (..., col1 int ...). Compatible to MySQL 8.0
-	query := fmt.Sprintf(`
-		SELECT
-		    TABLE_SCHEMA, 
-			TABLE_NAME,
-			MD5(GROUP_CONCAT(
-              CASE WHEN COLUMN_NAME NOT IN ('c_instance', 'c_schema', 'c_table') 
-                THEN COLUMN_NAME 
-              END 
-            ORDER BY COLUMN_NAME ASC SEPARATOR ','
-            )),
-			MD5(GROUP_CONCAT(
-              CASE WHEN COLUMN_NAME NOT IN ('c_instance', 'c_schema', 'c_table') 
-                   THEN CONCAT_WS(':',         
-                          COLUMN_NAME,
-                          DATA_TYPE,
-                          IS_NULLABLE,
-                          CHARACTER_MAXIMUM_LENGTH,
-                          CASE WHEN UPPER(DATA_TYPE) IN ('BIGINT', 'INT', 'MEDIUMINT', 'SMALLINT', 'TINYINT') 
-                               THEN '0' ELSE NUMERIC_PRECISION END,
-                          NUMERIC_SCALE,
-                          DATETIME_PRECISION)
-                   END 
-              ORDER BY COLUMN_NAME ASC SEPARATOR ','
-         )),
-		 MAX(CASE WHEN COLUMN_NAME = 'c_instance' THEN 1 ELSE 0 END) AS DestHasSource,
-         MAX(CASE WHEN COLUMN_NAME = 'c_schema'   THEN 1 ELSE 0 END) AS DestHasSchema,
-         MAX(CASE WHEN COLUMN_NAME = 'c_table'    THEN 1 ELSE 0 END) AS DestHasTableName
-		 FROM INFORMATION_SCHEMA.COLUMNS
-		WHERE TABLE_SCHEMA in ('%s') 
-		GROUP BY TABLE_SCHEMA, TABLE_NAME
-		ORDER BY TABLE_SCHEMA, TABLE_NAME;
-	`, strings.Join(dbInfo.DBs, "','"))
-	slog.Debug("generated INFORMATION_SCHEMA query", "tableType", tableType, "dbName", dbInfo.Name, "dbList", strings.Join(dbInfo.DBs, ","))
-
-	//                 COLUMN_DEFAULT,
-
-	// fmt.Printf("Query: %s \n", query)
-
-	// 3. Define the database and table you want to query
-	// databaseName := "orderdb_01"
-	// tableName := "your_table_name"
-
-	// 4. Prepare the SQL statement to prevent SQL injection
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		slog.Error("failed to prepare statement", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
-		return fmt.Errorf("prepare query: %w", err)
-	}
-	defer stmt.Close()
-
-	// 5. Execute the query with the table names as parameters
-	rows, err := stmt.Query()
-	if err != nil {
-		slog.Error("failed to execute query", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
-		return fmt.Errorf("execute query: %w", err)
-	}
-	defer rows.Close()
-
-	// 6. Iterate through the results
-	rowCount := 0
-	for rows.Next() {
-		var tableSchema, tableName, md5Columns, md5ColumnsWithTypes string
-		var hasSource, hasSchema, hasTableName int
-		if err := rows.Scan(&tableSchema, &tableName, &md5Columns, &md5ColumnsWithTypes, &hasSource, &hasSchema, &hasTableName); err != nil {
-			slog.Error("failed to scan row", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
-			return fmt.Errorf("scan row: %w", err)
-		}
-		rowCount++
-		slog.Debug("scanned table metadata", "tableType", tableType, "dbName", dbInfo.Name, "schema", tableSchema, "table", tableName, "md5Columns", md5Columns, "md5ColumnsWithTypes", md5ColumnsWithTypes)
-
-		// Create new TableInfo struct and append to slice
-		newTableInfo := TableInfo{
-			MD5Columns:          md5Columns,
-			MD5ColumnsWithTypes: md5ColumnsWithTypes,
-		}
-		if tableType == "source" {
-			newTableInfo.SrcTableInfo = []string{fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName)}
-		} else {
-			newTableInfo.DestTableInfo = []string{fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName)}
-		}
-
-		// Check if similar table structure exists
-		found := false
-		for i, existing := range *tableStructure {
-			if existing.MD5Columns == newTableInfo.MD5Columns &&
-				existing.MD5ColumnsWithTypes == newTableInfo.MD5ColumnsWithTypes {
-				if tableType == "source" {
-					existing.SrcTableInfo = append(existing.SrcTableInfo,
-						fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName))
-					(*tableStructure)[i] = existing
-				} else {
-					existing.DestTableInfo = append(existing.DestTableInfo,
-						fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName))
-					existing.DestHasSource = hasSource == 1
-					existing.DestHasSchema = hasSchema == 1
-					existing.DestHasTableName = hasTableName == 1
-					(*tableStructure)[i] = existing
-				}
-				found = true
-				slog.Debug("merged table into existing structure", "tableType", tableType, "schema", tableSchema, "table", tableName, "md5Columns", md5Columns)
-				break
-			}
-		}
-
-		// If no match found, append new structure
-		if !found {
-			*tableStructure = append(*tableStructure, newTableInfo)
-			slog.Debug("appended new table structure", "tableType", tableType, "schema", tableSchema, "table", tableName, "md5Columns", md5Columns)
-		}
-	}
-
-	if err := rows.Err(); err != nil {
-		slog.Error("error occurred during row iteration", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
-		return fmt.Errorf("rows iteration: %w", err)
-	}
-
-	slog.Info("completed fetch_table_def", "tableType", tableType, "dbName", dbInfo.Name, "rowCount", rowCount, "totalStructures", len(*tableStructure))
-	return nil
-}
-
-type Config struct {
-	SourceDB              []DBConnInfo `yaml:"SourceDB"`
-	DestDB                DBConnInfo   `yaml:"DestDB"`
-	Template              string       `yaml:"Template"`
-	Output                string       `yaml:"Output"`
-	ErrorLog              string       `yaml:"error_log"`
-	IncrementalDiffTables []string     `yaml:"IncrementalDiffTables"`
-}
-
-func readConfig(fileName string) (Config, error) {
-	var config Config
-
-	// Read the YAML file
-	yamlFile, err := os.ReadFile(fileName)
-	if err != nil {
-		slog.Error("failed to read config file", "fileName", fileName, "error", err)
-		return Config{}, fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	// Unmarshal the YAML into the Config struct
-	if err := yaml.Unmarshal(yamlFile, &config); err != nil {
-		slog.Error("failed to parse config file", "fileName", fileName, "error", err)
-		return Config{}, fmt.Errorf("failed to parse config file: %w", err)
-	}
-
-	// Validate required fields
-	if len(config.SourceDB) == 0 {
-		slog.Error("no source databases specified in config", "fileName", fileName)
-		return Config{}, fmt.Errorf("no source databases specified in config")
-	}
-
-	if config.DestDB.Host == "" {
-		slog.Error("destination database host not specified in config", "fileName", fileName)
-		return Config{}, fmt.Errorf("destination database host not specified")
-	}
-
-	slog.Debug("successfully read and validated config", "fileName", fileName, "sourceDBCount", len(config.SourceDB), "destDBHost", config.DestDB.Host)
-	return config, nil
-}
-
-// calculateSampleSize determines how many items to sample from the input data
-func calculateSampleSize(totalItems int) int {
-	switch {
-	case totalItems <= 100:
-		size := min(20, totalItems)
-		slog.Debug("calculated sample size for small dataset", "totalItems", totalItems, "sampleSize", size)
-		return size
-	case totalItems <= 1000:
-		extraItems := int(0.01 * float64(totalItems-100))
-		size := min(20+extraItems, 50)
-		slog.Debug("calculated sample size for medium dataset", "totalItems", totalItems, "sampleSize", size, "extraItems", extraItems)
-		return size
-	default:
-		slog.Debug("calculated sample size for large dataset", "totalItems", totalItems, "sampleSize", 50)
-		return 50
-	}
-}
-
-// sampleData takes a slice of data and returns a randomly sampled subset
-func sampleData(data []string, sampleSize int) []string {
-	if len(data) <= sampleSize {
-		slog.Debug("dataset smaller than or equal to sample size, returning full dataset", "dataSize", len(data), "sampleSize", sampleSize)
-		return data
-	}
-
-	half := sampleSize / 2
-	sample := make([]string, 0, sampleSize)
-
-	// Take first half
-	sample = append(sample, data[:half]...)
-
-	// Add middle placeholder
-	middleCount := len(data) - sampleSize
-	sample = append(sample, fmt.Sprintf("... other %d element ...", middleCount))
-
-	// Take last half
-	sample = append(sample, data[len(data)-half:]...)
-
-	slog.Debug("generated sample data", "originalSize", len(data), "sampleSize", sampleSize, "firstHalfSize", half, "lastHalfSize", half, "middlePlaceholder", middleCount)
-	return sample
-}
-
-func splitTables(tables []string) ([]string, []string) {
-	tmpTables := []string{}
-	// Convert table names from instanceName.DBName.Table format to DBName.Table
-	// by removing the instanceName prefix
-	dbList := []string{}
-	tableList := []string{}
-	for i := range tables {
-		parts := strings.Split(tables[i], ".")
-		if len(parts) == 3 {
-			tmpTables = append(tmpTables, fmt.Sprintf("%s.%s", parts[1], parts[2]))
-		}
-		found := false
-		for _, db := range dbList {
-			if db == parts[1] {
-				found = true
-				break
-			}
-		}
-		if !found {
-			dbList = append(dbList, parts[1])
-		}
-
-		found = false
-		for _, table := range tableList {
-			if table == parts[2] {
-				found = true
-				break
-			}
-		}
-		if !found {
-			tableList = append(tableList, parts[2])
-		}
-	}
-
-	slog.Debug("split tables into components", "inputTableCount", len(tables), "dbCount", len(dbList), "tableCount", len(tableList), "dbList", dbList, "tableList", tableList)
-	return dbList, tableList
-}
-
-func fetchDumpingSourceData(srcInstance, srcSchema, srcTable string, hasSourceCol, hasSchemaCol, hasTableCol bool) string {
-	slog.Debug("generating dumping source data", "srcInstance", srcInstance, "srcSchema", srcSchema, "srcTable", srcTable, "hasSourceCol", hasSourceCol, "hasSchemaCol", hasSchemaCol, "hasTableCol", hasTableCol)
-	if !hasSourceCol && !hasSchemaCol && !hasTableCol {
-		result := fmt.Sprintf("--tables-list '%s.%s'", srcSchema, srcTable)
-		slog.Debug("no metadata columns needed, using simple table list", "result", result)
-		return result
-	}
-	var selectCols []string
-	if hasSourceCol {
-		selectCols = append(selectCols, fmt.Sprintf("'%s' as c_source", srcInstance))
-	}
-	if hasSchemaCol {
-		selectCols = append(selectCols, fmt.Sprintf("'%s' as c_schema", srcSchema))
-	}
-	if hasTableCol {
-		selectCols = append(selectCols, fmt.Sprintf("'%s' as c_table", srcTable))
-	}
-	result := fmt.Sprintf("-S \"SELECT *, %s FROM %s.%s\"", strings.Join(selectCols, ", "), srcSchema, srcTable)
-	slog.Debug("metadata columns needed, generated SELECT query", "selectCols", selectCols, "result", result)
-	return result
-}
-
-func SetMaxID4IncreDiff(config Config, tableStructure []TableInfo) error {
-	slog.Info("starting incremental diff max ID processing", "incrementalDiffTables", config.IncrementalDiffTables, "outputDir", config.Output)
-
-	outputFile := fmt.Sprintf("%s/sync-diff-id.txt", config.Output)
-	if _, err := os.Stat(outputFile); err == nil {
-		// File exists, read it
-		slog.Info("found existing max ID file, reading cached values", "outputFile", outputFile)
-		content, err := os.ReadFile(outputFile)
-		if err != nil {
-			slog.Error("failed to read existing max ID file", "outputFile", outputFile, "error", err)
-			return fmt.Errorf("failed to read file %s: %w", outputFile, err)
-		}
-		slog.Debug("read existing max ID file content", "outputFile", outputFile, "contentLength", len(content))
-		// Parse each line in the content (format: schema.table:maxID)
-		lines := strings.Split(string(content), "\n")
-		parsedCount := 0
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			parts := strings.Split(line, ":")
-			if len(parts) != 2 {
-				slog.Warn("skipping malformed line in max ID file", "line", line, "outputFile", outputFile)
-				continue
-			}
-			schemaTable := parts[0]
-			maxID, err := strconv.ParseInt(parts[1], 10, 64)
-			if err != nil {
-				slog.Error("failed to parse maxID from line", "line", line, "schemaTable", schemaTable, "error", err)
-				continue
-			}
-			parsedCount++
-
-			// Find the matching table in tableStructure and set MaxID
-			matched := false
-			for i := range tableStructure {
-				for _, destTable := range tableStructure[i].DestTableInfo {
-					// Extract schema.table from destTable (instance.schema.table)
-					destParts := strings.Split(destTable, ".")
-					if len(destParts) == 3 {
-						destSchemaTable := fmt.Sprintf("%s.%s", destParts[1], destParts[2])
-						if destSchemaTable == schemaTable {
-							tableStructure[i].MaxID = maxID
-							matched = true
-							slog.Debug("applied cached max ID to table structure", "schemaTable", schemaTable, "maxID", maxID, "destTable", destTable)
-							break
-						}
-					}
-				}
-				if matched {
-					break
-				}
-			}
-			if !matched {
-				slog.Warn("no matching table found for cached max ID", "schemaTable", schemaTable, "maxID", maxID)
-			}
-		}
-		slog.Info("completed loading cached max IDs", "parsedCount", parsedCount, "totalLines", len(lines))
-		return nil
-	} else if errors.Is(err, os.ErrNotExist) {
-		// File does not exist, create it
-		slog.Info("no existing max ID file found, will query databases for max IDs", "outputFile", outputFile)
-	} else {
-		// Some other error
-		slog.Error("error checking max ID file existence", "outputFile", outputFile, "error", err)
-		return fmt.Errorf("error checking file %s: %w", outputFile, err)
-	}
-	mapMaxIDs := make(map[string]int64)
-
-	// Loop through tableStructure and find items whose target table is in IncrementalDiffTables
-	tablesProcessed := 0
-	queriesExecuted := 0
-	matchedIncTables := 0
-	slog.Debug("starting incremental diff table matching", "totalTableStructures", len(tableStructure), "incrementalDiffTables", config.IncrementalDiffTables)
-
-	for i := range tableStructure {
-		tableInfo := &tableStructure[i]
-		slog.Debug("checking table structure", "index", i, "destTableCount", len(tableInfo.DestTableInfo), "srcTableCount", len(tableInfo.SrcTableInfo))
-
-		for _, destTable := range tableInfo.DestTableInfo {
-			// Extract SchemaName.TableName from destTable (targetName.SchemaName.TableName)
-			destParts := strings.Split(destTable, ".")
-			if len(destParts) != 3 {
-				slog.Warn("skipping destTable with unexpected format", "destTable", destTable, "parts", len(destParts))
-				continue
-			}
-			destSchemaTable := fmt.Sprintf("%s.%s", destParts[1], destParts[2])
-			slog.Debug("extracted destSchemaTable", "destTable", destTable, "destSchemaTable", destSchemaTable)
-
-			for _, incTable := range config.IncrementalDiffTables {
-				slog.Debug("comparing with incremental diff table", "destSchemaTable", destSchemaTable, "incTable", incTable)
-				if destSchemaTable == incTable {
-					matchedIncTables++
-					tablesProcessed++
-					slog.Info("matched incremental diff table", "destSchemaTable", destSchemaTable, "incTable", incTable, "matchedCount", matchedIncTables)
-
-					for j, srcTable := range tableInfo.SrcTableInfo {
-						parts := strings.Split(srcTable, ".")
-						if len(parts) == 3 {
-							instance := parts[0]
-							schemaTable := fmt.Sprintf("%s.%s", parts[1], parts[2])
-							slog.Debug("processing source table for max ID", "index", j, "srcTable", srcTable, "instance", instance, "schemaTable", schemaTable)
-
-							// Find the DB config for this instance
-							var dbConfig *DBConnInfo
-							for _, srcDB := range config.SourceDB {
-								if srcDB.Name == instance {
-									dbConfig = &srcDB
-									break
-								}
-							}
-							if dbConfig != nil {
-								slog.Debug("found DB config for instance", "instance", instance, "dbHost", dbConfig.Host, "dbPort", dbConfig.Port, "dbName", dbConfig.DBs[0])
-								// Prepare DSN and open DB connection
-								dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", dbConfig.User, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.DBs[0])
-								db, err := sql.Open("mysql", dsn)
-								if err != nil {
-									slog.Error("failed to open DB connection for max ID query", "instance", instance, "schemaTable", schemaTable, "dsn", dsn, "error", err)
-									continue
-								}
-								// Note: defer in loop is generally discouraged, but acceptable here due to limited iteration count
-								defer db.Close()
-
-								if err := db.Ping(); err != nil {
-									slog.Error("failed to ping DB for max ID query", "instance", instance, "schemaTable", schemaTable, "error", err)
-									continue
-								}
-								slog.Debug("successfully connected to DB", "instance", instance, "schemaTable", schemaTable)
-
-								// Run the query: select max(id) from schemaTable
-								var maxID sql.NullInt64
-								query := fmt.Sprintf("SELECT MAX(id) FROM %s", schemaTable)
-								slog.Debug("executing max ID query", "instance", instance, "schemaTable", schemaTable, "query", query)
-								err = db.QueryRow(query).Scan(&maxID)
-								queriesExecuted++
-								if err != nil {
-									slog.Error("failed to get max id from table", "instance", instance, "schemaTable", schemaTable, "query", query, "error", err)
-									continue
-								}
-								slog.Debug("query result", "instance", instance, "schemaTable", schemaTable, "maxID.Valid", maxID.Valid, "maxID.Int64", maxID.Int64)
-								if maxID.Valid {
-									slog.Info("retrieved max ID from source table", "instance", instance, "schemaTable", schemaTable, "maxID", maxID.Int64)
-									if tableInfo.MaxID < maxID.Int64 {
-										oldMaxID := tableInfo.MaxID
-										tableInfo.MaxID = maxID.Int64
-										mapMaxIDs[destSchemaTable] = maxID.Int64
-										slog.Info("updated max ID for incremental diff table", "destSchemaTable", destSchemaTable, "oldMaxID", oldMaxID, "newMaxID", maxID.Int64, "instance", instance, "schemaTable", schemaTable)
-									} else {
-										slog.Debug("existing max ID is greater or equal", "destSchemaTable", destSchemaTable, "existingMaxID", tableInfo.MaxID, "newMaxID", maxID.Int64)
-									}
-								} else {
-									slog.Warn("no rows found in source table for max ID query", "instance", instance, "schemaTable", schemaTable, "query", query)
-								}
-							} else {
-								slog.Warn("no DB config found for instance", "instance", instance, "destSchemaTable", destSchemaTable, "availableInstances", func() []string {
-									var names []string
-									for _, db := range config.SourceDB {
-										names = append(names, db.Name)
-									}
-									return names
-								}())
-							}
-						} else {
-							slog.Warn("skipping source table with unexpected format", "srcTable", srcTable, "parts", len(parts))
-						}
-					}
-					break
-				} else {
-					slog.Debug("destSchemaTable does not match incTable", "destSchemaTable", destSchemaTable, "incTable", incTable)
-				}
-			}
-		}
-	}
-	slog.Info("completed incremental diff table processing", "tablesProcessed", tablesProcessed, "matchedIncTables", matchedIncTables, "queriesExecuted", queriesExecuted, "mapMaxIDsSize", len(mapMaxIDs))
-
-	slog.Info("completed max ID queries", "tablesProcessed", tablesProcessed, "queriesExecuted", queriesExecuted, "maxIDsCollected", len(mapMaxIDs))
-
-	// Write the mapMaxIDs to the output file
-	file, err := os.Create(outputFile)
-	if err != nil {
-		slog.Error("failed to create max ID output file", "outputFile", outputFile, "error", err)
-		return fmt.Errorf("failed to create file %s: %w", outputFile, err)
-	}
-	defer file.Close()
-
-	writtenCount := 0
-	for table, maxID := range mapMaxIDs {
-		_, err := fmt.Fprintf(file, "%s:%d\n", table, maxID)
-		if err != nil {
-			slog.Error("failed to write max ID to file", "outputFile", outputFile, "table", table, "maxID", maxID, "error", err)
-			return fmt.Errorf("failed to write to file %s: %w", outputFile, err)
-		}
-		writtenCount++
-		slog.Debug("wrote max ID to file", "table", table, "maxID", maxID)
-	}
+	finishArtifacts()
 
-	slog.Info("successfully wrote max IDs to file", "outputFile", outputFile, "writtenCount", writtenCount)
-	return nil
+	// Function already ends here; return is redundant and removed
 }
 
 func initLog() error {
@@ -1665,7 +902,7 @@ func initLog() error {
 					}
 				}
 			}
-			return a
+			return config.RedactAttr(groups, a)
 		},
 	}
 	logger := slog.New(slog.NewJSONHandler(logFile, opts))
@@ -1673,3 +910,71 @@ func initLog() error {
 	slog.Info("logger initialized", "logFile", "log/table_merge.log")
 	return nil
 }
+
+// loadConfig reads the config file and validates the type compatibility policy
+func loadConfig(fileName string) (config.Config, error) {
+	cfg, err := config.Load(fileName, config.LoadOptions{SecretKeyFile: secretKeyFile})
+	if err != nil {
+		return config.Config{}, err
+	}
+	if _, err := mapping.NewCompatPolicy(cfg.TypeCompatibility); err != nil {
+		slog.Error("invalid type compatibility policy", "fileName", fileName, "error", err)
+		return config.Config{}, fmt.Errorf("invalid type compatibility policy: %w", err)
+	}
+	return cfg, nil
+}
+
+// finishArtifacts shows the plan and writes the rendered files at the end of the operation
+func finishArtifacts() {
+	if err := artifacts.Finish(os.Stdout, time.Now()); err != nil {
+		slog.Error("failed to write the generated files", "error", err)
+		log.Fatalf("Failed to write the generated files: %v", err)
+	}
+}
+
+// syncDiffSummaryPaths returns the summary files of the last inspector run, one per rendered
+// shard if the sync-diff configs are sharded
+func syncDiffSummaryPaths(cfg config.Config) []string {
+	if cfg.SyncDiff.Shards <= 1 {
+		return []string{render.SyncDiffOutputDir + "/summary.txt"}
+	}
+	paths := []string{}
+	for shard := 1; shard <= cfg.SyncDiff.Shards; shard++ {
+		// A shard without groups has no config
+		if _, err := os.Stat(filepath.Join(cfg.Output, render.SyncDiffShardConfigName(shard))); err != nil {
+			continue
+		}
+		paths = append(paths, render.SyncDiffShardOutputDir(shard)+"/summary.txt")
+	}
+	return paths
+}
+
+// summariesExist reports whether every summary is there. The tables of a shard still running
+// must not be dropped from the next check.
+func summariesExist(paths []string) bool {
+	if len(paths) == 0 {
+		return false
+	}
+	missing := []string{}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) > 0 && len(missing) < len(paths) {
+		slog.Warn("not every sync diff shard has a summary, checking all the tables", "missing", missing)
+	}
+	return len(missing) == 0
+}
+
+// printSyncDiffOutput shows the parsed sync-diff summary
+func printSyncDiffOutput(output *syncdiff.Output) {
+	syncdiff.PrintResults(output.EquivalentTables, output.InconsistentTables)
+
+	fmt.Println("\n=== JSON OUTPUT ===")
+	jsonData, _ := json.MarshalIndent(output, "", "  ")
+	fmt.Println(string(jsonData))
+
+	fmt.Printf("\nSummary: %d equivalent, %d inconsistent, All Equivalent: %v\n",
+		output.TotalEquivalent, output.TotalInconsistent, output.AllEquivalent)
+}
@@ -0,0 +1,391 @@
+// Package audit compares the charsets, collations, sql_mode and lower_case_table_names of the
+// source instances with the destination. The grouping only compares the column types, these
+// differences surface at the import or as duplicated keys after the merge.
+package audit
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// Severity of an issue
+const (
+	// Blocking fails the import or the replication, or loses rows
+	Blocking = "blocking"
+	// Warning changes how the values are compared or stored, the migration goes through
+	Warning = "warning"
+)
+
+// Kind of an issue
+const (
+	SQLMode             = "sql_mode"
+	LowerCaseTableNames = "lower_case_table_names"
+	Charset             = "charset"
+	Collation           = "collation"
+	// NotAudited is an instance whose engine has no charset metadata
+	NotAudited = "not_audited"
+)
+
+// strictModes reject at the destination the values the source accepted without them
+var strictModes = []string{"STRICT_TRANS_TABLES", "STRICT_ALL_TABLES", "NO_ZERO_DATE", "NO_ZERO_IN_DATE"}
+
+// narrowCharsets can not store most of the characters of the other charsets
+var narrowCharsets = map[string]bool{"ascii": true, "latin1": true, "binary": true}
+
+// Issue is a difference between the sources and the destination. The same difference of the
+// shards of a group is reported once with all the shards.
+type Issue struct {
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"`
+	// Target is the destination instance, or the schema.table[.column] of the destination
+	Target string `json:"target"`
+	// Sources are the source instances, or the instance.schema.table of the source tables
+	Sources     []string `json:"sources"`
+	SourceValue string   `json:"source_value"`
+	DestValue   string   `json:"dest_value"`
+	Detail      string   `json:"detail"`
+}
+
+// Report is the result of Run
+type Report struct {
+	Issues []Issue `json:"issues"`
+}
+
+// Blocking returns the number of blocking issues
+func (r Report) Blocking() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Severity == Blocking {
+			count++
+		}
+	}
+	return count
+}
+
+// Options are the options of Run
+type Options struct {
+	// QuerySettings reads the server settings of an instance, schema.Settings if nil
+	QuerySettings func(config.DBConnInfo) (schema.ServerSettings, error)
+	// QueryCharsets reads the charsets of the tables of an instance, schema.Charsets if nil
+	QueryCharsets func(dbInfo config.DBConnInfo, schemas []string) (map[string]schema.TableCharset, error)
+}
+
+func (o Options) querySettings() func(config.DBConnInfo) (schema.ServerSettings, error) {
+	if o.QuerySettings != nil {
+		return o.QuerySettings
+	}
+	return schema.Settings
+}
+
+func (o Options) queryCharsets() func(config.DBConnInfo, []string) (map[string]schema.TableCharset, error) {
+	if o.QueryCharsets != nil {
+		return o.QueryCharsets
+	}
+	return schema.Charsets
+}
+
+// collector merges the issues of the same difference
+type collector struct {
+	issues []*Issue
+	index  map[string]*Issue
+}
+
+func (c *collector) add(issue Issue, source string) {
+	key := strings.Join([]string{issue.Kind, issue.Target, issue.SourceValue, issue.DestValue}, "\x00")
+	if existing, ok := c.index[key]; ok {
+		existing.Sources = append(existing.Sources, source)
+		return
+	}
+	issue.Sources = []string{source}
+	c.issues = append(c.issues, &issue)
+	c.index[key] = &issue
+}
+
+// Run audits the source instances of the config against the destination, the tables are
+// compared along the groups of the mapping
+func Run(cfg config.Config, tableStructure []mapping.TableInfo, opts Options) (Report, error) {
+	c := &collector{index: map[string]*Issue{}}
+	if !schema.IsMySQLEngine(cfg.DestDB) {
+		return Report{}, fmt.Errorf("charsets of destination engine %s are not supported", cfg.DestDB.Engine)
+	}
+	destSettings, err := opts.querySettings()(cfg.DestDB)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to fetch server settings of %s: %w", cfg.DestDB.Name, err)
+	}
+	destCharsets, err := opts.queryCharsets()(cfg.DestDB, cfg.DestDB.DBs)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to fetch charsets of %s: %w", cfg.DestDB.Name, err)
+	}
+
+	// The tables are indexed by instance.schema.table like the mapping
+	charsets := map[string]schema.TableCharset{}
+	for table, charset := range destCharsets {
+		charsets[cfg.DestDB.Name+"."+table] = charset
+	}
+	for _, dbInfo := range cfg.SourceDB {
+		if !schema.IsMySQLEngine(dbInfo) {
+			slog.Warn("engine has no charset metadata, instance not audited", "dbName", dbInfo.Name, "engine", dbInfo.Engine)
+			c.add(Issue{Severity: Warning, Kind: NotAudited, Target: cfg.DestDB.Name, SourceValue: dbInfo.Engine,
+				Detail: "the charsets of the engine are not compared"}, dbInfo.Name)
+			continue
+		}
+		settings, err := opts.querySettings()(dbInfo)
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to fetch server settings of %s: %w", dbInfo.Name, err)
+		}
+		auditSQLMode(c, cfg.DestDB.Name, dbInfo.Name, settings.SQLMode, destSettings.SQLMode)
+		auditLowerCaseTableNames(c, cfg.DestDB.Name, dbInfo.Name, settings.LowerCaseTableNames, destSettings.LowerCaseTableNames, tableStructure)
+
+		instanceCharsets, err := opts.queryCharsets()(dbInfo, dbInfo.DBs)
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to fetch charsets of %s: %w", dbInfo.Name, err)
+		}
+		for table, charset := range instanceCharsets {
+			charsets[dbInfo.Name+"."+table] = charset
+		}
+		slog.Debug("fetched charsets", "dbName", dbInfo.Name, "tableCount", len(instanceCharsets))
+	}
+
+	for _, tableInfo := range tableStructure {
+		for _, pair := range destPairs(tableInfo) {
+			srcCharset, srcOK := charsets[pair[0]]
+			destCharset, destOK := charsets[pair[1]]
+			if !srcOK || !destOK {
+				continue
+			}
+			auditTable(c, pair[0], trimInstance(pair[1]), srcCharset, destCharset)
+		}
+	}
+
+	report := Report{Issues: []Issue{}}
+	for _, issue := range c.issues {
+		sort.Strings(issue.Sources)
+		report.Issues = append(report.Issues, *issue)
+	}
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.Severity != b.Severity {
+			return a.Severity == Blocking
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Target < b.Target
+	})
+	return report, nil
+}
+
+// destPairs returns the (source, destination) tables of the group, the many-to-many groups
+// are paired by the table name
+func destPairs(tableInfo mapping.TableInfo) [][2]string {
+	pairs := [][2]string{}
+	if len(tableInfo.DestTableInfo) == 0 {
+		return pairs
+	}
+	for _, src := range tableInfo.SrcTableInfo {
+		if len(tableInfo.DestTableInfo) == 1 {
+			pairs = append(pairs, [2]string{src, tableInfo.DestTableInfo[0]})
+			continue
+		}
+		for _, dest := range tableInfo.DestTableInfo {
+			if tableName(src) == tableName(dest) {
+				pairs = append(pairs, [2]string{src, dest})
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+func tableName(table string) string {
+	parts := strings.Split(table, ".")
+	return parts[len(parts)-1]
+}
+
+// trimInstance returns the schema.table of instance.schema.table
+func trimInstance(table string) string {
+	if parts := strings.SplitN(table, ".", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	return table
+}
+
+// splitModes returns the upper cased modes of the sql_mode
+func splitModes(sqlMode string) map[string]bool {
+	modes := map[string]bool{}
+	for _, mode := range strings.Split(sqlMode, ",") {
+		if mode = strings.ToUpper(strings.TrimSpace(mode)); mode != "" {
+			modes[mode] = true
+		}
+	}
+	return modes
+}
+
+// auditSQLMode reports the strict modes only the destination has as blocking, the source may
+// hold values they reject. Any other difference changes how the replicated statements behave.
+func auditSQLMode(c *collector, destName, srcName, srcMode, destMode string) {
+	srcModes, destModes := splitModes(srcMode), splitModes(destMode)
+	blocking := []string{}
+	for _, mode := range strictModes {
+		if destModes[mode] && !srcModes[mode] {
+			blocking = append(blocking, mode)
+		}
+	}
+	if len(blocking) > 0 {
+		c.add(Issue{Severity: Blocking, Kind: SQLMode, Target: destName, SourceValue: srcMode, DestValue: destMode,
+			Detail: fmt.Sprintf("only the destination has %s, the values the source accepted without it are rejected", strings.Join(blocking, ","))}, srcName)
+		return
+	}
+
+	different := []string{}
+	for mode := range srcModes {
+		if !destModes[mode] {
+			different = append(different, "-"+mode)
+		}
+	}
+	for mode := range destModes {
+		if !srcModes[mode] {
+			different = append(different, "+"+mode)
+		}
+	}
+	if len(different) > 0 {
+		sort.Strings(different)
+		c.add(Issue{Severity: Warning, Kind: SQLMode, Target: destName, SourceValue: srcMode, DestValue: destMode,
+			Detail: fmt.Sprintf("the destination differs by %s", strings.Join(different, ","))}, srcName)
+	}
+}
+
+// auditLowerCaseTableNames reports the source tables whose names collide when the destination
+// compares the names case-insensitively as blocking
+func auditLowerCaseTableNames(c *collector, destName, srcName string, srcValue, destValue int, tableStructure []mapping.TableInfo) {
+	if srcValue == destValue {
+		return
+	}
+	srcValueStr, destValueStr := fmt.Sprintf("%d", srcValue), fmt.Sprintf("%d", destValue)
+	if srcValue == 0 && destValue != 0 {
+		names := map[string][]string{}
+		for _, tableInfo := range tableStructure {
+			for _, src := range tableInfo.SrcTableInfo {
+				if parts := strings.SplitN(src, ".", 2); len(parts) == 2 && parts[0] == srcName {
+					names[strings.ToLower(parts[1])] = append(names[strings.ToLower(parts[1])], parts[1])
+				}
+			}
+		}
+		collisions := []string{}
+		for _, tables := range names {
+			if len(tables) > 1 {
+				sort.Strings(tables)
+				collisions = append(collisions, strings.Join(tables, "/"))
+			}
+		}
+		if len(collisions) > 0 {
+			sort.Strings(collisions)
+			c.add(Issue{Severity: Blocking, Kind: LowerCaseTableNames, Target: destName, SourceValue: srcValueStr, DestValue: destValueStr,
+				Detail: fmt.Sprintf("the tables %s are the same table in the destination", strings.Join(collisions, ", "))}, srcName)
+			return
+		}
+	}
+	c.add(Issue{Severity: Warning, Kind: LowerCaseTableNames, Target: destName, SourceValue: srcValueStr, DestValue: destValueStr,
+		Detail: "the table names are compared differently, check the case of the routes and the filters"}, srcName)
+}
+
+// normalizeCharset returns the charset name of MySQL 8.0, utf8 is utf8mb3
+func normalizeCharset(charset string) string {
+	charset = strings.ToLower(charset)
+	if charset == "utf8" {
+		return "utf8mb3"
+	}
+	return charset
+}
+
+// caseSensitive reports whether the collation tells apart the values differing by the case
+func caseSensitive(collation string) bool {
+	collation = strings.ToLower(collation)
+	return collation == "binary" || strings.HasSuffix(collation, "_bin") || strings.HasSuffix(collation, "_cs")
+}
+
+// auditTable compares the table collation and the string columns of a source table with its
+// destination table
+func auditTable(c *collector, srcTable, destTable string, src, dest schema.TableCharset) {
+	if src.Collation != "" && dest.Collation != "" && !strings.EqualFold(src.Collation, dest.Collation) {
+		c.add(Issue{Severity: Warning, Kind: Collation, Target: destTable, SourceValue: src.Collation, DestValue: dest.Collation,
+			Detail: "the default collation of the table differs, the columns added later get the destination one"}, srcTable)
+	}
+
+	columns := make([]string, 0, len(dest.Columns))
+	for column := range dest.Columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		destColumn := dest.Columns[column]
+		srcColumn, ok := src.Columns[column]
+		if !ok {
+			continue
+		}
+		target := destTable + "." + column
+		srcCharset, destCharset := normalizeCharset(srcColumn.Charset), normalizeCharset(destColumn.Charset)
+		switch {
+		case srcCharset == destCharset:
+		case srcCharset == "utf8mb4" && destCharset == "utf8mb3":
+			c.add(Issue{Severity: Blocking, Kind: Charset, Target: target, SourceValue: srcColumn.Charset, DestValue: destColumn.Charset,
+				Detail: "the 4-byte characters, e.g. emoji, are rejected by utf8mb3"}, srcTable)
+		case narrowCharsets[destCharset]:
+			c.add(Issue{Severity: Blocking, Kind: Charset, Target: target, SourceValue: srcColumn.Charset, DestValue: destColumn.Charset,
+				Detail: fmt.Sprintf("%s can not store the characters outside of it", destCharset)}, srcTable)
+		default:
+			c.add(Issue{Severity: Warning, Kind: Charset, Target: target, SourceValue: srcColumn.Charset, DestValue: destColumn.Charset,
+				Detail: "the values are converted, the indexed columns may exceed the key length"}, srcTable)
+		}
+
+		if strings.EqualFold(srcColumn.Collation, destColumn.Collation) {
+			continue
+		}
+		if destColumn.Unique && caseSensitive(srcColumn.Collation) && !caseSensitive(destColumn.Collation) {
+			c.add(Issue{Severity: Blocking, Kind: Collation, Target: target, SourceValue: srcColumn.Collation, DestValue: destColumn.Collation,
+				Detail: "the values differing by the case are duplicates of the unique key in the destination"}, srcTable)
+			continue
+		}
+		// The collation of another charset differs anyway, the charset issue covers it
+		if srcCharset != destCharset {
+			continue
+		}
+		c.add(Issue{Severity: Warning, Kind: Collation, Target: target, SourceValue: srcColumn.Collation, DestValue: destColumn.Collation,
+			Detail: "the values are compared and sorted differently"}, srcTable)
+	}
+}
+
+// Print shows the issues of the report
+func (r Report) Print(out io.Writer) {
+	if len(r.Issues) == 0 {
+		fmt.Fprintln(out, "No charset, collation, sql_mode or lower_case_table_names difference")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tKIND\tTARGET\tSOURCES\tSOURCE VALUE\tDEST VALUE\tDETAIL")
+	for _, issue := range r.Issues {
+		sources := issue.Sources[0]
+		if len(issue.Sources) > 1 {
+			sources = fmt.Sprintf("%s (+%d)", sources, len(issue.Sources)-1)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", issue.Severity, issue.Kind, issue.Target, sources,
+			valueOrDash(issue.SourceValue), valueOrDash(issue.DestValue), issue.Detail)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d blocking, %d warning\n", r.Blocking(), len(r.Issues)-r.Blocking())
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
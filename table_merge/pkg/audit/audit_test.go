@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// fakeInstances answers the queries of Run from the settings and the charsets indexed by the
+// instance name
+func fakeInstances(settings map[string]schema.ServerSettings, charsets map[string]map[string]schema.TableCharset) Options {
+	return Options{
+		QuerySettings: func(dbInfo config.DBConnInfo) (schema.ServerSettings, error) {
+			return settings[dbInfo.Name], nil
+		},
+		QueryCharsets: func(dbInfo config.DBConnInfo, schemas []string) (map[string]schema.TableCharset, error) {
+			return charsets[dbInfo.Name], nil
+		},
+	}
+}
+
+func ordersCharset(tableCollation, charset, collation string, unique bool) map[string]schema.TableCharset {
+	return map[string]schema.TableCharset{"db.orders": {
+		Collation: tableCollation,
+		Columns: map[string]schema.ColumnCharset{
+			"code": {Charset: charset, Collation: collation, Unique: unique},
+			"name": {Charset: charset, Collation: collation},
+		},
+	}}
+}
+
+func Test_Run(t *testing.T) {
+	cfg := config.Config{
+		SourceDB: []config.DBConnInfo{{Name: "mysql01"}, {Name: "mysql02"}},
+		DestDB:   config.DBConnInfo{Name: "target"},
+	}
+	tableStructure := []mapping.TableInfo{{
+		SrcTableInfo:  []string{"mysql01.db.orders", "mysql02.db.orders", "mysql02.db.Orders"},
+		DestTableInfo: []string{"target.db.orders"},
+	}}
+	opts := fakeInstances(
+		map[string]schema.ServerSettings{
+			"mysql01": {SQLMode: "STRICT_TRANS_TABLES,NO_ENGINE_SUBSTITUTION", LowerCaseTableNames: 2},
+			"mysql02": {SQLMode: "ONLY_FULL_GROUP_BY", LowerCaseTableNames: 0},
+			"target":  {SQLMode: "STRICT_TRANS_TABLES,NO_ENGINE_SUBSTITUTION", LowerCaseTableNames: 2},
+		},
+		map[string]map[string]schema.TableCharset{
+			"mysql01": ordersCharset("utf8mb4_bin", "utf8mb4", "utf8mb4_bin", true),
+			"mysql02": ordersCharset("utf8mb4_general_ci", "utf8mb4", "utf8mb4_general_ci", false),
+			"target":  ordersCharset("utf8mb4_general_ci", "utf8mb4", "utf8mb4_general_ci", true),
+		},
+	)
+	report, err := Run(cfg, tableStructure, opts)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	got := map[string]Issue{}
+	for _, issue := range report.Issues {
+		got[issue.Severity+" "+issue.Kind+" "+issue.Target] = issue
+	}
+	wants := map[string][]string{
+		"blocking sql_mode target":               {"mysql02"},
+		"blocking lower_case_table_names target": {"mysql02"},
+		"blocking collation db.orders.code":      {"mysql01.db.orders"},
+		"warning collation db.orders.name":       {"mysql01.db.orders"},
+		"warning collation db.orders":            {"mysql01.db.orders"},
+	}
+	for key, sources := range wants {
+		issue, ok := got[key]
+		if !ok {
+			t.Errorf("missing issue %s in %+v", key, report.Issues)
+			continue
+		}
+		if !reflect.DeepEqual(issue.Sources, sources) {
+			t.Errorf("%s sources = %v, want %v", key, issue.Sources, sources)
+		}
+	}
+	if len(report.Issues) != len(wants) {
+		t.Errorf("Run() = %+v, want %d issues", report.Issues, len(wants))
+	}
+	if report.Blocking() != 3 || report.Issues[0].Severity != Blocking {
+		t.Errorf("Blocking() = %d, issues not sorted by severity: %+v", report.Blocking(), report.Issues)
+	}
+	if !strings.Contains(got["blocking lower_case_table_names target"].Detail, "db.Orders/db.orders") {
+		t.Errorf("lower_case_table_names detail = %s", got["blocking lower_case_table_names target"].Detail)
+	}
+}
+
+func Test_auditTableCharsets(t *testing.T) {
+	tests := []struct {
+		name         string
+		src, dest    string
+		wantSeverity string
+	}{
+		{name: "utf8mb4 to utf8mb3", src: "utf8mb4", dest: "utf8", wantSeverity: Blocking},
+		{name: "utf8mb4 to latin1", src: "utf8mb4", dest: "latin1", wantSeverity: Blocking},
+		{name: "utf8mb3 to utf8mb4", src: "utf8mb3", dest: "utf8mb4", wantSeverity: Warning},
+		{name: "utf8 is utf8mb3", src: "utf8", dest: "utf8mb3", wantSeverity: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &collector{index: map[string]*Issue{}}
+			auditTable(c, "mysql01.db.orders", "db.orders",
+				schema.TableCharset{Columns: map[string]schema.ColumnCharset{"name": {Charset: tt.src}}},
+				schema.TableCharset{Columns: map[string]schema.ColumnCharset{"name": {Charset: tt.dest}}})
+			if tt.wantSeverity == "" {
+				if len(c.issues) != 0 {
+					t.Errorf("issues = %+v, want none", c.issues)
+				}
+				return
+			}
+			if len(c.issues) != 1 || c.issues[0].Kind != Charset || c.issues[0].Severity != tt.wantSeverity {
+				t.Errorf("issues = %+v, want one %s charset issue", c.issues, tt.wantSeverity)
+			}
+		})
+	}
+}
+
+func TestReportPrint(t *testing.T) {
+	report := Report{Issues: []Issue{{Severity: Blocking, Kind: Charset, Target: "db.orders.name",
+		Sources: []string{"mysql01.db_00.orders", "mysql01.db_01.orders"}, SourceValue: "utf8mb4", DestValue: "utf8mb3", Detail: "emoji"}}}
+	var out bytes.Buffer
+	report.Print(&out)
+	for _, want := range []string{"mysql01.db_00.orders (+1)", "1 blocking, 0 warning"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Print() does not contain %s:\n%s", want, out.String())
+		}
+	}
+}
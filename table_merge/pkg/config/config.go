@@ -0,0 +1,308 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DBConnInfo is the connection and the tables of one database instance
+type DBConnInfo struct {
+	Name     string   `yaml:"Name"`
+	Host     string   `yaml:"Host"`
+	Port     int      `yaml:"Port"`
+	User     string   `yaml:"User"`
+	Password string   `yaml:"Password"`
+	DBs      []string `yaml:"DBs"`
+	// DMPassword is the password encrypted by `dmctl encrypt`, used in the DM configs
+	DMPassword string `yaml:"DMPassword"`
+	// Engine is mysql(default), tidb or postgresql
+	Engine string `yaml:"Engine"`
+	// Database is the PostgreSQL database, DBs are its schemas
+	Database string `yaml:"Database"`
+
+	// Connection options
+	TLS               TLSConfig         `yaml:"TLS"`
+	ConnectTimeout    time.Duration     `yaml:"ConnectTimeout"`
+	ReadTimeout       time.Duration     `yaml:"ReadTimeout"`
+	WriteTimeout      time.Duration     `yaml:"WriteTimeout"`
+	Charset           string            `yaml:"Charset"`
+	SessionVariables  map[string]string `yaml:"SessionVariables"`
+	InterpolateParams bool              `yaml:"InterpolateParams"`
+
+	// Filter selects the tables of the instance, combined with the global filter
+	Filter TableFilter `yaml:"Filter"`
+
+	// passwordRef is the env:/file:/enc: reference the Password was resolved from
+	passwordRef string
+	// cleartextOutput is Config.CleartextPasswords, set by Resolve
+	cleartextOutput bool
+}
+
+// Config is the config file of the toolkit
+type Config struct {
+	SourceDB []DBConnInfo `yaml:"SourceDB"`
+	DestDB   DBConnInfo   `yaml:"DestDB"`
+	Template string       `yaml:"Template"`
+	// DumplingPreset is the name of the embedded dumpling template used if Template is empty
+	DumplingPreset        string          `yaml:"DumplingPreset"`
+	Output                string          `yaml:"Output"`
+	ErrorLog              string          `yaml:"error_log"`
+	IncrementalDiffTables []string        `yaml:"IncrementalDiffTables"`
+	Lightning             LightningConfig `yaml:"Lightning"`
+	DDL                   DDLConfig       `yaml:"DDL"`
+	// Filter is applied to all the source and destination instances
+	Filter TableFilter `yaml:"Filter"`
+	// CleartextPasswords writes the passwords configured as cleartext into the generated files.
+	// By default the files reference the environment variable of the password.
+	CleartextPasswords bool `yaml:"CleartextPasswords"`
+	// TypeCompatibility decides which source tables are grouped to a destination table
+	TypeCompatibility TypeCompatibilityConfig `yaml:"TypeCompatibility"`
+	// PKOffset rewrites the primary key of the merged shards whose ids overlap
+	PKOffset PKOffsetConfig `yaml:"PKOffset"`
+	// Workload plans the dumpling export from the table statistics
+	Workload WorkloadConfig `yaml:"Workload"`
+	// SyncDiff sets the generated sync_diff_inspector configs
+	SyncDiff SyncDiffConfig `yaml:"SyncDiff"`
+	// DMTask splits the DM task into several tasks
+	DMTask DMTaskConfig `yaml:"DMTask"`
+	// TiCDC sets the reverse changefeeds of the rollback
+	TiCDC TiCDCConfig `yaml:"TiCDC"`
+}
+
+// LightningConfig holds the settings of the generated tidb-lightning configs
+type LightningConfig struct {
+	Backend          string `yaml:"Backend"`
+	SortedKVDir      string `yaml:"SortedKVDir"`
+	DataSourceDir    string `yaml:"DataSourceDir"`
+	CheckpointDriver string `yaml:"CheckpointDriver"`
+	StatusPort       int    `yaml:"StatusPort"`
+	PDAddr           string `yaml:"PDAddr"`
+}
+
+// DDLConfig holds the settings of the generated destination DDL
+type DDLConfig struct {
+	// TargetSchema is the destination schema of the generated tables. DestDB.DBs[0] is used if empty.
+	TargetSchema string `yaml:"TargetSchema"`
+	// OriginColumns are appended to the merged tables(c_instance, c_schema, c_table) and added
+	// to the primary key to avoid the key conflict between shards.
+	OriginColumns []string `yaml:"OriginColumns"`
+}
+
+// TLSConfig holds the TLS settings of one database connection
+type TLSConfig struct {
+	// Mode is one of disabled(default), preferred, required, verify-ca and verify-identity
+	Mode string `yaml:"Mode"`
+	CA   string `yaml:"CA"`
+	Cert string `yaml:"Cert"`
+	Key  string `yaml:"Key"`
+	// ServerName overrides the host name verified in verify-identity mode
+	ServerName string `yaml:"ServerName"`
+}
+
+// TypeCompatibilityConfig is the policy deciding which source tables are grouped to a destination table
+type TypeCompatibilityConfig struct {
+	// MinLevel is the weakest compatibility of every column to group the tables:
+	// identical, widening-safe(default) or lossy
+	MinLevel string `yaml:"MinLevel"`
+	// Overrides replace the built-in classification of a source -> destination data type pair
+	Overrides []TypeCompatibilityOverride `yaml:"Overrides"`
+}
+
+// TypeCompatibilityOverride is the classification of one data type pair
+type TypeCompatibilityOverride struct {
+	Source string `yaml:"Source"`
+	Dest   string `yaml:"Dest"`
+	Level  string `yaml:"Level"`
+}
+
+// PKOffsetConfig moves the ids of every shard of a merged table into its own range. The offset
+// of a shard is the DM partition id of its instance, schema and table, so that dumpling and
+// the DM column mapping produce the same ids.
+type PKOffsetConfig struct {
+	// Tables are the destination schema.table of the many-to-one groups to rewrite
+	Tables []string `yaml:"Tables"`
+	// Column is the auto-increment primary key, id if empty
+	Column string `yaml:"Column"`
+	// Separator splits the shard number from the schema and table names, _ if empty
+	Separator string `yaml:"Separator"`
+}
+
+// The defaults of the workload planning
+const (
+	DefaultExportMBps       = 50
+	DefaultChunkThresholdMB = 1024
+	DefaultChunkRows        = 200000
+	DefaultChunkFileSize    = "256MiB"
+)
+
+// WorkloadConfig sizes the dumpling export from TABLE_ROWS, DATA_LENGTH and INDEX_LENGTH of
+// the source tables
+type WorkloadConfig struct {
+	// Workers is the number of dumpling scripts the exports are balanced over, 1 if empty
+	Workers int `yaml:"Workers"`
+	// ExportMBps is the export rate of one worker used by the time estimates
+	ExportMBps float64 `yaml:"ExportMBps"`
+	// ChunkThresholdMB is the data size from which a table is exported in chunks, negative to
+	// never chunk
+	ChunkThresholdMB int64 `yaml:"ChunkThresholdMB"`
+	// ChunkRows and ChunkFileSize are the dumpling --rows and --filesize of the large tables
+	ChunkRows     int64  `yaml:"ChunkRows"`
+	ChunkFileSize string `yaml:"ChunkFileSize"`
+}
+
+// WithDefaults returns the workload config with the defaults of the empty settings
+func (w WorkloadConfig) WithDefaults() WorkloadConfig {
+	if w.Workers < 1 {
+		w.Workers = 1
+	}
+	if w.ExportMBps <= 0 {
+		w.ExportMBps = DefaultExportMBps
+	}
+	if w.ChunkThresholdMB == 0 {
+		w.ChunkThresholdMB = DefaultChunkThresholdMB
+	}
+	if w.ChunkRows <= 0 {
+		w.ChunkRows = DefaultChunkRows
+	}
+	if w.ChunkFileSize == "" {
+		w.ChunkFileSize = DefaultChunkFileSize
+	}
+	return w
+}
+
+// SyncDiffConfig holds the settings of the generated sync_diff_inspector configs
+type SyncDiffConfig struct {
+	// Shards splits the tables into the configs of parallel inspectors balanced by the
+	// estimated rows, a single sync-diff.toml if 0 or 1
+	Shards int `yaml:"Shards"`
+	// CheckThreadCount is the check-thread-count of every config, 10 if empty
+	CheckThreadCount int `yaml:"CheckThreadCount"`
+}
+
+// DMTaskConfig splits the generated DM task. A sharding group is never split, all its sources
+// are in the same task.
+type DMTaskConfig struct {
+	// MaxSources is the most source instances of a task, unlimited if 0
+	MaxSources int `yaml:"MaxSources"`
+	// MaxRoutes is the most routes of a task, unlimited if 0
+	MaxRoutes int `yaml:"MaxRoutes"`
+	// Groups put the destination tables they match into the named tasks, the other tables
+	// are split by MaxSources and MaxRoutes
+	Groups []DMTaskGroup `yaml:"Groups"`
+}
+
+// DMTaskGroup is a task of explicitly assigned destination tables
+type DMTaskGroup struct {
+	Name string `yaml:"Name"`
+	// Tables are the schema/table patterns of the destination tables, the same syntax as the
+	// table filters
+	Tables []TableRule `yaml:"Tables"`
+}
+
+// Split reports whether the DM task is split into several tasks
+func (d DMTaskConfig) Split() bool {
+	return d.MaxSources > 0 || d.MaxRoutes > 0 || len(d.Groups) > 0
+}
+
+// TiCDCConfig holds the settings of the TiCDC changefeeds replicating the destination back to
+// the source instances
+type TiCDCConfig struct {
+	// SinkURI is the sink of the changefeed of each instance, {instance} is replaced with the
+	// instance name. kafka://${KAFKA_ADDR}/dm-toolkit-reverse-{instance}?protocol=canal-json if empty
+	SinkURI string `yaml:"SinkURI"`
+}
+
+// LoadOptions are the options of Load
+type LoadOptions struct {
+	// SecretKeyFile is the local key decrypting the enc: passwords
+	SecretKeyFile string
+	// RejectPasswordRefs refuses the env:, file: and enc: passwords instead of resolving them,
+	// for the configs which are not written by the operator of the host
+	RejectPasswordRefs bool
+}
+
+// Load reads the config file, resolves the password references and validates the filters
+func Load(fileName string, opts LoadOptions) (Config, error) {
+	var config Config
+
+	// Read the YAML file
+	yamlFile, err := os.ReadFile(fileName)
+	if err != nil {
+		slog.Error("failed to read config file", "fileName", fileName, "error", err)
+		return Config{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Unmarshal the YAML into the Config struct
+	if err := yaml.Unmarshal(yamlFile, &config); err != nil {
+		slog.Error("failed to parse config file", "fileName", fileName, "error", err)
+		return Config{}, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if err := config.Resolve(opts); err != nil {
+		slog.Error("invalid config file", "fileName", fileName, "error", err)
+		return Config{}, err
+	}
+	slog.Debug("successfully read and validated config", "fileName", fileName, "sourceDBCount", len(config.SourceDB), "destDBHost", config.DestDB.Host)
+	return config, nil
+}
+
+// Resolve resolves the password references(env:, file:, enc:), merges the global filter into
+// each instance and validates the config. It is called by Load and for the configs which are
+// not read from a file.
+func (config *Config) Resolve(opts LoadOptions) error {
+	// Resolve the password references(env:, file:, enc:)
+	for i := range config.SourceDB {
+		if err := resolveDBSecrets(&config.SourceDB[i], opts); err != nil {
+			return err
+		}
+		config.SourceDB[i].cleartextOutput = config.CleartextPasswords
+	}
+	if err := resolveDBSecrets(&config.DestDB, opts); err != nil {
+		return err
+	}
+	config.DestDB.cleartextOutput = config.CleartextPasswords
+
+	// Combine the global filter into each instance and validate the patterns
+	for i := range config.SourceDB {
+		config.SourceDB[i].Filter = MergeFilters(config.Filter, config.SourceDB[i].Filter)
+		if _, err := config.SourceDB[i].Filter.Compile(); err != nil {
+			return fmt.Errorf("invalid table filter of %s: %w", config.SourceDB[i].Name, err)
+		}
+	}
+	config.DestDB.Filter = MergeFilters(config.Filter, config.DestDB.Filter)
+	if _, err := config.DestDB.Filter.Compile(); err != nil {
+		return fmt.Errorf("invalid table filter of %s: %w", config.DestDB.Name, err)
+	}
+
+	// Validate required fields
+	if len(config.SourceDB) == 0 {
+		return fmt.Errorf("no source databases specified in config")
+	}
+	names := map[string]bool{}
+	for i, dbInfo := range config.SourceDB {
+		if dbInfo.Name == "" {
+			return fmt.Errorf("source database %d has no name", i)
+		}
+		if names[dbInfo.Name] {
+			return fmt.Errorf("duplicate source database name %s", dbInfo.Name)
+		}
+		names[dbInfo.Name] = true
+		if dbInfo.Host == "" {
+			return fmt.Errorf("source database %s host not specified", dbInfo.Name)
+		}
+		if len(dbInfo.DBs) == 0 {
+			return fmt.Errorf("no databases(DBs) specified for source database %s", dbInfo.Name)
+		}
+	}
+	if config.DestDB.Host == "" {
+		return fmt.Errorf("destination database host not specified")
+	}
+	if len(config.DestDB.DBs) == 0 {
+		return fmt.Errorf("no databases(DBs) specified for destination database")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	t.Setenv("TEST_DEST_PASSWORD", "dest-secret")
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "password reference and global filter",
+			content: `SourceDB:
+  - Name: instance01
+    Host: 127.0.0.1
+    Port: 3306
+    DBs: [db_00]
+DestDB:
+  Name: target
+  Host: 127.0.0.1
+  Port: 4000
+  Password: env:TEST_DEST_PASSWORD
+  DBs: [messagedb]
+Filter:
+  Exclude:
+    - Schema: "*"
+      Table: "*_bak"
+`,
+		},
+		{
+			name:    "no source database",
+			content: "DestDB:\n  Host: 127.0.0.1\n",
+			wantErr: true,
+		},
+		{
+			name:    "no source schema",
+			content: "SourceDB:\n  - Name: instance01\n    Host: 127.0.0.1\nDestDB:\n  Host: 127.0.0.1\n  DBs: [messagedb]\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate source name",
+			content: "SourceDB:\n  - Name: instance01\n    Host: 127.0.0.1\n    DBs: [db_00]\n  - Name: instance01\n    Host: 127.0.0.2\n    DBs: [db_01]\nDestDB:\n  Host: 127.0.0.1\n  DBs: [messagedb]\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid filter",
+			content: "SourceDB:\n  - Name: instance01\n    Filter:\n      Exclude:\n        - Schema: \"~[\"\n          Table: \"*\"\nDestDB:\n  Host: 127.0.0.1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(fileName, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Load(fileName, LoadOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.DestDB.Password != "dest-secret" || got.DestDB.PasswordEnvName() != "TEST_DEST_PASSWORD" {
+				t.Errorf("DestDB password = %q, env name = %q", got.DestDB.Password, got.DestDB.PasswordEnvName())
+			}
+			// The global filter is merged into every instance
+			if len(got.SourceDB[0].Filter.Exclude) != 1 || len(got.DestDB.Filter.Exclude) != 1 {
+				t.Errorf("filters = %+v, %+v, want the global rule merged", got.SourceDB[0].Filter, got.DestDB.Filter)
+			}
+		})
+	}
+}
+
+func TestWorkloadConfigWithDefaults(t *testing.T) {
+	got := WorkloadConfig{ChunkThresholdMB: -1, ChunkRows: 5000}.WithDefaults()
+	want := WorkloadConfig{Workers: 1, ExportMBps: DefaultExportMBps, ChunkThresholdMB: -1, ChunkRows: 5000, ChunkFileSize: DefaultChunkFileSize}
+	if got != want {
+		t.Errorf("WithDefaults() = %+v, want %+v", got, want)
+	}
+	if got := (WorkloadConfig{}).WithDefaults().ChunkThresholdMB; got != DefaultChunkThresholdMB {
+		t.Errorf("WithDefaults().ChunkThresholdMB = %d, want %d", got, DefaultChunkThresholdMB)
+	}
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	selector "github.com/pingcap/tidb/pkg/util/table-rule-selector"
+)
+
+// TableRule is one schema/table pattern. The patterns follow the DM block-allow-list syntax:
+// the table-rule-selector wildcards(*, ?, [...]) or a regular expression prefixed with "~".
+type TableRule struct {
+	Schema string `yaml:"Schema"`
+	Table  string `yaml:"Table"`
+}
+
+// TableFilter selects the tables handled by the toolkit. It is configured globally and per
+// instance; the global rules apply to every instance.
+type TableFilter struct {
+	// Include keeps only the matched tables if it is not empty
+	Include []TableRule `yaml:"Include"`
+	// Exclude drops the matched tables, e.g. temp, backup and _bak tables
+	Exclude []TableRule `yaml:"Exclude"`
+	// StructureOnly keeps the structure of the matched tables but ignores their data
+	StructureOnly []TableRule `yaml:"StructureOnly"`
+}
+
+// tableMatcher evaluates a list of table rules. The wildcard rules are evaluated by the
+// table-rule-selector as DM does, the regex rules by regexp.
+type tableMatcher struct {
+	selector   selector.Selector
+	hasPattern bool
+	regexRules []regexTableRule
+}
+
+type regexTableRule struct {
+	schema *regexp.Regexp
+	table  *regexp.Regexp
+}
+
+// MergeFilters returns the filter of one instance including the global rules
+func MergeFilters(global, instance TableFilter) TableFilter {
+	return TableFilter{
+		Include:       append(append([]TableRule{}, global.Include...), instance.Include...),
+		Exclude:       append(append([]TableRule{}, global.Exclude...), instance.Exclude...),
+		StructureOnly: append(append([]TableRule{}, global.StructureOnly...), instance.StructureOnly...),
+	}
+}
+
+// wildcardToRegex converts a table-rule-selector wildcard to the equivalent regex
+func wildcardToRegex(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	inRange := false
+	for i, c := range pattern {
+		switch {
+		case inRange:
+			if c == ']' {
+				inRange = false
+			}
+			if c == '!' && i > 0 && pattern[i-1] == '[' {
+				sb.WriteRune('^')
+				continue
+			}
+			sb.WriteRune(c)
+		case c == '*':
+			sb.WriteString(".*")
+		case c == '?':
+			sb.WriteString(".")
+		case c == '[':
+			inRange = true
+			sb.WriteRune(c)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// compilePattern compiles one side of a rule to a regex. An empty pattern matches all.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if strings.HasPrefix(pattern, "~") {
+		return regexp.Compile(pattern[1:])
+	}
+	return regexp.Compile(wildcardToRegex(pattern))
+}
+
+func isRegexRule(rule TableRule) bool {
+	return strings.HasPrefix(rule.Schema, "~") || strings.HasPrefix(rule.Table, "~")
+}
+
+func newTableMatcher(rules []TableRule) (*tableMatcher, error) {
+	matcher := &tableMatcher{selector: selector.NewTrieSelector()}
+	for _, rule := range rules {
+		schema := rule.Schema
+		if schema == "" {
+			schema = "*"
+		}
+		table := rule.Table
+		if table == "" {
+			table = "*"
+		}
+		if !isRegexRule(rule) {
+			if err := matcher.selector.Insert(schema, table, rule, selector.Replace); err == nil {
+				matcher.hasPattern = true
+				continue
+			}
+			// The selector only accepts * at the end of the pattern, e.g. db_*. The other
+			// wildcards like *_bak are evaluated as the equivalent regex.
+			slog.Debug("table rule not supported by selector, using regex", "schema", rule.Schema, "table", rule.Table)
+		}
+
+		schemaRe, err := compilePattern(rule.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema pattern %s: %w", rule.Schema, err)
+		}
+		tableRe, err := compilePattern(rule.Table)
+		if err != nil {
+			return nil, fmt.Errorf("invalid table pattern %s: %w", rule.Table, err)
+		}
+		matcher.regexRules = append(matcher.regexRules, regexTableRule{schema: schemaRe, table: tableRe})
+	}
+	return matcher, nil
+}
+
+func (m *tableMatcher) empty() bool {
+	return !m.hasPattern && len(m.regexRules) == 0
+}
+
+func (m *tableMatcher) match(schema, table string) bool {
+	if m.hasPattern && len(m.selector.Match(schema, table)) > 0 {
+		return true
+	}
+	for _, rule := range m.regexRules {
+		if rule.schema.MatchString(schema) && rule.table.MatchString(table) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompiledFilter is the evaluable form of TableFilter
+type CompiledFilter struct {
+	include       *tableMatcher
+	exclude       *tableMatcher
+	structureOnly *tableMatcher
+}
+
+// Compile validates the patterns and returns the evaluable filter
+func (f TableFilter) Compile() (*CompiledFilter, error) {
+	include, err := newTableMatcher(f.Include)
+	if err != nil {
+		return nil, fmt.Errorf("include filter: %w", err)
+	}
+	exclude, err := newTableMatcher(f.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude filter: %w", err)
+	}
+	structureOnly, err := newTableMatcher(f.StructureOnly)
+	if err != nil {
+		return nil, fmt.Errorf("structure only filter: %w", err)
+	}
+	return &CompiledFilter{include: include, exclude: exclude, structureOnly: structureOnly}, nil
+}
+
+// Allowed reports whether the table is handled by the toolkit
+func (f *CompiledFilter) Allowed(schema, table string) bool {
+	if f.exclude.match(schema, table) {
+		slog.Debug("table dropped by exclude filter", "schema", schema, "table", table)
+		return false
+	}
+	if !f.include.empty() && !f.include.match(schema, table) {
+		slog.Debug("table dropped by include filter", "schema", schema, "table", table)
+		return false
+	}
+	return true
+}
+
+// IsStructureOnly reports whether only the structure of the table is migrated
+func (f *CompiledFilter) IsStructureOnly(schema, table string) bool {
+	return f.structureOnly.match(schema, table)
+}
+
+// DMDoTables returns the do-tables of the DM block-allow-list, nil if the filter has no include
+// rule. DM checks do-tables before ignore-tables, so a table matching an include and an exclude
+// rule would be replicated if the include patterns were passed as they are. The allowed tables
+// among the candidates, the source tables of the mapping, are listed by their names instead.
+func DMDoTables(filter TableFilter, tables []TableRule) ([]TableRule, error) {
+	if len(filter.Include) == 0 {
+		return nil, nil
+	}
+	compiled, err := filter.Compile()
+	if err != nil {
+		return nil, err
+	}
+	rules := []TableRule{}
+	for _, table := range tables {
+		if compiled.Allowed(table.Schema, table.Table) && !slices.Contains(rules, table) {
+			rules = append(rules, table)
+		}
+	}
+	return rules, nil
+}
+
+// DMIgnoreTables converts the exclude rules to the ignore-tables of the DM block-allow-list.
+func DMIgnoreTables(filter TableFilter) []TableRule {
+	return dmTableRules(filter.Exclude)
+}
+
+// dmTableRules fills the empty patterns with "*". The patterns use the same syntax as DM so they
+// are passed as they are.
+func dmTableRules(tableRules []TableRule) []TableRule {
+	rules := []TableRule{}
+	for _, rule := range tableRules {
+		dmRule := rule
+		if dmRule.Schema == "" {
+			dmRule.Schema = "*"
+		}
+		if dmRule.Table == "" {
+			dmRule.Table = "*"
+		}
+		rules = append(rules, dmRule)
+	}
+	return rules
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_compiledFilter(t *testing.T) {
+	filter := MergeFilters(
+		TableFilter{
+			Exclude: []TableRule{
+				{Schema: "*", Table: "*_bak"},
+				{Schema: "*", Table: "~^tmp_[0-9]+$"},
+			},
+		},
+		TableFilter{
+			Include:       []TableRule{{Schema: "db_*"}},
+			StructureOnly: []TableRule{{Schema: "db_*", Table: "log_*"}},
+		},
+	)
+	compiled, err := filter.Compile()
+	if err != nil {
+		t.Fatalf("compile() error = %v", err)
+	}
+
+	tests := []struct {
+		name              string
+		schema            string
+		table             string
+		wantAllowed       bool
+		wantStructureOnly bool
+	}{
+		{"Included table", "db_00", "orders", true, false},
+		{"Not included schema", "mysql", "user", false, false},
+		{"Excluded by wildcard", "db_00", "orders_bak", false, false},
+		{"Excluded by regex", "db_01", "tmp_123", false, false},
+		{"Regex does not match", "db_01", "tmp_abc", true, false},
+		{"Structure only", "db_02", "log_2024", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compiled.Allowed(tt.schema, tt.table); got != tt.wantAllowed {
+				t.Errorf("allowed() = %v, want %v", got, tt.wantAllowed)
+			}
+			if got := compiled.IsStructureOnly(tt.schema, tt.table); got != tt.wantStructureOnly {
+				t.Errorf("isStructureOnly() = %v, want %v", got, tt.wantStructureOnly)
+			}
+		})
+	}
+}
+
+func Test_DMDoTables(t *testing.T) {
+	tables := []TableRule{{Schema: "db_00", Table: "orders_01"}, {Schema: "db_00", Table: "orders_bak"}, {Schema: "db_00", Table: "users"}}
+	filter := TableFilter{
+		Include: []TableRule{{Schema: "db_*", Table: "orders_*"}},
+		Exclude: []TableRule{{Table: "*_bak"}},
+	}
+	got, err := DMDoTables(filter, tables)
+	if err != nil {
+		t.Fatalf("DMDoTables() error = %v", err)
+	}
+	if want := []TableRule{{Schema: "db_00", Table: "orders_01"}}; !slices.Equal(got, want) {
+		t.Errorf("DMDoTables() = %v, want %v", got, want)
+	}
+
+	got, err = DMDoTables(TableFilter{Exclude: filter.Exclude}, tables)
+	if err != nil {
+		t.Fatalf("DMDoTables() error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("DMDoTables() without include = %v, want nil", got)
+	}
+}
+
+func Test_compileInvalidFilter(t *testing.T) {
+	filter := TableFilter{Exclude: []TableRule{{Schema: "db", Table: "~(unclosed"}}}
+	if _, err := filter.Compile(); err == nil {
+		t.Errorf("compile() expected error for invalid regex")
+	}
+}
+
+func Test_wildcardToRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"db_*", `^db_.*$`},
+		{"t?", `^t.$`},
+		{"t[!0-9]", `^t[^0-9]$`},
+		{"a.b", `^a\.b$`},
+	}
+	for _, tt := range tests {
+		if got := wildcardToRegex(tt.pattern); got != tt.want {
+			t.Errorf("wildcardToRegex(%s) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The password in the config may be a reference instead of the cleartext:
+//   - env:VAR    read from the environment variable VAR
+//   - file:/path read from the file, the trailing newline is removed
+//   - enc:xxxx   encrypted by `dm-toolkit encrypt` with the local secret key
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretEncPrefix  = "enc:"
+)
+
+var dsnPasswordRe = regexp.MustCompile(`([^\s:/@]+):([^\s@]*)@(tcp|unix)\(`)
+
+// DefaultSecretKeyFile returns the local key used to decrypt the enc: passwords
+func DefaultSecretKeyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".dm-toolkit/secret.key"
+	}
+	return filepath.Join(home, ".dm-toolkit", "secret.key")
+}
+
+// LoadSecretKey reads the base64 encoded AES-256 key. The key is generated if it does not
+// exist and create is true.
+func LoadSecretKey(path string, create bool) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate secret key: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create secret key directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write secret key %s: %w", path, err)
+		}
+		slog.Info("generated new secret key", "path", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret key %s: %w", path, err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret key %s: %w", path, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key %s must be 32 bytes, got %d", path, len(key))
+	}
+	return key, nil
+}
+
+// EncryptSecret encrypts the cleartext with AES-GCM and returns the enc: reference
+func EncryptSecret(plain string, key []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("failed to create gcm: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return secretEncPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts the value of an enc: reference
+func decryptSecret(encrypted string, key []byte) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, secretEncPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode encrypted secret: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("failed to create gcm: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted secret is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plain), nil
+}
+
+// resolveSecret returns the cleartext of a password reference. A value without any
+// known prefix is returned as it is. The enc: references are decrypted by the key file.
+func resolveSecret(ref, secretKeyFile string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, secretEnvPrefix):
+		name := strings.TrimPrefix(ref, secretEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, secretFilePrefix):
+		path := strings.TrimPrefix(ref, secretFilePrefix)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case strings.HasPrefix(ref, secretEncPrefix):
+		key, err := LoadSecretKey(secretKeyFile, false)
+		if err != nil {
+			return "", err
+		}
+		return decryptSecret(ref, key)
+	default:
+		return ref, nil
+	}
+}
+
+// isSecretRef reports whether the password is an env:, file: or enc: reference
+func isSecretRef(ref string) bool {
+	return strings.HasPrefix(ref, secretEnvPrefix) || strings.HasPrefix(ref, secretFilePrefix) || strings.HasPrefix(ref, secretEncPrefix)
+}
+
+// resolveDBSecrets replaces the password reference of the connection with the cleartext.
+// The reference is kept to render the generated files without the cleartext.
+func resolveDBSecrets(dbInfo *DBConnInfo, opts LoadOptions) error {
+	if opts.RejectPasswordRefs && isSecretRef(dbInfo.Password) {
+		return fmt.Errorf("password of %s is a reference, the env:, file: and enc: references are not allowed", dbInfo.Name)
+	}
+	password, err := resolveSecret(dbInfo.Password, opts.SecretKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve password of %s: %w", dbInfo.Name, err)
+	}
+	if password != dbInfo.Password {
+		dbInfo.passwordRef = dbInfo.Password
+	}
+	dbInfo.Password = password
+	return nil
+}
+
+// PasswordEnvName is the environment variable referenced by the generated files for the
+// password of the connection.
+func (dbInfo DBConnInfo) PasswordEnvName() string {
+	if strings.HasPrefix(dbInfo.passwordRef, secretEnvPrefix) {
+		return strings.TrimPrefix(dbInfo.passwordRef, secretEnvPrefix)
+	}
+	name := regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(dbInfo.Name, "_")
+	return "DM_TOOLKIT_PASSWORD_" + strings.ToUpper(name)
+}
+
+// PasswordForOutput returns the password written to the generated files. The environment
+// variable is referenced, which is expanded by `envsubst` before the file is used. The
+// cleartext is only written if it is in the config file as cleartext and CleartextPasswords
+// is set.
+func (dbInfo DBConnInfo) PasswordForOutput() string {
+	if dbInfo.Password == "" && dbInfo.passwordRef == "" {
+		return ""
+	}
+	if dbInfo.passwordRef == "" && dbInfo.cleartextOutput {
+		return dbInfo.Password
+	}
+	return "${" + dbInfo.PasswordEnvName() + "}"
+}
+
+// DMPasswordForOutput returns the password written to the DM configs. The `dmctl encrypt`
+// form is used if it is configured.
+func (dbInfo DBConnInfo) DMPasswordForOutput() string {
+	if dbInfo.DMPassword != "" {
+		return dbInfo.DMPassword
+	}
+	return dbInfo.PasswordForOutput()
+}
+
+// redactDSN masks the password in the DSN(user:password@tcp(host:port)/db)
+func redactDSN(s string) string {
+	return dsnPasswordRe.ReplaceAllString(s, "$1:******@$3(")
+}
+
+// RedactAttr masks the passwords in the log output
+func RedactAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if strings.Contains(key, "password") || strings.Contains(key, "secret") {
+		return slog.String(a.Key, "******")
+	}
+	if a.Value.Kind() == slog.KindString {
+		if value := a.Value.String(); dsnPasswordRe.MatchString(value) {
+			return slog.String(a.Key, redactDSN(value))
+		}
+	}
+	return a
+}
+
+// Redacted returns a copy of the config without the passwords for logging
+func (c Config) Redacted() Config {
+	redact := func(dbInfo DBConnInfo) DBConnInfo {
+		if dbInfo.Password != "" {
+			dbInfo.Password = "******"
+		}
+		if dbInfo.DMPassword != "" {
+			dbInfo.DMPassword = "******"
+		}
+		dbInfo.passwordRef = ""
+		return dbInfo
+	}
+	redactedConfig := c
+	redactedConfig.SourceDB = make([]DBConnInfo, 0, len(c.SourceDB))
+	for _, dbInfo := range c.SourceDB {
+		redactedConfig.SourceDB = append(redactedConfig.SourceDB, redact(dbInfo))
+	}
+	redactedConfig.DestDB = redact(c.DestDB)
+	return redactedConfig
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_resolveSecret(t *testing.T) {
+	tmpDir := t.TempDir()
+	secretFile := filepath.Join(tmpDir, "password.txt")
+	if err := os.WriteFile(secretFile, []byte("fromFile\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret file: %v", err)
+	}
+	t.Setenv("DM_TOOLKIT_TEST_PASSWORD", "fromEnv")
+
+	secretKeyFile := filepath.Join(tmpDir, "secret.key")
+	key, err := LoadSecretKey(secretKeyFile, true)
+	if err != nil {
+		t.Fatalf("LoadSecretKey() error = %v", err)
+	}
+	encrypted, err := EncryptSecret("fromEnc", key)
+	if err != nil {
+		t.Fatalf("EncryptSecret() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"Cleartext", "1234Abcd", "1234Abcd", false},
+		{"Environment variable", "env:DM_TOOLKIT_TEST_PASSWORD", "fromEnv", false},
+		{"Missing environment variable", "env:DM_TOOLKIT_TEST_NOT_SET", "", true},
+		{"File", "file:" + secretFile, "fromFile", false},
+		{"Encrypted", encrypted, "fromEnc", false},
+		{"Broken encrypted value", "enc:AAAA", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSecret(tt.ref, secretKeyFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSecret() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_passwordForOutput(t *testing.T) {
+	t.Setenv("SRC_PASSWORD", "secret")
+	tests := []struct {
+		name   string
+		dbInfo DBConnInfo
+		want   string
+		wantDM string
+	}{
+		{"Cleartext is referenced", DBConnInfo{Name: "instance01", Password: "1234Abcd"}, "${DM_TOOLKIT_PASSWORD_INSTANCE01}", "${DM_TOOLKIT_PASSWORD_INSTANCE01}"},
+		{"Cleartext opted in", DBConnInfo{Name: "instance01", Password: "1234Abcd", cleartextOutput: true}, "1234Abcd", "1234Abcd"},
+		{"Reference is kept with the opt-in", DBConnInfo{Name: "instance01", Password: "env:SRC_PASSWORD", cleartextOutput: true}, "${SRC_PASSWORD}", "${SRC_PASSWORD}"},
+		{"No password", DBConnInfo{Name: "instance01"}, "", ""},
+		{"Environment variable is referenced", DBConnInfo{Name: "instance01", Password: "env:SRC_PASSWORD"}, "${SRC_PASSWORD}", "${SRC_PASSWORD}"},
+		{"DM encrypted form", DBConnInfo{Name: "instance-02", Password: "env:SRC_PASSWORD", DMPassword: "dmctlEncrypted=="}, "${SRC_PASSWORD}", "dmctlEncrypted=="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbInfo := tt.dbInfo
+			if err := resolveDBSecrets(&dbInfo, LoadOptions{}); err != nil {
+				t.Fatalf("resolveDBSecrets() error = %v", err)
+			}
+			if got := dbInfo.PasswordForOutput(); got != tt.want {
+				t.Errorf("passwordForOutput() = %v, want %v", got, tt.want)
+			}
+			if got := dbInfo.DMPasswordForOutput(); got != tt.wantDM {
+				t.Errorf("dmPasswordForOutput() = %v, want %v", got, tt.wantDM)
+			}
+		})
+	}
+
+	fileRef := DBConnInfo{Name: "instance-02", passwordRef: "file:/tmp/password"}
+	if got := fileRef.PasswordForOutput(); got != "${DM_TOOLKIT_PASSWORD_INSTANCE_02}" {
+		t.Errorf("passwordForOutput() = %v, want ${DM_TOOLKIT_PASSWORD_INSTANCE_02}", got)
+	}
+}
+
+func Test_RedactAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+		want string
+	}{
+		{"Password key", slog.String("password", "1234Abcd"), "******"},
+		{"DSN value", slog.String("dsn", "dmuser:1234Abcd@tcp(10.0.1.5:3306)/db"), "dmuser:******@tcp(10.0.1.5:3306)/db"},
+		{"Normal value", slog.String("host", "10.0.1.5"), "10.0.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactAttr(nil, tt.attr); got.Value.String() != tt.want {
+				t.Errorf("RedactAttr() = %v, want %v", got.Value.String(), tt.want)
+			}
+		})
+	}
+}
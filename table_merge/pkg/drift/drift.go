@@ -0,0 +1,293 @@
+// Package drift detects the schema changes between the planning and the cutover. The mapping
+// the configs were generated from is saved as a snapshot, the current table definitions are
+// compared with it table by table.
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	selector "github.com/pingcap/tidb/pkg/util/table-rule-selector"
+)
+
+// SnapshotFileName is the mapping snapshot kept in the output directory
+const SnapshotFileName = "dm-toolkit-mapping.json"
+
+// PKOffsetsFileName records the primary key offsets the dump was taken with, in the output
+// directory. It is kept apart from the snapshot whose routes are the DM ones.
+const PKOffsetsFileName = "dm-toolkit-pk-offsets.json"
+
+// Kind of a change
+const (
+	// Changed is a table whose column types changed
+	Changed = "changed"
+	// New is a source table no route of the snapshot covers with the same structure
+	New = "new"
+	// Routed is a new source table covered by a route to a table of the same structure.
+	// It is reported but not counted as drift.
+	Routed = "routed"
+	// Dropped is a table of the snapshot which does not exist any more
+	Dropped = "dropped"
+)
+
+// Snapshot is the saved mapping
+type Snapshot struct {
+	CreatedAt time.Time           `json:"created_at"`
+	Tables    []mapping.TableInfo `json:"tables"`
+}
+
+// PKOffsets are the primary key offsets of the rewritten groups by destination table
+type PKOffsets struct {
+	CreatedAt time.Time                    `json:"created_at"`
+	Tables    map[string]*mapping.PKOffset `json:"tables"`
+}
+
+// Change is the drift of one table
+type Change struct {
+	Kind string `json:"kind"`
+	// Table is instance.schema.table
+	Table string `json:"table"`
+	// Side is source or dest
+	Side string `json:"side"`
+	// Dest is the destination table of the source table in the snapshot, or of its route
+	Dest string `json:"dest,omitempty"`
+	// Saved and Current are MD5ColumnsWithTypes of the table
+	Saved   string `json:"saved,omitempty"`
+	Current string `json:"current,omitempty"`
+}
+
+// Report is the result of Compare
+type Report struct {
+	SnapshotAt time.Time `json:"snapshot_at"`
+	Changes    []Change  `json:"changes"`
+}
+
+// HasDrift reports whether any change breaks the snapshot
+func (r Report) HasDrift() bool {
+	for _, change := range r.Changes {
+		if change.Kind != Routed {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotPath returns the snapshot path in the output directory
+func SnapshotPath(outputDir string) string {
+	if outputDir == "" {
+		outputDir = "."
+	}
+	return filepath.Join(outputDir, SnapshotFileName)
+}
+
+// Save writes the snapshot of the mapping through a temporary file so that an interrupted run
+// keeps the previous version
+func Save(path string, tableStructure []mapping.TableInfo, now time.Time) error {
+	if err := writeJSON(path, Snapshot{CreatedAt: now, Tables: tableStructure}); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	slog.Info("saved mapping snapshot", "path", path, "groupCount", len(tableStructure))
+	return nil
+}
+
+// PKOffsetsPath returns the path of the primary key offsets in the output directory
+func PKOffsetsPath(outputDir string) string {
+	if outputDir == "" {
+		outputDir = "."
+	}
+	return filepath.Join(outputDir, PKOffsetsFileName)
+}
+
+// SavePKOffsets writes the primary key offsets of the groups like Save
+func SavePKOffsets(path string, tableStructure []mapping.TableInfo, now time.Time) error {
+	offsets := PKOffsets{CreatedAt: now, Tables: map[string]*mapping.PKOffset{}}
+	for _, tableInfo := range tableStructure {
+		if tableInfo.PKOffset != nil {
+			offsets.Tables[firstDest(tableInfo)] = tableInfo.PKOffset
+		}
+	}
+	if err := writeJSON(path, offsets); err != nil {
+		return fmt.Errorf("failed to save pk offsets: %w", err)
+	}
+	slog.Info("saved pk offsets", "path", path, "tableCount", len(offsets.Tables))
+	return nil
+}
+
+func writeJSON(path string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", path, err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// Load reads the snapshot
+func Load(path string) (*Snapshot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", path, err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// tableState is one table of a mapping with the group it belongs to
+type tableState struct {
+	side  string
+	hash  string
+	group mapping.TableInfo
+}
+
+// flatten indexes the tables of the mapping by instance.schema.table
+func flatten(tableStructure []mapping.TableInfo) map[string]tableState {
+	tables := map[string]tableState{}
+	for _, tableInfo := range tableStructure {
+		for _, table := range tableInfo.SrcTableInfo {
+			tables["source:"+table] = tableState{side: "source", hash: tableInfo.MD5ColumnsWithTypes, group: tableInfo}
+		}
+		for _, table := range tableInfo.DestTableInfo {
+			tables["dest:"+table] = tableState{side: "dest", hash: tableInfo.MD5ColumnsWithTypes, group: tableInfo}
+		}
+	}
+	return tables
+}
+
+func firstDest(tableInfo mapping.TableInfo) string {
+	if len(tableInfo.DestTableInfo) == 0 {
+		return ""
+	}
+	return tableInfo.DestTableInfo[0]
+}
+
+// routePattern returns the schema and table pattern of the route of the group, the same as
+// the rendered sync-diff and DM configs: SrcRegex, or the first source table without it
+func routePattern(tableInfo mapping.TableInfo) (string, string, bool) {
+	if tableInfo.SrcRegex != "" {
+		parts := strings.Split(tableInfo.SrcRegex, ".")
+		if len(parts) > 1 {
+			return parts[0], parts[1], true
+		}
+		return "", "", false
+	}
+	if len(tableInfo.SrcTableInfo) == 0 {
+		return "", "", false
+	}
+	parts := strings.Split(tableInfo.SrcTableInfo[0], ".")
+	if len(parts) > 2 {
+		return parts[1], parts[2], true
+	}
+	return "", "", false
+}
+
+// routes builds the selector of the routes of the snapshot, the rule is the routed group
+func routes(tableStructure []mapping.TableInfo) selector.Selector {
+	ts := selector.NewTrieSelector()
+	for _, tableInfo := range tableStructure {
+		if len(tableInfo.DestTableInfo) == 0 {
+			continue
+		}
+		schemaPattern, tablePattern, ok := routePattern(tableInfo)
+		if !ok {
+			continue
+		}
+		if err := ts.Insert(schemaPattern, tablePattern, tableInfo, selector.Insert); err != nil {
+			slog.Warn("skipping route of the snapshot", "schemaPattern", schemaPattern, "tablePattern", tablePattern, "error", err)
+		}
+	}
+	return ts
+}
+
+// Compare compares the current mapping with the snapshot. The new destination tables are not
+// reported since no route depends on them.
+func Compare(snapshot *Snapshot, current []mapping.TableInfo) Report {
+	report := Report{SnapshotAt: snapshot.CreatedAt, Changes: []Change{}}
+	saved, now := flatten(snapshot.Tables), flatten(current)
+
+	for key, state := range saved {
+		table := strings.TrimPrefix(key, state.side+":")
+		currentState, ok := now[key]
+		switch {
+		case !ok:
+			report.Changes = append(report.Changes, Change{Kind: Dropped, Table: table, Side: state.side, Dest: firstDest(state.group), Saved: state.hash})
+		case currentState.hash != state.hash:
+			report.Changes = append(report.Changes, Change{Kind: Changed, Table: table, Side: state.side, Dest: firstDest(state.group), Saved: state.hash, Current: currentState.hash})
+		}
+	}
+
+	ts := routes(snapshot.Tables)
+	for key, state := range now {
+		if _, ok := saved[key]; ok || state.side != "source" {
+			continue
+		}
+		table := strings.TrimPrefix(key, "source:")
+		change := Change{Kind: New, Table: table, Side: state.side, Current: state.hash}
+		if parts := strings.Split(table, "."); len(parts) == 3 {
+			// A route to a table of another structure is as broken as no route
+			for _, rule := range ts.Match(parts[1], parts[2]) {
+				group := rule.(mapping.TableInfo)
+				change.Dest, change.Saved = firstDest(group), group.MD5ColumnsWithTypes
+				if group.MD5ColumnsWithTypes == state.hash {
+					change.Kind = Routed
+					break
+				}
+			}
+		}
+		report.Changes = append(report.Changes, change)
+	}
+
+	sort.Slice(report.Changes, func(i, j int) bool {
+		if report.Changes[i].Side != report.Changes[j].Side {
+			return report.Changes[i].Side > report.Changes[j].Side
+		}
+		return report.Changes[i].Table < report.Changes[j].Table
+	})
+	return report
+}
+
+// Print shows the changes of the report
+func (r Report) Print(out io.Writer) {
+	if len(r.Changes) == 0 {
+		fmt.Fprintf(out, "No drift since the snapshot of %s\n", r.SnapshotAt.Local().Format("2006-01-02 15:04:05"))
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tSIDE\tTABLE\tDEST\tSAVED\tCURRENT")
+	counts := map[string]int{}
+	for _, change := range r.Changes {
+		counts[change.Kind]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", change.Kind, change.Side, change.Table,
+			valueOrDash(change.Dest), valueOrDash(change.Saved), valueOrDash(change.Current))
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d changed, %d new, %d dropped, %d routed since the snapshot of %s\n",
+		counts[Changed], counts[New], counts[Dropped], counts[Routed], r.SnapshotAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
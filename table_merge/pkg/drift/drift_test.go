@@ -0,0 +1,129 @@
+package drift
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+func snapshotTables() []mapping.TableInfo {
+	return []mapping.TableInfo{
+		{
+			MD5ColumnsWithTypes: "orders-v1",
+			SrcRegex:            "db_*.orders",
+			SrcTableInfo:        []string{"mysql01.db_00.orders", "mysql01.db_01.orders", "mysql02.db_02.orders"},
+			DestTableInfo:       []string{"target.messagedb.orders"},
+		},
+		{
+			MD5ColumnsWithTypes: "users-v1",
+			SrcTableInfo:        []string{"mysql01.db_00.users"},
+			DestTableInfo:       []string{"target.messagedb.users"},
+		},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	snapshot := &Snapshot{CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Tables: snapshotTables()}
+
+	t.Run("no drift", func(t *testing.T) {
+		report := Compare(snapshot, snapshotTables())
+		if len(report.Changes) != 0 || report.HasDrift() {
+			t.Errorf("Compare() = %+v, want no change", report.Changes)
+		}
+	})
+
+	t.Run("altered, new and dropped tables", func(t *testing.T) {
+		current := []mapping.TableInfo{
+			{
+				MD5ColumnsWithTypes: "orders-v1",
+				SrcTableInfo:        []string{"mysql01.db_00.orders", "mysql02.db_02.orders", "mysql02.db_03.orders"},
+				DestTableInfo:       []string{"target.messagedb.orders"},
+			},
+			// db_01.orders got a new column
+			{MD5ColumnsWithTypes: "orders-v2", SrcTableInfo: []string{"mysql01.db_01.orders", "mysql02.db_04.orders"}},
+			{MD5ColumnsWithTypes: "audit-v1", SrcTableInfo: []string{"mysql01.db_00.audit"}, DestTableInfo: []string{"target.messagedb.audit"}},
+		}
+		report := Compare(snapshot, current)
+		want := []Change{
+			{Kind: New, Table: "mysql01.db_00.audit", Side: "source", Current: "audit-v1"},
+			{Kind: Dropped, Table: "mysql01.db_00.users", Side: "source", Dest: "target.messagedb.users", Saved: "users-v1"},
+			{Kind: Changed, Table: "mysql01.db_01.orders", Side: "source", Dest: "target.messagedb.orders", Saved: "orders-v1", Current: "orders-v2"},
+			{Kind: Routed, Table: "mysql02.db_03.orders", Side: "source", Dest: "target.messagedb.orders", Saved: "orders-v1", Current: "orders-v1"},
+			{Kind: New, Table: "mysql02.db_04.orders", Side: "source", Dest: "target.messagedb.orders", Saved: "orders-v1", Current: "orders-v2"},
+			{Kind: Dropped, Table: "target.messagedb.users", Side: "dest", Dest: "target.messagedb.users", Saved: "users-v1"},
+		}
+		if !reflect.DeepEqual(report.Changes, want) {
+			t.Errorf("Compare() =\n%+v\nwant\n%+v", report.Changes, want)
+		}
+		if !report.HasDrift() {
+			t.Errorf("HasDrift() = false, want true")
+		}
+
+		var out bytes.Buffer
+		report.Print(&out)
+		if !strings.Contains(out.String(), "1 changed, 2 new, 2 dropped, 1 routed") {
+			t.Errorf("Print() =\n%s", out.String())
+		}
+	})
+
+	t.Run("new shard covered by a route", func(t *testing.T) {
+		current := snapshotTables()
+		current[0].SrcTableInfo = append(current[0].SrcTableInfo, "mysql02.db_03.orders")
+		report := Compare(snapshot, current)
+		if len(report.Changes) != 1 || report.Changes[0].Kind != Routed || report.HasDrift() {
+			t.Errorf("Compare() = %+v, want the routed table only", report.Changes)
+		}
+	})
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := SnapshotPath(filepath.Join(t.TempDir(), "output"))
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := Save(path, snapshotTables(), now); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !got.CreatedAt.Equal(now) || !reflect.DeepEqual(got.Tables, snapshotTables()) {
+		t.Errorf("Load() = %+v, want the saved snapshot", got)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), SnapshotFileName)); err == nil {
+		t.Errorf("Load() of a missing snapshot want error")
+	}
+}
+
+func TestSavePKOffsets(t *testing.T) {
+	outputDir := t.TempDir()
+	tables := snapshotTables()
+	tables[0].PKOffset = &mapping.PKOffset{Column: "id", MaxOriginID: 1 << 52, Shards: []mapping.ShardOffset{
+		{Table: "mysql01.db_00.orders", Offset: 0},
+		{Table: "mysql02.db_02.orders", Offset: 1<<59 | 2<<52},
+	}}
+	if err := SavePKOffsets(PKOffsetsPath(outputDir), tables, time.Now()); err != nil {
+		t.Fatalf("SavePKOffsets() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(outputDir, PKOffsetsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PKOffsets
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("failed to parse pk offsets: %v", err)
+	}
+	if len(got.Tables) != 1 || !reflect.DeepEqual(got.Tables["target.messagedb.orders"], tables[0].PKOffset) {
+		t.Errorf("pk offsets = %+v, want the orders offsets", got.Tables)
+	}
+	// The route snapshot is not written
+	if _, err := os.Stat(SnapshotPath(outputDir)); !os.IsNotExist(err) {
+		t.Errorf("snapshot written by SavePKOffsets: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package mapping
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// CompatLevel classifies how a source column converts to the destination column
+type CompatLevel int
+
+const (
+	// CompatIdentical is the same type with the same attributes
+	CompatIdentical CompatLevel = iota
+	// CompatWideningSafe keeps all the values, e.g. int -> bigint, varchar(64) -> varchar(128)
+	CompatWideningSafe
+	// CompatLossy may truncate or reject some values, e.g. bigint -> int
+	CompatLossy
+	// CompatIncompatible can not be converted, e.g. varchar -> int
+	CompatIncompatible
+)
+
+var compatLevelNames = []string{"identical", "widening-safe", "lossy", "incompatible"}
+
+func (l CompatLevel) String() string {
+	if int(l) < len(compatLevelNames) {
+		return compatLevelNames[l]
+	}
+	return fmt.Sprintf("CompatLevel(%d)", int(l))
+}
+
+func parseCompatLevel(name string) (CompatLevel, error) {
+	for i, levelName := range compatLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return CompatLevel(i), nil
+		}
+	}
+	return CompatIncompatible, fmt.Errorf("unknown compatibility level %s", name)
+}
+
+// CompatPolicy is the evaluable form of config.TypeCompatibilityConfig
+type CompatPolicy struct {
+	minLevel  CompatLevel
+	overrides map[[2]string]CompatLevel
+}
+
+// NewCompatPolicy validates the type compatibility config and compiles it to a policy
+func NewCompatPolicy(c config.TypeCompatibilityConfig) (*CompatPolicy, error) {
+	policy := &CompatPolicy{minLevel: CompatWideningSafe, overrides: map[[2]string]CompatLevel{}}
+	if c.MinLevel != "" {
+		level, err := parseCompatLevel(c.MinLevel)
+		if err != nil {
+			return nil, err
+		}
+		if level == CompatIncompatible {
+			return nil, fmt.Errorf("incompatible tables can not be grouped")
+		}
+		policy.minLevel = level
+	}
+	for _, override := range c.Overrides {
+		level, err := parseCompatLevel(override.Level)
+		if err != nil {
+			return nil, fmt.Errorf("override %s -> %s: %w", override.Source, override.Dest, err)
+		}
+		policy.overrides[[2]string{strings.ToLower(override.Source), strings.ToLower(override.Dest)}] = level
+	}
+	return policy, nil
+}
+
+// integerDigits is the number of decimal digits of the integer types
+var integerDigits = map[string]int64{"tinyint": 3, "smallint": 5, "mediumint": 8, "int": 10, "bigint": 20}
+
+var stringTypes = map[string]bool{"char": true, "varchar": true, "tinytext": true, "text": true, "mediumtext": true, "longtext": true}
+
+var binaryTypes = map[string]bool{"binary": true, "varbinary": true, "tinyblob": true, "blob": true, "mediumblob": true, "longblob": true}
+
+var temporalTypes = map[string]bool{"date": true, "datetime": true, "timestamp": true, "time": true, "year": true}
+
+func atLeast(ok bool) CompatLevel {
+	if ok {
+		return CompatWideningSafe
+	}
+	return CompatLossy
+}
+
+// classifyType classifies the data type conversion without the nullability
+func (p *CompatPolicy) classifyType(src, dest schema.ColumnDef) CompatLevel {
+	if src.DataType == dest.DataType && src.CharMaxLength == dest.CharMaxLength &&
+		src.NumericPrecision == dest.NumericPrecision && src.NumericScale == dest.NumericScale &&
+		src.DatetimePrecision == dest.DatetimePrecision {
+		return CompatIdentical
+	}
+	if level, ok := p.overrides[[2]string{src.DataType, dest.DataType}]; ok {
+		return level
+	}
+
+	srcDigits, srcIsInt := integerDigits[src.DataType]
+	destDigits, destIsInt := integerDigits[dest.DataType]
+	switch {
+	case srcIsInt && destIsInt:
+		return atLeast(destDigits >= srcDigits)
+	case srcIsInt && dest.DataType == "decimal":
+		return atLeast(dest.NumericPrecision.Int64-dest.NumericScale.Int64 >= srcDigits)
+	case src.DataType == "decimal" && dest.DataType == "decimal":
+		return atLeast(dest.NumericPrecision.Int64-dest.NumericScale.Int64 >= src.NumericPrecision.Int64-src.NumericScale.Int64 &&
+			dest.NumericScale.Int64 >= src.NumericScale.Int64)
+	case srcIsInt && dest.DataType == "double":
+		// double keeps 15 significant digits
+		return atLeast(srcDigits <= 10)
+	case src.DataType == "float" && dest.DataType == "double":
+		return CompatWideningSafe
+	case (srcIsInt || src.DataType == "decimal" || src.DataType == "float" || src.DataType == "double") &&
+		(destIsInt || dest.DataType == "decimal" || dest.DataType == "float" || dest.DataType == "double"):
+		return CompatLossy
+	case stringTypes[src.DataType] && stringTypes[dest.DataType],
+		binaryTypes[src.DataType] && binaryTypes[dest.DataType]:
+		return atLeast(dest.CharMaxLength.Int64 >= src.CharMaxLength.Int64)
+	case temporalTypes[src.DataType] && temporalTypes[dest.DataType]:
+		return classifyTemporal(src, dest)
+	case stringTypes[dest.DataType] && !binaryTypes[src.DataType]:
+		// Numbers, temporal values, json, enum and set are kept as text but the type changes
+		return CompatLossy
+	case src.DataType == dest.DataType && src.DataType == "bit":
+		return atLeast(dest.NumericPrecision.Int64 >= src.NumericPrecision.Int64)
+	case src.DataType == dest.DataType:
+		// enum, set and the other types whose attributes are not compared
+		return CompatLossy
+	default:
+		return CompatIncompatible
+	}
+}
+
+// classifyTemporal classifies the conversion between the date and time types
+func classifyTemporal(src, dest schema.ColumnDef) CompatLevel {
+	precisionKept := dest.DatetimePrecision.Int64 >= src.DatetimePrecision.Int64
+	switch {
+	case src.DataType == dest.DataType:
+		return atLeast(precisionKept)
+	case src.DataType == "date" && (dest.DataType == "datetime" || dest.DataType == "timestamp"):
+		return CompatWideningSafe
+	case src.DataType == "timestamp" && dest.DataType == "datetime":
+		return atLeast(precisionKept)
+	case src.DataType == "time" || dest.DataType == "time":
+		return CompatIncompatible
+	default:
+		// datetime -> timestamp has a smaller range, datetime -> date drops the time
+		return CompatLossy
+	}
+}
+
+// classifyColumn classifies the conversion of the source column to the destination column
+func (p *CompatPolicy) classifyColumn(src, dest schema.ColumnDef) CompatLevel {
+	level := p.classifyType(src, dest)
+	switch {
+	case src.Nullable && !dest.Nullable:
+		// NULL values are rejected by the destination
+		level = max(level, CompatLossy)
+	case !src.Nullable && dest.Nullable:
+		level = max(level, CompatWideningSafe)
+	}
+	return level
+}
+
+// classifyTable returns the weakest compatibility of the columns and the description of the
+// columns which are not converted safely. The columns are matched by name.
+func (p *CompatPolicy) classifyTable(src, dest []schema.ColumnDef) (CompatLevel, []string) {
+	destColumns := map[string]schema.ColumnDef{}
+	for _, column := range dest {
+		destColumns[strings.ToLower(column.Name)] = column
+	}
+	level := CompatIdentical
+	issues := []string{}
+	for _, srcColumn := range src {
+		destColumn, ok := destColumns[strings.ToLower(srcColumn.Name)]
+		if !ok {
+			return CompatIncompatible, []string{fmt.Sprintf("column %s not found", srcColumn.Name)}
+		}
+		columnLevel := p.classifyColumn(srcColumn, destColumn)
+		if columnLevel >= CompatLossy {
+			issues = append(issues, fmt.Sprintf("column %s %s -> %s is %s", srcColumn.Name, srcColumn.TypeString(), destColumn.TypeString(), columnLevel))
+		}
+		level = max(level, columnLevel)
+	}
+	return level, issues
+}
+
+// MatchCompatibleDestinations groups the source tables without an identical destination to
+// the destination table whose columns are compatible by the policy. The lossy pairings are
+// kept in TypeIssues of the table group for the analysis output.
+func MatchCompatibleDestinations(tableMapping []TableInfo, policy *CompatPolicy) []TableInfo {
+	tableStructure := append([]TableInfo{}, tableMapping...)
+	merged := make([]bool, len(tableStructure))
+	for i := range tableStructure {
+		srcInfo := &tableStructure[i]
+		if len(srcInfo.SrcTableInfo) == 0 || len(srcInfo.DestTableInfo) > 0 {
+			continue
+		}
+
+		// Pick the destination with the best compatibility
+		bestIdx, bestLevel := -1, CompatIncompatible
+		var bestIssues []string
+		for j := range tableStructure {
+			destInfo := tableStructure[j]
+			if i == j || merged[j] || len(destInfo.DestTableInfo) == 0 || destInfo.MD5Columns != srcInfo.MD5Columns ||
+				destInfo.StructureOnly != srcInfo.StructureOnly {
+				continue
+			}
+			level, issues := policy.classifyTable(srcInfo.Columns, destInfo.Columns)
+			if level < bestLevel {
+				bestIdx, bestLevel, bestIssues = j, level, issues
+			}
+		}
+		if bestIdx < 0 {
+			continue
+		}
+
+		destInfo := &tableStructure[bestIdx]
+		issues := make([]string, 0, len(bestIssues))
+		for _, issue := range bestIssues {
+			issues = append(issues, fmt.Sprintf("%s -> %s: %s", srcInfo.SrcTableInfo[0], destInfo.DestTableInfo[0], issue))
+		}
+		if bestLevel > policy.minLevel {
+			slog.Warn("source tables not grouped to the destination by the type compatibility",
+				"srcTables", srcInfo.SrcTableInfo, "destTable", destInfo.DestTableInfo[0], "level", bestLevel.String(), "minLevel", policy.minLevel.String(), "issues", issues)
+			if bestLevel == CompatLossy {
+				srcInfo.TypeIssues = append(append([]string{}, srcInfo.TypeIssues...), issues...)
+			}
+			continue
+		}
+
+		slog.Info("grouped source tables to compatible destination", "srcTables", srcInfo.SrcTableInfo, "destTable", destInfo.DestTableInfo[0], "level", bestLevel.String())
+		destInfo.SrcTableInfo = append(append([]string{}, destInfo.SrcTableInfo...), srcInfo.SrcTableInfo...)
+		destInfo.TypeIssues = append(append(append([]string{}, destInfo.TypeIssues...), srcInfo.TypeIssues...), issues...)
+		merged[i] = true
+	}
+
+	result := make([]TableInfo, 0, len(tableStructure))
+	for i, tableInfo := range tableStructure {
+		if !merged[i] {
+			result = append(result, tableInfo)
+		}
+	}
+	return result
+}
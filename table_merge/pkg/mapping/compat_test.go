@@ -0,0 +1,121 @@
+package mapping
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+func intValue(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: true}
+}
+
+func Test_classifyColumn(t *testing.T) {
+	policy, err := NewCompatPolicy(config.TypeCompatibilityConfig{})
+	if err != nil {
+		t.Fatalf("NewCompatPolicy() error = %v", err)
+	}
+	varchar := func(length int64, nullable bool) schema.ColumnDef {
+		return schema.ColumnDef{Name: "c", DataType: "varchar", CharMaxLength: intValue(length), Nullable: nullable}
+	}
+	integer := func(dataType string) schema.ColumnDef {
+		return schema.ColumnDef{Name: "c", DataType: dataType, NumericPrecision: intValue(0), NumericScale: intValue(0)}
+	}
+	decimal := func(precision, scale int64) schema.ColumnDef {
+		return schema.ColumnDef{Name: "c", DataType: "decimal", NumericPrecision: intValue(precision), NumericScale: intValue(scale)}
+	}
+	datetime := func(dataType string, precision int64) schema.ColumnDef {
+		return schema.ColumnDef{Name: "c", DataType: dataType, DatetimePrecision: intValue(precision)}
+	}
+
+	tests := []struct {
+		name string
+		src  schema.ColumnDef
+		dest schema.ColumnDef
+		want CompatLevel
+	}{
+		{"Identical", varchar(64, true), varchar(64, true), CompatIdentical},
+		{"Longer varchar", varchar(64, true), varchar(128, true), CompatWideningSafe},
+		{"Shorter varchar", varchar(128, true), varchar(64, true), CompatLossy},
+		{"NOT NULL to NULL", varchar(64, false), varchar(64, true), CompatWideningSafe},
+		{"NULL to NOT NULL", varchar(64, true), varchar(64, false), CompatLossy},
+		{"int to bigint", integer("int"), integer("bigint"), CompatWideningSafe},
+		{"bigint to int", integer("bigint"), integer("int"), CompatLossy},
+		{"int to decimal(12,2)", integer("int"), decimal(12, 2), CompatWideningSafe},
+		{"decimal(10,2) to decimal(12,4)", decimal(10, 2), decimal(12, 4), CompatWideningSafe},
+		{"decimal(10,2) to decimal(10,4)", decimal(10, 2), decimal(10, 4), CompatLossy},
+		{"datetime(3) to datetime(6)", datetime("datetime", 3), datetime("datetime", 6), CompatWideningSafe},
+		{"datetime to timestamp", datetime("datetime", 0), datetime("timestamp", 0), CompatLossy},
+		{"int to varchar", integer("int"), varchar(64, true), CompatLossy},
+		{"varchar to int", varchar(64, true), integer("int"), CompatIncompatible},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.classifyColumn(tt.src, tt.dest); got != tt.want {
+				t.Errorf("classifyColumn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_NewCompatPolicy(t *testing.T) {
+	policy, err := NewCompatPolicy(config.TypeCompatibilityConfig{
+		MinLevel:  "lossy",
+		Overrides: []config.TypeCompatibilityOverride{{Source: "varchar", Dest: "INT", Level: "lossy"}},
+	})
+	if err != nil {
+		t.Fatalf("NewCompatPolicy() error = %v", err)
+	}
+	if policy.minLevel != CompatLossy {
+		t.Errorf("minLevel = %v, want lossy", policy.minLevel)
+	}
+	src := schema.ColumnDef{Name: "c", DataType: "varchar", CharMaxLength: intValue(10)}
+	dest := schema.ColumnDef{Name: "c", DataType: "int", NumericPrecision: intValue(0)}
+	if got := policy.classifyColumn(src, dest); got != CompatLossy {
+		t.Errorf("classifyColumn() with override = %v, want lossy", got)
+	}
+
+	if _, err := NewCompatPolicy(config.TypeCompatibilityConfig{MinLevel: "incompatible"}); err == nil {
+		t.Errorf("NewCompatPolicy() expected error for incompatible min level")
+	}
+	if _, err := NewCompatPolicy(config.TypeCompatibilityConfig{MinLevel: "unknown"}); err == nil {
+		t.Errorf("NewCompatPolicy() expected error for unknown level")
+	}
+}
+
+func Test_matchCompatibleDestinations(t *testing.T) {
+	columns := func(nameLength, amountScale int64) []schema.ColumnDef {
+		return []schema.ColumnDef{
+			{Name: "amount", DataType: "decimal", NumericPrecision: intValue(12), NumericScale: intValue(amountScale)},
+			{Name: "name", DataType: "varchar", CharMaxLength: intValue(nameLength)},
+		}
+	}
+	tableStructure := []TableInfo{
+		// Identical to the destination, grouped by the hash
+		{MD5Columns: "m1", SrcTableInfo: []string{"inst01.db_00.orders"}, DestTableInfo: []string{"target.messagedb.orders"}, Columns: columns(128, 2)},
+		// Widening-safe
+		{MD5Columns: "m1", SrcTableInfo: []string{"inst02.db_01.orders"}, Columns: columns(64, 2)},
+		// Lossy
+		{MD5Columns: "m1", SrcTableInfo: []string{"inst03.db_02.orders"}, Columns: columns(64, 4)},
+	}
+
+	policy, _ := NewCompatPolicy(config.TypeCompatibilityConfig{})
+	got := MatchCompatibleDestinations(tableStructure, policy)
+	if len(got) != 2 {
+		t.Fatalf("MatchCompatibleDestinations() returned %d groups, want 2", len(got))
+	}
+	if len(got[0].SrcTableInfo) != 2 || got[0].SrcTableInfo[1] != "inst02.db_01.orders" {
+		t.Errorf("SrcTableInfo = %v, want the widening-safe table grouped", got[0].SrcTableInfo)
+	}
+	if len(got[1].DestTableInfo) != 0 || len(got[1].TypeIssues) != 1 {
+		t.Errorf("lossy group = %+v, want no destination and one type issue", got[1])
+	}
+
+	lossyPolicy, _ := NewCompatPolicy(config.TypeCompatibilityConfig{MinLevel: "lossy"})
+	got = MatchCompatibleDestinations(tableStructure, lossyPolicy)
+	if len(got) != 1 || len(got[0].SrcTableInfo) != 3 || len(got[0].TypeIssues) != 1 {
+		t.Errorf("MatchCompatibleDestinations() with lossy policy = %+v, want one group with the lossy issue", got)
+	}
+}
@@ -0,0 +1,276 @@
+// Package mapping groups the source and destination tables sharing the same column structure
+package mapping
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// TableInfo is a group of source tables and the destination tables they are merged to
+type TableInfo struct {
+	MD5Columns          string
+	MD5ColumnsWithTypes string
+	SrcRegex            string
+	SrcTableInfo        []string
+	DestTableInfo       []string
+	DestHasSource       bool
+	DestHasSchema       bool
+	DestHasTableName    bool
+	MaxID               int64
+	// StructureOnly marks the group whose data is ignored by the filter
+	StructureOnly bool
+	// Columns are the normalised columns of the group, the destination ones if it has a destination
+	Columns []schema.ColumnDef
+	// TypeIssues are the lossy column pairings found by the type compatibility policy
+	TypeIssues []string
+	// ColumnOrder are the column names in their ordinal order, the destination ones if it has a destination
+	ColumnOrder []string
+	// PKOffset is the primary key rewriting of the merged shards, see AssignPKOffsets
+	PKOffset *PKOffset
+	// SrcSizes are the statistics of the source tables indexed by instance.schema.table, see AssignSizes
+	SrcSizes map[string]schema.TableSize
+}
+
+// Options are the options of Build
+type Options struct {
+	// OpenSource opens the schema source of an instance, schema.Open if nil
+	OpenSource func(config.DBConnInfo) (schema.Source, error)
+	// QueryIDRange measures the id range of a source table for AssignPKOffsets, schema.IDRange if nil
+	QueryIDRange func(dbInfo config.DBConnInfo, schemaName, table, column string) (int64, int64, error)
+	// QueryTableSizes reads the statistics of the tables of an instance for AssignSizes, schema.TableSizes if nil
+	QueryTableSizes func(dbInfo config.DBConnInfo, schemas []string) (map[string]schema.TableSize, error)
+}
+
+func (o Options) openSource() func(config.DBConnInfo) (schema.Source, error) {
+	if o.OpenSource != nil {
+		return o.OpenSource
+	}
+	return schema.Open
+}
+
+// Build fetches the table definitions of all the source and destination
+// databases and groups the tables sharing the same column structure.
+func Build(cfg config.Config, opts Options) ([]TableInfo, error) {
+	tableStructure := []TableInfo{}
+
+	/*
+			Fetch source database table definitions and create a mapping where:
+		    - Key: MD5 hash of consolidated column definitions
+		    - Value: List of table names sharing the same column structure
+	*/
+	for _, sourceDB := range cfg.SourceDB {
+		slog.Info("fetching source table definitions", "sourceDB", sourceDB.Name)
+		err := fetchTableDefs("source", &tableStructure, sourceDB, opts.openSource())
+		if err != nil {
+			slog.Error("failed to fetch source table definitions", "error", err, "sourceDB", sourceDB.Name)
+			return nil, err
+		}
+		slog.Debug("fetched source tables", "sourceDB", sourceDB.Name, "totalTables", len(tableStructure))
+	}
+
+	/*
+			Similarly, fetch destination database table definitions and create a mapping where:
+		    - Key: MD5 hash of consolidated column definitions
+		    - Value: List of table names sharing the same column structure
+	*/
+	slog.Info("fetching destination table definitions", "destDB", cfg.DestDB.Name)
+	if err := fetchTableDefs("dest", &tableStructure, cfg.DestDB, opts.openSource()); err != nil {
+		slog.Error("failed to fetch destination table definitions", "error", err, "destDB", cfg.DestDB.Name)
+		return nil, err
+	}
+	slog.Debug("fetched destination tables", "destDB", cfg.DestDB.Name, "totalTables", len(tableStructure))
+
+	// Group the source tables to the destination tables with compatible column types
+	policy, err := NewCompatPolicy(cfg.TypeCompatibility)
+	if err != nil {
+		slog.Error("invalid type compatibility policy", "error", err)
+		return nil, err
+	}
+	tableStructure = MatchCompatibleDestinations(tableStructure, policy)
+
+	// Convert the tableInfo like source: ["TableA, TableB01, TableB02"]  dest: ["TableA, TableB"]
+	// to Source: [TableA], Dest: [TableA]
+	//   and Source: [TableB01, TableB02], Dest: [TableB]
+	// If both source and dest has multiple tables, separate those table with same name.
+	// Multiple to multiple can not be handle. Use the name format to make the mapping between the source and destination.
+	convertedTableStructure := []TableInfo{}
+	for _, tableInfo := range tableStructure {
+		// Skip if one to one
+		if len(tableInfo.SrcTableInfo) <= 1 || len(tableInfo.DestTableInfo) <= 1 {
+			convertedTableStructure = append(convertedTableStructure, tableInfo)
+			continue
+		}
+
+		// If multiple to multiple, separate them as one-to-one mapping and many-to-one mapping
+		if len(tableInfo.SrcTableInfo) > 1 && len(tableInfo.DestTableInfo) > 1 {
+			foundTable := []string{}
+			// If the table name is same, then we will separate them as one-to-one mapping
+			for _, srcTable := range tableInfo.SrcTableInfo {
+				for _, destTable := range tableInfo.DestTableInfo {
+					if (strings.Split(srcTable, "."))[2] == (strings.Split(destTable, "."))[2] {
+						slog.Debug("matched same table name", "srcTable", srcTable, "destTable", destTable)
+						convertedTableStructure = append(convertedTableStructure, TableInfo{
+							MD5Columns:          tableInfo.MD5Columns,
+							MD5ColumnsWithTypes: tableInfo.MD5ColumnsWithTypes,
+							SrcTableInfo:        []string{srcTable},
+							DestTableInfo:       []string{destTable},
+							StructureOnly:       tableInfo.StructureOnly,
+							Columns:             tableInfo.Columns,
+							TypeIssues:          tableInfo.TypeIssues,
+							ColumnOrder:         tableInfo.ColumnOrder,
+						})
+						foundTable = append(foundTable, srcTable)
+					}
+				}
+			}
+
+			// If the table name is not same, then we will separate them as many-to-one mapping
+			tmpSrcTable := []string{}
+			tmpDestTable := []string{}
+			for _, srcTable := range tableInfo.SrcTableInfo {
+				isFound := false
+				for _, foundSrc := range foundTable {
+					if srcTable == foundSrc {
+						isFound = true
+						break
+					}
+				}
+				if !isFound {
+					tmpSrcTable = append(tmpSrcTable, srcTable)
+				}
+			}
+
+			// Find the dest table that has the same base name as the srcTable that was found
+			for _, destTable := range tableInfo.DestTableInfo {
+				isFound := false
+				for _, foundSrc := range foundTable {
+					// Check against the base name of the srcTable that was found
+					if (strings.Split(destTable, "."))[2] == (strings.Split(foundSrc, "."))[2] {
+						isFound = true
+						break
+					}
+				}
+				if !isFound {
+					tmpDestTable = append(tmpDestTable, destTable)
+				}
+			}
+
+			// If there are remaining src and dest tables, add them to the convertedTableStructure
+			if len(tmpSrcTable) > 0 && len(tmpDestTable) > 0 {
+				slog.Debug("remaining many-to-many tables after name matching", "srcCount", len(tmpSrcTable), "destCount", len(tmpDestTable))
+				convertedTableStructure = append(convertedTableStructure, TableInfo{
+					MD5Columns:          tableInfo.MD5Columns,
+					MD5ColumnsWithTypes: tableInfo.MD5ColumnsWithTypes,
+					SrcTableInfo:        tmpSrcTable,
+					DestTableInfo:       tmpDestTable,
+					StructureOnly:       tableInfo.StructureOnly,
+					Columns:             tableInfo.Columns,
+					TypeIssues:          tableInfo.TypeIssues,
+					ColumnOrder:         tableInfo.ColumnOrder,
+				})
+			}
+		}
+	}
+
+	slog.Info("table structure conversion completed", "finalTableCount", len(convertedTableStructure))
+	return convertedTableStructure, nil
+}
+
+// fetchTableDefs reads the table definitions of the instance and groups them into tableStructure
+func fetchTableDefs(tableType string, tableStructure *[]TableInfo, dbInfo config.DBConnInfo, openSource func(config.DBConnInfo) (schema.Source, error)) error {
+	slog.Debug("connecting for fetchTableDefs", "tableType", tableType, "dbName", dbInfo.Name, "host", dbInfo.Host, "port", dbInfo.Port, "engine", dbInfo.Engine, "dbCount", len(dbInfo.DBs))
+
+	// 1. Open the schema source of the engine and verify the connection
+	source, err := openSource(dbInfo)
+	if err != nil {
+		slog.Error("failed to connect to database", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
+		return err
+	}
+	// Ensure the connection is closed when the tables are grouped
+	defer source.Close()
+
+	// 2. Read the column metadata. The columns are hashed in Go so that the tables of
+	// different engines are compared with the same normalised types.
+	tables, err := source.Tables(dbInfo.DBs)
+	if err != nil {
+		slog.Error("failed to fetch table definitions", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
+		return err
+	}
+
+	return groupTableDefs(tableType, tableStructure, dbInfo, tables)
+}
+
+// groupTableDefs adds the tables to the group of the same structure or a new group
+func groupTableDefs(tableType string, tableStructure *[]TableInfo, dbInfo config.DBConnInfo, tables []schema.TableDef) error {
+	filter, err := dbInfo.Filter.Compile()
+	if err != nil {
+		slog.Error("invalid table filter", "error", err, "tableType", tableType, "dbName", dbInfo.Name)
+		return err
+	}
+
+	rowCount := 0
+	for _, tableDef := range tables {
+		tableSchema, tableName := tableDef.Schema, tableDef.Table
+		if !filter.Allowed(tableSchema, tableName) {
+			continue
+		}
+		md5Columns, md5ColumnsWithTypes := tableDef.MD5Columns(), tableDef.MD5ColumnsWithTypes()
+		rowCount++
+		slog.Debug("scanned table metadata", "tableType", tableType, "dbName", dbInfo.Name, "schema", tableSchema, "table", tableName, "md5Columns", md5Columns, "md5ColumnsWithTypes", md5ColumnsWithTypes)
+
+		// Create new TableInfo struct and append to slice
+		newTableInfo := TableInfo{
+			MD5Columns:          md5Columns,
+			MD5ColumnsWithTypes: md5ColumnsWithTypes,
+			Columns:             tableDef.SortedColumns(),
+			ColumnOrder:         tableDef.ColumnNames(),
+		}
+		if tableType == "source" {
+			// The structure only tables are not grouped with the tables whose data is migrated
+			newTableInfo.StructureOnly = filter.IsStructureOnly(tableSchema, tableName)
+			newTableInfo.SrcTableInfo = []string{fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName)}
+		} else {
+			newTableInfo.DestTableInfo = []string{fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName)}
+		}
+
+		// Check if similar table structure exists
+		found := false
+		for i, existing := range *tableStructure {
+			if existing.MD5Columns == newTableInfo.MD5Columns &&
+				existing.MD5ColumnsWithTypes == newTableInfo.MD5ColumnsWithTypes &&
+				(tableType != "source" || existing.StructureOnly == newTableInfo.StructureOnly) {
+				if tableType == "source" {
+					existing.SrcTableInfo = append(existing.SrcTableInfo,
+						fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName))
+					(*tableStructure)[i] = existing
+				} else {
+					if len(existing.DestTableInfo) == 0 {
+						existing.ColumnOrder = newTableInfo.ColumnOrder
+					}
+					existing.DestTableInfo = append(existing.DestTableInfo,
+						fmt.Sprintf("%s.%s.%s", dbInfo.Name, tableSchema, tableName))
+					existing.DestHasSource = tableDef.HasColumn("c_instance")
+					existing.DestHasSchema = tableDef.HasColumn("c_schema")
+					existing.DestHasTableName = tableDef.HasColumn("c_table")
+					(*tableStructure)[i] = existing
+				}
+				found = true
+				slog.Debug("merged table into existing structure", "tableType", tableType, "schema", tableSchema, "table", tableName, "md5Columns", md5Columns)
+				break
+			}
+		}
+
+		// If no match found, append new structure
+		if !found {
+			*tableStructure = append(*tableStructure, newTableInfo)
+			slog.Debug("appended new table structure", "tableType", tableType, "schema", tableSchema, "table", tableName, "md5Columns", md5Columns)
+		}
+	}
+
+	slog.Info("completed fetchTableDefs", "tableType", tableType, "dbName", dbInfo.Name, "rowCount", rowCount, "totalStructures", len(*tableStructure))
+	return nil
+}
@@ -0,0 +1,122 @@
+package mapping
+
+import (
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema/schematest"
+)
+
+func ordersTable(schemaName string, extra ...schema.ColumnDef) schema.TableDef {
+	columns := []schema.ColumnDef{
+		{Name: "id", DataType: "bigint", NumericPrecision: intValue(19), NumericScale: intValue(0)},
+		{Name: "name", DataType: "varchar", CharMaxLength: intValue(64), Nullable: true},
+	}
+	return schema.TableDef{Schema: schemaName, Table: "orders", Columns: append(columns, extra...)}
+}
+
+func Test_Build(t *testing.T) {
+	sources := map[string]schematest.StaticSource{
+		"mysql01": {Defs: []schema.TableDef{ordersTable("db_00")}},
+		"mysql02": {Defs: []schema.TableDef{ordersTable("db_01"), ordersTable("db_02")}},
+		"target": {Defs: []schema.TableDef{ordersTable("messagedb",
+			schema.ColumnDef{Name: "c_instance", DataType: "varchar", CharMaxLength: intValue(64)})}},
+	}
+	cfg := config.Config{
+		SourceDB: []config.DBConnInfo{
+			{Name: "mysql01", DBs: []string{"db_00"}},
+			{Name: "mysql02", DBs: []string{"db_01", "db_02"}},
+		},
+		DestDB: config.DBConnInfo{Name: "target", DBs: []string{"messagedb"}},
+	}
+	opts := Options{OpenSource: schematest.Open(sources)}
+
+	got, err := Build(cfg, opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Build() returned %d groups, want 1: %+v", len(got), got)
+	}
+	if len(got[0].SrcTableInfo) != 3 || got[0].SrcTableInfo[2] != "mysql02.db_02.orders" {
+		t.Errorf("SrcTableInfo = %v, want the three source tables", got[0].SrcTableInfo)
+	}
+	if len(got[0].DestTableInfo) != 1 || !got[0].DestHasSource || got[0].DestHasSchema {
+		t.Errorf("DestTableInfo = %v, DestHasSource = %v, DestHasSchema = %v", got[0].DestTableInfo, got[0].DestHasSource, got[0].DestHasSchema)
+	}
+
+	// The filter drops the tables before they are grouped
+	cfg.SourceDB[1].Filter = config.TableFilter{Exclude: []config.TableRule{{Schema: "db_02", Table: "*"}}}
+	got, err = Build(cfg, opts)
+	if err != nil {
+		t.Fatalf("Build() with filter error = %v", err)
+	}
+	if len(got) != 1 || len(got[0].SrcTableInfo) != 2 {
+		t.Errorf("Build() with filter = %+v, want the two remaining source tables", got)
+	}
+}
+
+func Test_BuildManyToManyKeepsStructureOnly(t *testing.T) {
+	renamed := func(table schema.TableDef, name string) schema.TableDef {
+		table.Table = name
+		return table
+	}
+	sources := map[string]schematest.StaticSource{
+		"mysql01": {Defs: []schema.TableDef{ordersTable("db_00"), renamed(ordersTable("db_00"), "orders_archive_01")}},
+		"target":  {Defs: []schema.TableDef{ordersTable("messagedb"), renamed(ordersTable("messagedb"), "orders_archive")}},
+	}
+	cfg := config.Config{
+		SourceDB: []config.DBConnInfo{{Name: "mysql01", DBs: []string{"db_00"},
+			Filter: config.TableFilter{StructureOnly: []config.TableRule{{Schema: "db_00", Table: "*"}}}}},
+		DestDB: config.DBConnInfo{Name: "target", DBs: []string{"messagedb"}},
+	}
+	opts := Options{OpenSource: schematest.Open(sources)}
+
+	got, err := Build(cfg, opts)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	// The group of 2 sources and 2 destinations is split into orders -> orders and the archive
+	if len(got) != 2 {
+		t.Fatalf("Build() returned %d groups, want 2: %+v", len(got), got)
+	}
+	for _, tableInfo := range got {
+		if len(tableInfo.SrcTableInfo) != 1 || len(tableInfo.DestTableInfo) != 1 {
+			t.Errorf("group %v -> %v, want one-to-one", tableInfo.SrcTableInfo, tableInfo.DestTableInfo)
+		}
+		if !tableInfo.StructureOnly {
+			t.Errorf("group %v lost the structure-only marker in the split", tableInfo.SrcTableInfo)
+		}
+	}
+}
+
+func Test_BuildTypeCompatibilityError(t *testing.T) {
+	cfg := config.Config{TypeCompatibility: config.TypeCompatibilityConfig{MinLevel: "incompatible"}}
+	opts := Options{OpenSource: func(config.DBConnInfo) (schema.Source, error) { return schematest.StaticSource{}, nil }}
+	if _, err := Build(cfg, opts); err == nil {
+		t.Errorf("Build() with incompatible min level want error")
+	}
+}
+
+func Test_fetchTableDefs(t *testing.T) {
+	type args struct {
+		tableType      string
+		tableStructure *[]TableInfo
+		dbInfo         config.DBConnInfo
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := fetchTableDefs(tt.args.tableType, tt.args.tableStructure, tt.args.dbInfo, schema.Open); (err != nil) != tt.wantErr {
+				t.Errorf("fetchTableDefs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// The bits of the DM partition id: the sign bit, the instance id, the schema id, the table id
+// and the origin id in the remaining bits. A part without its argument takes no bit.
+const (
+	partitionInstanceBits = 4
+	partitionSchemaBits   = 7
+	partitionTableBits    = 8
+)
+
+// ShardOffset is the primary key offset of one source table of the group
+type ShardOffset struct {
+	// Table is instance.schema.table of the source
+	Table  string `json:"table"`
+	Offset int64  `json:"offset"`
+	// MinID and MaxID are the measured range of the primary key
+	MinID int64 `json:"min_id"`
+	MaxID int64 `json:"max_id"`
+	// Arguments of the DM partition id column mapping: instance id, schema prefix, table prefix and separator
+	Arguments []string `json:"arguments"`
+}
+
+// PKOffset is the primary key rewriting of a many-to-one group
+type PKOffset struct {
+	Column string `json:"column"`
+	// MaxOriginID is the exclusive upper bound of the ids of every shard
+	MaxOriginID int64         `json:"max_origin_id"`
+	Shards      []ShardOffset `json:"shards"`
+}
+
+// Shard returns the offset of the instance.schema.table source table
+func (o *PKOffset) Shard(table string) (ShardOffset, bool) {
+	if o == nil {
+		return ShardOffset{}, false
+	}
+	for _, shard := range o.Shards {
+		if shard.Table == table {
+			return shard, true
+		}
+	}
+	return ShardOffset{}, false
+}
+
+func (o Options) queryIDRange() func(config.DBConnInfo, string, string, string) (int64, int64, error) {
+	if o.QueryIDRange != nil {
+		return o.QueryIDRange
+	}
+	return schema.IDRange
+}
+
+// partitionPart is the schema or the table part of the partition id
+type partitionPart struct {
+	prefix string
+	ids    map[string]uint64
+}
+
+// parsePartitionPart splits the names into the common prefix and the numbered suffixes like DM
+// does. The part is not used(empty prefix) if all the names are the same.
+func parsePartitionPart(names []string, separator string, bits int) (partitionPart, error) {
+	distinct := distinctValues(names)
+	part := partitionPart{ids: map[string]uint64{}}
+	if len(distinct) <= 1 {
+		return part, nil
+	}
+
+	// The prefix is taken from the numbered names, a name equal to the prefix is id 0
+	for name := range distinct {
+		idx := strings.LastIndex(name, separator)
+		if idx <= 0 {
+			continue
+		}
+		if _, err := strconv.ParseUint(name[idx+len(separator):], 10, bits); err == nil {
+			part.prefix = name[:idx]
+			break
+		}
+	}
+	if part.prefix == "" {
+		return part, fmt.Errorf("names %s have no %s<number> suffix", strings.Join(names, ", "), separator)
+	}
+	for name := range distinct {
+		if name == part.prefix {
+			part.ids[name] = 0
+			continue
+		}
+		if !strings.HasPrefix(name, part.prefix+separator) {
+			return part, fmt.Errorf("name %s does not start with %s%s", name, part.prefix, separator)
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(name, part.prefix+separator), 10, bits)
+		if err != nil {
+			return part, fmt.Errorf("suffix of name %s is not a number below %d", name, 1<<bits)
+		}
+		part.ids[name] = id
+	}
+	return part, nil
+}
+
+// buildPKOffset computes the partition id of every shard of the group. instanceIndex is the
+// position of the instance in the config, used as the instance id.
+func buildPKOffset(tableInfo TableInfo, instanceIndex map[string]int, column, separator string) (*PKOffset, error) {
+	instances, schemas, tables := []string{}, []string{}, []string{}
+	for _, srcTable := range tableInfo.SrcTableInfo {
+		parts := strings.Split(srcTable, ".")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected source table %s, expected instance.schema.table", srcTable)
+		}
+		instances, schemas, tables = append(instances, parts[0]), append(schemas, parts[1]), append(tables, parts[2])
+	}
+
+	useInstance := len(distinctValues(instances)) > 1
+	schemaPart, err := parsePartitionPart(schemas, separator, partitionSchemaBits)
+	if err != nil {
+		return nil, fmt.Errorf("schema id: %w", err)
+	}
+	tablePart, err := parsePartitionPart(tables, separator, partitionTableBits)
+	if err != nil {
+		return nil, fmt.Errorf("table id: %w", err)
+	}
+
+	shift := 63
+	instanceShift, schemaShift, tableShift := 0, 0, 0
+	if useInstance {
+		shift -= partitionInstanceBits
+		instanceShift = shift
+	}
+	if schemaPart.prefix != "" {
+		shift -= partitionSchemaBits
+		schemaShift = shift
+	}
+	if tablePart.prefix != "" {
+		shift -= partitionTableBits
+		tableShift = shift
+	}
+
+	offset := &PKOffset{Column: column, MaxOriginID: int64(1) << shift}
+	seen := map[int64]string{}
+	for i, srcTable := range tableInfo.SrcTableInfo {
+		shard := ShardOffset{Table: srcTable, Arguments: []string{"", schemaPart.prefix, tablePart.prefix, separator}}
+		if useInstance {
+			index, ok := instanceIndex[instances[i]]
+			if !ok {
+				return nil, fmt.Errorf("instance %s of %s is not in the config", instances[i], srcTable)
+			}
+			if index >= 1<<partitionInstanceBits {
+				return nil, fmt.Errorf("instance %s is number %d of the config, DM supports %d instances", instances[i], index, 1<<partitionInstanceBits)
+			}
+			shard.Arguments[0] = strconv.Itoa(index)
+			shard.Offset |= int64(index) << instanceShift
+		}
+		if schemaPart.prefix != "" {
+			shard.Offset |= int64(schemaPart.ids[schemas[i]]) << schemaShift
+		}
+		if tablePart.prefix != "" {
+			shard.Offset |= int64(tablePart.ids[tables[i]]) << tableShift
+		}
+		if previous, ok := seen[shard.Offset]; ok {
+			return nil, fmt.Errorf("%s and %s get the same partition id %d", previous, srcTable, shard.Offset)
+		}
+		seen[shard.Offset] = srcTable
+		offset.Shards = append(offset.Shards, shard)
+	}
+	return offset, nil
+}
+
+func distinctValues(values []string) map[string]bool {
+	distinct := map[string]bool{}
+	for _, value := range values {
+		distinct[value] = true
+	}
+	return distinct
+}
+
+// AssignPKOffsets sets PKOffset of the many-to-one groups of cfg.PKOffset.Tables. The offset of
+// each shard is its DM partition id, the measured id range of every shard must fit below
+// MaxOriginID so that the shifted ids do not overlap.
+func AssignPKOffsets(cfg config.Config, tableStructure []TableInfo, opts Options) error {
+	if len(cfg.PKOffset.Tables) == 0 {
+		return nil
+	}
+	column := cfg.PKOffset.Column
+	if column == "" {
+		column = "id"
+	}
+	separator := cfg.PKOffset.Separator
+	if separator == "" {
+		separator = "_"
+	}
+	instanceIndex := map[string]int{}
+	dbInfos := map[string]config.DBConnInfo{}
+	for i, dbInfo := range cfg.SourceDB {
+		instanceIndex[dbInfo.Name] = i
+		dbInfos[dbInfo.Name] = dbInfo
+	}
+	wanted := map[string]bool{}
+	for _, table := range cfg.PKOffset.Tables {
+		wanted[table] = true
+	}
+
+	var errs []error
+	for i := range tableStructure {
+		tableInfo := &tableStructure[i]
+		if len(tableInfo.DestTableInfo) != 1 {
+			continue
+		}
+		destParts := strings.SplitN(tableInfo.DestTableInfo[0], ".", 2)
+		if len(destParts) != 2 || !wanted[destParts[1]] {
+			continue
+		}
+		delete(wanted, destParts[1])
+		if len(tableInfo.SrcTableInfo) < 2 {
+			slog.Warn("pk offset skipped, not a merged table", "destTable", tableInfo.DestTableInfo[0], "srcTables", tableInfo.SrcTableInfo)
+			continue
+		}
+
+		// The shifted ids need the whole BIGINT of the destination
+		isBigint := false
+		for _, col := range tableInfo.Columns {
+			if strings.EqualFold(col.Name, column) {
+				isBigint = col.DataType == "bigint"
+			}
+		}
+		if !isBigint {
+			errs = append(errs, fmt.Errorf("%s: column %s is not a BIGINT", tableInfo.DestTableInfo[0], column))
+			continue
+		}
+
+		offset, err := buildPKOffset(*tableInfo, instanceIndex, column, separator)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tableInfo.DestTableInfo[0], err))
+			continue
+		}
+		for j := range offset.Shards {
+			shard := &offset.Shards[j]
+			parts := strings.Split(shard.Table, ".")
+			minID, maxID, err := opts.queryIDRange()(dbInfos[parts[0]], parts[1], parts[2], column)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", shard.Table, err))
+				continue
+			}
+			shard.MinID, shard.MaxID = minID, maxID
+			if minID < 0 || maxID >= offset.MaxOriginID {
+				errs = append(errs, fmt.Errorf("%s: ids %d..%d do not fit in 0..%d", shard.Table, minID, maxID, offset.MaxOriginID-1))
+			}
+			slog.Info("assigned pk offset", "srcTable", shard.Table, "offset", shard.Offset, "minID", minID, "maxID", maxID)
+		}
+		tableInfo.PKOffset = offset
+	}
+	for table := range wanted {
+		slog.Warn("pk offset table not found in the mapping", "destTable", table)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to assign pk offsets: %w", errors.Join(errs...))
+	}
+	return nil
+}
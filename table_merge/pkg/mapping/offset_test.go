@@ -0,0 +1,103 @@
+package mapping
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+func pkOffsetConfig(tables ...string) config.Config {
+	return config.Config{
+		SourceDB: []config.DBConnInfo{{Name: "mysql01"}, {Name: "mysql02"}},
+		PKOffset: config.PKOffsetConfig{Tables: tables},
+	}
+}
+
+func mergedOrders(srcTables ...string) []TableInfo {
+	return []TableInfo{{
+		SrcTableInfo:  srcTables,
+		DestTableInfo: []string{"target.messagedb.orders"},
+		Columns:       ordersTable("messagedb").Columns,
+	}}
+}
+
+// idRanges is the fake QueryIDRange, the ranges are indexed by instance.schema.table
+func idRanges(ranges map[string][2]int64) Options {
+	return Options{QueryIDRange: func(dbInfo config.DBConnInfo, schemaName, table, column string) (int64, int64, error) {
+		r := ranges[dbInfo.Name+"."+schemaName+"."+table]
+		return r[0], r[1], nil
+	}}
+}
+
+func Test_AssignPKOffsets(t *testing.T) {
+	tableStructure := mergedOrders("mysql01.db_00.orders", "mysql02.db_01.orders")
+	opts := idRanges(map[string][2]int64{
+		"mysql01.db_00.orders": {1, 1000},
+		"mysql02.db_01.orders": {5, 2000},
+	})
+	if err := AssignPKOffsets(pkOffsetConfig("messagedb.orders"), tableStructure, opts); err != nil {
+		t.Fatalf("AssignPKOffsets() error = %v", err)
+	}
+
+	want := &PKOffset{Column: "id", MaxOriginID: 1 << 52, Shards: []ShardOffset{
+		{Table: "mysql01.db_00.orders", Offset: 0, MinID: 1, MaxID: 1000, Arguments: []string{"0", "db", "", "_"}},
+		{Table: "mysql02.db_01.orders", Offset: 1<<59 | 1<<52, MinID: 5, MaxID: 2000, Arguments: []string{"1", "db", "", "_"}},
+	}}
+	if !reflect.DeepEqual(tableStructure[0].PKOffset, want) {
+		t.Errorf("PKOffset = %+v, want %+v", tableStructure[0].PKOffset, want)
+	}
+	if shard, ok := tableStructure[0].PKOffset.Shard("mysql02.db_01.orders"); !ok || shard.Offset != 1<<59|1<<52 {
+		t.Errorf("Shard() = %+v, %v", shard, ok)
+	}
+}
+
+func Test_AssignPKOffsetsErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		tableStructure []TableInfo
+		ranges         map[string][2]int64
+		wantErr        string
+	}{
+		{
+			name:           "no numbered suffix",
+			tableStructure: mergedOrders("mysql01.sales.orders", "mysql01.users.orders"),
+			wantErr:        "no _<number> suffix",
+		},
+		{
+			name:           "ids overflow the partition id",
+			tableStructure: mergedOrders("mysql01.db_00.orders", "mysql02.db_01.orders"),
+			ranges:         map[string][2]int64{"mysql02.db_01.orders": {1, 1 << 52}},
+			wantErr:        "do not fit",
+		},
+		{
+			name: "not a BIGINT",
+			tableStructure: []TableInfo{{
+				SrcTableInfo:  []string{"mysql01.db_00.orders", "mysql02.db_01.orders"},
+				DestTableInfo: []string{"target.messagedb.orders"},
+				Columns:       []schema.ColumnDef{{Name: "id", DataType: "int"}},
+			}},
+			wantErr: "is not a BIGINT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AssignPKOffsets(pkOffsetConfig("messagedb.orders"), tt.tableStructure, idRanges(tt.ranges))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("AssignPKOffsets() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_AssignPKOffsetsNotConfigured(t *testing.T) {
+	tableStructure := mergedOrders("mysql01.db_00.orders", "mysql02.db_01.orders")
+	if err := AssignPKOffsets(pkOffsetConfig(), tableStructure, Options{}); err != nil {
+		t.Fatalf("AssignPKOffsets() error = %v", err)
+	}
+	if tableStructure[0].PKOffset != nil {
+		t.Errorf("PKOffset = %+v, want nil", tableStructure[0].PKOffset)
+	}
+}
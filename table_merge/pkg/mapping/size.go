@@ -0,0 +1,111 @@
+package mapping
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+func (o Options) queryTableSizes() func(config.DBConnInfo, []string) (map[string]schema.TableSize, error) {
+	if o.QueryTableSizes != nil {
+		return o.QueryTableSizes
+	}
+	return schema.TableSizes
+}
+
+// AssignSizes sets SrcSizes of every group from the statistics of the source instances
+func AssignSizes(cfg config.Config, tableStructure []TableInfo, opts Options) error {
+	sizes := map[string]schema.TableSize{}
+	for _, dbInfo := range cfg.SourceDB {
+		instanceSizes, err := opts.queryTableSizes()(dbInfo, dbInfo.DBs)
+		if err != nil {
+			return fmt.Errorf("failed to fetch table sizes of %s: %w", dbInfo.Name, err)
+		}
+		for table, size := range instanceSizes {
+			sizes[dbInfo.Name+"."+table] = size
+		}
+		slog.Debug("fetched table sizes", "dbName", dbInfo.Name, "tableCount", len(instanceSizes))
+	}
+
+	for i := range tableStructure {
+		tableInfo := &tableStructure[i]
+		if len(tableInfo.SrcTableInfo) == 0 {
+			continue
+		}
+		tableInfo.SrcSizes = map[string]schema.TableSize{}
+		for _, srcTable := range tableInfo.SrcTableInfo {
+			if size, ok := sizes[srcTable]; ok {
+				tableInfo.SrcSizes[srcTable] = size
+			}
+		}
+	}
+	return nil
+}
+
+// TotalSize returns the sum of the statistics of the source tables
+func (t TableInfo) TotalSize() schema.TableSize {
+	total := schema.TableSize{}
+	for _, size := range t.SrcSizes {
+		total = total.Add(size)
+	}
+	return total
+}
+
+// EstimateExport returns the time one worker exports the bytes at the rate of mbps MB/s
+func EstimateExport(bytes int64, mbps float64) time.Duration {
+	if mbps <= 0 {
+		return 0
+	}
+	seconds := float64(bytes) / (mbps * (1 << 20))
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+// PrintSizes shows the statistics of every group with its export estimate, then the export
+// volume and the window of the workload. The window is the longest of the total spread over
+// the workers and the largest table, a table is exported by one worker.
+func PrintSizes(out io.Writer, tableStructure []TableInfo, workload config.WorkloadConfig) {
+	workload = workload.WithDefaults()
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IDX\tSOURCE TABLES\tDEST TABLE\tROWS\tDATA\tINDEX\tEXPORT")
+	total, tableCount := schema.TableSize{}, 0
+	var largest int64
+	for idx, table := range tableStructure {
+		if len(table.SrcTableInfo) == 0 {
+			continue
+		}
+		size := table.TotalSize()
+		export := EstimateExport(size.DataLength, workload.ExportMBps).String()
+		// The data of the structure only tables is not exported
+		if table.StructureOnly {
+			export = "structure only"
+		} else {
+			total, tableCount = total.Add(size), tableCount+len(table.SrcTableInfo)
+			for _, srcSize := range table.SrcSizes {
+				largest = max(largest, srcSize.DataLength)
+			}
+		}
+		destTable := "-"
+		if len(table.DestTableInfo) > 0 {
+			destTable = strings.Join(table.DestTableInfo, ",")
+		}
+		srcTables := table.SrcTableInfo[0]
+		if len(table.SrcTableInfo) > 1 {
+			srcTables = fmt.Sprintf("%s (+%d)", srcTables, len(table.SrcTableInfo)-1)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", idx, srcTables, destTable, size.Rows,
+			schema.FormatBytes(size.DataLength), schema.FormatBytes(size.IndexLength), export)
+	}
+	w.Flush()
+
+	window := max(EstimateExport(total.DataLength/int64(workload.Workers), workload.ExportMBps), EstimateExport(largest, workload.ExportMBps))
+	fmt.Fprintf(out, "\nExported: %d tables, %d rows, data %s, index %s\n", tableCount, total.Rows,
+		schema.FormatBytes(total.DataLength), schema.FormatBytes(total.IndexLength))
+	fmt.Fprintf(out, "Dumpling output about %s, estimated window %s with %d workers at %g MB/s\n",
+		schema.FormatBytes(total.DataLength), window, workload.Workers, workload.ExportMBps)
+}
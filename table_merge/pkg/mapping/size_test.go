@@ -0,0 +1,89 @@
+package mapping
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+func Test_AssignSizes(t *testing.T) {
+	cfg := config.Config{SourceDB: []config.DBConnInfo{
+		{Name: "mysql01", DBs: []string{"db_00"}},
+		{Name: "mysql02", DBs: []string{"db_01"}},
+	}}
+	sizes := map[string]map[string]schema.TableSize{
+		"mysql01": {"db_00.orders": {Rows: 100, DataLength: 1 << 20, IndexLength: 1 << 10}},
+		"mysql02": {"db_01.orders": {Rows: 300, DataLength: 3 << 20}},
+	}
+	opts := Options{QueryTableSizes: func(dbInfo config.DBConnInfo, schemas []string) (map[string]schema.TableSize, error) {
+		return sizes[dbInfo.Name], nil
+	}}
+	tableStructure := []TableInfo{
+		{SrcTableInfo: []string{"mysql01.db_00.orders", "mysql02.db_01.orders"}, DestTableInfo: []string{"target.messagedb.orders"}},
+		{DestTableInfo: []string{"target.messagedb.audit"}},
+	}
+	if err := AssignSizes(cfg, tableStructure, opts); err != nil {
+		t.Fatalf("AssignSizes() error = %v", err)
+	}
+	want := schema.TableSize{Rows: 400, DataLength: 4 << 20, IndexLength: 1 << 10}
+	if got := tableStructure[0].TotalSize(); got != want {
+		t.Errorf("TotalSize() = %+v, want %+v", got, want)
+	}
+	if tableStructure[1].SrcSizes != nil {
+		t.Errorf("SrcSizes of the destination only group = %+v, want nil", tableStructure[1].SrcSizes)
+	}
+
+	opts.QueryTableSizes = func(config.DBConnInfo, []string) (map[string]schema.TableSize, error) {
+		return nil, fmt.Errorf("access denied")
+	}
+	if err := AssignSizes(cfg, tableStructure, opts); err == nil {
+		t.Errorf("AssignSizes() error = nil, want the query error")
+	}
+}
+
+func Test_EstimateExport(t *testing.T) {
+	if got := EstimateExport(100<<20, 50); got != 2*time.Second {
+		t.Errorf("EstimateExport() = %v, want 2s", got)
+	}
+	if got := EstimateExport(100<<20, 0); got != 0 {
+		t.Errorf("EstimateExport() without rate = %v, want 0", got)
+	}
+}
+
+func Test_PrintSizes(t *testing.T) {
+	tableStructure := []TableInfo{
+		{
+			SrcTableInfo:  []string{"mysql01.db_00.orders", "mysql02.db_01.orders"},
+			DestTableInfo: []string{"target.messagedb.orders"},
+			SrcSizes: map[string]schema.TableSize{
+				"mysql01.db_00.orders": {Rows: 100, DataLength: 100 << 20},
+				"mysql02.db_01.orders": {Rows: 300, DataLength: 300 << 20},
+			},
+		},
+		{
+			SrcTableInfo:  []string{"mysql01.db_00.log_2024"},
+			DestTableInfo: []string{"target.messagedb.log_2024"},
+			StructureOnly: true,
+			SrcSizes:      map[string]schema.TableSize{"mysql01.db_00.log_2024": {Rows: 5, DataLength: 1 << 30}},
+		},
+	}
+	var buf bytes.Buffer
+	PrintSizes(&buf, tableStructure, config.WorkloadConfig{Workers: 4, ExportMBps: 10})
+	for _, want := range []string{
+		"mysql01.db_00.orders (+1)",
+		"400.0 MiB",
+		"structure only",
+		"Exported: 2 tables, 400 rows, data 400.0 MiB",
+		// The largest table takes longer than the total spread over the workers
+		"estimated window 30s with 4 workers at 10 MB/s",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PrintSizes() does not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
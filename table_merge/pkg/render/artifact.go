@@ -0,0 +1,471 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestFileName lists the files generated into the output directory by each operation. The
+// files of the previous run which are not generated any more are stale, e.g. the dm-source of a
+// dropped instance.
+const ManifestFileName = "dm-toolkit-artifacts.json"
+
+// Artifacts collects the files generated by the renderers. With Plan or Apply the files are
+// rendered into memory first. Plan shows the unified diff against the existing files and Apply
+// writes them, moving the previous versions into a timestamped backup directory. A nil
+// *Artifacts writes the files directly as they are generated.
+//
+// The generated files are recorded in the manifest of the output directory by Scope. Plan shows
+// the files of the previous run of the scope which are not generated any more as deleted, Apply
+// moves them into the backup directory.
+type Artifacts struct {
+	Plan      bool
+	Apply     bool
+	OutputDir string
+	// Scope is the operation generating the files, the stale files are searched among the files
+	// of the same scope only
+	Scope string
+	files map[string]*bytes.Buffer
+	order []string
+}
+
+// NewArtifacts returns the collection of the files generated into outputDir
+func NewArtifacts(outputDir string, plan, apply bool) *Artifacts {
+	return &Artifacts{Plan: plan, Apply: apply, OutputDir: outputDir, files: map[string]*bytes.Buffer{}}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Deferred reports whether the files are kept in memory until Finish
+func (a *Artifacts) Deferred() bool {
+	return a != nil && (a.Plan || a.Apply)
+}
+
+// PlanOnly reports whether the files are only shown, nothing is written
+func (a *Artifacts) PlanOnly() bool {
+	return a != nil && a.Plan && !a.Apply
+}
+
+func (a *Artifacts) buffer(path string) *bytes.Buffer {
+	path = filepath.Clean(path)
+	if a.files == nil {
+		a.files = map[string]*bytes.Buffer{}
+	}
+	if _, ok := a.files[path]; !ok {
+		a.order = append(a.order, path)
+	}
+	buf := &bytes.Buffer{}
+	a.files[path] = buf
+	return buf
+}
+
+// record keeps the path of the file written directly for the manifest
+func (a *Artifacts) record(path string) {
+	if a == nil {
+		return
+	}
+	if path = filepath.Clean(path); !slices.Contains(a.order, path) {
+		a.order = append(a.order, path)
+	}
+}
+
+// Create opens the generated file for writing
+func (a *Artifacts) Create(path string) (io.WriteCloser, error) {
+	if !a.Deferred() {
+		a.record(path)
+		return os.Create(path)
+	}
+	slog.Debug("rendering artifact into memory", "path", path)
+	return nopWriteCloser{a.buffer(path)}, nil
+}
+
+// Write writes the whole content of the generated file
+func (a *Artifacts) Write(path string, content []byte) error {
+	if !a.Deferred() {
+		a.record(path)
+		return os.WriteFile(path, content, 0644)
+	}
+	a.buffer(path).Write(content)
+	return nil
+}
+
+// Read returns the generated content of the file, the rendered one if it is not written yet
+func (a *Artifacts) Read(path string) ([]byte, error) {
+	if a != nil {
+		if buf, ok := a.files[filepath.Clean(path)]; ok {
+			return buf.Bytes(), nil
+		}
+	}
+	return os.ReadFile(path)
+}
+
+// File is a generated file kept in memory
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// Files returns the files rendered into memory in the order they were generated
+func (a *Artifacts) Files() []File {
+	if !a.Deferred() {
+		return nil
+	}
+	files := make([]File, 0, len(a.order))
+	for _, path := range a.order {
+		files = append(files, File{Path: path, Content: a.files[path].Bytes()})
+	}
+	return files
+}
+
+// backupPath returns the path of the file in the backup directory, relative to the output directory
+func (a *Artifacts) backupPath(backupDir, path string) string {
+	rel, err := filepath.Rel(a.OutputDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return filepath.Join(backupDir, rel)
+}
+
+// manifest is the content of ManifestFileName, the paths are relative to the output directory
+type manifest struct {
+	Generated map[string][]string `json:"generated"`
+}
+
+func (a *Artifacts) manifestPath() string {
+	return filepath.Join(a.OutputDir, ManifestFileName)
+}
+
+func (a *Artifacts) readManifest() (manifest, error) {
+	theManifest := manifest{Generated: map[string][]string{}}
+	content, err := os.ReadFile(a.manifestPath())
+	if os.IsNotExist(err) {
+		return theManifest, nil
+	}
+	if err != nil {
+		return theManifest, fmt.Errorf("failed to read %s: %w", a.manifestPath(), err)
+	}
+	if err := json.Unmarshal(content, &theManifest); err != nil {
+		return theManifest, fmt.Errorf("failed to parse %s: %w", a.manifestPath(), err)
+	}
+	if theManifest.Generated == nil {
+		theManifest.Generated = map[string][]string{}
+	}
+	return theManifest, nil
+}
+
+func (a *Artifacts) writeManifest(theManifest manifest) error {
+	content, err := json.MarshalIndent(theManifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", a.manifestPath(), err)
+	}
+	if err := os.MkdirAll(a.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", a.manifestPath(), err)
+	}
+	if err := os.WriteFile(a.manifestPath(), append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", a.manifestPath(), err)
+	}
+	return nil
+}
+
+// generated returns the paths of the files of this run relative to the output directory. The
+// files outside of the output directory are not tracked.
+func (a *Artifacts) generated() []string {
+	paths := []string{}
+	for _, path := range a.order {
+		rel, err := filepath.Rel(a.OutputDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") || rel == ManifestFileName {
+			continue
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// stale returns the existing files of the previous run of the scope which are not generated
+// by this run
+func (a *Artifacts) stale(previous, generated []string) []string {
+	paths := []string{}
+	for _, rel := range previous {
+		if slices.Contains(generated, rel) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(a.OutputDir, filepath.FromSlash(rel))); err == nil {
+			paths = append(paths, filepath.Join(a.OutputDir, filepath.FromSlash(rel)))
+		}
+	}
+	return paths
+}
+
+// Finish prints the plan and writes the rendered files. The files written directly are only
+// recorded in the manifest, the stale ones are kept and reported.
+func (a *Artifacts) Finish(out io.Writer, now time.Time) error {
+	if a == nil {
+		return nil
+	}
+	theManifest, err := a.readManifest()
+	if err != nil {
+		return err
+	}
+	generated := a.generated()
+	previous, tracked := theManifest.Generated[a.Scope]
+	stale := a.stale(previous, generated)
+
+	if !a.Deferred() {
+		if len(generated) == 0 && !tracked {
+			return nil
+		}
+		for _, path := range stale {
+			slog.Warn("stale generated file, run with --apply to remove it", "path", path)
+			rel, _ := filepath.Rel(a.OutputDir, path)
+			generated = append(generated, filepath.ToSlash(rel))
+		}
+		slices.Sort(generated)
+		theManifest.Generated[a.Scope] = generated
+		return a.writeManifest(theManifest)
+	}
+
+	backupDir := filepath.Join(a.OutputDir, "backup", now.Format("20060102-150405"))
+	created, changed, unchanged := 0, 0, 0
+	for _, path := range a.order {
+		content := a.files[path].Bytes()
+		previous, err := os.ReadFile(path)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		switch {
+		case !exists:
+			created++
+		case bytes.Equal(previous, content):
+			unchanged++
+			continue
+		default:
+			changed++
+		}
+
+		if a.Plan {
+			oldName := "a/" + path
+			if !exists {
+				oldName = "/dev/null"
+			}
+			fmt.Fprint(out, unifiedDiff(oldName, "b/"+path, string(previous), string(content)))
+		}
+
+		if a.Apply {
+			if exists {
+				if err := a.backup(backupDir, path, previous); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("failed to create directory of %s: %w", path, err)
+			}
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			slog.Info("wrote artifact", "path", path)
+		}
+	}
+
+	for _, path := range stale {
+		previous, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if a.Plan {
+			fmt.Fprint(out, unifiedDiff("a/"+path, "/dev/null", string(previous), ""))
+		}
+		if a.Apply {
+			if err := a.backup(backupDir, path, previous); err != nil {
+				return err
+			}
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			slog.Info("removed stale artifact", "path", path)
+		}
+	}
+
+	if a.Apply {
+		theManifest.Generated[a.Scope] = generated
+		if err := a.writeManifest(theManifest); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "Plan: %d to create, %d to change, %d to delete, %d unchanged.\n", created, changed, len(stale), unchanged)
+	switch {
+	case a.Apply && changed+len(stale) > 0:
+		fmt.Fprintf(out, "Applied. The previous versions are in %s\n", backupDir)
+	case a.Apply:
+		fmt.Fprintf(out, "Applied.\n")
+	default:
+		fmt.Fprintf(out, "Nothing written, run with --apply to write the files.\n")
+	}
+	return nil
+}
+
+// backup copies the previous version of the file into the backup directory
+func (a *Artifacts) backup(backupDir, path string, previous []byte) error {
+	target := a.backupPath(backupDir, path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := os.WriteFile(target, previous, 0644); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	slog.Info("backed up previous version", "path", path, "backup", target)
+	return nil
+}
+
+// diffOp is one line of the diff, kind is ' ', '-' or '+'
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// maxDiffCells limits the memory of the LCS table, bigger changes are shown as replaced
+const maxDiffCells = 25_000_000
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the line diff by the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// The common prefix and suffix are kept out of the LCS table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []diffOp{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range midB {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		lcs := make([][]int32, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				ops = append(ops, diffOp{' ', midA[i]})
+				i++
+				j++
+			case i < len(midA) && (j == len(midB) || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', midA[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', midB[j]})
+				j++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// unifiedDiff returns the diff of the texts in the unified format with 3 lines of context.
+// An empty string is returned if the texts are the same.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	const context = 3
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	// The position of each op in the old and new text
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	changes := []int{}
+	for k, op := range ops {
+		oldLine[k+1], newLine[k+1] = oldLine[k], newLine[k]
+		if op.kind != '+' {
+			oldLine[k+1]++
+		}
+		if op.kind != '-' {
+			newLine[k+1]++
+		}
+		if op.kind != ' ' {
+			changes = append(changes, k)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for c := 0; c < len(changes); {
+		start := max(changes[c]-context, 0)
+		end := changes[c]
+		// Merge the changes whose contexts overlap into one hunk
+		for c < len(changes) && changes[c] <= end+2*context {
+			end = changes[c]
+			c++
+		}
+		end = min(end+context+1, len(ops))
+
+		oldCount, newCount := oldLine[end]-oldLine[start], newLine[end]-newLine[start]
+		oldStart, newStart := oldLine[start]+1, newLine[start]+1
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			if !strings.HasSuffix(op.text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
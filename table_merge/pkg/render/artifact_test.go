@@ -0,0 +1,160 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_unifiedDiff(t *testing.T) {
+	oldText := "routes:\n  r_orders:\n    schema-pattern: \"db_0[0-7]\"\n    table-pattern: \"orders\"\n"
+	newText := "routes:\n  r_orders:\n    schema-pattern: \"db_0[0-9]\"\n    table-pattern: \"orders\"\n  r_users:\n    schema-pattern: \"db_00\"\n"
+	want := `--- a/dm-task.yaml
++++ b/dm-task.yaml
+@@ -1,4 +1,6 @@
+ routes:
+   r_orders:
+-    schema-pattern: "db_0[0-7]"
++    schema-pattern: "db_0[0-9]"
+     table-pattern: "orders"
++  r_users:
++    schema-pattern: "db_00"
+`
+	if got := unifiedDiff("a/dm-task.yaml", "b/dm-task.yaml", oldText, newText); got != want {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a", "b", oldText, oldText); got != "" {
+		t.Errorf("unifiedDiff() of same text = %q, want empty", got)
+	}
+}
+
+func Test_unifiedDiffHunks(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	oldText := strings.Join(lines, "\n") + "\n"
+	lines[1], lines[18] = "first", "last"
+	newText := strings.Join(lines, "\n") + "\n"
+
+	got := unifiedDiff("a", "b", oldText, newText)
+	if strings.Count(got, "@@ -") != 2 {
+		t.Errorf("unifiedDiff() want 2 hunks:\n%s", got)
+	}
+	if !strings.Contains(got, "@@ -1,5 +1,5 @@") || !strings.Contains(got, "@@ -16,5 +16,5 @@") {
+		t.Errorf("unifiedDiff() unexpected hunk headers:\n%s", got)
+	}
+}
+
+func TestArtifactsFinish(t *testing.T) {
+	outputDir := t.TempDir()
+	changedFile := filepath.Join(outputDir, "dumpling.sh")
+	if err := os.WriteFile(changedFile, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		apply       bool
+		wantContent string
+		wantBackup  bool
+	}{
+		{"Plan only", false, "old\n", false},
+		{"Plan and apply", true, "new\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifacts := NewArtifacts(outputDir, true, tt.apply)
+			w, err := artifacts.Create(changedFile)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			w.Write([]byte("new\n"))
+			w.Close()
+			if err := artifacts.Write(filepath.Join(outputDir, "ddl", "0000_db-schema-create.sql"), []byte("CREATE DATABASE db;\n")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			var out bytes.Buffer
+			now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+			if err := artifacts.Finish(&out, now); err != nil {
+				t.Fatalf("Finish() error = %v", err)
+			}
+			if !strings.Contains(out.String(), "-old\n+new\n") || !strings.Contains(out.String(), "Plan: 1 to create, 1 to change, 0 to delete, 0 unchanged.") {
+				t.Errorf("Finish() output =\n%s", out.String())
+			}
+
+			content, _ := os.ReadFile(changedFile)
+			if string(content) != tt.wantContent {
+				t.Errorf("dumpling.sh = %q, want %q", content, tt.wantContent)
+			}
+			backup, err := os.ReadFile(filepath.Join(outputDir, "backup", "20240501-100000", "dumpling.sh"))
+			if tt.wantBackup && (err != nil || string(backup) != "old\n") {
+				t.Errorf("backup = %q, %v, want old version", backup, err)
+			}
+			if !tt.wantBackup && err == nil {
+				t.Errorf("backup written in plan mode")
+			}
+		})
+	}
+}
+
+func TestArtifactsFinishStale(t *testing.T) {
+	outputDir := t.TempDir()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	generate := func(plan, apply bool, names ...string) string {
+		t.Helper()
+		artifacts := NewArtifacts(outputDir, plan, apply)
+		artifacts.Scope = "generateDMConfig"
+		for _, name := range names {
+			if err := artifacts.Write(filepath.Join(outputDir, name), []byte(name+"\n")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+		var out bytes.Buffer
+		if err := artifacts.Finish(&out, now); err != nil {
+			t.Fatalf("Finish() error = %v", err)
+		}
+		return out.String()
+	}
+
+	// The direct run records the files, another scope does not see them
+	generate(false, false, "dm-source-mysql01.yaml", "dm-source-mysql02.yaml", "dm-task-01.yaml", "dm-task-02.yaml")
+	other := NewArtifacts(outputDir, true, false)
+	other.Scope = "generateDumpling"
+	other.Write(filepath.Join(outputDir, "dumpling.sh"), []byte("dumpling\n"))
+	var out bytes.Buffer
+	if err := other.Finish(&out, now); err != nil || !strings.Contains(out.String(), "0 to delete") {
+		t.Fatalf("Finish() of another scope = %v:\n%s", err, out.String())
+	}
+
+	// mysql02 is dropped and the task is not split any more
+	stalePath := filepath.Join(outputDir, "dm-source-mysql02.yaml")
+	got := generate(true, false, "dm-source-mysql01.yaml", "dm-task.yaml")
+	if !strings.Contains(got, "--- a/"+stalePath+"\n+++ /dev/null\n") || !strings.Contains(got, "Plan: 1 to create, 0 to change, 3 to delete, 1 unchanged.") {
+		t.Errorf("Finish() plan =\n%s", got)
+	}
+	if _, err := os.Stat(stalePath); err != nil {
+		t.Errorf("stale file removed in plan mode: %v", err)
+	}
+
+	generate(true, true, "dm-source-mysql01.yaml", "dm-task.yaml")
+	for _, name := range []string{"dm-source-mysql02.yaml", "dm-task-01.yaml", "dm-task-02.yaml"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); !os.IsNotExist(err) {
+			t.Errorf("stale %s not removed: %v", name, err)
+		}
+		backup, err := os.ReadFile(filepath.Join(outputDir, "backup", "20240501-100000", name))
+		if err != nil || string(backup) != name+"\n" {
+			t.Errorf("backup of %s = %q, %v", name, backup, err)
+		}
+	}
+
+	// The manifest follows the applied files
+	if got := generate(true, false, "dm-source-mysql01.yaml", "dm-task.yaml"); !strings.Contains(got, "0 to delete, 2 unchanged.") {
+		t.Errorf("Finish() plan after apply =\n%s", got)
+	}
+}
@@ -0,0 +1,383 @@
+package render
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// originColumnDefs is the column definition of the supported origin columns
+var originColumnDefs = map[string]string{
+	"c_instance": "`c_instance` varchar(64) NOT NULL DEFAULT ''",
+	"c_schema":   "`c_schema` varchar(64) NOT NULL DEFAULT ''",
+	"c_table":    "`c_table` varchar(64) NOT NULL DEFAULT ''",
+}
+
+// mergedTableName derives the destination table name from the source tables of one group.
+// The common table name is used if all the shards share it. Otherwise the common prefix
+// without the trailing shard suffix(digits and underscores) is used.
+func mergedTableName(srcTables []string) string {
+	names := []string{}
+	for _, src := range srcTables {
+		parts := strings.Split(src, ".")
+		names = append(names, parts[len(parts)-1])
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	allSame := true
+	for _, name := range names[1:] {
+		if name != names[0] {
+			allSame = false
+			break
+		}
+	}
+	if allSame {
+		return names[0]
+	}
+
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	prefix = strings.TrimRight(prefix, "_0123456789")
+	if prefix == "" {
+		slog.Warn("no common prefix among source tables, using the first table name", "srcTables", srcTables)
+		return names[0]
+	}
+	return prefix
+}
+
+// rewriteCreateTable converts the SHOW CREATE TABLE output of a source table to the DDL of the
+// destination table. The origin columns are appended and added to the primary key.
+func rewriteCreateTable(createTable, destSchema, destTable string, originColumns []string) (string, error) {
+	headerRe := regexp.MustCompile("(?s)^\\s*CREATE TABLE\\s+(?:IF NOT EXISTS\\s+)?(?:`[^`]+`\\.)?`[^`]+`\\s*\\(\\s*\\n")
+	header := headerRe.FindString(createTable)
+	if header == "" {
+		return "", fmt.Errorf("unexpected create table statement: %s", createTable)
+	}
+	closeIdx := strings.LastIndex(createTable, "\n)")
+	if closeIdx < len(header) {
+		return "", fmt.Errorf("no closing parenthesis in create table statement: %s", createTable)
+	}
+	body := createTable[len(header):closeIdx]
+	tableOptions := createTable[closeIdx+1:]
+
+	// The next AUTO_INCREMENT value of the source does not apply to the merged table
+	tableOptions = regexp.MustCompile(`\s+AUTO_INCREMENT=\d+`).ReplaceAllString(tableOptions, "")
+
+	definitions := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(strings.TrimSpace(line), ",")
+		if line != "" {
+			definitions = append(definitions, line)
+		}
+	}
+
+	// Append the origin columns after the last column definition
+	lastColumn := -1
+	existing := map[string]bool{}
+	for i, def := range definitions {
+		if strings.HasPrefix(def, "`") {
+			lastColumn = i
+			existing[strings.Split(def, "`")[1]] = true
+		}
+	}
+	newColumns := []string{}
+	pkColumns := []string{}
+	for _, col := range originColumns {
+		colDef, ok := originColumnDefs[col]
+		if !ok {
+			return "", fmt.Errorf("unsupported origin column %s", col)
+		}
+		pkColumns = append(pkColumns, "`"+col+"`")
+		if existing[col] {
+			continue
+		}
+		newColumns = append(newColumns, colDef)
+	}
+	definitions = append(definitions[:lastColumn+1], append(newColumns, definitions[lastColumn+1:]...)...)
+
+	// Widen the primary key so that the same key from different shards does not conflict
+	if len(pkColumns) > 0 {
+		for i, def := range definitions {
+			if strings.HasPrefix(def, "PRIMARY KEY") {
+				open := strings.Index(def, "(")
+				end := matchingParenthesis(def, open)
+				if open < 0 || end < 0 {
+					return "", fmt.Errorf("unexpected primary key definition: %s", def)
+				}
+				keyColumns := def[open+1 : end]
+				for _, col := range pkColumns {
+					if !strings.Contains(keyColumns, col) {
+						keyColumns += "," + col
+					}
+				}
+				definitions[i] = def[:open+1] + keyColumns + def[end:]
+			} else if strings.HasPrefix(def, "UNIQUE KEY") {
+				slog.Warn("unique key is not widened with origin columns and may conflict between shards", "destTable", destTable, "key", def)
+			}
+		}
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`.`%s` (\n  %s\n%s;\n",
+		destSchema, destTable, strings.Join(definitions, ",\n  "), strings.TrimSpace(tableOptions)), nil
+}
+
+// matchingParenthesis returns the index of the parenthesis closing the one at open
+func matchingParenthesis(s string, open int) int {
+	if open < 0 {
+		return -1
+	}
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// fetchCreateTable returns the SHOW CREATE TABLE output of the table
+func fetchCreateTable(db *sql.DB, schema, table string) (string, error) {
+	var name, createTable string
+	query := fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", schema, table)
+	if err := db.QueryRow(query).Scan(&name, &createTable); err != nil {
+		return "", fmt.Errorf("show create table %s.%s: %w", schema, table, err)
+	}
+	return createTable, nil
+}
+
+// SkippedDDL is a group without destination table whose merged table name is already used
+type SkippedDDL struct {
+	DestTable string
+	SrcTables []string
+	// UsedBy is the destination table of the other group with the name
+	UsedBy string
+}
+
+// GenerateDestDDL writes the CREATE TABLE DDL of the destination tables for all the groups
+// without destination table. The files are numbered in the order they should be applied.
+// The groups whose merged table name is already used are returned as skipped.
+func GenerateDestDDL(cfg *config.Config, tableMapping *[]mapping.TableInfo, artifacts *Artifacts) ([]string, []SkippedDDL, error) {
+	if cfg == nil {
+		slog.Error("GenerateDestDDL received nil config")
+		return nil, nil, fmt.Errorf("config is nil")
+	}
+	if tableMapping == nil {
+		slog.Error("GenerateDestDDL received nil tableMapping", "configOutput", cfg.Output)
+		return nil, nil, fmt.Errorf("tableMapping is nil")
+	}
+
+	destSchema := cfg.DDL.TargetSchema
+	if destSchema == "" {
+		if len(cfg.DestDB.DBs) == 0 {
+			slog.Error("no destination schema for DDL generation")
+			return nil, nil, fmt.Errorf("neither DDL.TargetSchema nor DestDB.DBs is specified")
+		}
+		destSchema = cfg.DestDB.DBs[0]
+	}
+	slog.Info("starting GenerateDestDDL", "destSchema", destSchema, "originColumns", cfg.DDL.OriginColumns, "tableMappingCount", len(*tableMapping))
+
+	// Destination tables which already exist must not be created again
+	usedNames := map[string]string{}
+	for _, tableInfo := range *tableMapping {
+		for _, dest := range tableInfo.DestTableInfo {
+			parts := strings.Split(dest, ".")
+			if len(parts) == 3 {
+				usedNames[parts[1]+"."+parts[2]] = dest
+			}
+		}
+	}
+
+	type ddlEntry struct {
+		destTable string
+		srcTable  string
+		ddl       string
+	}
+	entries := []ddlEntry{}
+	skipped := []SkippedDDL{}
+	mapDB := make(map[string]*sql.DB)
+	defer func() {
+		for _, db := range mapDB {
+			db.Close()
+		}
+	}()
+
+	for tiIdx, tableInfo := range *tableMapping {
+		if len(tableInfo.DestTableInfo) > 0 || len(tableInfo.SrcTableInfo) == 0 {
+			continue
+		}
+
+		destTable := mergedTableName(tableInfo.SrcTableInfo)
+		if src, ok := usedNames[destSchema+"."+destTable]; ok {
+			slog.Warn("destination table name already used by another group, skipping", "tableMappingIdx", tiIdx, "destTable", destSchema+"."+destTable, "usedBy", src, "srcTables", tableInfo.SrcTableInfo)
+			skipped = append(skipped, SkippedDDL{DestTable: destSchema + "." + destTable, SrcTables: tableInfo.SrcTableInfo, UsedBy: src})
+			continue
+		}
+
+		// Use the first shard as the representative table of the group
+		srcTable := tableInfo.SrcTableInfo[0]
+		srcParts := strings.Split(srcTable, ".")
+		if len(srcParts) != 3 {
+			slog.Warn("unexpected source table format", "tableMappingIdx", tiIdx, "srcTable", srcTable)
+			continue
+		}
+		db, ok := mapDB[srcParts[0]]
+		if !ok {
+			var dbConfig *config.DBConnInfo
+			for i := range cfg.SourceDB {
+				if cfg.SourceDB[i].Name == srcParts[0] {
+					dbConfig = &cfg.SourceDB[i]
+					break
+				}
+			}
+			if dbConfig == nil {
+				slog.Error("no DB config found for instance", "instance", srcParts[0])
+				return nil, nil, fmt.Errorf("no source db config for instance %s", srcParts[0])
+			}
+			var err error
+			db, err = schema.OpenDB(*dbConfig, dbConfig.DBs[0])
+			if err != nil {
+				slog.Error("failed to connect to database", "error", err, "instance", srcParts[0])
+				return nil, nil, err
+			}
+			mapDB[srcParts[0]] = db
+		}
+
+		createTable, err := fetchCreateTable(db, srcParts[1], srcParts[2])
+		if err != nil {
+			slog.Error("failed to fetch create table", "error", err, "srcTable", srcTable)
+			return nil, nil, err
+		}
+
+		var originColumns []string
+		if len(tableInfo.SrcTableInfo) > 1 {
+			originColumns = cfg.DDL.OriginColumns
+		}
+		ddl, err := rewriteCreateTable(createTable, destSchema, destTable, originColumns)
+		if err != nil {
+			slog.Error("failed to rewrite create table", "error", err, "srcTable", srcTable)
+			return nil, nil, err
+		}
+		usedNames[destSchema+"."+destTable] = srcTable
+		entries = append(entries, ddlEntry{destTable: destTable, srcTable: srcTable, ddl: ddl})
+		slog.Debug("generated destination DDL", "srcTable", srcTable, "srcTableCount", len(tableInfo.SrcTableInfo), "destTable", destSchema+"."+destTable)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].destTable < entries[j].destTable })
+
+	outputPath := cfg.Output
+	if !strings.HasSuffix(outputPath, "/") {
+		outputPath += "/"
+	}
+	ddlDir := outputPath + "ddl/"
+	if !artifacts.Deferred() {
+		if err := os.MkdirAll(ddlDir, 0755); err != nil {
+			slog.Error("failed to create ddl directory", "error", err, "ddlDir", ddlDir)
+			return nil, nil, fmt.Errorf("failed to create ddl directory: %w", err)
+		}
+	}
+
+	files := []string{}
+	schemaFile := fmt.Sprintf("%s%04d_%s-schema-create.sql", ddlDir, 0, destSchema)
+	if err := artifacts.Write(schemaFile, []byte(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`;\n", destSchema))); err != nil {
+		slog.Error("failed to write ddl file", "error", err, "file", schemaFile)
+		return nil, nil, fmt.Errorf("failed to write ddl file %s: %w", schemaFile, err)
+	}
+	files = append(files, schemaFile)
+
+	for idx, entry := range entries {
+		fileName := fmt.Sprintf("%s%04d_%s.%s.sql", ddlDir, idx+1, destSchema, entry.destTable)
+		content := fmt.Sprintf("-- Generated from %s\n%s", entry.srcTable, entry.ddl)
+		if err := artifacts.Write(fileName, []byte(content)); err != nil {
+			slog.Error("failed to write ddl file", "error", err, "file", fileName)
+			return nil, nil, fmt.Errorf("failed to write ddl file %s: %w", fileName, err)
+		}
+		files = append(files, fileName)
+		slog.Info("wrote destination DDL", "file", fileName, "srcTable", entry.srcTable)
+	}
+
+	slog.Info("completed GenerateDestDDL", "ddlDir", ddlDir, "tableCount", len(entries))
+	return files, skipped, nil
+}
+
+// ApplyDestDDL executes the generated DDL files against the destination database in order
+func ApplyDestDDL(cfg *config.Config, files []string, artifacts *Artifacts) error {
+	// The target schema may not exist yet, connect without default schema
+	db, err := schema.OpenDB(cfg.DestDB, "")
+	if err != nil {
+		slog.Error("failed to connect to database", "error", err, "destDB", cfg.DestDB.Name)
+		return err
+	}
+	defer db.Close()
+
+	for _, file := range files {
+		content, err := artifacts.Read(file)
+		if err != nil {
+			slog.Error("failed to read ddl file", "error", err, "file", file)
+			return fmt.Errorf("failed to read ddl file %s: %w", file, err)
+		}
+		// Drop the comment lines, each file holds one statement
+		lines := []string{}
+		for _, line := range strings.Split(string(content), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				lines = append(lines, line)
+			}
+		}
+		stmt := strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, "\n")), ";")
+		if _, err := db.Exec(stmt); err != nil {
+			slog.Error("failed to apply ddl", "error", err, "file", file)
+			return fmt.Errorf("failed to apply ddl %s: %w", file, err)
+		}
+		slog.Info("applied destination DDL", "file", file, "destDB", cfg.DestDB.Name)
+	}
+	return nil
+}
+
+// VerifyDestCoverage rebuilds the table mapping and returns the source groups which still
+// do not have any destination table.
+func VerifyDestCoverage(cfg config.Config, opts mapping.Options) ([]mapping.TableInfo, error) {
+	// The generated tables are only found if the target schema is fetched
+	if cfg.DDL.TargetSchema != "" {
+		found := false
+		for _, db := range cfg.DestDB.DBs {
+			if db == cfg.DDL.TargetSchema {
+				found = true
+				break
+			}
+		}
+		if !found {
+			cfg.DestDB.DBs = append(append([]string{}, cfg.DestDB.DBs...), cfg.DDL.TargetSchema)
+		}
+	}
+
+	tableStructure, err := mapping.Build(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	missing := []mapping.TableInfo{}
+	for _, tableInfo := range tableStructure {
+		if len(tableInfo.SrcTableInfo) > 0 && len(tableInfo.DestTableInfo) == 0 {
+			missing = append(missing, tableInfo)
+		}
+	}
+	slog.Info("verified destination coverage", "groupCount", len(tableStructure), "missingCount", len(missing))
+	return missing, nil
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+func Test_mergedTableName(t *testing.T) {
+	tests := []struct {
+		name      string
+		srcTables []string
+		want      string
+	}{
+		{"Single table", []string{"instance01.db_00.users"}, "users"},
+		{"Same table name in all shards", []string{"instance01.db_00.orders", "instance02.db_08.orders"}, "orders"},
+		{"Numbered shards", []string{"instance01.db_00.sharding_table_00", "instance01.db_00.sharding_table_01", "instance02.db_08.sharding_table_15"}, "sharding_table"},
+		{"No common prefix", []string{"instance01.db_00.abc", "instance01.db_00.xyz"}, "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergedTableName(tt.srcTables); got != tt.want {
+				t.Errorf("mergedTableName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rewriteCreateTable(t *testing.T) {
+	createTable := "CREATE TABLE `orders_00` (\n" +
+		"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(64) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`) /*T![clustered_index] CLUSTERED */,\n" +
+		"  KEY `idx_name` (`name`(10))\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=101 DEFAULT CHARSET=utf8mb4"
+
+	tests := []struct {
+		name          string
+		originColumns []string
+		want          string
+		wantErr       bool
+	}{
+		{
+			name: "One-to-one: no origin columns",
+			want: "CREATE TABLE IF NOT EXISTS `messagedb`.`orders` (\n" +
+				"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+				"  `name` varchar(64) DEFAULT NULL,\n" +
+				"  PRIMARY KEY (`id`) /*T![clustered_index] CLUSTERED */,\n" +
+				"  KEY `idx_name` (`name`(10))\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n",
+		},
+		{
+			name:          "Pattern 03: origin columns widen the primary key",
+			originColumns: []string{"c_instance", "c_schema"},
+			want: "CREATE TABLE IF NOT EXISTS `messagedb`.`orders` (\n" +
+				"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+				"  `name` varchar(64) DEFAULT NULL,\n" +
+				"  `c_instance` varchar(64) NOT NULL DEFAULT '',\n" +
+				"  `c_schema` varchar(64) NOT NULL DEFAULT '',\n" +
+				"  PRIMARY KEY (`id`,`c_instance`,`c_schema`) /*T![clustered_index] CLUSTERED */,\n" +
+				"  KEY `idx_name` (`name`(10))\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n",
+		},
+		{
+			name:          "Unsupported origin column",
+			originColumns: []string{"c_unknown"},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteCreateTable(createTable, "messagedb", "orders", tt.originColumns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rewriteCreateTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rewriteCreateTable() = \n%v, want \n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateDestDDLSkipped(t *testing.T) {
+	cfg := &config.Config{
+		Output: t.TempDir(),
+		DestDB: config.DBConnInfo{Name: "target", DBs: []string{"messagedb"}},
+	}
+	tableMapping := &[]mapping.TableInfo{
+		{SrcTableInfo: []string{"mysql01.db_00.orders"}, DestTableInfo: []string{"target.messagedb.orders"}},
+		// The structure differs from the existing orders table, its merged name is taken
+		{SrcTableInfo: []string{"mysql02.db_01.orders_00", "mysql02.db_01.orders_01"}},
+	}
+	files, skipped, err := GenerateDestDDL(cfg, tableMapping, NewArtifacts(cfg.Output, true, false))
+	if err != nil {
+		t.Fatalf("GenerateDestDDL() error = %v", err)
+	}
+	want := []SkippedDDL{{DestTable: "messagedb.orders", SrcTables: []string{"mysql02.db_01.orders_00", "mysql02.db_01.orders_01"}, UsedBy: "target.messagedb.orders"}}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("GenerateDestDDL() skipped = %+v, want %+v", skipped, want)
+	}
+	if len(files) != 1 {
+		t.Errorf("GenerateDestDDL() files = %v, want the schema file only", files)
+	}
+}
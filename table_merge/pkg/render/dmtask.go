@@ -0,0 +1,255 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+// DMTaskManifestFile lists the split DM tasks in the output directory
+const DMTaskManifestFile = "dm-task-manifest.json"
+
+// DMTaskManifestEntry is one split DM task with the destination tables it owns
+type DMTaskManifestEntry struct {
+	Name       string   `json:"name"`
+	File       string   `json:"file"`
+	MetaSchema string   `json:"meta_schema"`
+	Sources    []string `json:"sources"`
+	Routes     int      `json:"routes"`
+	// Tables are the destination schema.table replicated by the task
+	Tables []string `json:"tables"`
+}
+
+// DMTaskManifest is the content of dm-task-manifest.json
+type DMTaskManifest struct {
+	Tasks []DMTaskManifestEntry `json:"tasks"`
+}
+
+// dmTaskPlan is one DM task to render
+type dmTaskPlan struct {
+	Name       string
+	MetaSchema string
+	FileName   string
+	// Sources are the instances of the task in the config order, all the instances if nil
+	Sources []string
+	Groups  []mapping.TableInfo
+	Tables  []string
+	Routes  int
+}
+
+// dmTaskUnit is the groups sharing a route. They are kept in one task, DM coordinates the
+// DDL of a sharding group within a task only.
+type dmTaskUnit struct {
+	key     string
+	groups  []mapping.TableInfo
+	sources map[string]bool
+	tables  []string
+	routes  int
+}
+
+var dmTaskNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// dmTaskUnits merges the groups of the mapping by their route, r_<dest table> like the routes
+// of the task, or by the first source table of the groups without a destination
+func dmTaskUnits(tableMapping []mapping.TableInfo) []*dmTaskUnit {
+	units := []*dmTaskUnit{}
+	index := map[string]*dmTaskUnit{}
+	for _, tableInfo := range tableMapping {
+		if len(tableInfo.SrcTableInfo) == 0 {
+			continue
+		}
+		key, routes := "src:"+tableInfo.SrcTableInfo[0], 0
+		if len(tableInfo.DestTableInfo) > 0 {
+			if destParts := strings.Split(tableInfo.DestTableInfo[0], "."); len(destParts) > 2 {
+				key, routes = "r_"+destParts[2], 1
+			}
+		}
+		unit, ok := index[key]
+		if !ok {
+			unit = &dmTaskUnit{key: key, sources: map[string]bool{}, routes: routes}
+			index[key] = unit
+			units = append(units, unit)
+		}
+		unit.groups = append(unit.groups, tableInfo)
+		for _, src := range tableInfo.SrcTableInfo {
+			unit.sources[strings.Split(src, ".")[0]] = true
+		}
+		for _, dest := range tableInfo.DestTableInfo {
+			if parts := strings.SplitN(dest, ".", 2); len(parts) == 2 && !containsString(unit.tables, parts[1]) {
+				unit.tables = append(unit.tables, parts[1])
+			}
+		}
+	}
+	return units
+}
+
+// add puts the unit into the task
+func (p *dmTaskPlan) add(unit *dmTaskUnit) {
+	p.Groups = append(p.Groups, unit.groups...)
+	p.Tables = append(p.Tables, unit.tables...)
+	p.Routes += unit.routes
+	for source := range unit.sources {
+		if !containsString(p.Sources, source) {
+			p.Sources = append(p.Sources, source)
+		}
+	}
+}
+
+// sourcesWith returns the number of instances of the task with the unit added
+func (p *dmTaskPlan) sourcesWith(unit *dmTaskUnit) int {
+	count := len(p.Sources)
+	for source := range unit.sources {
+		if !containsString(p.Sources, source) {
+			count++
+		}
+	}
+	return count
+}
+
+// exceeds reports whether the task is over the limits of the config
+func (p *dmTaskPlan) exceeds(limits config.DMTaskConfig) bool {
+	return (limits.MaxSources > 0 && len(p.Sources) > limits.MaxSources) || (limits.MaxRoutes > 0 && p.Routes > limits.MaxRoutes)
+}
+
+// fits reports whether the unit can be added to the task within the limits
+func (p *dmTaskPlan) fits(unit *dmTaskUnit, limits config.DMTaskConfig) bool {
+	if limits.MaxSources > 0 && p.sourcesWith(unit) > limits.MaxSources {
+		return false
+	}
+	return limits.MaxRoutes <= 0 || p.Routes+unit.routes <= limits.MaxRoutes
+}
+
+// matchGroup returns the index of the first explicit group matching a destination table of the
+// unit, -1 if none
+func matchGroup(unit *dmTaskUnit, groups []*config.CompiledFilter) int {
+	for i, group := range groups {
+		for _, table := range unit.tables {
+			parts := strings.SplitN(table, ".", 2)
+			if group.Allowed(parts[0], parts[1]) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// planDMTasks splits the mapping into the explicit groups of the config, then packs the other
+// groups first-fit into dm-task-NN within MaxSources and MaxRoutes. A group over the limits
+// alone gets a task of its own.
+func planDMTasks(cfg *config.Config, tableMapping []mapping.TableInfo) ([]dmTaskPlan, error) {
+	limits := cfg.DMTask
+	explicit := make([]*dmTaskPlan, len(limits.Groups))
+	matchers := make([]*config.CompiledFilter, len(limits.Groups))
+	for i, group := range limits.Groups {
+		if !dmTaskNamePattern.MatchString(group.Name) {
+			return nil, fmt.Errorf("invalid DM task group name %q, only letters, digits, - and _ are allowed", group.Name)
+		}
+		if len(group.Tables) == 0 {
+			return nil, fmt.Errorf("DM task group %s has no tables", group.Name)
+		}
+		matcher, err := config.TableFilter{Include: group.Tables}.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("DM task group %s: %w", group.Name, err)
+		}
+		matchers[i] = matcher
+		explicit[i] = &dmTaskPlan{
+			Name:       group.Name,
+			MetaSchema: "dm_meta_" + strings.ReplaceAll(group.Name, "-", "_"),
+			FileName:   fmt.Sprintf("dm-task-%s.yaml", group.Name),
+			Sources:    []string{},
+		}
+	}
+
+	auto := []*dmTaskPlan{}
+	for _, unit := range dmTaskUnits(tableMapping) {
+		if idx := matchGroup(unit, matchers); idx >= 0 {
+			explicit[idx].add(unit)
+			continue
+		}
+		var task *dmTaskPlan
+		for _, candidate := range auto {
+			if candidate.fits(unit, limits) {
+				task = candidate
+				break
+			}
+		}
+		if task == nil {
+			task = &dmTaskPlan{Sources: []string{}}
+			auto = append(auto, task)
+		}
+		task.add(unit)
+	}
+	for i, task := range auto {
+		task.Name = fmt.Sprintf("dm-task-%02d", i+1)
+		task.MetaSchema = fmt.Sprintf("dm_meta_%02d", i+1)
+		task.FileName = task.Name + ".yaml"
+	}
+
+	// The instances are listed in the config order like the single task
+	order := map[string]int{}
+	for i, dbInfo := range cfg.SourceDB {
+		order[dbInfo.Name] = i
+	}
+	plans := []dmTaskPlan{}
+	files := map[string]bool{}
+	for _, task := range append(explicit, auto...) {
+		if len(task.Groups) == 0 {
+			slog.Warn("DM task group matches no destination table, not rendered", "task", task.Name)
+			continue
+		}
+		if files[task.FileName] {
+			return nil, fmt.Errorf("DM task %s is rendered twice, rename the group", task.FileName)
+		}
+		files[task.FileName] = true
+		if task.exceeds(limits) {
+			slog.Warn("DM task over the limits, a sharding group is not split", "task", task.Name,
+				"sources", len(task.Sources), "maxSources", limits.MaxSources, "routes", task.Routes, "maxRoutes", limits.MaxRoutes)
+		}
+		sort.SliceStable(task.Sources, func(i, j int) bool { return order[task.Sources[i]] < order[task.Sources[j]] })
+		sort.Strings(task.Tables)
+		slog.Info("planned DM task", "task", task.Name, "sources", task.Sources, "routes", task.Routes, "tableCount", len(task.Tables))
+		plans = append(plans, *task)
+	}
+	return plans, nil
+}
+
+// writeDMTaskManifest writes dm-task-manifest.json listing the tasks and their tables
+func writeDMTaskManifest(cfg *config.Config, plans []dmTaskPlan, artifacts *Artifacts) error {
+	manifest := DMTaskManifest{Tasks: []DMTaskManifestEntry{}}
+	for _, plan := range plans {
+		manifest.Tasks = append(manifest.Tasks, DMTaskManifestEntry{
+			Name:       plan.Name,
+			File:       plan.FileName,
+			MetaSchema: plan.MetaSchema,
+			Sources:    plan.Sources,
+			Routes:     plan.Routes,
+			Tables:     plan.Tables,
+		})
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode DM task manifest: %w", err)
+	}
+
+	outputPath := cfg.Output
+	if !strings.HasSuffix(outputPath, "/") {
+		outputPath += "/"
+	}
+	outFile, err := artifacts.Create(outputPath + DMTaskManifestFile)
+	if err != nil {
+		slog.Error("failed to create output file", "file", outputPath+DMTaskManifestFile, "error", err)
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outFile.Close()
+	if _, err := outFile.Write(append(content, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputPath+DMTaskManifestFile, err)
+	}
+	slog.Info("successfully rendered DM task manifest", "file", outputPath+DMTaskManifestFile, "taskCount", len(plans))
+	return nil
+}
@@ -0,0 +1,121 @@
+package render
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+func dmTaskGroup(dest string, srcTables ...string) mapping.TableInfo {
+	return mapping.TableInfo{SrcTableInfo: srcTables, DestTableInfo: []string{"target.messagedb." + dest}}
+}
+
+func Test_planDMTasks(t *testing.T) {
+	cfg := &config.Config{
+		SourceDB: []config.DBConnInfo{{Name: "mysql01"}, {Name: "mysql02"}, {Name: "mysql03"}},
+		DMTask: config.DMTaskConfig{
+			MaxSources: 2,
+			MaxRoutes:  2,
+			Groups:     []config.DMTaskGroup{{Name: "billing", Tables: []config.TableRule{{Schema: "messagedb", Table: "invoice*"}}}},
+		},
+	}
+	tableMapping := []mapping.TableInfo{
+		dmTaskGroup("orders", "mysql01.db_00.orders", "mysql02.db_01.orders"),
+		dmTaskGroup("users", "mysql02.db_01.users"),
+		dmTaskGroup("audit", "mysql03.db_02.audit"),
+		dmTaskGroup("invoices", "mysql01.db_00.invoices", "mysql03.db_02.invoices"),
+		dmTaskGroup("events", "mysql01.db_00.events", "mysql02.db_01.events", "mysql03.db_02.events"),
+		dmTaskGroup("items", "mysql01.db_00.items"),
+	}
+	plans, err := planDMTasks(cfg, tableMapping)
+	if err != nil {
+		t.Fatalf("planDMTasks() error = %v", err)
+	}
+
+	type task struct {
+		name, file, metaSchema string
+		sources, tables        []string
+	}
+	want := []task{
+		{"billing", "dm-task-billing.yaml", "dm_meta_billing", []string{"mysql01", "mysql03"}, []string{"messagedb.invoices"}},
+		{"dm-task-01", "dm-task-01.yaml", "dm_meta_01", []string{"mysql01", "mysql02"}, []string{"messagedb.orders", "messagedb.users"}},
+		{"dm-task-02", "dm-task-02.yaml", "dm_meta_02", []string{"mysql01", "mysql03"}, []string{"messagedb.audit", "messagedb.items"}},
+		// The sharding group over MaxSources is not split
+		{"dm-task-03", "dm-task-03.yaml", "dm_meta_03", []string{"mysql01", "mysql02", "mysql03"}, []string{"messagedb.events"}},
+	}
+	got := []task{}
+	for _, plan := range plans {
+		got = append(got, task{plan.Name, plan.FileName, plan.MetaSchema, plan.Sources, plan.Tables})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planDMTasks() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_planDMTasksInvalidGroup(t *testing.T) {
+	for _, group := range []config.DMTaskGroup{
+		{Name: "bad name", Tables: []config.TableRule{{Schema: "messagedb"}}},
+		{Name: "empty"},
+	} {
+		cfg := &config.Config{DMTask: config.DMTaskConfig{Groups: []config.DMTaskGroup{group}}}
+		if _, err := planDMTasks(cfg, nil); err == nil {
+			t.Errorf("planDMTasks() with group %+v succeeded", group)
+		}
+	}
+}
+
+func Test_RenderDMTaskConfigSplit(t *testing.T) {
+	cfg := &config.Config{
+		Output:   t.TempDir(),
+		SourceDB: []config.DBConnInfo{{Name: "mysql01"}, {Name: "mysql02"}},
+		DestDB:   config.DBConnInfo{Name: "target", Host: "127.0.0.1", Port: 4000},
+		DMTask:   config.DMTaskConfig{MaxSources: 1},
+	}
+	tableMapping := &[]mapping.TableInfo{
+		dmTaskGroup("orders", "mysql01.db_00.orders"),
+		dmTaskGroup("users", "mysql02.db_01.users"),
+	}
+	if err := RenderDMTaskConfig(cfg, tableMapping, nil); err != nil {
+		t.Fatalf("RenderDMTaskConfig() error = %v", err)
+	}
+
+	files := map[string][]string{
+		"dm-task-01.yaml": {"name: dm-task-01", `meta-schema: "dm_meta_01"`, `source-id: "mysql-sourcedb-10000"`, `route-rules: ["r_orders"]`},
+		"dm-task-02.yaml": {"name: dm-task-02", `meta-schema: "dm_meta_02"`, `source-id: "mysql-sourcedb-10001"`, `route-rules: ["r_users"]`},
+	}
+	for name, wants := range files {
+		content, err := os.ReadFile(filepath.Join(cfg.Output, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("%s does not contain %s:\n%s", name, want, content)
+			}
+		}
+		if strings.Count(string(content), "source-id:") != 1 {
+			t.Errorf("%s has the instances of the other task:\n%s", name, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Output, "dm-task.yaml")); err == nil {
+		t.Errorf("dm-task.yaml rendered with the split tasks")
+	}
+
+	content, err := os.ReadFile(filepath.Join(cfg.Output, DMTaskManifestFile))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	var manifest DMTaskManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	if len(manifest.Tasks) != 2 || !reflect.DeepEqual(manifest.Tasks[1].Tables, []string{"messagedb.users"}) || manifest.Tasks[1].File != "dm-task-02.yaml" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+}
@@ -0,0 +1,559 @@
+package render
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// The named dumpling command presets. The leading # lines of a preset are its description.
+//
+//go:embed templates/dumpling/*.tpl
+var dumplingPresetFS embed.FS
+
+// DumplingTask is one dumpling export derived from the table mapping. The exported
+// fields are the variables available to the dumpling command template.
+type DumplingTask struct {
+	SrcTable       string `desc:"Source table as schema.table" example:"db_08.orders"`
+	DestTable      string `desc:"--output-filename-template value: destination schema.table, shard prefix and {{.Index}}" example:"messagedb.orders.00002{{.Index}}"`
+	SrcSchemaName  string `desc:"Source schema" example:"db_08"`
+	SrcTableName   string `desc:"Source table" example:"orders"`
+	DestSchemaName string `desc:"Destination schema" example:"messagedb"`
+	DestTableName  string `desc:"Destination table" example:"orders"`
+	InstanceName   string `desc:"Source instance name of the config" example:"instance02"`
+	SourceData     string `desc:"Data selection, --tables-list or -S with the origin columns" example:"-S \"SELECT *, 'db_08' as c_schema FROM db_08.orders\""`
+
+	// Case is the mapping case(one-to-one, many-to-many, many-to-one) the task was built from.
+	Case string `desc:"Mapping case: one-to-one, many-to-many or many-to-one" example:"many-to-one"`
+	// FilePrefix is the sequence prefix put in front of dumpling's {{.Index}} for consolidated
+	// tables, so that the files of every shard can be told apart on import.
+	FilePrefix string `desc:"Shard sequence in front of {{.Index}} for many-to-one, empty otherwise" example:"00002"`
+	// Rows and DataBytes are the statistics of the source table, 0 if they were not fetched
+	Rows      int64 `desc:"Estimated rows of the source table(TABLE_ROWS)" example:"1200000"`
+	DataBytes int64 `desc:"Data size of the source table(DATA_LENGTH)" example:"268435456"`
+}
+
+// buildDumplingTasks converts the table mapping to the list of dumpling exports.
+// The same list drives the dumpling commands and the lightning routing rules so
+// that the file names written by the export are the ones expected by the import.
+func buildDumplingTasks(tableStructure []mapping.TableInfo) []DumplingTask {
+	tasks := []DumplingTask{}
+	for _, tableInfo := range tableStructure {
+		// The data of the structure only tables is not exported
+		if tableInfo.StructureOnly {
+			slog.Debug("skipping structure only table", "srcTables", tableInfo.SrcTableInfo)
+			continue
+		}
+
+		// Case 1: One-to-one mapping
+		if len(tableInfo.SrcTableInfo) == 1 && len(tableInfo.DestTableInfo) == 1 {
+			srcParts := strings.Split(tableInfo.SrcTableInfo[0], ".")
+			destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+			tasks = append(tasks, newDumplingTask("one-to-one", srcParts, destParts, "", tableInfo))
+		}
+
+		// Case 2: Many-to-many mapping with same table names and count
+		if len(tableInfo.SrcTableInfo) > 1 && len(tableInfo.DestTableInfo) > 1 &&
+			len(tableInfo.SrcTableInfo) == len(tableInfo.DestTableInfo) {
+			slog.Debug("processing many-to-many mapping with same count",
+				"srcCount", len(tableInfo.SrcTableInfo),
+				"destCount", len(tableInfo.DestTableInfo))
+
+			// Match tables by comparing table names after the schema
+			for i := 0; i < len(tableInfo.SrcTableInfo); i++ {
+				srcParts := strings.Split(tableInfo.SrcTableInfo[i], ".")
+				srcTableName := srcParts[len(srcParts)-1]
+
+				// Find matching destination table
+				for j := 0; j < len(tableInfo.DestTableInfo); j++ {
+					destParts := strings.Split(tableInfo.DestTableInfo[j], ".")
+					if srcTableName == destParts[len(destParts)-1] {
+						tasks = append(tasks, newDumplingTask("many-to-many", srcParts, destParts, "", tableInfo))
+						break
+					}
+				}
+			}
+		}
+
+		// Case 3: Many-to-one consolidation
+		if len(tableInfo.SrcTableInfo) > 1 && len(tableInfo.DestTableInfo) == 1 {
+			slog.Debug("processing many-to-one consolidation",
+				"srcCount", len(tableInfo.SrcTableInfo),
+				"destTable", tableInfo.DestTableInfo[0])
+
+			destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+			for idx, srcTable := range tableInfo.SrcTableInfo {
+				srcParts := strings.Split(srcTable, ".")
+				tasks = append(tasks, newDumplingTask("many-to-one", srcParts, destParts, fmt.Sprintf("%05d", idx+1), tableInfo))
+			}
+		}
+	}
+
+	slog.Debug("built dumpling tasks", "tableStructureCount", len(tableStructure), "taskCount", len(tasks))
+	return tasks
+}
+
+func newDumplingTask(mappingCase string, srcParts, destParts []string, filePrefix string, tableInfo mapping.TableInfo) DumplingTask {
+	size := tableInfo.SrcSizes[strings.Join(srcParts, ".")]
+	return DumplingTask{
+		SrcTable:       fmt.Sprintf("%s.%s", srcParts[1], srcParts[2]),
+		DestTable:      fmt.Sprintf("%s.%s.%s{{.Index}}", destParts[1], destParts[2], filePrefix),
+		SrcSchemaName:  srcParts[1],
+		SrcTableName:   srcParts[2],
+		DestSchemaName: destParts[1],
+		DestTableName:  destParts[2],
+		InstanceName:   srcParts[0],
+		SourceData: fetchDumpingSourceData(srcParts[0], srcParts[1], srcParts[2],
+			tableInfo.DestHasSource, tableInfo.DestHasSchema, tableInfo.DestHasTableName, newPKRewrite(srcParts, tableInfo)),
+		Case:       mappingCase,
+		FilePrefix: filePrefix,
+		Rows:       size.Rows,
+		DataBytes:  size.DataLength,
+	}
+}
+
+// chunkDumplingTask splits the export of a large table: --rows chunks the table by its key and
+// --filesize splits the output files. Dumpling does not chunk the -S queries by rows.
+func chunkDumplingTask(task *DumplingTask, workload config.WorkloadConfig) {
+	if workload.ChunkThresholdMB < 0 || task.DataBytes < workload.ChunkThresholdMB<<20 {
+		return
+	}
+	options := []string{}
+	if !strings.HasPrefix(task.SourceData, "-S ") {
+		options = append(options, fmt.Sprintf("--rows %d", workload.ChunkRows))
+	}
+	options = append(options, "--filesize "+workload.ChunkFileSize)
+	task.SourceData += " " + strings.Join(options, " ")
+	slog.Debug("chunking large table export", "srcTable", task.SrcTable, "dataBytes", task.DataBytes, "options", options)
+}
+
+// dumplingBucket is the exports of one dumpling worker script
+type dumplingBucket struct {
+	Tasks []DumplingTask
+	Rows  int64
+	Bytes int64
+}
+
+// balanceByWeight spreads the items over the buckets, the heaviest first to the least loaded
+// bucket. The item count breaks the tie of the items without weight. The indexes of a bucket
+// are kept in order.
+func balanceByWeight(weights []int64, buckets int) [][]int {
+	if buckets < 1 {
+		buckets = 1
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return weights[order[i]] > weights[order[j]]
+	})
+
+	assigned := make([][]int, buckets)
+	loads := make([]int64, buckets)
+	for _, idx := range order {
+		least := 0
+		for i := range assigned {
+			if loads[i] < loads[least] || (loads[i] == loads[least] && len(assigned[i]) < len(assigned[least])) {
+				least = i
+			}
+		}
+		assigned[least] = append(assigned[least], idx)
+		loads[least] += weights[idx]
+	}
+	for i := range assigned {
+		sort.Ints(assigned[i])
+	}
+	return assigned
+}
+
+// balanceDumplingTasks spreads the tasks over the workers by their data size
+func balanceDumplingTasks(tasks []DumplingTask, workers int) []dumplingBucket {
+	weights := make([]int64, len(tasks))
+	for i, task := range tasks {
+		weights[i] = task.DataBytes
+	}
+	assigned := balanceByWeight(weights, workers)
+	buckets := make([]dumplingBucket, len(assigned))
+	for i, indexes := range assigned {
+		for _, idx := range indexes {
+			buckets[i].Tasks = append(buckets[i].Tasks, tasks[idx])
+			buckets[i].Rows += tasks[idx].Rows
+			buckets[i].Bytes += tasks[idx].DataBytes
+		}
+	}
+	return buckets
+}
+
+// pkRewrite shifts the primary key of a merged shard by its offset in the dumpling SELECT
+type pkRewrite struct {
+	Column string
+	Offset int64
+	// Columns are all the columns of the destination in their ordinal order
+	Columns []string
+}
+
+// newPKRewrite returns the rewriting of the source table, nil if its ids are kept
+func newPKRewrite(srcParts []string, tableInfo mapping.TableInfo) *pkRewrite {
+	shard, ok := tableInfo.PKOffset.Shard(strings.Join(srcParts, "."))
+	if !ok || shard.Offset == 0 {
+		return nil
+	}
+	columns := tableInfo.ColumnOrder
+	if len(columns) == 0 {
+		for _, column := range tableInfo.Columns {
+			columns = append(columns, column.Name)
+		}
+	}
+	return &pkRewrite{Column: tableInfo.PKOffset.Column, Offset: shard.Offset, Columns: columns}
+}
+
+// DumplingPreset is one named dumpling command template
+type DumplingPreset struct {
+	Name        string
+	Description string
+	Template    string
+}
+
+// loadDumplingPreset reads the embedded preset
+func loadDumplingPreset(name string) (DumplingPreset, error) {
+	content, err := dumplingPresetFS.ReadFile(path.Join("templates/dumpling", name+".tpl"))
+	if err != nil {
+		return DumplingPreset{}, fmt.Errorf("unknown dumpling preset %s, available: %s", name, strings.Join(dumplingPresetNames(), ", "))
+	}
+	preset := DumplingPreset{Name: name}
+	descriptions, commands := []string{}, []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			descriptions = append(descriptions, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		case strings.TrimSpace(line) != "":
+			commands = append(commands, line)
+		}
+	}
+	preset.Description = strings.Join(descriptions, " ")
+	preset.Template = strings.Join(commands, "\n")
+	return preset, nil
+}
+
+// dumplingPresetNames lists the embedded presets
+func dumplingPresetNames() []string {
+	entries, err := dumplingPresetFS.ReadDir("templates/dumpling")
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".tpl"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveDumplingTemplate returns the dumpling command template. The --template flag wins
+// over the Template of the config, which wins over the preset.
+func ResolveDumplingTemplate(flagTemplate string, cfg config.Config) (string, error) {
+	if flagTemplate != "" {
+		slog.Debug("using dumpling template from --template")
+		return flagTemplate, nil
+	}
+	if cfg.Template != "" {
+		if cfg.DumplingPreset != "" {
+			slog.Warn("both Template and DumplingPreset are configured, using Template", "dumplingPreset", cfg.DumplingPreset)
+		}
+		return cfg.Template, nil
+	}
+	if cfg.DumplingPreset == "" {
+		return "", nil
+	}
+	preset, err := loadDumplingPreset(cfg.DumplingPreset)
+	if err != nil {
+		return "", err
+	}
+	slog.Debug("using dumpling preset", "preset", preset.Name, "template", preset.Template)
+	return preset.Template, nil
+}
+
+// syntheticTableMapping covers the mapping cases rendered by the dumpling template
+func syntheticTableMapping() []mapping.TableInfo {
+	return []mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"instance01.db_00.users"},
+			DestTableInfo: []string{"target.messagedb.users"},
+		},
+		{
+			SrcTableInfo:  []string{"instance01.db_00.orders", "instance02.db_08.orders"},
+			DestTableInfo: []string{"target.messagedb.orders"},
+			DestHasSchema: true,
+		},
+	}
+}
+
+// ParseDumplingTemplate parses the template and validates it by executing it against the
+// synthetic table mapping, so that a broken template fails at startup instead of per table.
+func ParseDumplingTemplate(tpl string) (*template.Template, error) {
+	tmpl, err := template.New("dumpling").Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dumpling template: %w", err)
+	}
+	for _, task := range buildDumplingTasks(syntheticTableMapping()) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, task); err != nil {
+			return nil, fmt.Errorf("invalid dumpling template for the %s case: %w", task.Case, err)
+		}
+		if strings.TrimSpace(buf.String()) == "" {
+			return nil, fmt.Errorf("dumpling template renders an empty command")
+		}
+	}
+	return tmpl, nil
+}
+
+// PrintDumplingTemplateFields writes the fields available to the dumpling template
+func PrintDumplingTemplateFields(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "FIELD\tDESCRIPTION\tEXAMPLE\n")
+	taskType := reflect.TypeOf(DumplingTask{})
+	for i := 0; i < taskType.NumField(); i++ {
+		field := taskType.Field(i)
+		fmt.Fprintf(w, "{{.%s}}\t%s\t%s\n", field.Name, field.Tag.Get("desc"), field.Tag.Get("example"))
+	}
+	w.Flush()
+}
+
+// PrintDumplingPresets writes the presets and their templates
+func PrintDumplingPresets(out io.Writer) error {
+	for _, name := range dumplingPresetNames() {
+		preset, err := loadDumplingPreset(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: %s\n  %s\n", preset.Name, preset.Description, preset.Template)
+	}
+	return nil
+}
+
+// dumplingIdent quotes the identifier for the SELECT of dumpling. The SELECT is in a double
+// quoted shell argument, so the backticks are escaped.
+func dumplingIdent(name string) string {
+	return "\\`" + strings.ReplaceAll(name, "`", "\\`\\`") + "\\`"
+}
+
+// fetchDumpingSourceData returns the data selection of the dumpling command. The origin columns
+// of the destination are filled by the SELECT. With the pk rewriting every column is listed so
+// that the primary key is selected with its offset.
+func fetchDumpingSourceData(srcInstance, srcSchema, srcTable string, hasInstanceCol, hasSchemaCol, hasTableCol bool, rewrite *pkRewrite) string {
+	slog.Debug("generating dumping source data", "srcInstance", srcInstance, "srcSchema", srcSchema, "srcTable", srcTable, "hasInstanceCol", hasInstanceCol, "hasSchemaCol", hasSchemaCol, "hasTableCol", hasTableCol)
+	if rewrite != nil {
+		selectCols := make([]string, 0, len(rewrite.Columns))
+		for _, column := range rewrite.Columns {
+			switch strings.ToLower(column) {
+			case strings.ToLower(rewrite.Column):
+				selectCols = append(selectCols, fmt.Sprintf("%s + %d AS %s", dumplingIdent(column), rewrite.Offset, dumplingIdent(column)))
+			case "c_instance":
+				selectCols = append(selectCols, fmt.Sprintf("'%s' as %s", srcInstance, dumplingIdent(column)))
+			case "c_schema":
+				selectCols = append(selectCols, fmt.Sprintf("'%s' as %s", srcSchema, dumplingIdent(column)))
+			case "c_table":
+				selectCols = append(selectCols, fmt.Sprintf("'%s' as %s", srcTable, dumplingIdent(column)))
+			default:
+				selectCols = append(selectCols, dumplingIdent(column))
+			}
+		}
+		result := fmt.Sprintf("-S \"SELECT %s FROM %s.%s\"", strings.Join(selectCols, ", "), dumplingIdent(srcSchema), dumplingIdent(srcTable))
+		slog.Debug("pk offset needed, generated SELECT query", "offset", rewrite.Offset, "result", result)
+		return result
+	}
+	if !hasInstanceCol && !hasSchemaCol && !hasTableCol {
+		result := fmt.Sprintf("--tables-list '%s.%s'", srcSchema, srcTable)
+		slog.Debug("no metadata columns needed, using simple table list", "result", result)
+		return result
+	}
+	var selectCols []string
+	if hasInstanceCol {
+		selectCols = append(selectCols, fmt.Sprintf("'%s' as c_instance", srcInstance))
+	}
+	if hasSchemaCol {
+		selectCols = append(selectCols, fmt.Sprintf("'%s' as c_schema", srcSchema))
+	}
+	if hasTableCol {
+		selectCols = append(selectCols, fmt.Sprintf("'%s' as c_table", srcTable))
+	}
+	result := fmt.Sprintf("-S \"SELECT *, %s FROM %s.%s\"", strings.Join(selectCols, ", "), srcSchema, srcTable)
+	slog.Debug("metadata columns needed, generated SELECT query", "selectCols", selectCols, "result", result)
+	return result
+}
+
+// writeDumplingCommands writes the dumpling command of every task. A task whose command fails
+// to render is logged and skipped.
+func writeDumplingCommands(w io.Writer, tmpl *template.Template, tasks []DumplingTask, scriptPath string) {
+	for _, task := range tasks {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, task); err != nil {
+			slog.Error("template execution failed",
+				"case", task.Case,
+				"srcTable", task.SrcTable,
+				"destTable", task.DestTable,
+				"error", err)
+			continue
+		}
+		slog.Debug("dumpling command generated",
+			"case", task.Case,
+			"srcTable", task.SrcTable,
+			"destTable", task.DestTable,
+			"dbName", task.InstanceName)
+		if _, werr := fmt.Fprintf(w, "%s\n", buf.String()); werr != nil {
+			slog.Error("failed to write dumpling command to file", "error", werr, "dumplingPath", scriptPath)
+		}
+	}
+}
+
+// renderDumplingWorker writes the script of one dumpling worker
+func renderDumplingWorker(workerPath, summary string, tmpl *template.Template, tasks []DumplingTask, artifacts *Artifacts) error {
+	workerFile, err := artifacts.Create(workerPath)
+	if err != nil {
+		slog.Error("failed to create dumpling worker script", "error", err, "workerPath", workerPath)
+		return fmt.Errorf("failed to create %s: %w", workerPath, err)
+	}
+	defer workerFile.Close()
+	if _, err := io.WriteString(workerFile, "#!/bin/bash\n\n"+summary+"\n"); err != nil {
+		return fmt.Errorf("failed to write header to %s: %w", workerPath, err)
+	}
+	writeDumplingCommands(workerFile, tmpl, tasks, workerPath)
+	if err := workerFile.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", workerPath, err)
+	}
+	return nil
+}
+
+// dumplingBaseVariables are the connection and output variables of the dumpling.sh header
+var dumplingBaseVariables = []string{"DBHOST", "DBPORT", "DBUSER", "DBPASSWORD", "DUMPLING_OUTPUT"}
+
+// shellVariableRe matches the $NAME and ${NAME} references of the dumpling template
+var shellVariableRe = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// dumplingHeader returns the header of dumpling.sh. The base variables and the other variables
+// the template references, e.g. DUMPLING_SNAPSHOT of the consistent-snapshot preset, are
+// exported to be filled in. The script stops if a referenced variable is empty, the password
+// excepted.
+func dumplingHeader(tmpl *template.Template) string {
+	variables := append([]string{}, dumplingBaseVariables...)
+	referenced := []string{}
+	if tmpl.Tree != nil {
+		for _, match := range shellVariableRe.FindAllStringSubmatch(tmpl.Tree.Root.String(), -1) {
+			if !slices.Contains(referenced, match[1]) {
+				referenced = append(referenced, match[1])
+			}
+		}
+	}
+	for _, name := range referenced {
+		if !slices.Contains(variables, name) {
+			variables = append(variables, name)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#!/bin/bash\n\n")
+	for _, name := range variables {
+		fmt.Fprintf(&sb, "export %s=\n", name)
+	}
+	sb.WriteString("\n")
+	guarded := false
+	for _, name := range referenced {
+		if name == "DBPASSWORD" {
+			continue
+		}
+		fmt.Fprintf(&sb, ": \"${%s:?%s is not set}\"\n", name, name)
+		guarded = true
+	}
+	if guarded {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// RenderDumpling writes dumpling.sh with one dumpling command per task of the table mapping
+// and returns its path. With more than one worker of the workload the commands are balanced
+// over the dumpling-worker-NN.sh scripts which dumpling.sh runs in parallel.
+func RenderDumpling(cfg *config.Config, tableStructure []mapping.TableInfo, tmpl *template.Template, artifacts *Artifacts) (string, error) {
+	// Fallback to current directory if the output is empty
+	outputDir := cfg.Output
+	if outputDir == "" {
+		outputDir = "."
+	}
+
+	// Open a single output file for all dumpling commands
+	dumplingPath := fmt.Sprintf("%s/dumpling.sh", outputDir)
+	if !artifacts.Deferred() {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			slog.Error("failed to create output directory", "error", err, "outputDir", outputDir)
+			return "", fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	dumplingFile, err := artifacts.Create(dumplingPath)
+	if err != nil {
+		slog.Error("failed to create dumpling.sh", "error", err, "dumplingPath", dumplingPath)
+		return "", fmt.Errorf("failed to create dumpling.sh: %w", err)
+	}
+	defer dumplingFile.Close()
+
+	// Write shell header and environment-variable template to dumpling.sh
+	if _, err := io.WriteString(dumplingFile, dumplingHeader(tmpl)); err != nil {
+		slog.Error("failed to write header to dumpling.sh", "error", err)
+		return "", fmt.Errorf("failed to write header to dumpling.sh: %w", err)
+	}
+
+	slog.Info("starting generateDumpling operation",
+		"totalTableStructures", len(tableStructure),
+		"description", "generating dumpling commands for table mappings",
+		"outputPath", dumplingPath)
+	workload := cfg.Workload.WithDefaults()
+	tasks := buildDumplingTasks(tableStructure)
+	for i := range tasks {
+		chunkDumplingTask(&tasks[i], workload)
+	}
+	if workload.Workers == 1 {
+		writeDumplingCommands(dumplingFile, tmpl, tasks, dumplingPath)
+	} else {
+		// dumpling.sh runs the worker scripts in parallel, they inherit the exported variables
+		for i, bucket := range balanceDumplingTasks(tasks, workload.Workers) {
+			if len(bucket.Tasks) == 0 {
+				continue
+			}
+			workerName := fmt.Sprintf("dumpling-worker-%02d.sh", i+1)
+			summary := fmt.Sprintf("%d tables, %d rows, %s, estimated %s", len(bucket.Tasks), bucket.Rows,
+				schema.FormatBytes(bucket.Bytes), mapping.EstimateExport(bucket.Bytes, workload.ExportMBps))
+			if err := renderDumplingWorker(fmt.Sprintf("%s/%s", outputDir, workerName), fmt.Sprintf("# Worker %d of %d: %s\n", i+1, workload.Workers, summary), tmpl, bucket.Tasks, artifacts); err != nil {
+				return "", err
+			}
+			if _, err := fmt.Fprintf(dumplingFile, "# %s\nbash \"$(dirname \"$0\")/%s\" &\n", summary, workerName); err != nil {
+				return "", fmt.Errorf("failed to write dumpling.sh: %w", err)
+			}
+			slog.Info("balanced dumpling worker", "worker", workerName, "tableCount", len(bucket.Tasks), "bytes", bucket.Bytes)
+		}
+		if _, err := io.WriteString(dumplingFile, "wait\n"); err != nil {
+			return "", fmt.Errorf("failed to write dumpling.sh: %w", err)
+		}
+	}
+
+	// Explicitly close the file to ensure all data is written
+	if err := dumplingFile.Close(); err != nil {
+		slog.Error("failed to close dumpling.sh", "error", err, "dumplingPath", dumplingPath)
+		return "", fmt.Errorf("failed to close dumpling.sh: %w", err)
+	}
+	return dumplingPath, nil
+}
@@ -0,0 +1,332 @@
+package render
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+func Test_buildDumplingTasks(t *testing.T) {
+	tableMapping := []mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"instance01.db_00.users"},
+			DestTableInfo: []string{"target.messagedb.users"},
+		},
+		{
+			SrcTableInfo:  []string{"instance01.db_00.orders", "instance02.db_08.orders"},
+			DestTableInfo: []string{"target.messagedb.orders"},
+			DestHasSchema: true,
+		},
+	}
+	tests := []struct {
+		name           string
+		idx            int
+		wantDestTable  string
+		wantInstance   string
+		wantSourceData string
+	}{
+		{"One-to-one", 0, "messagedb.users.{{.Index}}", "instance01", "--tables-list 'db_00.users'"},
+		{"Many-to-one first shard", 1, "messagedb.orders.00001{{.Index}}", "instance01", "-S \"SELECT *, 'db_00' as c_schema FROM db_00.orders\""},
+		{"Many-to-one second shard", 2, "messagedb.orders.00002{{.Index}}", "instance02", "-S \"SELECT *, 'db_08' as c_schema FROM db_08.orders\""},
+	}
+
+	tasks := buildDumplingTasks(tableMapping)
+	if len(tasks) != len(tests) {
+		t.Fatalf("buildDumplingTasks() returned %d tasks, want %d", len(tasks), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tasks[tt.idx]
+			if got.DestTable != tt.wantDestTable {
+				t.Errorf("DestTable = %v, want %v", got.DestTable, tt.wantDestTable)
+			}
+			if got.InstanceName != tt.wantInstance {
+				t.Errorf("InstanceName = %v, want %v", got.InstanceName, tt.wantInstance)
+			}
+			if got.SourceData != tt.wantSourceData {
+				t.Errorf("SourceData = %v, want %v", got.SourceData, tt.wantSourceData)
+			}
+		})
+	}
+}
+
+func Test_dumplingPresets(t *testing.T) {
+	names := dumplingPresetNames()
+	if len(names) < 4 {
+		t.Fatalf("dumplingPresetNames() = %v, want the embedded presets", names)
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			preset, err := loadDumplingPreset(name)
+			if err != nil {
+				t.Fatalf("loadDumplingPreset() error = %v", err)
+			}
+			if preset.Description == "" || strings.Contains(preset.Template, "#") {
+				t.Errorf("preset = %+v, want the description split from the template", preset)
+			}
+			if _, err := ParseDumplingTemplate(preset.Template); err != nil {
+				t.Errorf("ParseDumplingTemplate() error = %v", err)
+			}
+		})
+	}
+	if _, err := loadDumplingPreset("parquet"); err == nil {
+		t.Errorf("loadDumplingPreset() expected error for unknown preset")
+	}
+}
+
+func Test_RenderDumplingSnapshotPreset(t *testing.T) {
+	preset, err := loadDumplingPreset("consistent-snapshot")
+	if err != nil {
+		t.Fatalf("loadDumplingPreset() error = %v", err)
+	}
+	tmpl, err := ParseDumplingTemplate(preset.Template)
+	if err != nil {
+		t.Fatalf("ParseDumplingTemplate() error = %v", err)
+	}
+	cfg := &config.Config{Output: t.TempDir()}
+	tableMapping := []mapping.TableInfo{{
+		SrcTableInfo:  []string{"instance01.db_00.orders"},
+		DestTableInfo: []string{"target.messagedb.orders"},
+	}}
+	if _, err := RenderDumpling(cfg, tableMapping, tmpl, nil); err != nil {
+		t.Fatalf("RenderDumpling() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(cfg.Output, "dumpling.sh"))
+	if err != nil {
+		t.Fatalf("failed to read dumpling.sh: %v", err)
+	}
+	for _, want := range []string{
+		"export DUMPLING_SNAPSHOT=\n",
+		`: "${DUMPLING_SNAPSHOT:?DUMPLING_SNAPSHOT is not set}"`,
+		`: "${DBHOST:?DBHOST is not set}"`,
+		`--snapshot "${DUMPLING_SNAPSHOT}"`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("dumpling.sh does not contain %s:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "${DBPASSWORD:?") {
+		t.Errorf("dumpling.sh requires the password, it may be empty:\n%s", content)
+	}
+}
+
+func Test_parseDumplingTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tpl     string
+		wantErr bool
+	}{
+		{"Valid", "dumpling {{.SourceData}} --output-filename-template '{{.DestTable}}'", false},
+		{"Unknown field", "dumpling --tables-list '{{.SrcTables}}'", true},
+		{"Syntax error", "dumpling {{.SourceData}", true},
+		{"Empty command", "{{if false}}dumpling{{end}}", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDumplingTemplate(tt.tpl); (err != nil) != tt.wantErr {
+				t.Errorf("ParseDumplingTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_resolveDumplingTemplate(t *testing.T) {
+	sqlPreset, _ := loadDumplingPreset("sql")
+	tests := []struct {
+		name         string
+		flagTemplate string
+		cfg          config.Config
+		want         string
+	}{
+		{"Flag wins", "from flag", config.Config{Template: "from config", DumplingPreset: "sql"}, "from flag"},
+		{"Config template wins over preset", "", config.Config{Template: "from config", DumplingPreset: "sql"}, "from config"},
+		{"Preset", "", config.Config{DumplingPreset: "sql"}, sqlPreset.Template},
+		{"Nothing", "", config.Config{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveDumplingTemplate(tt.flagTemplate, tt.cfg)
+			if err != nil || got != tt.want {
+				t.Errorf("ResolveDumplingTemplate() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_printDumplingTemplateFields(t *testing.T) {
+	var buf bytes.Buffer
+	PrintDumplingTemplateFields(&buf)
+	taskType := reflect.TypeOf(DumplingTask{})
+	for i := 0; i < taskType.NumField(); i++ {
+		field := taskType.Field(i)
+		if field.Tag.Get("desc") == "" {
+			t.Errorf("field %s has no description", field.Name)
+		}
+		if !strings.Contains(buf.String(), "{{."+field.Name+"}}") {
+			t.Errorf("field %s not printed", field.Name)
+		}
+	}
+}
+
+func Test_buildDumplingTasksStructureOnly(t *testing.T) {
+	tableMapping := []mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"instance01.db_00.users"},
+			DestTableInfo: []string{"target.messagedb.users"},
+		},
+		{
+			SrcTableInfo:  []string{"instance01.db_00.log_2024"},
+			DestTableInfo: []string{"target.messagedb.log_2024"},
+			StructureOnly: true,
+		},
+	}
+	tasks := buildDumplingTasks(tableMapping)
+	if len(tasks) != 1 || tasks[0].SrcTableName != "users" {
+		t.Errorf("buildDumplingTasks() = %+v, want only the users table", tasks)
+	}
+}
+
+func Test_fetchDumpingSourceData(t *testing.T) {
+	type args struct {
+		srcInstance    string
+		srcSchema      string
+		srcTable       string
+		hasInstanceCol bool
+		hasSchemaCol   bool
+		hasTableCol    bool
+		rewrite        *pkRewrite
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "tables list",
+			args: args{srcInstance: "instance01", srcSchema: "db_00", srcTable: "orders"},
+			want: "--tables-list 'db_00.orders'",
+		},
+		{
+			name: "origin columns",
+			args: args{srcInstance: "instance01", srcSchema: "db_00", srcTable: "orders", hasSchemaCol: true},
+			want: `-S "SELECT *, 'db_00' as c_schema FROM db_00.orders"`,
+		},
+		{
+			name: "pk offset",
+			args: args{srcInstance: "instance01", srcSchema: "db_01", srcTable: "orders", hasSchemaCol: true,
+				rewrite: &pkRewrite{Column: "id", Offset: 4503599627370496, Columns: []string{"id", "name", "c_schema"}}},
+			want: "-S \"SELECT \\`id\\` + 4503599627370496 AS \\`id\\`, \\`name\\`, 'db_01' as \\`c_schema\\` FROM \\`db_01\\`.\\`orders\\`\"",
+		},
+		{
+			name: "all origin columns",
+			args: args{srcInstance: "instance01", srcSchema: "db_00", srcTable: "orders", hasInstanceCol: true, hasSchemaCol: true, hasTableCol: true},
+			want: `-S "SELECT *, 'instance01' as c_instance, 'db_00' as c_schema, 'orders' as c_table FROM db_00.orders"`,
+		},
+		{
+			name: "pk offset of reserved names",
+			args: args{srcInstance: "instance01", srcSchema: "db_01", srcTable: "order", hasInstanceCol: true,
+				rewrite: &pkRewrite{Column: "id", Offset: 1, Columns: []string{"id", "key", "c_instance"}}},
+			want: "-S \"SELECT \\`id\\` + 1 AS \\`id\\`, \\`key\\`, 'instance01' as \\`c_instance\\` FROM \\`db_01\\`.\\`order\\`\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fetchDumpingSourceData(tt.args.srcInstance, tt.args.srcSchema, tt.args.srcTable, tt.args.hasInstanceCol, tt.args.hasSchemaCol, tt.args.hasTableCol, tt.args.rewrite); got != tt.want {
+				t.Errorf("fetchDumpingSourceData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_chunkDumplingTask(t *testing.T) {
+	workload := config.WorkloadConfig{ChunkThresholdMB: 1024}.WithDefaults()
+	tests := []struct {
+		name      string
+		task      DumplingTask
+		threshold int64
+		want      string
+	}{
+		{"small table", DumplingTask{SourceData: "--tables-list 'db_00.orders'", DataBytes: 1 << 20}, 1024, "--tables-list 'db_00.orders'"},
+		{"large table", DumplingTask{SourceData: "--tables-list 'db_00.orders'", DataBytes: 2 << 30}, 1024, "--tables-list 'db_00.orders' --rows 200000 --filesize 256MiB"},
+		{"large query", DumplingTask{SourceData: `-S "SELECT * FROM db_00.orders"`, DataBytes: 2 << 30}, 1024, `-S "SELECT * FROM db_00.orders" --filesize 256MiB`},
+		{"chunking disabled", DumplingTask{SourceData: "--tables-list 'db_00.orders'", DataBytes: 2 << 30}, -1, "--tables-list 'db_00.orders'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload.ChunkThresholdMB = tt.threshold
+			chunkDumplingTask(&tt.task, workload)
+			if tt.task.SourceData != tt.want {
+				t.Errorf("SourceData = %s, want %s", tt.task.SourceData, tt.want)
+			}
+		})
+	}
+}
+
+func Test_balanceDumplingTasks(t *testing.T) {
+	tasks := []DumplingTask{
+		{SrcTable: "db_00.a", DataBytes: 10},
+		{SrcTable: "db_00.b", DataBytes: 60},
+		{SrcTable: "db_00.c", DataBytes: 30},
+		{SrcTable: "db_00.d", DataBytes: 40},
+		{SrcTable: "db_00.e", DataBytes: 20},
+	}
+	buckets := balanceDumplingTasks(tasks, 2)
+	got := [][]string{}
+	for _, bucket := range buckets {
+		tables := []string{}
+		for _, task := range bucket.Tasks {
+			tables = append(tables, task.SrcTable)
+		}
+		got = append(got, tables)
+	}
+	want := [][]string{{"db_00.b", "db_00.e"}, {"db_00.a", "db_00.c", "db_00.d"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("balanceDumplingTasks() = %v, want %v", got, want)
+	}
+	if buckets[0].Bytes != 80 || buckets[1].Bytes != 80 {
+		t.Errorf("bucket bytes = %d, %d, want 80, 80", buckets[0].Bytes, buckets[1].Bytes)
+	}
+}
+
+func Test_RenderDumplingWorkers(t *testing.T) {
+	cfg := &config.Config{Output: t.TempDir(), Workload: config.WorkloadConfig{Workers: 2}}
+	tableMapping := []mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"instance01.db_00.orders", "instance02.db_08.orders"},
+			DestTableInfo: []string{"target.messagedb.orders"},
+			SrcSizes: map[string]schema.TableSize{
+				"instance01.db_00.orders": {Rows: 10, DataLength: 4 << 20},
+				"instance02.db_08.orders": {Rows: 20, DataLength: 2 << 30},
+			},
+		},
+	}
+	tmpl, err := ParseDumplingTemplate("dumpling {{.SourceData}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RenderDumpling(cfg, tableMapping, tmpl, nil); err != nil {
+		t.Fatalf("RenderDumpling() error = %v", err)
+	}
+
+	files := map[string]string{
+		"dumpling.sh":           `bash "$(dirname "$0")/dumpling-worker-02.sh" &`,
+		"dumpling-worker-01.sh": "dumpling --tables-list 'db_08.orders' --rows 200000 --filesize 256MiB",
+		"dumpling-worker-02.sh": "dumpling --tables-list 'db_00.orders'\n",
+	}
+	for name, want := range files {
+		content, err := os.ReadFile(filepath.Join(cfg.Output, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if !strings.Contains(string(content), want) {
+			t.Errorf("%s does not contain %s:\n%s", name, want, content)
+		}
+	}
+}
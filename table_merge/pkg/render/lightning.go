@@ -0,0 +1,278 @@
+package render
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+// DumplingOptions are the export options read from the dumpling command template
+type DumplingOptions struct {
+	FileType     string
+	Compress     string
+	CSVSeparator string
+	CSVDelimiter string
+	CSVNullValue string
+	NoHeader     bool
+}
+
+// LightningFileRule represents one [[mydumper.files]] routing rule
+type LightningFileRule struct {
+	Pattern     string
+	Schema      string
+	Table       string
+	Type        string
+	Key         string
+	Compression string
+}
+
+// parseDumplingOptions reads the flags which decide the dumpling output file format
+// from the command template. Dumpling defaults are used for the missing flags.
+func parseDumplingOptions(tpl string) DumplingOptions {
+	opts := DumplingOptions{
+		FileType:     "sql",
+		CSVSeparator: ",",
+		CSVDelimiter: `"`,
+		CSVNullValue: `\N`,
+	}
+	if v, ok := dumplingFlagValue(tpl, "filetype"); ok {
+		opts.FileType = strings.ToLower(v)
+	}
+	if v, ok := dumplingFlagValue(tpl, "compress"); ok {
+		opts.Compress = strings.ToLower(v)
+	}
+	if v, ok := dumplingFlagValue(tpl, "csv-separator"); ok {
+		opts.CSVSeparator = v
+	}
+	if v, ok := dumplingFlagValue(tpl, "csv-delimiter"); ok {
+		opts.CSVDelimiter = v
+	}
+	if v, ok := dumplingFlagValue(tpl, "csv-null-value"); ok {
+		opts.CSVNullValue = v
+	}
+	opts.NoHeader = regexp.MustCompile(`(^|\s)--no-header(\s|=true|$)`).MatchString(tpl)
+
+	slog.Debug("parsed dumpling options from template", "fileType", opts.FileType, "compress", opts.Compress,
+		"csvSeparator", opts.CSVSeparator, "csvDelimiter", opts.CSVDelimiter, "noHeader", opts.NoHeader)
+	return opts
+}
+
+// dumplingFlagValue returns the value of --name in the command template. The value may be
+// quoted with single or double quotes.
+func dumplingFlagValue(tpl, name string) (string, bool) {
+	re := regexp.MustCompile(`(?:^|\s)--` + regexp.QuoteMeta(name) + `(?:=|\s+)(?:'([^']*)'|"([^"]*)"|(\S+))`)
+	matches := re.FindStringSubmatch(tpl)
+	if matches == nil {
+		return "", false
+	}
+	for _, v := range matches[1:] {
+		if v != "" {
+			return v, true
+		}
+	}
+	return "", true
+}
+
+// dumplingFileExtension returns the extension and lightning compression name of the
+// files written by dumpling for the given options.
+func dumplingFileExtension(opts DumplingOptions) (string, string) {
+	switch opts.Compress {
+	case "gzip", "gz":
+		return opts.FileType + ".gz", "gzip"
+	case "zstd", "zst":
+		return opts.FileType + ".zst", "zstd"
+	case "snappy":
+		return opts.FileType + ".snappy", "snappy"
+	default:
+		return opts.FileType, ""
+	}
+}
+
+// buildLightningFileRules builds the routing rules of one source instance. The pattern
+// follows the --output-filename-template of the dumpling task so that each shard file
+// is routed to its destination table.
+func buildLightningFileRules(instanceName string, tasks []DumplingTask, opts DumplingOptions) []LightningFileRule {
+	ext, compression := dumplingFileExtension(opts)
+	rules := []LightningFileRule{}
+	for _, task := range tasks {
+		if task.InstanceName != instanceName {
+			continue
+		}
+		pattern := fmt.Sprintf(`(?i)^(?:[^/]*/)*%s\.%s\.%s([0-9]+)\.%s$`,
+			regexp.QuoteMeta(task.DestSchemaName), regexp.QuoteMeta(task.DestTableName),
+			task.FilePrefix, regexp.QuoteMeta(ext))
+		rules = append(rules, LightningFileRule{
+			Pattern:     pattern,
+			Schema:      task.DestSchemaName,
+			Table:       task.DestTableName,
+			Type:        opts.FileType,
+			Key:         "$1",
+			Compression: compression,
+		})
+		slog.Debug("built lightning file rule", "instance", instanceName, "srcTable", task.SrcTable, "pattern", pattern)
+	}
+	return rules
+}
+
+// lightningTLSMode converts the TLS mode of the connection to the [tidb] tls value of lightning
+func lightningTLSMode(dbInfo config.DBConnInfo) string {
+	switch dbInfo.TLS.Mode {
+	case "preferred":
+		return "preferred"
+	case "required":
+		return "skip-verify"
+	case "verify-ca", "verify-identity":
+		if securityForOutput(dbInfo) != nil {
+			// Use the certificates of [tidb.security]
+			return "cluster"
+		}
+		return "true"
+	default:
+		return ""
+	}
+}
+
+// RenderLightningConfig renders one tidb-lightning config per source instance to import
+// the files exported by the generated dumpling commands.
+func RenderLightningConfig(cfg *config.Config, tableMapping *[]mapping.TableInfo, artifacts *Artifacts) error {
+	if cfg == nil {
+		slog.Error("RenderLightningConfig received nil config")
+		return fmt.Errorf("config is nil")
+	}
+	if tableMapping == nil {
+		slog.Error("RenderLightningConfig received nil tableMapping", "configOutput", cfg.Output)
+		return fmt.Errorf("tableMapping is nil")
+	}
+
+	slog.Info("starting RenderLightningConfig", "output", cfg.Output, "sourceDBCount", len(cfg.SourceDB), "tableMappingCount", len(*tableMapping))
+
+	type LightningTemplateData struct {
+		InstanceName  string
+		Backend       string
+		SortedKVDir   string
+		DataSourceDir string
+		FileType      string
+		CSV           struct {
+			Separator string
+			Delimiter string
+			Null      string
+			Header    bool
+		}
+		Checkpoint struct {
+			Schema string
+			Driver string
+			DSN    string
+		}
+		Target struct {
+			Host       string
+			Port       int
+			User       string
+			Password   string
+			StatusPort int
+			PDAddr     string
+			TLS        string
+			Security   *SecurityConfig
+		}
+		Files []LightningFileRule
+	}
+
+	opts := parseDumplingOptions(cfg.Template)
+	if opts.FileType != "csv" && opts.FileType != "sql" {
+		slog.Error("dumpling file type not supported by lightning config", "fileType", opts.FileType)
+		return fmt.Errorf("dumpling file type %s is not supported", opts.FileType)
+	}
+	tasks := buildDumplingTasks(*tableMapping)
+
+	tmplBytes, err := readmeFS.ReadFile("templates/lightning.tpl.toml")
+	if err != nil {
+		slog.Error("failed to read template file", "template", "templates/lightning.tpl.toml", "error", err)
+		return fmt.Errorf("failed to read template file: %w", err)
+	}
+	tmpl, err := template.New("lightning").Parse(string(tmplBytes))
+	if err != nil {
+		slog.Error("failed to parse template", "error", err)
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	lightningConfig := cfg.Lightning
+	if lightningConfig.Backend == "" {
+		lightningConfig.Backend = "local"
+	}
+	if lightningConfig.SortedKVDir == "" {
+		lightningConfig.SortedKVDir = "./sorted-kv"
+	}
+	if lightningConfig.DataSourceDir == "" {
+		lightningConfig.DataSourceDir = "./dumpling_output"
+	}
+	if lightningConfig.CheckpointDriver == "" {
+		lightningConfig.CheckpointDriver = "file"
+	}
+	if lightningConfig.StatusPort == 0 {
+		lightningConfig.StatusPort = 10080
+	}
+
+	outputPath := cfg.Output
+	if !strings.HasSuffix(outputPath, "/") {
+		outputPath += "/"
+	}
+
+	for _, db := range cfg.SourceDB {
+		data := LightningTemplateData{
+			InstanceName:  db.Name,
+			Backend:       lightningConfig.Backend,
+			SortedKVDir:   fmt.Sprintf("%s/%s", strings.TrimSuffix(lightningConfig.SortedKVDir, "/"), db.Name),
+			DataSourceDir: lightningConfig.DataSourceDir,
+			FileType:      opts.FileType,
+			Files:         buildLightningFileRules(db.Name, tasks, opts),
+		}
+		data.CSV.Separator = opts.CSVSeparator
+		data.CSV.Delimiter = opts.CSVDelimiter
+		data.CSV.Null = opts.CSVNullValue
+		data.CSV.Header = !opts.NoHeader
+
+		// Each instance keeps its own checkpoint so the imports can be resumed independently
+		data.Checkpoint.Schema = fmt.Sprintf("tidb_lightning_checkpoint_%s", db.Name)
+		data.Checkpoint.Driver = lightningConfig.CheckpointDriver
+		if lightningConfig.CheckpointDriver == "file" {
+			data.Checkpoint.DSN = fmt.Sprintf("./checkpoint/tidb_lightning_checkpoint_%s.pb", db.Name)
+		}
+
+		data.Target.Host = cfg.DestDB.Host
+		data.Target.Port = cfg.DestDB.Port
+		data.Target.User = cfg.DestDB.User
+		data.Target.Password = cfg.DestDB.PasswordForOutput()
+		data.Target.StatusPort = lightningConfig.StatusPort
+		data.Target.PDAddr = lightningConfig.PDAddr
+		data.Target.TLS = lightningTLSMode(cfg.DestDB)
+		data.Target.Security = securityForOutput(cfg.DestDB)
+
+		if len(data.Files) == 0 {
+			slog.Warn("no dumpling task found for source instance, skipping lightning config", "instance", db.Name)
+			continue
+		}
+
+		outFileName := fmt.Sprintf("%stidb-lightning-%s.toml", outputPath, db.Name)
+		outFile, err := artifacts.Create(outFileName)
+		if err != nil {
+			slog.Error("failed to create output file", "file", outFileName, "error", err)
+			return fmt.Errorf("failed to create output file %s: %w", outFileName, err)
+		}
+
+		if err := tmpl.Execute(outFile, data); err != nil {
+			outFile.Close()
+			slog.Error("failed to execute template", "file", outFileName, "error", err)
+			return fmt.Errorf("failed to execute template for %s: %w", db.Name, err)
+		}
+		outFile.Close()
+
+		slog.Info("successfully rendered lightning config", "file", outFileName, "instance", db.Name, "fileRuleCount", len(data.Files))
+	}
+
+	slog.Info("completed RenderLightningConfig", "output", cfg.Output)
+	return nil
+}
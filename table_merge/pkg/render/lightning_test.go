@@ -0,0 +1,108 @@
+package render
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+func Test_parseDumplingOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		tpl  string
+		want DumplingOptions
+	}{
+		{
+			name: "Default: no filetype falls back to sql",
+			tpl:  "dumpling -h ${DBHOST} {{.SourceData}} --output-filename-template '{{.DestTable}}'",
+			want: DumplingOptions{FileType: "sql", CSVSeparator: ",", CSVDelimiter: `"`, CSVNullValue: `\N`},
+		},
+		{
+			name: "CSV: custom separator and compression",
+			tpl:  "dumpling {{.SourceData}} --filetype csv --csv-separator '|' --compress gzip --no-header -o \"${DUMPLING_OUTPUT}\"",
+			want: DumplingOptions{FileType: "csv", Compress: "gzip", CSVSeparator: "|", CSVDelimiter: `"`, CSVNullValue: `\N`, NoHeader: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDumplingOptions(tt.tpl); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDumplingOptions() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_buildLightningFileRules(t *testing.T) {
+	tableMapping := []mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"instance01.db_00.orders", "instance02.db_08.orders"},
+			DestTableInfo: []string{"target.messagedb.orders"},
+		},
+		{
+			SrcTableInfo:  []string{"instance01.db_00.users"},
+			DestTableInfo: []string{"target.messagedb.users"},
+		},
+	}
+	opts := DumplingOptions{FileType: "csv"}
+	rules := buildLightningFileRules("instance02", buildDumplingTasks(tableMapping), opts)
+	if len(rules) != 1 {
+		t.Fatalf("buildLightningFileRules() returned %d rules, want 1", len(rules))
+	}
+	if rules[0].Schema != "messagedb" || rules[0].Table != "orders" {
+		t.Errorf("buildLightningFileRules() routed to %s.%s, want messagedb.orders", rules[0].Schema, rules[0].Table)
+	}
+
+	re := regexp.MustCompile(rules[0].Pattern)
+	if !re.MatchString("messagedb.orders.00002000000001.csv") {
+		t.Errorf("pattern %s does not match the file of instance02", rules[0].Pattern)
+	}
+	if re.MatchString("messagedb.orders.00001000000001.csv") {
+		t.Errorf("pattern %s matches the file of instance01", rules[0].Pattern)
+	}
+}
+
+func TestRenderLightningConfig(t *testing.T) {
+	cfg := &config.Config{
+		SourceDB: []config.DBConnInfo{
+			{Name: "source1", Host: "localhost", Port: 3306, User: "root", Password: "password", DBs: []string{"db1"}},
+			{Name: "source2", Host: "localhost", Port: 3307, User: "root", Password: "password", DBs: []string{"db2"}},
+		},
+		DestDB:   config.DBConnInfo{Name: "dest1", Host: "localhost", Port: 4000, User: "root", Password: "password", DBs: []string{"db"}},
+		Template: "dumpling {{.SourceData}} --output-filename-template '{{.DestTable}}' --filetype csv -o \"${DUMPLING_OUTPUT}\"",
+		Output:   t.TempDir(),
+	}
+	tableMapping := &[]mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"source1.db1.table1"},
+			DestTableInfo: []string{"dest1.db.table1"},
+		},
+	}
+
+	if err := RenderLightningConfig(cfg, tableMapping, nil); err != nil {
+		t.Fatalf("RenderLightningConfig() error = %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.Output + "/tidb-lightning-source1.toml")
+	if err != nil {
+		t.Fatalf("lightning config for source1 not generated: %v", err)
+	}
+	for _, want := range []string{"[mydumper.csv]", `table = "table1"`, `driver = "file"`, "[[mydumper.files]]"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("lightning config does not contain %q", want)
+		}
+	}
+
+	// source2 has no table to import, no config is expected
+	if _, err := os.Stat(cfg.Output + "/tidb-lightning-source2.toml"); !os.IsNotExist(err) {
+		t.Errorf("unexpected lightning config for source2")
+	}
+
+	if err := RenderLightningConfig(nil, tableMapping, nil); err == nil {
+		t.Errorf("RenderLightningConfig() with nil config should return error")
+	}
+}
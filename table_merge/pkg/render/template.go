@@ -0,0 +1,1093 @@
+package render
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+//go:embed templates/diff.tpl.toml templates/task.tpl.toml templates/lightning.tpl.toml templates/ticdc-reverse.tpl.toml
+var readmeFS embed.FS
+
+type SyncDiffConfig struct {
+	CheckThreadCount     int                    `yaml:"check-thread-count" json:"check_thread_count"`
+	ExportFixSQL         bool                   `yaml:"export-fix-sql" json:"export_fix_sql"`
+	CheckDataOnly        bool                   `yaml:"check-data-only" json:"check_data_only"`
+	CheckStructOnly      bool                   `yaml:"check-struct-only" json:"check_struct_only"`
+	SkipNonExistingTable bool                   `yaml:"skip-non-existing-table" json:"skip_non_existing_table"`
+	DataSources          map[string]DataSource  `yaml:"data-sources" json:"data_sources"`
+	Task                 TaskConfig             `yaml:"task" json:"task"`
+	Routes               map[string]RouteRule   `yaml:"routes,omitempty" json:"routes,omitempty"`
+	TableConfigs         map[string]TableConfig `yaml:"table-configs,omitempty" json:"table_configs,omitempty"`
+}
+
+// DataSource represents a database connection configuration
+type DataSource struct {
+	Host       string          `yaml:"host" json:"host"`
+	Port       int             `yaml:"port" json:"port"`
+	User       string          `yaml:"user" json:"user"`
+	Password   string          `yaml:"password" json:"password"`
+	TimeZone   string          `yaml:"time-zone,omitempty" json:"time_zone,omitempty"`
+	Location   string          `yaml:"location,omitempty" json:"location,omitempty"`
+	RouteRules []string        `yaml:"route-rules,omitempty" json:"route_rules,omitempty"`
+	Security   *SecurityConfig `yaml:"security,omitempty" json:"security,omitempty"`
+}
+
+// SecurityConfig represents the TLS file paths of a connection in the generated configs
+type SecurityConfig struct {
+	CAPath   string `yaml:"ca-path" json:"ca_path"`
+	CertPath string `yaml:"cert-path" json:"cert_path"`
+	KeyPath  string `yaml:"key-path" json:"key_path"`
+}
+
+// securityForOutput returns the TLS settings written to the generated configs. nil is
+// returned if TLS is disabled or there is no file to reference.
+func securityForOutput(dbInfo config.DBConnInfo) *SecurityConfig {
+	if dbInfo.TLS.Mode == "" || dbInfo.TLS.Mode == "disabled" {
+		return nil
+	}
+	if dbInfo.TLS.CA == "" && dbInfo.TLS.Cert == "" && dbInfo.TLS.Key == "" {
+		return nil
+	}
+	return &SecurityConfig{
+		CAPath:   dbInfo.TLS.CA,
+		CertPath: dbInfo.TLS.Cert,
+		KeyPath:  dbInfo.TLS.Key,
+	}
+}
+
+// TaskConfig represents the task configuration
+type TaskConfig struct {
+	OutputDir         string   `yaml:"output-dir" json:"output_dir"`
+	SourceInstances   []string `yaml:"source-instances" json:"source_instances"`
+	TargetInstance    string   `yaml:"target-instance" json:"target_instance"`
+	TargetCheckTables []string `yaml:"target-check-tables,omitempty" json:"target_check_tables,omitempty"`
+	TargetConfigs     []string `yaml:"target-configs,omitempty" json:"target_configs,omitempty"`
+}
+
+// RouteRule represents a routing rule for schema/table mapping
+type RouteRule struct {
+	SchemaPattern string `yaml:"schema-pattern" json:"schema_pattern"`
+	TablePattern  string `yaml:"table-pattern" json:"table_pattern"`
+	TargetSchema  string `yaml:"target-schema,omitempty" json:"target_schema,omitempty"`
+	TargetTable   string `yaml:"target-table,omitempty" json:"target_table,omitempty"`
+}
+
+// DMFilterRule represents a binlog event filter rule of the DM task
+type DMFilterRule struct {
+	SchemaPattern string   `yaml:"schema-pattern" json:"schema_pattern"`
+	TablePattern  string   `yaml:"table-pattern" json:"table_pattern"`
+	Events        []string `yaml:"events" json:"events"`
+	Action        string   `yaml:"action" json:"action"`
+}
+
+// DMColumnMappingRule represents a column mapping rule of the DM task
+type DMColumnMappingRule struct {
+	SchemaPattern string   `yaml:"schema-pattern" json:"schema_pattern"`
+	TablePattern  string   `yaml:"table-pattern" json:"table_pattern"`
+	Expression    string   `yaml:"expression" json:"expression"`
+	SourceColumn  string   `yaml:"source-column" json:"source_column"`
+	TargetColumn  string   `yaml:"target-column" json:"target_column"`
+	Arguments     []string `yaml:"arguments" json:"arguments"`
+}
+
+// TableConfig represents table-specific configurations
+type TableConfig struct {
+	TargetTables  []string `yaml:"target-tables" json:"target_tables"`
+	IgnoreColumns []string `yaml:"ignore-columns" json:"ignore_columns"`
+	Range         string   `yaml:"range,omitempty" json:"range,omitempty"`
+}
+
+// SyncDiffOutputDir is the output-dir of the sync-diff.toml, the shards write to its subdirectories
+const SyncDiffOutputDir = "./output"
+
+// SyncDiffShardOutputDir returns the output-dir of the sync-diff-NN.toml shard, numbered from 1
+func SyncDiffShardOutputDir(shard int) string {
+	return fmt.Sprintf("%s/shard-%02d", SyncDiffOutputDir, shard)
+}
+
+// SyncDiffShardConfigName returns the file name of the sync-diff config shard, numbered from 1
+func SyncDiffShardConfigName(shard int) string {
+	return fmt.Sprintf("sync-diff-%02d.toml", shard)
+}
+
+// RenderSyncDiffConfig writes sync-diff.toml comparing every group of the mapping. With more
+// than one shard in the config, the groups are balanced by their estimated rows over
+// sync-diff-01.toml ... sync-diff-NN.toml, each with its own output-dir.
+func RenderSyncDiffConfig(cfg *config.Config, tableMapping *[]mapping.TableInfo, artifacts *Artifacts) error {
+	if cfg == nil {
+		slog.Error("RenderSyncDiffConfig received nil config")
+		return fmt.Errorf("config is nil")
+	}
+	if tableMapping == nil {
+		slog.Error("RenderSyncDiffConfig received nil tableMapping", "configOutput", cfg.Output)
+		return fmt.Errorf("tableMapping is nil")
+	}
+	if cfg.SyncDiff.Shards <= 1 {
+		return renderSyncDiffShard(cfg, tableMapping, "sync-diff.toml", SyncDiffOutputDir, artifacts)
+	}
+
+	// Only the compared groups are balanced, the structure only tables are not checked
+	compared := []mapping.TableInfo{}
+	for _, tableInfo := range *tableMapping {
+		if !tableInfo.StructureOnly && len(tableInfo.DestTableInfo) > 0 {
+			compared = append(compared, tableInfo)
+		}
+	}
+	weights := make([]int64, len(compared))
+	for i, tableInfo := range compared {
+		weights[i] = tableInfo.TotalSize().Rows
+	}
+	for i, indexes := range balanceByWeight(weights, cfg.SyncDiff.Shards) {
+		if len(indexes) == 0 {
+			continue
+		}
+		shard := make([]mapping.TableInfo, 0, len(indexes))
+		var rows int64
+		for _, idx := range indexes {
+			shard = append(shard, compared[idx])
+			rows += weights[idx]
+		}
+		slog.Info("balanced sync diff shard", "shard", i+1, "groupCount", len(shard), "estimatedRows", rows)
+		if err := renderSyncDiffShard(cfg, &shard, SyncDiffShardConfigName(i+1), SyncDiffShardOutputDir(i+1), artifacts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMergedRoute fails the group merging several source tables without SrcRegex. Its route
+// would be built from the first source table and capture that shard only.
+func checkMergedRoute(tableInfo mapping.TableInfo) error {
+	if len(tableInfo.SrcTableInfo) > 1 {
+		return fmt.Errorf("group of %s merges %d source tables but has no SrcRegex, the route would only capture %s",
+			strings.Join(tableInfo.DestTableInfo, ","), len(tableInfo.SrcTableInfo), tableInfo.SrcTableInfo[0])
+	}
+	return nil
+}
+
+// renderSyncDiffShard writes the sync_diff_inspector config comparing the groups of the mapping
+func renderSyncDiffShard(cfg *config.Config, tableMapping *[]mapping.TableInfo, fileName, outputDir string, artifacts *Artifacts) error {
+	slog.Info("starting RenderSyncDiffConfig", "output", cfg.Output, "file", fileName, "sourceDBCount", len(cfg.SourceDB), "tableMappingCount", len(*tableMapping))
+
+	checkThreadCount := cfg.SyncDiff.CheckThreadCount
+	if checkThreadCount <= 0 {
+		checkThreadCount = 10
+	}
+	syncDiffConfig := SyncDiffConfig{
+		CheckThreadCount:     checkThreadCount,
+		ExportFixSQL:         true,
+		CheckDataOnly:        false,
+		CheckStructOnly:      false,
+		SkipNonExistingTable: false,
+		DataSources:          make(map[string]DataSource),
+		Task:                 TaskConfig{},
+		Routes:               make(map[string]RouteRule),
+		TableConfigs:         make(map[string]TableConfig),
+	}
+
+	// 01. Map config to DataSources including the routes for each source instance
+	for _, ds := range cfg.SourceDB {
+		slog.Debug("processing source DB", "dsName", ds.Name, "host", ds.Host, "port", ds.Port)
+
+		// Collect route rules for this data source
+		var routeRules []string
+		for tiIdx, tableInfo := range *tableMapping {
+			// Extract instance name from SrcTableInfo
+			if len(tableInfo.DestTableInfo) == 0 {
+				slog.Warn("tableInfo.DestTableInfo empty, skipping", "tableMappingIdx", tiIdx, "SrcTableInfo", tableInfo.SrcTableInfo)
+				continue
+			}
+			destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+			var ruleName string
+			if len(destParts) > 2 {
+				ruleName = "r_" + destParts[2]
+			} else {
+				slog.Warn("dest table info insufficient parts", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo[0], "SrcTableInfo", tableInfo.SrcTableInfo, "parts", destParts)
+				continue
+			}
+			for _, src := range tableInfo.SrcTableInfo {
+				parts := strings.Split(src, ".")
+				if len(parts) > 0 && parts[0] == ds.Name {
+					routeRules = append(routeRules, ruleName)
+					slog.Debug("matched route rule", "dsName", ds.Name, "ruleName", ruleName, "src", src)
+					break // at least one matched, append and stop checking further
+				} else {
+					slog.Debug("src table instance mismatch", "srcInstance", parts[0], "expectedInstance", ds.Name, "src", src)
+				}
+			}
+		}
+		slog.Info("built route rules for source DB", "dsName", ds.Name, "routeRules", routeRules)
+		syncDiffConfig.DataSources[ds.Name] = DataSource{
+			Host:       ds.Host,
+			Port:       ds.Port,
+			User:       ds.User,
+			Password:   ds.PasswordForOutput(),
+			RouteRules: routeRules,
+			Security:   securityForOutput(ds),
+		}
+	}
+
+	// Map cfg.DestDB (single struct) to DataSources as well
+	syncDiffConfig.DataSources[cfg.DestDB.Name] = DataSource{
+		Host:     cfg.DestDB.Host,
+		Port:     cfg.DestDB.Port,
+		User:     cfg.DestDB.User,
+		Password: cfg.DestDB.PasswordForOutput(),
+		Security: securityForOutput(cfg.DestDB),
+	}
+	slog.Info("added destination DB to DataSources", "destName", cfg.DestDB.Name)
+
+	// 02. Build routing rules from tableMapping
+	for tiIdx, tableInfo := range *tableMapping {
+		if len(tableInfo.DestTableInfo) == 0 {
+			slog.Warn("tableInfo.DestTableInfo empty, skipping rule build", "tableMappingIdx", tiIdx, "SrcTableInfo", tableInfo.SrcTableInfo)
+			continue
+		}
+
+		var schemaPattern, tablePattern string
+		destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+		if len(destParts) < 3 {
+			slog.Warn("dest table info insufficient parts for rule", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo[0], "parts", destParts)
+			continue
+		}
+		routeKey := "r_" + destParts[2]
+		if tableInfo.SrcRegex == "" {
+			if len(tableInfo.SrcTableInfo) == 0 {
+				slog.Warn("tableInfo.SrcTableInfo empty and no SrcRegex", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo)
+				continue
+			}
+			if err := checkMergedRoute(tableInfo); err != nil {
+				return err
+			}
+			parts := strings.Split(tableInfo.SrcTableInfo[0], ".")
+			if len(parts) > 2 {
+				schemaPattern = parts[1]
+				tablePattern = parts[2]
+			} else {
+				slog.Warn("src table info insufficient parts for rule", "tableMappingIdx", tiIdx, "SrcTableInfo", tableInfo.SrcTableInfo[0], "parts", parts)
+				continue
+			}
+		} else {
+			parts := strings.Split(tableInfo.SrcRegex, ".")
+			if len(parts) > 1 {
+				schemaPattern = parts[0]
+				tablePattern = parts[1]
+			} else {
+				slog.Warn("SrcRegex invalid for rule", "tableMappingIdx", tiIdx, "SrcRegex", tableInfo.SrcRegex)
+				continue
+			}
+		}
+		syncDiffConfig.Routes[routeKey] = RouteRule{
+			SchemaPattern: schemaPattern,
+			TablePattern:  tablePattern,
+			TargetSchema:  destParts[1],
+			TargetTable:   destParts[2],
+		}
+		slog.Debug("built route rule", "routeKey", routeKey, "schemaPattern", schemaPattern, "tablePattern", tablePattern, "targetSchema", destParts[1], "targetTable", destParts[2])
+	}
+
+	// 03. Build source-instances list
+	sourceInstances := make([]string, 0, len(cfg.SourceDB))
+	for _, ds := range cfg.SourceDB {
+		sourceInstances = append(sourceInstances, ds.Name)
+	}
+	slog.Info("built source instances list", "sourceInstances", sourceInstances)
+
+	// 04. Build target-check-tables list. The data of the structure only tables is not compared.
+	targetCheckTables := make([]string, 0, len(*tableMapping))
+	for tiIdx, tableInfo := range *tableMapping {
+		if tableInfo.StructureOnly {
+			slog.Debug("skipping structure only table from target check tables", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo)
+			continue
+		}
+		if len(tableInfo.DestTableInfo) > 0 {
+			parts := strings.SplitN(tableInfo.DestTableInfo[0], ".", 3)
+			if len(parts) == 3 {
+				targetCheckTables = append(targetCheckTables, parts[1]+"."+parts[2])
+			} else {
+				slog.Warn("unexpected DestTableInfo format", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo[0], "parts", parts)
+				targetCheckTables = append(targetCheckTables, tableInfo.DestTableInfo[0])
+			}
+		}
+	}
+	slog.Info("built target check tables", "targetCheckTables", targetCheckTables)
+
+	// 05. Set Task field
+	syncDiffConfig.Task = TaskConfig{
+		OutputDir:         outputDir,
+		SourceInstances:   sourceInstances,
+		TargetInstance:    cfg.DestDB.Name,
+		TargetCheckTables: targetCheckTables,
+	}
+	slog.Debug("set Task field", "outputDir", syncDiffConfig.Task.OutputDir, "targetInstance", syncDiffConfig.Task.TargetInstance)
+
+	// 06. Build mapExcludeColumns
+	var mapExcludeColumns = make(map[string][]string)
+	for tiIdx, tbl := range *tableMapping {
+		if len(tbl.DestTableInfo) == 0 {
+			slog.Warn("tbl.DestTableInfo empty, skipping exclude build", "tableMappingIdx", tiIdx, "SrcTableInfo", tbl.SrcTableInfo)
+			continue
+		}
+		destParts := strings.Split(tbl.DestTableInfo[0], ".")
+		if len(destParts) < 3 {
+			slog.Warn("dest table info insufficient parts for exclude", "tableMappingIdx", tiIdx, "DestTableInfo", tbl.DestTableInfo[0], "parts", destParts)
+			continue
+		}
+		schema := destParts[1]
+		table := destParts[2]
+
+		parts := []string{}
+		if tbl.DestHasSource {
+			parts = append(parts, "c_instance")
+		}
+		if tbl.DestHasSchema {
+			parts = append(parts, "c_schema")
+		}
+		if tbl.DestHasTableName {
+			parts = append(parts, "c_table")
+		}
+		if len(parts) == 0 {
+			slog.Debug("no exclude flags set", "tableMappingIdx", tiIdx, "schema", schema, "table", table)
+			continue
+		}
+		key := strings.Join(parts, ",")
+		mapExcludeColumns[key] = append(mapExcludeColumns[key], schema+"."+table)
+		slog.Debug("added exclude entry", "key", key, "schemaTable", schema+"."+table)
+	}
+	slog.Info("built mapExcludeColumns", "mapExcludeColumns", mapExcludeColumns)
+
+	// 07. Loop mapExcludeColumns and prepare the TableConfigs
+	idx := 0
+	for key, tables := range mapExcludeColumns {
+		cfgKey := fmt.Sprintf("cfg_%d", idx)
+		syncDiffConfig.TableConfigs[cfgKey] = TableConfig{
+			TargetTables:  tables,
+			IgnoreColumns: strings.Split(key, ","),
+		}
+		syncDiffConfig.Task.TargetConfigs = append(syncDiffConfig.Task.TargetConfigs, cfgKey)
+		slog.Debug("added TableConfig for exclude", "cfgKey", cfgKey, "tables", tables, "ignoreCols", strings.Split(key, ","))
+		idx++
+	}
+
+	// 08. Loop all tableInfos again and prepare TableConfigs for items with MaxID > 0
+	for tiIdx, tbl := range *tableMapping {
+		if tbl.MaxID <= 0 {
+			continue
+		}
+		if len(tbl.DestTableInfo) == 0 {
+			slog.Warn("tbl.DestTableInfo empty for MaxID entry", "tableMappingIdx", tiIdx, "MaxID", tbl.MaxID)
+			continue
+		}
+		destParts := strings.Split(tbl.DestTableInfo[0], ".")
+		if len(destParts) < 3 {
+			slog.Warn("dest table info insufficient parts for MaxID entry", "tableMappingIdx", tiIdx, "DestTableInfo", tbl.DestTableInfo[0], "MaxID", tbl.MaxID, "parts", destParts)
+			continue
+		}
+		schema := destParts[1]
+		table := destParts[2]
+
+		cfgKey := fmt.Sprintf("range_cfg_%s_%s", schema, table)
+		syncDiffConfig.TableConfigs[cfgKey] = TableConfig{
+			TargetTables: []string{schema + "." + table},
+			Range:        fmt.Sprintf("id <= %d", tbl.MaxID),
+		}
+		syncDiffConfig.Task.TargetConfigs = append(syncDiffConfig.Task.TargetConfigs, cfgKey)
+		slog.Debug("added range TableConfig", "cfgKey", cfgKey, "schema", schema, "table", table, "range", fmt.Sprintf("id <= %d", tbl.MaxID))
+	}
+
+	// 09. Write output file
+	outputPath := cfg.Output
+	if !strings.HasSuffix(outputPath, "/") {
+		outputPath += "/"
+	}
+	outFileName := outputPath + fileName
+	outFile, err := artifacts.Create(outFileName)
+	if err != nil {
+		slog.Error("failed to create output file", "file", outFileName, "error", err)
+		return fmt.Errorf("failed to create output file %s: %w", outFileName, err)
+	}
+	defer outFile.Close()
+
+	tmplBytes, err := readmeFS.ReadFile("templates/diff.tpl.toml")
+	if err != nil {
+		slog.Error("failed to read template file", "template", "templates/diff.tpl.toml", "error", err)
+		return fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	tmpl, err := template.New("diff").Parse(string(tmplBytes))
+	if err != nil {
+		slog.Error("failed to parse template", "error", err)
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	if err := tmpl.Execute(outFile, syncDiffConfig); err != nil {
+		slog.Error("failed to execute template", "file", outFileName, "error", err)
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	slog.Info("successfully rendered sync diff config", "file", outFileName)
+	return nil
+}
+
+func RenderDMSourceConfig(cfg *config.Config, artifacts *Artifacts) error {
+	if cfg == nil {
+		slog.Error("RenderDMSourceConfig received nil config")
+		return fmt.Errorf("config is nil")
+	}
+
+	// Define the data structure for the template
+	type DMTemplateData struct {
+		SourceID        string
+		ServerID        int
+		Flavor          string
+		EnableGTID      bool
+		EnableRelay     bool
+		RelayBinlogName string
+		RelayBinlogGTID string
+		Host            string
+		User            string
+		Password        string
+		Port            int
+		EnableChecker   bool
+		Security        *SecurityConfig
+		Session         map[string]string
+	}
+
+	// Template string
+	const dmTemplate = `source-id: "{{.SourceID}}"
+server-id: {{.ServerID}}
+flavor: "{{with .Flavor}}{{.}}{{else}}mysql{{end}}"
+enable-gtid: {{.EnableGTID}}
+
+# Relay log settings
+enable-relay: {{.EnableRelay}}
+relay-binlog-name: "{{.RelayBinlogName}}"
+relay-binlog-gtid: "{{.RelayBinlogGTID}}"
+
+# Connection details
+from:
+  host: "{{.Host}}"
+  user: "{{.User}}"
+  password: "{{.Password}}"
+  port: {{.Port}}
+{{- with .Security}}
+  security:
+    ssl-ca: "{{.CAPath}}"
+    ssl-cert: "{{.CertPath}}"
+    ssl-key: "{{.KeyPath}}"
+{{- end}}
+{{- if .Session}}
+  session:
+{{- range $name, $value := .Session}}
+    {{$name}}: "{{$value}}"
+{{- end}}
+{{- end}}
+
+# Pre-migration checks
+checker:
+  check-enable: {{.EnableChecker}}
+`
+
+	slog.Info("starting RenderDMSourceConfig", "output", cfg.Output, "sourceDBCount", len(cfg.SourceDB))
+
+	// Loop over each source DB and generate a config file
+	for i, db := range cfg.SourceDB {
+		slog.Debug("processing source DB", "dbName", db.Name, "host", db.Host, "port", db.Port)
+
+		data := DMTemplateData{
+			SourceID:      fmt.Sprintf("mysql-sourcedb-%d", 10000+i),
+			ServerID:      10000 + i,
+			Flavor:        "mysql",
+			EnableGTID:    false,
+			EnableRelay:   false,
+			EnableChecker: true,
+			Host:          db.Host,
+			Port:          db.Port,
+			User:          db.User,
+			Password:      db.DMPasswordForOutput(),
+			Security:      securityForOutput(db),
+			Session:       db.SessionVariables,
+		}
+
+		// Parse and execute the template
+		tmpl, err := template.New("dm").Parse(dmTemplate)
+		if err != nil {
+			slog.Error("failed to parse DM template", "error", err)
+			return fmt.Errorf("failed to parse DM template: %w", err)
+		}
+
+		// Create output file
+		outputPath := cfg.Output
+		if !strings.HasSuffix(outputPath, "/") {
+			outputPath += "/"
+		}
+		outFileName := fmt.Sprintf("%sdm-source-%s.yaml", outputPath, db.Name)
+		slog.Debug("creating DM source config file", "file", outFileName)
+		outFile, err := artifacts.Create(outFileName)
+		if err != nil {
+			slog.Error("failed to create output file", "file", outFileName, "error", err)
+			return fmt.Errorf("failed to create output file %s: %w", outFileName, err)
+		}
+		defer outFile.Close()
+
+		if err := tmpl.Execute(outFile, data); err != nil {
+			slog.Error("failed to execute template", "file", outFileName, "error", err)
+			return fmt.Errorf("failed to execute template for %s: %w", db.Name, err)
+		}
+
+		slog.Info("successfully rendered DM source config", "file", outFileName)
+	}
+
+	slog.Info("completed RenderDMSourceConfig", "output", cfg.Output, "filesGenerated", len(cfg.SourceDB))
+	return nil
+}
+
+// RenderDMTaskConfig writes dm-task.yaml replicating every group of the mapping, or the tasks
+// split by the DMTask config with dm-task-manifest.json listing them
+func RenderDMTaskConfig(cfg *config.Config, tableMapping *[]mapping.TableInfo, artifacts *Artifacts) error {
+	if cfg == nil {
+		slog.Error("RenderDMTaskConfig received nil config")
+		return fmt.Errorf("config is nil")
+	}
+	if tableMapping == nil {
+		slog.Error("RenderDMTaskConfig received nil tableMapping", "configOutput", cfg.Output)
+		return fmt.Errorf("tableMapping is nil")
+	}
+	if !cfg.DMTask.Split() {
+		return renderDMTask(cfg, tableMapping, dmTaskPlan{Name: "dm-task", MetaSchema: "dm_meta", FileName: "dm-task.yaml"}, artifacts)
+	}
+
+	plans, err := planDMTasks(cfg, *tableMapping)
+	if err != nil {
+		slog.Error("failed to split DM task", "error", err)
+		return err
+	}
+	for _, plan := range plans {
+		if err := renderDMTask(cfg, &plan.Groups, plan, artifacts); err != nil {
+			return err
+		}
+	}
+	return writeDMTaskManifest(cfg, plans, artifacts)
+}
+
+// renderDMTask writes the DM task replicating the groups of the mapping. The instances without
+// a table in the groups are left out of a split task.
+func renderDMTask(cfg *config.Config, tableMapping *[]mapping.TableInfo, plan dmTaskPlan, artifacts *Artifacts) error {
+	slog.Info("starting RenderDMTaskConfig", "output", cfg.Output, "task", plan.Name, "sourceDBCount", len(cfg.SourceDB), "tableMappingCount", len(*tableMapping))
+
+	// Define the data structure for the template
+	type DMTaskTemplateData struct {
+		Name       string
+		TaskMode   string
+		IsSharding bool
+		MetaSchema string
+		TargetDB   struct {
+			Host     string
+			Port     int
+			User     string
+			Password string
+			Security *SecurityConfig
+			Session  map[string]string
+		}
+		MySQLInstances []struct {
+			SourceID     string
+			InstanceName string
+			RouteRules   []string
+			FilterRules  []string
+			// ColumnMappingRules apply the pk offsets of the merged shards
+			ColumnMappingRules []string
+		}
+		Validators struct {
+			Mode        string
+			WorkerCount int
+			ErrorDelay  string
+		}
+		AllowList      map[string][]string
+		DoTables       map[string][]config.TableRule
+		IgnoreTables   map[string][]config.TableRule
+		Filters        map[string]DMFilterRule
+		ColumnMappings map[string]DMColumnMappingRule
+		Routes         map[string]RouteRule
+	}
+
+	// Read the template file
+	tmplBytes, err := readmeFS.ReadFile("templates/task.tpl.toml")
+	if err != nil {
+		slog.Error("failed to read template file", "template", "templates/task.tpl.toml", "error", err)
+		return fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	// Prepare template data
+	data := DMTaskTemplateData{
+		Name:       plan.Name,
+		TaskMode:   "incremental",
+		IsSharding: true,
+		MetaSchema: plan.MetaSchema,
+		TargetDB: struct {
+			Host     string
+			Port     int
+			User     string
+			Password string
+			Security *SecurityConfig
+			Session  map[string]string
+		}{
+			Host:     cfg.DestDB.Host,
+			Port:     cfg.DestDB.Port,
+			User:     cfg.DestDB.User,
+			Password: cfg.DestDB.DMPasswordForOutput(),
+			Security: securityForOutput(cfg.DestDB),
+			Session:  cfg.DestDB.SessionVariables,
+		},
+		Validators: struct {
+			Mode        string
+			WorkerCount int
+			ErrorDelay  string
+		}{
+			Mode:        "full",
+			WorkerCount: 4,
+			ErrorDelay:  "30s",
+		},
+		AllowList:      map[string][]string{},
+		DoTables:       map[string][]config.TableRule{},
+		IgnoreTables:   map[string][]config.TableRule{},
+		Filters:        map[string]DMFilterRule{},
+		ColumnMappings: map[string]DMColumnMappingRule{},
+		Routes:         make(map[string]RouteRule),
+	}
+
+	// Build MySQL instances
+	for i, dbConnInfo := range cfg.SourceDB {
+		// The source id stays the index of the instance, the same as the dm-source files
+		if plan.Sources != nil && !containsString(plan.Sources, dbConnInfo.Name) {
+			continue
+		}
+		slog.Debug("processing source DB", "dbName", dbConnInfo.Name, "host", dbConnInfo.Host, "port", dbConnInfo.Port)
+
+		instance := struct {
+			SourceID           string
+			InstanceName       string
+			RouteRules         []string
+			FilterRules        []string
+			ColumnMappingRules []string
+		}{
+			InstanceName: dbConnInfo.Name,
+			SourceID:     fmt.Sprintf("mysql-sourcedb-%d", 10000+i),
+		}
+
+		allowList := []string{}
+		// srcTables are the source tables of the instance, the candidates of the do-tables
+		srcTables := []config.TableRule{}
+
+		// Collect route rules for this instance
+		for tiIdx, tableInfo := range *tableMapping {
+			// 01. Prepare the route name from the regrex and dest table name
+			if tableInfo.SrcRegex == "" {
+				if len(tableInfo.DestTableInfo) == 0 || len(tableInfo.SrcTableInfo) == 0 {
+					slog.Warn("tableInfo.DestTableInfo or SrcTableInfo is empty", "tableMappingIdx", tiIdx, "SrcTableInfo", tableInfo.SrcTableInfo)
+					continue
+				}
+				destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+				srcParts := strings.Split(tableInfo.SrcTableInfo[0], ".")
+				if len(srcParts) > 0 && srcParts[0] == dbConnInfo.Name {
+					if len(destParts) > 2 {
+						ruleName := "r_" + destParts[2]
+						instance.RouteRules = append(instance.RouteRules, ruleName)
+						slog.Debug("added route rule for non-regex table", "dbName", dbConnInfo.Name, "ruleName", ruleName, "tableMappingIdx", tiIdx)
+					} else {
+						slog.Warn("dest table info has insufficient parts (<3)", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo[0], "parts", destParts)
+						continue
+					}
+				}
+			} else {
+				// Loop through each source table info
+				for _, src := range tableInfo.SrcTableInfo {
+					srcParts := strings.Split(src, ".")
+					if len(srcParts) > 0 && srcParts[0] == dbConnInfo.Name {
+						destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+						if len(destParts) > 2 {
+							ruleName := "r_" + destParts[2]
+							instance.RouteRules = append(instance.RouteRules, ruleName)
+							slog.Debug("added route rule for regex table", "dbName", dbConnInfo.Name, "ruleName", ruleName, "src", src)
+						}
+						break // at least one matched, append and stop checking further
+					}
+				}
+			}
+
+			// The structure only tables replicate the DDL but not the DML
+			if tableInfo.StructureOnly {
+				for _, src := range tableInfo.SrcTableInfo {
+					parts := strings.Split(src, ".")
+					if len(parts) != 3 || parts[0] != dbConnInfo.Name {
+						continue
+					}
+					filterName := fmt.Sprintf("f_%s_%s_%s", parts[0], parts[1], parts[2])
+					data.Filters[filterName] = DMFilterRule{
+						SchemaPattern: parts[1],
+						TablePattern:  parts[2],
+						Events:        []string{"all dml"},
+						Action:        "Ignore",
+					}
+					instance.FilterRules = append(instance.FilterRules, filterName)
+					slog.Debug("added structure only filter rule", "dbName", dbConnInfo.Name, "filterName", filterName)
+				}
+			}
+
+			// The incremental replication shifts the primary key of the merged shards by the
+			// same partition id as the dumpling SELECT
+			for _, src := range tableInfo.SrcTableInfo {
+				parts := strings.Split(src, ".")
+				if len(parts) != 3 || parts[0] != dbConnInfo.Name {
+					continue
+				}
+				shard, ok := tableInfo.PKOffset.Shard(src)
+				if !ok {
+					continue
+				}
+				ruleName := fmt.Sprintf("cm_%s_%s_%s", parts[0], parts[1], parts[2])
+				data.ColumnMappings[ruleName] = DMColumnMappingRule{
+					SchemaPattern: parts[1],
+					TablePattern:  parts[2],
+					Expression:    "partition id",
+					SourceColumn:  tableInfo.PKOffset.Column,
+					TargetColumn:  tableInfo.PKOffset.Column,
+					Arguments:     shard.Arguments,
+				}
+				instance.ColumnMappingRules = append(instance.ColumnMappingRules, ruleName)
+				slog.Debug("added pk offset column mapping rule", "dbName", dbConnInfo.Name, "ruleName", ruleName, "offset", shard.Offset)
+			}
+
+			// Loop the tableInfo.SrcTableInfo and add the db name into allowList if it does not exists.
+			// The SrcTableInfo format is instanceName.SchemaName.TableName
+			for _, src := range tableInfo.SrcTableInfo {
+				parts := strings.Split(src, ".")
+				if len(parts) > 1 {
+					instanceName := parts[0]
+					dbName := parts[1]
+					if instanceName != dbConnInfo.Name {
+						continue
+					}
+					if len(parts) == 3 {
+						srcTables = append(srcTables, config.TableRule{Schema: dbName, Table: parts[2]})
+					}
+					found := false
+					for _, existing := range allowList {
+						if existing == dbName {
+							found = true
+							break
+						}
+					}
+					if !found {
+						allowList = append(allowList, dbName)
+						slog.Debug("added db to allowList", "dbName", dbName, "instance", dbConnInfo.Name)
+					}
+				}
+			}
+		}
+
+		data.MySQLInstances = append(data.MySQLInstances, instance)
+		data.AllowList[dbConnInfo.Name] = allowList
+		// Only the included tables of the allowed databases are replicated
+		doTables, err := config.DMDoTables(dbConnInfo.Filter, srcTables)
+		if err != nil {
+			return fmt.Errorf("invalid table filter of %s: %w", dbConnInfo.Name, err)
+		}
+		if len(doTables) > 0 {
+			data.DoTables[dbConnInfo.Name] = doTables
+		}
+		// The excluded tables in the allowed databases must not be replicated either
+		if ignoreTables := config.DMIgnoreTables(dbConnInfo.Filter); len(ignoreTables) > 0 {
+			data.IgnoreTables[dbConnInfo.Name] = ignoreTables
+		}
+		slog.Info("built MySQL instance", "dbName", dbConnInfo.Name, "sourceID", instance.SourceID, "allowList", allowList, "routeRules", instance.RouteRules)
+	}
+
+	// Build routes from tableMapping
+	for tiIdx, tableInfo := range *tableMapping {
+		var schemaPattern, tablePattern string
+		if len(tableInfo.DestTableInfo) == 0 {
+			slog.Warn("tableInfo.DestTableInfo is empty, skipping route build", "tableMappingIdx", tiIdx, "SrcTableInfo", tableInfo.SrcTableInfo)
+			continue
+		}
+		destParts := strings.Split(tableInfo.DestTableInfo[0], ".")
+		if len(destParts) < 3 {
+			slog.Warn("dest table info insufficient parts for route", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo[0], "parts", destParts)
+			continue
+		}
+		routeKey := "r_" + destParts[2]
+		if tableInfo.SrcRegex == "" {
+			if len(tableInfo.SrcTableInfo) == 0 {
+				slog.Warn("tableInfo.SrcTableInfo is empty and no SrcRegex", "tableMappingIdx", tiIdx, "DestTableInfo", tableInfo.DestTableInfo)
+				continue
+			}
+			if err := checkMergedRoute(tableInfo); err != nil {
+				return err
+			}
+			parts := strings.Split(tableInfo.SrcTableInfo[0], ".")
+			if len(parts) > 2 {
+				schemaPattern = parts[1]
+				tablePattern = parts[2]
+			} else {
+				slog.Warn("src table info insufficient parts for route", "tableMappingIdx", tiIdx, "SrcTableInfo", tableInfo.SrcTableInfo[0], "parts", parts)
+				continue
+			}
+		} else {
+			if len(tableInfo.SrcRegex) == 0 {
+				slog.Warn("tableInfo.SrcRegex is invalid", "tableMappingIdx", tiIdx, "SrcRegex", tableInfo.SrcRegex)
+				continue
+			}
+			parts := strings.Split(tableInfo.SrcRegex, ".")
+			if len(parts) > 1 {
+				schemaPattern = parts[0]
+				tablePattern = parts[1]
+			} else {
+				slog.Warn("SrcRegex invalid for route", "tableMappingIdx", tiIdx, "SrcRegex", tableInfo.SrcRegex)
+				continue
+			}
+		}
+		data.Routes[routeKey] = RouteRule{
+			SchemaPattern: schemaPattern,
+			TablePattern:  tablePattern,
+			TargetSchema:  destParts[1],
+			TargetTable:   destParts[2],
+		}
+		slog.Debug("built route", "routeKey", routeKey, "schemaPattern", schemaPattern, "tablePattern", tablePattern, "targetSchema", destParts[1], "targetTable", destParts[2])
+	}
+
+	// Parse the template
+	tmpl, err := template.New("dm-task").Parse(string(tmplBytes))
+	if err != nil {
+		slog.Error("failed to parse template", "error", err)
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	// Create output file
+	outputPath := cfg.Output
+	if !strings.HasSuffix(outputPath, "/") {
+		outputPath += "/"
+	}
+	outFileName := outputPath + plan.FileName
+	outFile, err := artifacts.Create(outFileName)
+	if err != nil {
+		slog.Error("failed to create output file", "file", outFileName, "error", err)
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outFile.Close()
+
+	// Execute the template
+	if err := tmpl.Execute(outFile, data); err != nil {
+		slog.Error("failed to execute template", "file", outFileName, "error", err)
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	slog.Info("successfully rendered DM task", "task", plan.Name, "file", outFileName)
+	return nil
+}
+
+// SetMaxID4IncreDiff sets MaxID of the IncrementalDiffTables so that sync-diff only compares the
+// rows existing before the incremental replication. The max ids are cached in sync-diff-id.txt.
+func SetMaxID4IncreDiff(cfg config.Config, tableStructure []mapping.TableInfo, artifacts *Artifacts) error {
+	slog.Info("starting incremental diff max ID processing", "incrementalDiffTables", cfg.IncrementalDiffTables, "outputDir", cfg.Output)
+
+	outputFile := fmt.Sprintf("%s/sync-diff-id.txt", cfg.Output)
+	if _, err := os.Stat(outputFile); err == nil {
+		// File exists, read it
+		slog.Info("found existing max ID file, reading cached values", "outputFile", outputFile)
+		content, err := os.ReadFile(outputFile)
+		if err != nil {
+			slog.Error("failed to read existing max ID file", "outputFile", outputFile, "error", err)
+			return fmt.Errorf("failed to read file %s: %w", outputFile, err)
+		}
+		slog.Debug("read existing max ID file content", "outputFile", outputFile, "contentLength", len(content))
+		// Parse each line in the content (format: schema.table:maxID)
+		lines := strings.Split(string(content), "\n")
+		parsedCount := 0
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			parts := strings.Split(line, ":")
+			if len(parts) != 2 {
+				slog.Warn("skipping malformed line in max ID file", "line", line, "outputFile", outputFile)
+				continue
+			}
+			schemaTable := parts[0]
+			maxID, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				slog.Error("failed to parse maxID from line", "line", line, "schemaTable", schemaTable, "error", err)
+				continue
+			}
+			parsedCount++
+
+			// Find the matching table in tableStructure and set MaxID
+			matched := false
+			for i := range tableStructure {
+				for _, destTable := range tableStructure[i].DestTableInfo {
+					// Extract schema.table from destTable (instance.schema.table)
+					destParts := strings.Split(destTable, ".")
+					if len(destParts) == 3 {
+						destSchemaTable := fmt.Sprintf("%s.%s", destParts[1], destParts[2])
+						if destSchemaTable == schemaTable {
+							tableStructure[i].MaxID = maxID
+							matched = true
+							slog.Debug("applied cached max ID to table structure", "schemaTable", schemaTable, "maxID", maxID, "destTable", destTable)
+							break
+						}
+					}
+				}
+				if matched {
+					break
+				}
+			}
+			if !matched {
+				slog.Warn("no matching table found for cached max ID", "schemaTable", schemaTable, "maxID", maxID)
+			}
+		}
+		slog.Info("completed loading cached max IDs", "parsedCount", parsedCount, "totalLines", len(lines))
+		return nil
+	} else if errors.Is(err, os.ErrNotExist) {
+		// File does not exist, create it
+		slog.Info("no existing max ID file found, will query databases for max IDs", "outputFile", outputFile)
+	} else {
+		// Some other error
+		slog.Error("error checking max ID file existence", "outputFile", outputFile, "error", err)
+		return fmt.Errorf("error checking file %s: %w", outputFile, err)
+	}
+	mapMaxIDs := make(map[string]int64)
+
+	// Loop through tableStructure and find items whose target table is in IncrementalDiffTables
+	tablesProcessed := 0
+	queriesExecuted := 0
+	matchedIncTables := 0
+	slog.Debug("starting incremental diff table matching", "totalTableStructures", len(tableStructure), "incrementalDiffTables", cfg.IncrementalDiffTables)
+
+	for i := range tableStructure {
+		tableInfo := &tableStructure[i]
+		slog.Debug("checking table structure", "index", i, "destTableCount", len(tableInfo.DestTableInfo), "srcTableCount", len(tableInfo.SrcTableInfo))
+
+		for _, destTable := range tableInfo.DestTableInfo {
+			// Extract SchemaName.TableName from destTable (targetName.SchemaName.TableName)
+			destParts := strings.Split(destTable, ".")
+			if len(destParts) != 3 {
+				slog.Warn("skipping destTable with unexpected format", "destTable", destTable, "parts", len(destParts))
+				continue
+			}
+			destSchemaTable := fmt.Sprintf("%s.%s", destParts[1], destParts[2])
+			slog.Debug("extracted destSchemaTable", "destTable", destTable, "destSchemaTable", destSchemaTable)
+
+			for _, incTable := range cfg.IncrementalDiffTables {
+				slog.Debug("comparing with incremental diff table", "destSchemaTable", destSchemaTable, "incTable", incTable)
+				if destSchemaTable == incTable {
+					matchedIncTables++
+					tablesProcessed++
+					slog.Info("matched incremental diff table", "destSchemaTable", destSchemaTable, "incTable", incTable, "matchedCount", matchedIncTables)
+
+					for j, srcTable := range tableInfo.SrcTableInfo {
+						parts := strings.Split(srcTable, ".")
+						if len(parts) == 3 {
+							instance := parts[0]
+							schemaTable := fmt.Sprintf("%s.%s", parts[1], parts[2])
+							slog.Debug("processing source table for max ID", "index", j, "srcTable", srcTable, "instance", instance, "schemaTable", schemaTable)
+
+							// Find the DB config for this instance
+							var dbConfig *config.DBConnInfo
+							for _, srcDB := range cfg.SourceDB {
+								if srcDB.Name == instance {
+									dbConfig = &srcDB
+									break
+								}
+							}
+							if dbConfig != nil {
+								slog.Debug("found DB config for instance", "instance", instance, "dbHost", dbConfig.Host, "dbPort", dbConfig.Port, "dbName", dbConfig.DBs[0])
+								// Open DB connection
+								db, err := schema.OpenDB(*dbConfig, dbConfig.DBs[0])
+								if err != nil {
+									slog.Error("failed to connect to DB for max ID query", "instance", instance, "schemaTable", schemaTable, "error", err)
+									continue
+								}
+								// Note: defer in loop is generally discouraged, but acceptable here due to limited iteration count
+								defer db.Close()
+								slog.Debug("successfully connected to DB", "instance", instance, "schemaTable", schemaTable)
+
+								// Run the query: select max(id) from schemaTable
+								var maxID sql.NullInt64
+								query := fmt.Sprintf("SELECT MAX(id) FROM %s", schemaTable)
+								slog.Debug("executing max ID query", "instance", instance, "schemaTable", schemaTable, "query", query)
+								err = db.QueryRow(query).Scan(&maxID)
+								queriesExecuted++
+								if err != nil {
+									slog.Error("failed to get max id from table", "instance", instance, "schemaTable", schemaTable, "query", query, "error", err)
+									continue
+								}
+								slog.Debug("query result", "instance", instance, "schemaTable", schemaTable, "maxID.Valid", maxID.Valid, "maxID.Int64", maxID.Int64)
+								if maxID.Valid {
+									slog.Info("retrieved max ID from source table", "instance", instance, "schemaTable", schemaTable, "maxID", maxID.Int64)
+									if tableInfo.MaxID < maxID.Int64 {
+										oldMaxID := tableInfo.MaxID
+										tableInfo.MaxID = maxID.Int64
+										mapMaxIDs[destSchemaTable] = maxID.Int64
+										slog.Info("updated max ID for incremental diff table", "destSchemaTable", destSchemaTable, "oldMaxID", oldMaxID, "newMaxID", maxID.Int64, "instance", instance, "schemaTable", schemaTable)
+									} else {
+										slog.Debug("existing max ID is greater or equal", "destSchemaTable", destSchemaTable, "existingMaxID", tableInfo.MaxID, "newMaxID", maxID.Int64)
+									}
+								} else {
+									slog.Warn("no rows found in source table for max ID query", "instance", instance, "schemaTable", schemaTable, "query", query)
+								}
+							} else {
+								slog.Warn("no DB config found for instance", "instance", instance, "destSchemaTable", destSchemaTable, "availableInstances", func() []string {
+									var names []string
+									for _, db := range cfg.SourceDB {
+										names = append(names, db.Name)
+									}
+									return names
+								}())
+							}
+						} else {
+							slog.Warn("skipping source table with unexpected format", "srcTable", srcTable, "parts", len(parts))
+						}
+					}
+					break
+				} else {
+					slog.Debug("destSchemaTable does not match incTable", "destSchemaTable", destSchemaTable, "incTable", incTable)
+				}
+			}
+		}
+	}
+	slog.Info("completed incremental diff table processing", "tablesProcessed", tablesProcessed, "matchedIncTables", matchedIncTables, "queriesExecuted", queriesExecuted, "mapMaxIDsSize", len(mapMaxIDs))
+
+	slog.Info("completed max ID queries", "tablesProcessed", tablesProcessed, "queriesExecuted", queriesExecuted, "maxIDsCollected", len(mapMaxIDs))
+
+	// Write the mapMaxIDs to the output file
+	file, err := artifacts.Create(outputFile)
+	if err != nil {
+		slog.Error("failed to create max ID output file", "outputFile", outputFile, "error", err)
+		return fmt.Errorf("failed to create file %s: %w", outputFile, err)
+	}
+	defer file.Close()
+
+	writtenCount := 0
+	for table, maxID := range mapMaxIDs {
+		_, err := fmt.Fprintf(file, "%s:%d\n", table, maxID)
+		if err != nil {
+			slog.Error("failed to write max ID to file", "outputFile", outputFile, "table", table, "maxID", maxID, "error", err)
+			return fmt.Errorf("failed to write to file %s: %w", outputFile, err)
+		}
+		writtenCount++
+		slog.Debug("wrote max ID to file", "table", table, "maxID", maxID)
+	}
+
+	slog.Info("successfully wrote max IDs to file", "outputFile", outputFile, "writtenCount", writtenCount)
+	return nil
+}
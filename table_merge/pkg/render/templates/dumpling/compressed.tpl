@@ -0,0 +1,2 @@
+# gzip compressed CSV files with the column header
+dumpling -h ${DBHOST} -P ${DBPORT} -u ${DBUSER} -p "${DBPASSWORD}" --threads 4 {{.SourceData}} --output-filename-template '{{.DestTable}}' --filetype csv --compress gzip -o "${DUMPLING_OUTPUT}"
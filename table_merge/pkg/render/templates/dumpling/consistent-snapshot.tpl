@@ -0,0 +1,2 @@
+# CSV files exported from the TiDB snapshot DUMPLING_SNAPSHOT(TSO or datetime) for consistent data across the shards
+dumpling -h ${DBHOST} -P ${DBPORT} -u ${DBUSER} -p "${DBPASSWORD}" --threads 4 {{.SourceData}} --output-filename-template '{{.DestTable}}' --filetype csv --consistency snapshot --snapshot "${DUMPLING_SNAPSHOT}" -o "${DUMPLING_OUTPUT}"
@@ -0,0 +1,2 @@
+# CSV files with the column header
+dumpling -h ${DBHOST} -P ${DBPORT} -u ${DBUSER} -p "${DBPASSWORD}" --threads 4 {{.SourceData}} --output-filename-template '{{.DestTable}}' --filetype csv -o "${DUMPLING_OUTPUT}"
@@ -0,0 +1,2 @@
+# SQL INSERT statements
+dumpling -h ${DBHOST} -P ${DBPORT} -u ${DBUSER} -p "${DBPASSWORD}" --threads 4 {{.SourceData}} --output-filename-template '{{.DestTable}}' --filetype sql -o "${DUMPLING_OUTPUT}"
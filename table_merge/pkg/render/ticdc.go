@@ -0,0 +1,310 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+// defaultReverseSinkURI is the sink of the reverse changefeeds if the config has none. A kafka
+// consumer writes the rows into the shards by their origin columns.
+const defaultReverseSinkURI = "kafka://${KAFKA_ADDR}/dm-toolkit-reverse-{instance}?protocol=canal-json"
+
+// ReverseIssue is a group whose rows cannot be routed back to the source shards
+type ReverseIssue struct {
+	DestTable string
+	SrcTables []string
+	Reason    string
+}
+
+// reverseEventFilter keeps the rows of one instance in the changefeed of the instance
+type reverseEventFilter struct {
+	Matcher string
+	Expr    string
+}
+
+// reverseDispatcher partitions the rows of a merged table by their origin columns
+type reverseDispatcher struct {
+	Matcher string
+	Columns []string
+}
+
+// reverseChangefeed is the TiCDC changefeed replicating the destination back to one instance
+type reverseChangefeed struct {
+	ID           string
+	InstanceName string
+	SinkURI      string
+	// Tables are the destination schema.table replicated back
+	Tables       []string
+	EventFilters []reverseEventFilter
+	Dispatchers  []reverseDispatcher
+}
+
+var changefeedIDPattern = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// reverseChangefeedID returns the changefeed id of the instance, TiCDC only accepts
+// alphanumerics separated by -
+func reverseChangefeedID(instanceName string) string {
+	return "dm-toolkit-reverse-" + strings.Trim(changefeedIDPattern.ReplaceAllString(instanceName, "-"), "-")
+}
+
+// isMySQLSink reports whether the changefeed writes to MySQL directly. The MySQL sink writes
+// a row to the table of the same name, it cannot route the rows by their column values.
+func isMySQLSink(sinkURI string) bool {
+	return strings.HasPrefix(sinkURI, "mysql://") || strings.HasPrefix(sinkURI, "mysql+ssl://") ||
+		strings.HasPrefix(sinkURI, "tidb://") || strings.HasPrefix(sinkURI, "tidb+ssl://")
+}
+
+// reversePair is one destination table with the source tables it is routed back to
+type reversePair struct {
+	tableInfo mapping.TableInfo
+	destTable string
+	srcTables []string
+}
+
+// reversePairs splits the group into the destination tables, the many-to-many groups are
+// paired by the table name like the dumpling exports
+func reversePairs(tableInfo mapping.TableInfo) []reversePair {
+	if len(tableInfo.DestTableInfo) == 1 {
+		return []reversePair{{tableInfo: tableInfo, destTable: tableInfo.DestTableInfo[0], srcTables: tableInfo.SrcTableInfo}}
+	}
+	pairs := []reversePair{}
+	if len(tableInfo.SrcTableInfo) != len(tableInfo.DestTableInfo) {
+		return pairs
+	}
+	for _, src := range tableInfo.SrcTableInfo {
+		srcParts := strings.Split(src, ".")
+		for _, dest := range tableInfo.DestTableInfo {
+			destParts := strings.Split(dest, ".")
+			if srcParts[len(srcParts)-1] == destParts[len(destParts)-1] {
+				pairs = append(pairs, reversePair{tableInfo: tableInfo, destTable: dest, srcTables: []string{src}})
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// reverseRoute returns the origin columns telling the shards of the pair apart, or the reason
+// the rows cannot be routed back
+func reverseRoute(pair reversePair, mysqlSink bool) ([]string, string) {
+	instances, schemas, tables := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, src := range pair.srcTables {
+		parts := strings.Split(src, ".")
+		if len(parts) != 3 {
+			return nil, fmt.Sprintf("unexpected source table %s, expected instance.schema.table", src)
+		}
+		instances[parts[0]], schemas[parts[1]], tables[parts[2]] = true, true, true
+	}
+	destParts := strings.SplitN(pair.destTable, ".", 2)
+
+	if len(pair.srcTables) == 1 {
+		srcParts := strings.SplitN(pair.srcTables[0], ".", 2)
+		if mysqlSink && len(destParts) == 2 && srcParts[1] != destParts[1] {
+			return nil, fmt.Sprintf("the MySQL sink writes the rows to %s, not to the renamed source table %s", destParts[1], srcParts[1])
+		}
+		return nil, ""
+	}
+
+	columns, missing := []string{}, []string{}
+	for _, origin := range []struct {
+		column   string
+		distinct int
+		present  bool
+	}{
+		{"c_instance", len(instances), pair.tableInfo.DestHasSource},
+		{"c_schema", len(schemas), pair.tableInfo.DestHasSchema},
+		{"c_table", len(tables), pair.tableInfo.DestHasTableName},
+	} {
+		if origin.distinct <= 1 {
+			continue
+		}
+		if !origin.present {
+			missing = append(missing, origin.column)
+			continue
+		}
+		columns = append(columns, origin.column)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Sprintf("the shards differ by %s but the destination has no such column", strings.Join(missing, ", "))
+	}
+	if mysqlSink {
+		return nil, "the MySQL sink cannot route the merged rows by their origin columns, use an MQ sink"
+	}
+	return columns, ""
+}
+
+// buildReverseChangefeeds returns the changefeed of every instance with a table to replicate
+// back, and the groups which cannot be routed back
+func buildReverseChangefeeds(cfg *config.Config, tableMapping []mapping.TableInfo) ([]reverseChangefeed, []ReverseIssue) {
+	sinkURI := cfg.TiCDC.SinkURI
+	if sinkURI == "" {
+		sinkURI = defaultReverseSinkURI
+	}
+	mysqlSink := isMySQLSink(sinkURI)
+
+	changefeeds := map[string]*reverseChangefeed{}
+	for _, dbInfo := range cfg.SourceDB {
+		changefeeds[dbInfo.Name] = &reverseChangefeed{
+			ID:           reverseChangefeedID(dbInfo.Name),
+			InstanceName: dbInfo.Name,
+			SinkURI:      strings.ReplaceAll(sinkURI, "{instance}", dbInfo.Name),
+		}
+	}
+
+	issues := []ReverseIssue{}
+	for _, tableInfo := range tableMapping {
+		// The structure only tables have no data to roll back
+		if tableInfo.StructureOnly || len(tableInfo.SrcTableInfo) == 0 || len(tableInfo.DestTableInfo) == 0 {
+			continue
+		}
+		for _, pair := range reversePairs(tableInfo) {
+			destParts := strings.SplitN(pair.destTable, ".", 2)
+			if len(destParts) != 2 {
+				continue
+			}
+			destTable := destParts[1]
+			columns, reason := reverseRoute(pair, mysqlSink)
+			if reason != "" {
+				slog.Warn("reverse routing impossible", "destTable", pair.destTable, "srcTables", pair.srcTables, "reason", reason)
+				issues = append(issues, ReverseIssue{DestTable: pair.destTable, SrcTables: pair.srcTables, Reason: reason})
+				continue
+			}
+
+			instances := []string{}
+			for _, src := range pair.srcTables {
+				instance := strings.Split(src, ".")[0]
+				if !containsString(instances, instance) {
+					instances = append(instances, instance)
+				}
+			}
+			dispatchColumns := []string{}
+			for _, column := range columns {
+				// c_instance is the same in the changefeed of an instance
+				if column != "c_instance" {
+					dispatchColumns = append(dispatchColumns, column)
+				}
+			}
+			for _, instance := range instances {
+				changefeed, ok := changefeeds[instance]
+				if !ok {
+					slog.Warn("source instance not in the config, skipping reverse replication", "instance", instance, "destTable", pair.destTable)
+					continue
+				}
+				changefeed.Tables = append(changefeed.Tables, destTable)
+				if len(instances) > 1 {
+					changefeed.EventFilters = append(changefeed.EventFilters, reverseEventFilter{
+						Matcher: destTable,
+						Expr:    fmt.Sprintf("c_instance != '%s'", instance),
+					})
+				}
+				if len(dispatchColumns) > 0 {
+					changefeed.Dispatchers = append(changefeed.Dispatchers, reverseDispatcher{Matcher: destTable, Columns: dispatchColumns})
+				}
+			}
+		}
+	}
+
+	result := []reverseChangefeed{}
+	for _, dbInfo := range cfg.SourceDB {
+		changefeed := changefeeds[dbInfo.Name]
+		if len(changefeed.Tables) == 0 {
+			slog.Info("no table to replicate back", "instance", dbInfo.Name)
+			continue
+		}
+		sort.Strings(changefeed.Tables)
+		result = append(result, *changefeed)
+	}
+	return result, issues
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderTiCDCReverse writes the TiCDC changefeed configs replicating the destination back to
+// every source instance, ticdc-reverse-<instance>.toml, and ticdc-reverse.sh creating them.
+// The groups whose rows cannot be routed back to their shards are returned.
+func RenderTiCDCReverse(cfg *config.Config, tableMapping *[]mapping.TableInfo, artifacts *Artifacts) ([]ReverseIssue, error) {
+	if cfg == nil {
+		slog.Error("RenderTiCDCReverse received nil config")
+		return nil, fmt.Errorf("config is nil")
+	}
+	if tableMapping == nil {
+		slog.Error("RenderTiCDCReverse received nil tableMapping", "configOutput", cfg.Output)
+		return nil, fmt.Errorf("tableMapping is nil")
+	}
+
+	tmplBytes, err := readmeFS.ReadFile("templates/ticdc-reverse.tpl.toml")
+	if err != nil {
+		slog.Error("failed to read template file", "template", "templates/ticdc-reverse.tpl.toml", "error", err)
+		return nil, fmt.Errorf("failed to read template file: %w", err)
+	}
+	tmpl, err := template.New("ticdc-reverse").Parse(string(tmplBytes))
+	if err != nil {
+		slog.Error("failed to parse template", "error", err)
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	changefeeds, issues := buildReverseChangefeeds(cfg, *tableMapping)
+	outputPath := cfg.Output
+	if !strings.HasSuffix(outputPath, "/") {
+		outputPath += "/"
+	}
+
+	script := &strings.Builder{}
+	script.WriteString(`#!/bin/bash
+
+export TICDC_SERVER=
+export KAFKA_ADDR=
+# START_TS is the TSO the traffic was switched to TiDB at, the current time if empty
+export START_TS=
+
+`)
+	for _, changefeed := range changefeeds {
+		fileName := fmt.Sprintf("ticdc-reverse-%s.toml", changefeed.InstanceName)
+		outFile, err := artifacts.Create(outputPath + fileName)
+		if err != nil {
+			slog.Error("failed to create output file", "file", outputPath+fileName, "error", err)
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		if err := tmpl.Execute(outFile, changefeed); err != nil {
+			outFile.Close()
+			slog.Error("failed to execute template", "file", outputPath+fileName, "error", err)
+			return nil, fmt.Errorf("failed to execute template: %w", err)
+		}
+		if err := outFile.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close %s: %w", outputPath+fileName, err)
+		}
+		fmt.Fprintf(script, "cdc cli changefeed create --server=\"${TICDC_SERVER}\" --changefeed-id=\"%s\" --sink-uri=\"%s\" --config=\"$(dirname \"$0\")/%s\" ${START_TS:+--start-ts=\"${START_TS}\"}\n",
+			changefeed.ID, changefeed.SinkURI, fileName)
+		slog.Info("rendered reverse changefeed", "instance", changefeed.InstanceName, "tableCount", len(changefeed.Tables), "file", outputPath+fileName)
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(script, "# Not replicated back, %s: %s\n", issue.DestTable, issue.Reason)
+	}
+
+	scriptPath := outputPath + "ticdc-reverse.sh"
+	scriptFile, err := artifacts.Create(scriptPath)
+	if err != nil {
+		slog.Error("failed to create output file", "file", scriptPath, "error", err)
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer scriptFile.Close()
+	if _, err := io.WriteString(scriptFile, script.String()); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", scriptPath, err)
+	}
+	return issues, nil
+}
@@ -0,0 +1,129 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+func reverseConfig(t *testing.T, sinkURI string) *config.Config {
+	return &config.Config{
+		Output:   t.TempDir(),
+		SourceDB: []config.DBConnInfo{{Name: "mysql01"}, {Name: "mysql02"}},
+		DestDB:   config.DBConnInfo{Name: "target"},
+		TiCDC:    config.TiCDCConfig{SinkURI: sinkURI},
+	}
+}
+
+func Test_RenderTiCDCReverse(t *testing.T) {
+	cfg := reverseConfig(t, "")
+	tableMapping := &[]mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"mysql01.db_00.orders", "mysql01.db_01.orders", "mysql02.db_02.orders"},
+			DestTableInfo: []string{"target.messagedb.orders"},
+			DestHasSource: true,
+			DestHasSchema: true,
+		},
+		{
+			SrcTableInfo:  []string{"mysql02.db_02.users"},
+			DestTableInfo: []string{"target.messagedb.users"},
+		},
+		{
+			SrcTableInfo:  []string{"mysql01.db_00.audit", "mysql01.db_01.audit"},
+			DestTableInfo: []string{"target.messagedb.audit"},
+		},
+	}
+	issues, err := RenderTiCDCReverse(cfg, tableMapping, nil)
+	if err != nil {
+		t.Fatalf("RenderTiCDCReverse() error = %v", err)
+	}
+	if len(issues) != 1 || issues[0].DestTable != "target.messagedb.audit" || !strings.Contains(issues[0].Reason, "c_schema") {
+		t.Errorf("issues = %+v, want the missing c_schema of messagedb.audit", issues)
+	}
+
+	files := map[string][]string{
+		"ticdc-reverse-mysql01.toml": {
+			`rules = ['messagedb.orders']`,
+			`ignore-insert-value-expr = "c_instance != 'mysql01'"`,
+			`{matcher = ['messagedb.orders'], partition = "columns", columns = ['c_schema']}`,
+		},
+		"ticdc-reverse-mysql02.toml": {
+			`rules = ['messagedb.orders', 'messagedb.users']`,
+			`ignore-delete-value-expr = "c_instance != 'mysql02'"`,
+		},
+		"ticdc-reverse.sh": {
+			`--changefeed-id="dm-toolkit-reverse-mysql01" --sink-uri="kafka://${KAFKA_ADDR}/dm-toolkit-reverse-mysql01?protocol=canal-json"`,
+			`--config="$(dirname "$0")/ticdc-reverse-mysql02.toml"`,
+			`# Not replicated back, target.messagedb.audit`,
+		},
+	}
+	for name, wants := range files {
+		content, err := os.ReadFile(filepath.Join(cfg.Output, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("%s does not contain %s:\n%s", name, want, content)
+			}
+		}
+	}
+	content, _ := os.ReadFile(filepath.Join(cfg.Output, "ticdc-reverse-mysql02.toml"))
+	if strings.Contains(string(content), "messagedb.users'], partition") || strings.Contains(string(content), "matcher = ['messagedb.users']") {
+		t.Errorf("the one-to-one table is filtered or dispatched:\n%s", content)
+	}
+}
+
+func Test_RenderTiCDCReverseMySQLSink(t *testing.T) {
+	cfg := reverseConfig(t, "mysql://root@{instance}:3306/")
+	tableMapping := &[]mapping.TableInfo{
+		{
+			SrcTableInfo:  []string{"mysql01.db_00.orders", "mysql02.db_00.orders"},
+			DestTableInfo: []string{"target.db_00.orders"},
+			DestHasSource: true,
+		},
+		{
+			SrcTableInfo:  []string{"mysql01.db_00.users"},
+			DestTableInfo: []string{"target.db_00.users"},
+		},
+		{
+			SrcTableInfo:  []string{"mysql02.db_01.audit"},
+			DestTableInfo: []string{"target.messagedb.audit"},
+		},
+	}
+	issues, err := RenderTiCDCReverse(cfg, tableMapping, nil)
+	if err != nil {
+		t.Fatalf("RenderTiCDCReverse() error = %v", err)
+	}
+	reasons := map[string]string{}
+	for _, issue := range issues {
+		reasons[issue.DestTable] = issue.Reason
+	}
+	if !strings.Contains(reasons["target.db_00.orders"], "MySQL sink cannot route") {
+		t.Errorf("merged group issue = %q", reasons["target.db_00.orders"])
+	}
+	if !strings.Contains(reasons["target.messagedb.audit"], "renamed source table db_01.audit") {
+		t.Errorf("renamed table issue = %q", reasons["target.messagedb.audit"])
+	}
+	if _, ok := reasons["target.db_00.users"]; ok || len(issues) != 2 {
+		t.Errorf("issues = %+v, want the merged and the renamed table", issues)
+	}
+
+	script, err := os.ReadFile(filepath.Join(cfg.Output, "ticdc-reverse.sh"))
+	if err != nil {
+		t.Fatalf("failed to read ticdc-reverse.sh: %v", err)
+	}
+	if !strings.Contains(string(script), `--sink-uri="mysql://root@mysql01:3306/"`) || strings.Contains(string(script), "ticdc-reverse-mysql02.toml") {
+		t.Errorf("ticdc-reverse.sh:\n%s", script)
+	}
+}
+
+func Test_reverseChangefeedID(t *testing.T) {
+	if got := reverseChangefeedID("aurora_01.prod"); got != "dm-toolkit-reverse-aurora-01-prod" {
+		t.Errorf("reverseChangefeedID() = %s", got)
+	}
+}
@@ -0,0 +1,194 @@
+package rules
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// chatProvider is the chat completion API of the LLM, *openai.Client implements it
+type chatProvider interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+// llmRound is one completion of the conversation and the rule_is_valid results of its answer
+type llmRound struct {
+	Response    openai.ChatCompletionMessage `json:"response"`
+	ToolResults []ToolReturn                 `json:"tool_results,omitempty"`
+}
+
+// llmConversation is the conversation generating the rule of one data list. One conversation
+// is one line of the recording.
+type llmConversation struct {
+	Key            string                         `json:"key"`
+	RecordedAt     time.Time                      `json:"recorded_at"`
+	Model          string                         `json:"model"`
+	DataList       []string                       `json:"data_list"`
+	ShouldNotMatch []string                       `json:"should_not_match"`
+	Messages       []openai.ChatCompletionMessage `json:"messages"`
+	Rounds         []llmRound                     `json:"rounds"`
+	Rule           string                         `json:"rule,omitempty"`
+	Error          string                         `json:"error,omitempty"`
+
+	provider chatProvider
+	// replay is the recorded conversation answered instead of the provider
+	replay *llmConversation
+	// recordFile is the JSONL file the conversation is appended to when it finishes
+	recordFile string
+}
+
+// conversationKey identifies the conversation of the data list in the recording
+func conversationKey(dataList, shouldNotMatch []string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(dataList, ",")+"|"+strings.Join(shouldNotMatch, ","))))
+}
+
+// loadLLMRecording reads the recorded conversations by key. The last recording of a key wins.
+func loadLLMRecording(path string) (map[string]*llmConversation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open LLM recording: %w", err)
+	}
+	defer file.Close()
+
+	conversations := map[string]*llmConversation{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var conversation llmConversation
+		if err := json.Unmarshal(scanner.Bytes(), &conversation); err != nil {
+			return nil, fmt.Errorf("invalid LLM recording %s line %d: %w", path, line, err)
+		}
+		if conversation.Key == "" {
+			conversation.Key = conversationKey(conversation.DataList, conversation.ShouldNotMatch)
+		}
+		conversations[conversation.Key] = &conversation
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read LLM recording %s: %w", path, err)
+	}
+	return conversations, nil
+}
+
+// newLLMConversation starts the conversation for the data list. With ReplayFile the recorded
+// answers are replayed without network, otherwise the LLMProduct is called.
+func (s *Synthesizer) newLLMConversation(dataList, shouldNotMatch []string) (*llmConversation, error) {
+	conversation := &llmConversation{
+		Key:            conversationKey(dataList, shouldNotMatch),
+		RecordedAt:     time.Now().UTC(),
+		DataList:       dataList,
+		ShouldNotMatch: shouldNotMatch,
+		recordFile:     s.opts.RecordFile,
+	}
+
+	if s.opts.ReplayFile != "" {
+		if s.replay == nil {
+			conversations, err := loadLLMRecording(s.opts.ReplayFile)
+			if err != nil {
+				return nil, err
+			}
+			s.replay = conversations
+		}
+		replay, ok := s.replay[conversation.Key]
+		if !ok {
+			return nil, fmt.Errorf("no recorded conversation for the data list in %s, key %s", s.opts.ReplayFile, conversation.Key)
+		}
+		slog.Debug("replaying recorded LLM conversation", "key", conversation.Key, "rounds", len(replay.Rounds))
+		conversation.replay = replay
+		conversation.Model = replay.Model
+		return conversation, nil
+	}
+
+	if s.opts.LLMProduct == "deepseek" {
+		config := openai.DefaultConfig(os.Getenv("DEEPSEEK_API_KEY"))
+		config.BaseURL = "https://api.deepseek.com/v1"
+		conversation.provider = openai.NewClientWithConfig(config)
+		conversation.Model = "deepseek-chat"
+		slog.Debug("deepseek client initialized", "baseURL", config.BaseURL, "model", conversation.Model)
+	} else {
+		conversation.provider = openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+		conversation.Model = openai.GPT3Dot5Turbo
+		slog.Debug("openai client initialized", "model", conversation.Model)
+	}
+	return conversation, nil
+}
+
+// complete sends the messages and returns the answer of the assistant
+func (c *llmConversation) complete(messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error) {
+	if len(c.Messages) == 0 {
+		c.Messages = append([]openai.ChatCompletionMessage{}, messages...)
+	}
+
+	var assistant openai.ChatCompletionMessage
+	if c.replay != nil {
+		if len(c.Rounds) >= len(c.replay.Rounds) {
+			return assistant, fmt.Errorf("recorded conversation %s has only %d round(s)", c.Key, len(c.replay.Rounds))
+		}
+		assistant = c.replay.Rounds[len(c.Rounds)].Response
+	} else {
+		resp, err := c.provider.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:       c.Model,
+			Messages:    messages,
+			Temperature: 0.7,
+			Tools:       tools,
+		})
+		if err != nil {
+			return assistant, err
+		}
+		if len(resp.Choices) == 0 {
+			return assistant, fmt.Errorf("no choice in the chat completion response")
+		}
+		assistant = resp.Choices[0].Message
+	}
+	c.Rounds = append(c.Rounds, llmRound{Response: assistant})
+	return assistant, nil
+}
+
+// addToolResult keeps the rule_is_valid result of the last answer
+func (c *llmConversation) addToolResult(result ToolReturn) {
+	if len(c.Rounds) == 0 {
+		return
+	}
+	round := &c.Rounds[len(c.Rounds)-1]
+	round.ToolResults = append(round.ToolResults, result)
+}
+
+// finish keeps the outcome and appends the conversation to the record file
+func (c *llmConversation) finish(rule *string, err error) {
+	if rule != nil {
+		c.Rule = *rule
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	if c.recordFile == "" {
+		return
+	}
+
+	line, merr := json.Marshal(c)
+	if merr != nil {
+		slog.Warn("failed to encode LLM conversation", "error", merr)
+		return
+	}
+	file, ferr := os.OpenFile(c.recordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		slog.Warn("failed to open LLM recording", "error", ferr, "path", c.recordFile)
+		return
+	}
+	defer file.Close()
+	if _, werr := file.Write(append(line, '\n')); werr != nil {
+		slog.Warn("failed to write LLM recording", "error", werr, "path", c.recordFile)
+		return
+	}
+	slog.Debug("recorded LLM conversation", "key", c.Key, "rounds", len(c.Rounds), "path", c.recordFile)
+}
@@ -0,0 +1,668 @@
+// Package rules synthesizes the schema and table patterns routing the source tables of a group
+// to the destination table. The patterns are generated by the LLM and validated locally by the
+// table-rule-selector DM and sync_diff_inspector use.
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	selector "github.com/pingcap/tidb/pkg/util/table-rule-selector"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Options configures the LLM of the Synthesizer
+type Options struct {
+	// LLMProduct is openai or deepseek, the placeholder pattern is generated if it is empty
+	LLMProduct string
+	// RecordFile is the JSONL file every regex conversation is appended to
+	RecordFile string
+	// ReplayFile is the JSONL recording answered instead of the LLM
+	ReplayFile string
+}
+
+// Synthesizer generates the patterns. The patterns of the same db or table list are cached so
+// that the LLM is asked once per list.
+type Synthesizer struct {
+	opts     Options
+	patterns map[string]string
+	// replay is the loaded recording of opts.ReplayFile
+	replay map[string]*llmConversation
+}
+
+// New returns the Synthesizer of the options
+func New(opts Options) *Synthesizer {
+	return &Synthesizer{opts: opts, patterns: map[string]string{}}
+}
+
+// AssignSrcRegex sets SrcRegex of the groups with more than one source table. The route of a
+// merged group must capture all its shards, so a group without a valid pattern fails instead
+// of being routed by its first source table. The other groups are still processed, the error
+// lists the failed groups.
+func (s *Synthesizer) AssignSrcRegex(tableStructure []mapping.TableInfo) error {
+	slog.Info("starting regex generation for table consolidations", "totalTableStructures", len(tableStructure))
+	failed := []string{}
+	for idx := range tableStructure {
+		if len(tableStructure[idx].SrcTableInfo) < 2 {
+			continue
+		}
+		slog.Debug("processing table structure for regex generation",
+			"index", idx,
+			"srcTableCount", len(tableStructure[idx].SrcTableInfo),
+			"destTableCount", len(tableStructure[idx].DestTableInfo),
+			"md5Columns", tableStructure[idx].MD5Columns)
+
+		// Get all source tables except the current one
+		allSourceTables := make([]string, 0)
+		for i := range tableStructure {
+			if i != idx {
+				allSourceTables = append(allSourceTables, tableStructure[i].SrcTableInfo...)
+			}
+		}
+		slog.Debug("prepared exclusion list for regex generation",
+			"currentIndex", idx,
+			"exclusionCount", len(allSourceTables))
+
+		regex, err := s.GenerateRegex(tableStructure[idx].SrcTableInfo, allSourceTables)
+		if err != nil {
+			slog.Error("failed to generate regex for table consolidation",
+				"error", err,
+				"index", idx,
+				"srcTables", tableStructure[idx].SrcTableInfo,
+				"exclusionCount", len(allSourceTables))
+			failed = append(failed, fmt.Sprintf("%s(%v)", strings.Join(tableStructure[idx].DestTableInfo, ","), err))
+			continue
+		}
+
+		tableStructure[idx].SrcRegex = *regex
+		slog.Debug("successfully generated regex",
+			"index", idx,
+			"regex", *regex,
+			"srcTableCount", len(tableStructure[idx].SrcTableInfo))
+	}
+	slog.Info("completed regex generation for table consolidations", "processedCount", len(tableStructure), "failedCount", len(failed))
+	if len(failed) > 0 {
+		return fmt.Errorf("no valid rule for %d group(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// placeholderRegex is generated without the LLM, it is replaced manually in the generated config
+const placeholderRegex = "---------- todo ----------"
+
+type RuleResult struct {
+	Rule string `json:"rule"`
+}
+
+// generateGeneralRegex asks the LLM for a pattern matching dataList but none of dataListShouldNotMatch.
+// The answers are checked by validate, rule_is_valid of the lists if it is nil, and its result
+// is returned to the LLM to refine the rule. The placeholder is returned if no LLM is configured.
+func (s *Synthesizer) generateGeneralRegex(dataList []string, dataListShouldNotMatch []string, validate func(rule string) ToolReturn) (result *string, err error) {
+	if s.opts.LLMProduct == "" && s.opts.ReplayFile == "" {
+		slog.Warn("llmProduct not configured, returning placeholder regex", "dataList", dataList, "dataListShouldNotMatch", dataListShouldNotMatch)
+		return &[]string{placeholderRegex}[0], nil
+	}
+
+	if validate == nil {
+		validate = func(rule string) ToolReturn { return rule_is_valid(rule, dataList, dataListShouldNotMatch) }
+	}
+
+	// Log the LLM product selection for troubleshooting
+	slog.Debug("configuring LLM client", "llmProduct", s.opts.LLMProduct, "replay", s.opts.ReplayFile)
+
+	conversation, err := s.newLLMConversation(dataList, dataListShouldNotMatch)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { conversation.finish(result, err) }()
+
+	// Log the full data list for debugging pattern generation
+	slog.Debug("generating regex pattern", "dataListCount", len(dataList), "shouldNotMatchCount", len(dataListShouldNotMatch), "dataList", strings.Join(dataList, ", "))
+
+	tools := []openai.Tool{
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "rule_is_valid",
+				Description: "Verify if the given rule matches all required names and excludes others. The rule should match the exact database naming pattern. The false_positives of the result are the excluded names, or the instance.schema.table names of the other groups, matched by the rule.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"rule": map[string]interface{}{
+							"type":        "string",
+							"description": "The candidate rule to validate.",
+						},
+						"dbs_to_match": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "string",
+							},
+							"description": "List of names that the rule MUST match (e.g., 'db_01', 'db_02').",
+						},
+						"dbs_to_exclude": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "string",
+							},
+							"description": "List of names that the rule MUST NOT match.",
+						},
+					},
+					"required": []string{"rule", "dbs_to_match", "dbs_to_exclude"},
+				},
+			},
+		},
+	}
+
+	// System message for database name regex generation
+	system := openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleSystem,
+		Content: strings.Join([]string{
+			"You are an assistant that generates concise and accurate pattern-matching rules according to the given specification. ",
+		}, "\n"),
+	}
+
+	samplingNum := calculateSampleSize(len(dataList))
+	sampledData := sampleData(dataList, samplingNum)
+
+	// Log sampling details for troubleshooting
+	slog.Debug("sampled data for pattern generation", "originalCount", len(dataList), "sampleSize", samplingNum, "sampledData", strings.Join(sampledData, ", "))
+
+	user := openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser,
+		Content: strings.Join([]string{
+			"Rules must follow the pattern specification below:",
+			"1. Pattern Characters:",
+			"  - '*': Matches zero or more characters (must be the last character)",
+			"  - '?': Matches exactly one character",
+			"  - '[...]': Matches a single character from the specified range",
+			"2. Range Pattern Format:",
+			"  - [a-z]: Matches any single character from 'a' to 'z'",
+			"  - [!a-z]: Matches any single character NOT in range 'a' to 'z'",
+			"  - [abc]: Matches 'a', 'b', or 'c'",
+			"3. Limitations:",
+			"  - '*' can only appear at the end of the pattern",
+			"  - Each '?' matches exactly one character",
+			"  - Range patterns are case-sensitive",
+			"  - Empty patterns are not allowed",
+			"  - Maximum pattern length is not restricted",
+			"4. Pattern Types and Examples:",
+			"  a. Exact Match:",
+			"    - \"abc\" matches exactly \"abc\"",
+			"    - \"abd\" matches exactly \"abd\"",
+			"  b. Single Character Wildcard (?):",
+			"    - \"?bc\" matches \"abc\", \"dbc\"",
+			"    - \"a?c\" matches \"abc\", \"adc\"",
+			"    - \"ab?\" matches \"abc\", \"abd\"",
+			"  c. Multi-Character Wildcard (*):",
+			"    - \"ab*\" matches \"abc\", \"abcd\", \"abcde\"",
+			"    - \"schema*\" matches \"schema1\", \"schema12\"",
+			"    - \"test*\" matches \"test1\", \"test_abc\"",
+			"   Note: '*' must be the last character",
+			"  d. Character Range ([...]):",
+			"    - \"ik[hjkl]\" matches \"ikh\", \"ikj\", \"ikk\", \"ikl\" ",
+			"    - \"ik[f-h]\" matches \"ikf\", \"ikg\", \"ikh\"",
+			"    - \"i[x-z][1-3]\" matches \"ix1\", \"iy2\", \"iz3\"",
+			"  e. Negated Range ([!...]):",
+			"    - \"ik[!zxc]\" matches any \"ik\" followed by any character except 'z', 'x', 'c'",
+			"    - \"ik[!a-ce-g]\" matches any \"ik\" followed by any character not in ranges a-c and e-g",
+			fmt.Sprintf("Create a pattern rule for these sampling values: %s", strings.Join(sampledData, ", ")),
+		}, "\n"),
+	}
+	if len(dataListShouldNotMatch) > 0 {
+		user.Content += "\n" + fmt.Sprintf("The rule must not match these values: %s", strings.Join(sampleData(dataListShouldNotMatch, calculateSampleSize(len(dataListShouldNotMatch))), ", "))
+	}
+
+	messages := []openai.ChatCompletionMessage{system, user}
+	const maxRounds = 5
+	for round := 1; round <= maxRounds; round++ {
+		slog.Debug("starting LLM conversation round", "round", round, "maxRounds", maxRounds)
+
+		assistant, err := conversation.complete(messages, tools)
+		if err != nil {
+			slog.Error("LLM chat completion failed", "round", round, "error", err, "model", conversation.Model)
+			return nil, fmt.Errorf("chat completion error (round %d): %w", round, err)
+		}
+		slog.Debug("received LLM response", "round", round, "hasToolCalls", len(assistant.ToolCalls) > 0)
+
+		if len(assistant.ToolCalls) > 0 {
+			// Add the assistant message with tool_calls to history
+			messages = append(messages, assistant)
+
+			for _, tc := range assistant.ToolCalls {
+				if tc.Function.Name != "rule_is_valid" {
+					slog.Debug("skipping non-rule_is_valid tool call", "toolName", tc.Function.Name)
+					continue
+				}
+
+				// Parse arguments
+				var args RuleResult
+				if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+					// If parsing fails, give the model a helpful error signal
+					slog.Error("failed to parse rule_is_valid arguments", "error", err, "arguments", tc.Function.Arguments)
+					toolContent := ToolReturn{Valid: false, Error: "Bad JSON arguments for rule_is_valid"}
+					conversation.addToolResult(toolContent)
+					contentBytes, _ := json.Marshal(toolContent)
+					messages = append(messages, openai.ChatCompletionMessage{
+						Role:       openai.ChatMessageRoleTool,
+						ToolCallID: tc.ID,
+						Content:    string(contentBytes),
+					})
+					continue
+				}
+
+				// Run your local validator
+				slog.Debug("validating generated rule", "rule", args.Rule, "dataListCount", len(dataList), "shouldNotMatchCount", len(dataListShouldNotMatch))
+				toolContent := validate(args.Rule)
+				conversation.addToolResult(toolContent)
+				slog.Debug("rule validation completed", "rule", args.Rule, "valid", toolContent.Valid, "missedMatches", len(toolContent.MissedMatches), "falsePositives", len(toolContent.FalsePositives))
+
+				if toolContent.Valid {
+					slog.Info("successfully generated valid regex pattern", "rule", toolContent.Rule, "round", round)
+					return &toolContent.Rule, nil
+				}
+				contentBytes, _ := json.Marshal(toolContent)
+
+				messages = append(messages, openai.ChatCompletionMessage{
+					Role:       openai.ChatMessageRoleTool,
+					ToolCallID: tc.ID,
+					Content:    string(contentBytes),
+				})
+			}
+
+		} else {
+			rule := strings.TrimSpace(assistant.Content)
+			slog.Debug("validating rule from assistant content", "rule", rule)
+			validation := validate(rule)
+			conversation.addToolResult(validation)
+			if validation.Valid {
+				slog.Info("successfully generated valid regex pattern from content", "rule", validation.Rule, "round", round)
+				return &rule, nil
+			}
+			slog.Debug("rule validation failed", "rule", rule, "missedMatches", len(validation.MissedMatches), "falsePositives", len(validation.FalsePositives))
+		}
+	}
+
+	slog.Error("failed to generate regex after max rounds", "maxRounds", maxRounds, "dataListCount", len(dataList),
+		"shouldNotMatchCount", len(dataListShouldNotMatch), "conversationKey", conversation.Key, "recording", s.opts.RecordFile)
+
+	return nil, fmt.Errorf("failed to generate regex")
+}
+
+/*
+ * GenerateRegex is used to detect the tables that are in the same structure for sync_diff_inspector which
+ * only allow one routes.rule to compare the data between source tables and destination table. The only one regex is required
+ * to conver all the source tables while it should not match any other tables.
+ *
+ * The table pattern is generated first. The schema pattern is then validated together with the
+ * table pattern against the instance.schema.table names, so the foreign tables the pair
+ * captures are returned to the LLM to refine the schema pattern.
+ */
+func (s *Synthesizer) GenerateRegex(tables []string, tablesShouldNotMatch []string) (*string, error) {
+	slog.Debug("starting regex generation",
+		"inputTableCount", len(tables),
+		"exclusionCount", len(tablesShouldNotMatch),
+		"cacheSize", len(s.patterns))
+
+	dbList, tableList := splitTables(tables)
+	_, tableListExclude := splitTables(tablesShouldNotMatch)
+	// The table names of the group may be used by the other groups in the other schemas, the
+	// schema pattern tells them apart
+	tableListExclude = slices.DeleteFunc(tableListExclude, func(table string) bool { return slices.Contains(tableList, table) })
+
+	slog.Debug("split tables into components",
+		"dbList", dbList,
+		"tableList", tableList,
+		"tableListExclude", tableListExclude)
+
+	tableRegex, err := s.regexOf(tableList, tableListExclude, nil)
+	if err != nil {
+		slog.Error("failed to generate table regex", "error", err, "tableList", tableList, "excludeList", tableListExclude)
+		return nil, err
+	}
+
+	var dbListExclude []string
+	var validate func(rule string) ToolReturn
+	if *tableRegex != placeholderRegex {
+		dbListExclude = capturedSchemas(*tableRegex, dbList, tables, tablesShouldNotMatch)
+		validate = func(rule string) ToolReturn {
+			return schema_rule_is_valid(rule, *tableRegex, dbList, dbListExclude, tables, tablesShouldNotMatch)
+		}
+	}
+	dbRegex, err := s.regexOf(dbList, dbListExclude, validate)
+	if err != nil {
+		slog.Error("failed to generate db regex", "error", err, "dbList", dbList, "excludeList", dbListExclude, "tableRegex", *tableRegex)
+		return nil, err
+	}
+
+	regex := fmt.Sprintf("%s.%s", *dbRegex, *tableRegex)
+	slog.Debug("final regex assembled", "regex", regex)
+
+	// A cached or single-name pattern is not checked by the LLM conversation, the pair is
+	// validated again
+	if *dbRegex != placeholderRegex && *tableRegex != placeholderRegex {
+		result := rule_pair_is_valid(*dbRegex, *tableRegex, tables, tablesShouldNotMatch)
+		if !result.Valid {
+			slog.Error("combined rule is invalid", "rule", regex, "missedMatches", result.MissedMatches, "foreignCaptures", result.FalsePositives, "error", result.Error)
+			return nil, fmt.Errorf("rule %s is invalid: missed %s, captures foreign tables %s",
+				regex, strings.Join(result.MissedMatches, ","), strings.Join(result.FalsePositives, ","))
+		}
+	}
+
+	return &regex, nil
+}
+
+// regexOf returns the pattern of the names, the name itself if there is only one. The patterns
+// are cached by the names and the exclusions. A cached pattern is used only if it passes
+// validate, which may check more than the names, e.g. the table pattern of the route.
+func (s *Synthesizer) regexOf(dataList, dataListShouldNotMatch []string, validate func(rule string) ToolReturn) (*string, error) {
+	if len(dataList) == 1 {
+		slog.Debug("using single name as regex", "name", dataList[0])
+		return &dataList[0], nil
+	}
+
+	key := conversationKey(dataList, dataListShouldNotMatch)
+	if cachedRegex, ok := s.patterns[key]; ok {
+		if validate == nil || cachedRegex == placeholderRegex || validate(cachedRegex).Valid {
+			slog.Debug("found cached regex", "key", key, "regex", cachedRegex)
+			return &cachedRegex, nil
+		}
+		slog.Debug("cached regex is invalid for the route, generating again", "key", key, "regex", cachedRegex)
+	}
+
+	slog.Debug("generating new regex", "dataList", dataList, "excludeList", dataListShouldNotMatch)
+	regex, err := s.generateGeneralRegex(dataList, dataListShouldNotMatch, validate)
+	if err != nil {
+		return nil, err
+	}
+	s.patterns[key] = *regex
+	slog.Debug("cached newly generated regex", "key", key, "regex", *regex)
+	return regex, nil
+}
+
+// capturedSchemas returns the schemas of the foreign tables on the instances of the group whose
+// table name is matched by the table pattern. The schema pattern must not match them unless
+// they are schemas of the group too, which is checked by rule_pair_is_valid.
+func capturedSchemas(tablePattern string, dbList, tables, tablesShouldNotMatch []string) []string {
+	ts := selector.NewTrieSelector()
+	if err := ts.Insert("*", tablePattern, tablePattern, selector.Insert); err != nil {
+		slog.Warn("failed to insert table pattern into trie selector", "error", err, "tablePattern", tablePattern)
+		return nil
+	}
+	instances := map[string]bool{}
+	for _, table := range tables {
+		if parts := strings.Split(table, "."); len(parts) == 3 {
+			instances[parts[0]] = true
+		}
+	}
+	schemas := []string{}
+	for _, table := range tablesShouldNotMatch {
+		parts := strings.Split(table, ".")
+		if len(parts) != 3 || !instances[parts[0]] || slices.Contains(dbList, parts[1]) || slices.Contains(schemas, parts[1]) {
+			continue
+		}
+		if ts.Match(parts[1], parts[2]) != nil {
+			schemas = append(schemas, parts[1])
+		}
+	}
+	return schemas
+}
+
+type ToolReturn struct {
+	Rule           string   `json:"rule"`
+	Valid          bool     `json:"valid"`
+	MissedMatches  []string `json:"missed_matches"`
+	FalsePositives []string `json:"false_positives"`
+	Error          string   `json:"error"`
+}
+
+func rule_is_valid(pattern string, tables []string, tablesShouldNotMatch []string) ToolReturn {
+	slog.Debug("starting rule validation",
+		"pattern", pattern,
+		"tablesToMatch", len(tables),
+		"tablesToExclude", len(tablesShouldNotMatch))
+
+	result := ToolReturn{
+		Rule:           pattern,
+		Valid:          true,
+		MissedMatches:  []string{},
+		FalsePositives: []string{},
+		Error:          "",
+	}
+
+	// Create a new trie selector
+	ts := selector.NewTrieSelector()
+
+	// Create a rule for tables
+	// Let's say we want to match all tables that:
+	// - Are in schema "mydb"
+	schema := "mydb"
+
+	// Define a rule (can be any type)
+	rulePattern := struct {
+		Action   string
+		Priority int
+	}{
+		Action:   "verification",
+		Priority: 1,
+	}
+
+	// Insert the rule
+	err := ts.Insert(schema, pattern, rulePattern, selector.Insert)
+	if err != nil {
+		slog.Error("failed to insert rule into trie selector", "error", err, "pattern", pattern, "schema", schema)
+		result.Valid = false
+		result.Error = fmt.Sprintf("Failed to insert rule: %v", err)
+		return result
+	}
+	slog.Debug("rule inserted successfully", "pattern", pattern, "schema", schema)
+
+	// Test all the tables which should match the rule
+	for _, table := range tables {
+		rules := ts.Match(schema, table)
+		if rules == nil {
+			result.MissedMatches = append(result.MissedMatches, table)
+			slog.Debug("table failed to match rule", "table", table, "pattern", pattern)
+			result.Valid = false
+		} else {
+			slog.Debug("table matched rule", "table", table, "pattern", pattern)
+		}
+	}
+
+	// Test all the tables which should not match the rule
+	for _, table := range tablesShouldNotMatch {
+		rules := ts.Match(schema, table)
+		if rules != nil {
+			result.FalsePositives = append(result.FalsePositives, table)
+			slog.Debug("table incorrectly matched rule (false positive)", "table", table, "pattern", pattern, "matchedRules", rules)
+			result.Valid = false
+		} else {
+			slog.Debug("table correctly excluded", "table", table, "pattern", pattern)
+		}
+	}
+
+	if len(result.MissedMatches) > 0 {
+		result.Valid = false
+		result.Error = "The validator reports these missed matches (see tool message). You must refine the rule so that all previously provided names match."
+		slog.Debug("validation failed due to missed matches", "missedCount", len(result.MissedMatches), "missedTables", result.MissedMatches)
+	}
+
+	if len(result.FalsePositives) > 0 {
+		result.Valid = false
+		result.Error = fmt.Sprintf("%s \nThe validator reports false positives (see tool message). You must refine the rule so that all of names are excluded.", result.Error)
+		slog.Debug("validation failed due to false positives", "falsePositiveCount", len(result.FalsePositives), "falsePositiveTables", result.FalsePositives)
+	}
+
+	slog.Debug("rule validation completed", "valid", result.Valid, "pattern", pattern)
+	return result
+}
+
+// calculateSampleSize determines how many items to sample from the input data
+func calculateSampleSize(totalItems int) int {
+	switch {
+	case totalItems <= 100:
+		size := min(20, totalItems)
+		slog.Debug("calculated sample size for small dataset", "totalItems", totalItems, "sampleSize", size)
+		return size
+	case totalItems <= 1000:
+		extraItems := int(0.01 * float64(totalItems-100))
+		size := min(20+extraItems, 50)
+		slog.Debug("calculated sample size for medium dataset", "totalItems", totalItems, "sampleSize", size, "extraItems", extraItems)
+		return size
+	default:
+		slog.Debug("calculated sample size for large dataset", "totalItems", totalItems, "sampleSize", 50)
+		return 50
+	}
+}
+
+// sampleData takes a slice of data and returns a randomly sampled subset
+func sampleData(data []string, sampleSize int) []string {
+	if len(data) <= sampleSize {
+		slog.Debug("dataset smaller than or equal to sample size, returning full dataset", "dataSize", len(data), "sampleSize", sampleSize)
+		return data
+	}
+
+	half := sampleSize / 2
+	sample := make([]string, 0, sampleSize)
+
+	// Take first half
+	sample = append(sample, data[:half]...)
+
+	// Add middle placeholder
+	middleCount := len(data) - sampleSize
+	sample = append(sample, fmt.Sprintf("... other %d element ...", middleCount))
+
+	// Take last half
+	sample = append(sample, data[len(data)-half:]...)
+
+	slog.Debug("generated sample data", "originalSize", len(data), "sampleSize", sampleSize, "firstHalfSize", half, "lastHalfSize", half, "middlePlaceholder", middleCount)
+	return sample
+}
+
+/*
+ * rule_pair_is_valid validates the (schema-pattern, table-pattern) pair of a route against the
+ * instance.schema.table names as DM evaluates it: the pair is matched by the table-rule-selector
+ * on the schema and the table together. The route is attached to the instances of the tables,
+ * so only the tables of those instances can be captured. MissedMatches and FalsePositives are
+ * the full instance.schema.table names, FalsePositives are the foreign tables captured.
+ */
+func rule_pair_is_valid(schemaPattern, tablePattern string, tables []string, tablesShouldNotMatch []string) ToolReturn {
+	result := ToolReturn{
+		Rule:           schemaPattern + "." + tablePattern,
+		Valid:          true,
+		MissedMatches:  []string{},
+		FalsePositives: []string{},
+	}
+
+	ts := selector.NewTrieSelector()
+	if err := ts.Insert(schemaPattern, tablePattern, result.Rule, selector.Insert); err != nil {
+		slog.Error("failed to insert rule pair into trie selector", "error", err, "schemaPattern", schemaPattern, "tablePattern", tablePattern)
+		result.Valid = false
+		result.Error = fmt.Sprintf("Failed to insert rule: %v", err)
+		return result
+	}
+
+	instances := map[string]bool{}
+	for _, table := range tables {
+		parts := strings.Split(table, ".")
+		if len(parts) != 3 {
+			slog.Warn("unexpected table name format", "table", table, "expectedFormat", "instance.schema.table")
+			continue
+		}
+		instances[parts[0]] = true
+		if ts.Match(parts[1], parts[2]) == nil {
+			result.MissedMatches = append(result.MissedMatches, table)
+		}
+	}
+	for _, table := range tablesShouldNotMatch {
+		parts := strings.Split(table, ".")
+		if len(parts) != 3 || !instances[parts[0]] {
+			continue
+		}
+		if ts.Match(parts[1], parts[2]) != nil {
+			slog.Debug("rule pair captures foreign table", "rule", result.Rule, "table", table)
+			result.FalsePositives = append(result.FalsePositives, table)
+		}
+	}
+
+	if len(result.MissedMatches) > 0 {
+		result.Valid = false
+		result.Error = "The rule does not match the tables of the group."
+	}
+	if len(result.FalsePositives) > 0 {
+		result.Valid = false
+		result.Error = strings.TrimSpace(result.Error + " The rule captures the tables of the other groups.")
+	}
+	slog.Debug("rule pair validation completed", "rule", result.Rule, "valid", result.Valid,
+		"missedMatches", len(result.MissedMatches), "foreignCaptures", len(result.FalsePositives))
+	return result
+}
+
+/*
+ * schema_rule_is_valid validates the schema pattern of a route: rule_is_valid on the schema
+ * names, then rule_pair_is_valid together with the table pattern. The foreign tables captured by
+ * the pair are added to FalsePositives by their instance.schema.table names, so the LLM sees
+ * them and refines the schema pattern.
+ */
+func schema_rule_is_valid(schemaPattern, tablePattern string, dbList, dbListShouldNotMatch, tables, tablesShouldNotMatch []string) ToolReturn {
+	result := rule_is_valid(schemaPattern, dbList, dbListShouldNotMatch)
+	if result.Error != "" && len(result.MissedMatches) == 0 && len(result.FalsePositives) == 0 {
+		// The rule is not accepted by the selector
+		return result
+	}
+	pair := rule_pair_is_valid(schemaPattern, tablePattern, tables, tablesShouldNotMatch)
+	if pair.Valid {
+		return result
+	}
+	result.Valid = false
+	result.FalsePositives = append(result.FalsePositives, pair.FalsePositives...)
+	if len(pair.FalsePositives) > 0 {
+		result.Error = strings.TrimSpace(fmt.Sprintf("%s \nCombined with the table pattern %s, the rule captures the tables of the other groups in false_positives(instance.schema.table). You must refine the rule so that their schemas are excluded.", result.Error, tablePattern))
+	}
+	if len(pair.MissedMatches) > 0 && len(result.MissedMatches) == 0 {
+		result.MissedMatches = pair.MissedMatches
+		result.Error = strings.TrimSpace(result.Error + " \n" + pair.Error)
+	}
+	return result
+}
+
+func splitTables(tables []string) ([]string, []string) {
+	tmpTables := []string{}
+	// Convert table names from instanceName.DBName.Table format to DBName.Table
+	// by removing the instanceName prefix
+	dbList := []string{}
+	tableList := []string{}
+	for i := range tables {
+		parts := strings.Split(tables[i], ".")
+		if len(parts) == 3 {
+			tmpTables = append(tmpTables, fmt.Sprintf("%s.%s", parts[1], parts[2]))
+		}
+		found := false
+		for _, db := range dbList {
+			if db == parts[1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dbList = append(dbList, parts[1])
+		}
+
+		found = false
+		for _, table := range tableList {
+			if table == parts[2] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tableList = append(tableList, parts[2])
+		}
+	}
+
+	slog.Debug("split tables into components", "inputTableCount", len(tables), "dbCount", len(dbList), "tableCount", len(tableList), "dbList", dbList, "tableList", tableList)
+	return dbList, tableList
+}
@@ -0,0 +1,325 @@
+package rules
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+)
+
+func Test_generateGeneralRegex(t *testing.T) {
+	type args struct {
+		dataList               []string
+		dataListShouldNotMatch []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *string
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(Options{}).generateGeneralRegex(tt.args.dataList, tt.args.dataListShouldNotMatch, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("generateGeneralRegex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("generateGeneralRegex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateRegex(t *testing.T) {
+	type args struct {
+		tables               []string
+		tablesShouldNotMatch []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *string
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(Options{}).GenerateRegex(tt.args.tables, tt.args.tablesShouldNotMatch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateRegex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GenerateRegex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rule_is_valid(t *testing.T) {
+	type args struct {
+		pattern              string
+		tables               []string
+		tablesShouldNotMatch []string
+	}
+	tests := []struct {
+		name string
+		args args
+		want ToolReturn
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rule_is_valid(tt.args.pattern, tt.args.tables, tt.args.tablesShouldNotMatch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rule_is_valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_calculateSampleSize(t *testing.T) {
+	type args struct {
+		totalItems int
+	}
+	tests := []struct {
+		name string
+		args args
+		want int
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateSampleSize(tt.args.totalItems); got != tt.want {
+				t.Errorf("calculateSampleSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sampleData(t *testing.T) {
+	type args struct {
+		data       []string
+		sampleSize int
+	}
+	tests := []struct {
+		name string
+		args args
+		want []string
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampleData(tt.args.data, tt.args.sampleSize); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sampleData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitTables(t *testing.T) {
+	type args struct {
+		tables []string
+	}
+	tests := []struct {
+		name  string
+		args  args
+		want  []string
+		want1 []string
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1 := splitTables(tt.args.tables)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitTables() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(got1, tt.want1) {
+				t.Errorf("splitTables() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
+
+func Test_generateGeneralRegexReplay(t *testing.T) {
+	// The recordings are captured with --llm-record, a failing shard list is kept as a case
+	tests := []struct {
+		name                   string
+		recording              string
+		dataList               []string
+		dataListShouldNotMatch []string
+		want                   string
+		wantErr                bool
+	}{
+		{
+			name:                   "rule refined after missed match",
+			recording:              "testdata/llm/regex_conversations.jsonl",
+			dataList:               []string{"messagedb_00", "messagedb_01", "messagedb_02", "messagedb_10"},
+			dataListShouldNotMatch: []string{"messagedb_test"},
+			want:                   "messagedb_[01]?",
+		},
+		{
+			name:                   "no valid rule in the answers",
+			recording:              "testdata/llm/regex_conversations.jsonl",
+			dataList:               []string{"orders_2023", "orders_2024"},
+			dataListShouldNotMatch: []string{"orders_archive"},
+			wantErr:                true,
+		},
+		{
+			name:      "data list not recorded",
+			recording: "testdata/llm/regex_conversations.jsonl",
+			dataList:  []string{"users_00"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordFile := filepath.Join(t.TempDir(), "conversations.jsonl")
+			synthesizer := New(Options{ReplayFile: tt.recording, RecordFile: recordFile})
+
+			got, err := synthesizer.generateGeneralRegex(tt.dataList, tt.dataListShouldNotMatch, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("generateGeneralRegex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got == nil || *got != tt.want {
+				t.Fatalf("generateGeneralRegex() = %v, want %v", got, tt.want)
+			}
+
+			// The replayed conversation is recorded again with the rule_is_valid results
+			recorded, err := loadLLMRecording(recordFile)
+			if err != nil {
+				t.Fatalf("loadLLMRecording() error = %v", err)
+			}
+			conversation := recorded[conversationKey(tt.dataList, tt.dataListShouldNotMatch)]
+			if conversation == nil || conversation.Rule != tt.want || len(conversation.Messages) != 2 {
+				t.Fatalf("recorded conversation = %+v", conversation)
+			}
+			if results := conversation.Rounds[0].ToolResults; len(results) != 1 || results[0].Valid {
+				t.Errorf("first round tool results = %+v, want one invalid result", results)
+			}
+		})
+	}
+}
+
+func Test_rule_pair_is_valid(t *testing.T) {
+	tables := []string{"instance01.db_00.orders", "instance01.db_01.orders"}
+	tests := []struct {
+		name                 string
+		schemaPattern        string
+		tablePattern         string
+		tablesShouldNotMatch []string
+		wantValid            bool
+		wantMissed           []string
+		wantForeign          []string
+	}{
+		{
+			name:          "pair matches the group only",
+			schemaPattern: "db_0[01]", tablePattern: "orders",
+			tablesShouldNotMatch: []string{"instance01.db_02.orders", "instance01.db_00.users"},
+			wantValid:            true, wantMissed: []string{}, wantForeign: []string{},
+		},
+		{
+			// db_0? and orders pass on their own, the pair captures db_02.orders
+			name:          "cross product captures foreign table",
+			schemaPattern: "db_0?", tablePattern: "orders",
+			tablesShouldNotMatch: []string{"instance01.db_02.orders", "instance01.db_02.users"},
+			wantMissed:           []string{}, wantForeign: []string{"instance01.db_02.orders"},
+		},
+		{
+			name:          "tables of other instances are not captured",
+			schemaPattern: "db_0?", tablePattern: "orders",
+			tablesShouldNotMatch: []string{"instance02.db_02.orders"},
+			wantValid:            true, wantMissed: []string{}, wantForeign: []string{},
+		},
+		{
+			name:          "missed table",
+			schemaPattern: "db_00", tablePattern: "orders",
+			wantMissed: []string{"instance01.db_01.orders"}, wantForeign: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rule_pair_is_valid(tt.schemaPattern, tt.tablePattern, tables, tt.tablesShouldNotMatch)
+			if got.Valid != tt.wantValid || !reflect.DeepEqual(got.MissedMatches, tt.wantMissed) || !reflect.DeepEqual(got.FalsePositives, tt.wantForeign) {
+				t.Errorf("rule_pair_is_valid() = %+v, want valid %v, missed %v, foreign %v", got, tt.wantValid, tt.wantMissed, tt.wantForeign)
+			}
+		})
+	}
+}
+
+func Test_generateRegexForeignCapture(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "conversations.jsonl")
+	synthesizer := New(Options{ReplayFile: "testdata/llm/regex_conversations.jsonl", RecordFile: recordFile})
+
+	// db_0? matches the db list, combined with orders it captures db_02.orders. The capture is
+	// returned to the LLM which answers db_0[01].
+	got, err := synthesizer.GenerateRegex([]string{"instance01.db_00.orders", "instance01.db_01.orders"},
+		[]string{"instance01.db_02.orders", "instance01.db_00.users"})
+	if err != nil {
+		t.Fatalf("GenerateRegex() error = %v", err)
+	}
+	if *got != "db_0[01].orders" {
+		t.Errorf("GenerateRegex() = %s, want db_0[01].orders", *got)
+	}
+
+	recorded, err := loadLLMRecording(recordFile)
+	if err != nil {
+		t.Fatalf("loadLLMRecording() error = %v", err)
+	}
+	conversation := recorded[conversationKey([]string{"db_00", "db_01"}, []string{"db_02"})]
+	if conversation == nil || len(conversation.Rounds) != 2 {
+		t.Fatalf("recorded conversation = %+v, want two rounds", conversation)
+	}
+	if results := conversation.Rounds[0].ToolResults; len(results) != 1 || !slices.Contains(results[0].FalsePositives, "instance01.db_02.orders") {
+		t.Errorf("first round tool results = %+v, want the foreign capture of instance01.db_02.orders", results)
+	}
+}
+
+func Test_generateRegexNoValidPair(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "conversations.jsonl")
+	synthesizer := New(Options{ReplayFile: "testdata/llm/regex_conversations.jsonl", RecordFile: recordFile})
+
+	// orders_? is valid for the table list, but any schema pattern of db_00 and db_01 captures
+	// db_00.orders_b of the other group
+	tableStructure := []mapping.TableInfo{
+		{SrcTableInfo: []string{"instance01.db_00.orders_a", "instance01.db_01.orders_b"}, DestTableInfo: []string{"target.messagedb.orders"}},
+		{SrcTableInfo: []string{"instance01.db_00.orders_b"}, DestTableInfo: []string{"target.messagedb.orders_b"}},
+	}
+	err := synthesizer.AssignSrcRegex(tableStructure)
+	if err == nil || !strings.Contains(err.Error(), "target.messagedb.orders") {
+		t.Fatalf("AssignSrcRegex() error = %v, want the failure of target.messagedb.orders", err)
+	}
+	if tableStructure[0].SrcRegex != "" {
+		t.Errorf("SrcRegex = %s, want empty for the failed group", tableStructure[0].SrcRegex)
+	}
+
+	recorded, err := loadLLMRecording(recordFile)
+	if err != nil {
+		t.Fatalf("loadLLMRecording() error = %v", err)
+	}
+	conversation := recorded[conversationKey([]string{"db_00", "db_01"}, nil)]
+	if conversation == nil || len(conversation.Rounds) == 0 {
+		t.Fatalf("recorded conversation = %+v", conversation)
+	}
+	if results := conversation.Rounds[0].ToolResults; len(results) != 1 || !slices.Contains(results[0].FalsePositives, "instance01.db_00.orders_b") {
+		t.Errorf("first round tool results = %+v, want the foreign capture of instance01.db_00.orders_b", results)
+	}
+}
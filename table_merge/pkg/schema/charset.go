@@ -0,0 +1,158 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+)
+
+// ServerSettings are the global variables deciding which values are accepted and how the
+// table names are compared
+type ServerSettings struct {
+	SQLMode             string `json:"sql_mode"`
+	LowerCaseTableNames int    `json:"lower_case_table_names"`
+}
+
+// ColumnCharset is the character set of a string column
+type ColumnCharset struct {
+	Charset   string
+	Collation string
+	// Unique is set if the column is part of a primary or unique key
+	Unique bool
+}
+
+// TableCharset is the default collation of a table with the character set of its string
+// columns indexed by the column name
+type TableCharset struct {
+	Collation string
+	Columns   map[string]ColumnCharset
+}
+
+// IsMySQLEngine reports whether the instance is MySQL or TiDB, the only engines with the charset
+// metadata of the audit
+func IsMySQLEngine(dbInfo config.DBConnInfo) bool {
+	switch strings.ToLower(dbInfo.Engine) {
+	case "", "mysql", "tidb":
+		return true
+	}
+	return false
+}
+
+// Settings returns the sql_mode and lower_case_table_names of the instance
+func Settings(dbInfo config.DBConnInfo) (ServerSettings, error) {
+	if !IsMySQLEngine(dbInfo) {
+		return ServerSettings{}, fmt.Errorf("server settings of engine %s are not supported", dbInfo.Engine)
+	}
+	db, err := OpenDB(dbInfo, dbInfo.DBs[0])
+	if err != nil {
+		return ServerSettings{}, err
+	}
+	defer db.Close()
+
+	var settings ServerSettings
+	if err := db.QueryRow("SELECT @@GLOBAL.sql_mode, @@lower_case_table_names").Scan(&settings.SQLMode, &settings.LowerCaseTableNames); err != nil {
+		return ServerSettings{}, fmt.Errorf("query server settings of %s: %w", dbInfo.Name, err)
+	}
+	return settings, nil
+}
+
+// Charsets returns the collation and the string columns of the tables of the schemas indexed
+// by schema.table
+func Charsets(dbInfo config.DBConnInfo, schemas []string) (map[string]TableCharset, error) {
+	if !IsMySQLEngine(dbInfo) {
+		return nil, fmt.Errorf("charsets of engine %s are not supported", dbInfo.Engine)
+	}
+	db, err := OpenDB(dbInfo, dbInfo.DBs[0])
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	in := placeholders(len(schemas), false)
+	slog.Debug("querying charsets", "dbName", dbInfo.Name, "schemas", strings.Join(schemas, ","))
+	return queryCharsets(db, schemaArgs(schemas),
+		fmt.Sprintf(`
+		SELECT TABLE_SCHEMA, TABLE_NAME, TABLE_COLLATION
+		  FROM INFORMATION_SCHEMA.TABLES
+		 WHERE TABLE_SCHEMA IN (%s) AND TABLE_TYPE = 'BASE TABLE'`, in),
+		fmt.Sprintf(`
+		SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, CHARACTER_SET_NAME, COLLATION_NAME
+		  FROM INFORMATION_SCHEMA.COLUMNS
+		 WHERE TABLE_SCHEMA IN (%s) AND COLLATION_NAME IS NOT NULL`, in),
+		fmt.Sprintf(`
+		SELECT DISTINCT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME
+		  FROM INFORMATION_SCHEMA.STATISTICS
+		 WHERE TABLE_SCHEMA IN (%s) AND NON_UNIQUE = 0`, in))
+}
+
+// queryCharsets reads the rows of (schema, table, collation), (schema, table, column, charset,
+// collation) and the (schema, table, column) of the unique keys. The columns of the tables
+// missing in the first query are skipped, e.g. the columns of the views.
+func queryCharsets(db *sql.DB, args []any, tableQuery, columnQuery, uniqueQuery string) (map[string]TableCharset, error) {
+	charsets := map[string]TableCharset{}
+	err := queryRows(db, tableQuery, args, func(rows *sql.Rows) error {
+		var schema, table string
+		var collation sql.NullString
+		if err := rows.Scan(&schema, &table, &collation); err != nil {
+			return err
+		}
+		charsets[schema+"."+table] = TableCharset{Collation: collation.String, Columns: map[string]ColumnCharset{}}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query table collations: %w", err)
+	}
+
+	err = queryRows(db, columnQuery, args, func(rows *sql.Rows) error {
+		var schema, table, column string
+		var charset, collation sql.NullString
+		if err := rows.Scan(&schema, &table, &column, &charset, &collation); err != nil {
+			return err
+		}
+		if tableCharset, ok := charsets[schema+"."+table]; ok {
+			tableCharset.Columns[column] = ColumnCharset{Charset: charset.String, Collation: collation.String}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query column charsets: %w", err)
+	}
+
+	err = queryRows(db, uniqueQuery, args, func(rows *sql.Rows) error {
+		var schema, table, column string
+		if err := rows.Scan(&schema, &table, &column); err != nil {
+			return err
+		}
+		// Only the string columns have a collation to collide on
+		if columnCharset, ok := charsets[schema+"."+table].Columns[column]; ok {
+			columnCharset.Unique = true
+			charsets[schema+"."+table].Columns[column] = columnCharset
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query unique keys: %w", err)
+	}
+	return charsets, nil
+}
+
+// queryRows calls scan on every row of the query
+func queryRows(db *sql.DB, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("execute query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func Test_queryCharsets(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE tables (table_schema TEXT, table_name TEXT, table_collation TEXT)`,
+		`CREATE TABLE columns (table_schema TEXT, table_name TEXT, column_name TEXT, character_set_name TEXT, collation_name TEXT)`,
+		`CREATE TABLE statistics (table_schema TEXT, table_name TEXT, column_name TEXT, non_unique INTEGER)`,
+		`INSERT INTO tables VALUES ('db_00', 'orders', 'utf8mb4_bin')`,
+		`INSERT INTO columns VALUES ('db_00', 'orders', 'code', 'utf8mb4', 'utf8mb4_bin'),
+			('db_00', 'orders', 'name', 'utf8mb4', 'utf8mb4_general_ci'),
+			('db_00', 'orders_view', 'code', 'utf8mb4', 'utf8mb4_bin')`,
+		`INSERT INTO statistics VALUES ('db_00', 'orders', 'id', 0), ('db_00', 'orders', 'code', 0), ('db_00', 'orders', 'name', 1)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to prepare %s: %v", stmt, err)
+		}
+	}
+
+	args := schemaArgs([]string{"db_00"})
+	charsets, err := queryCharsets(db, args,
+		`SELECT table_schema, table_name, table_collation FROM tables WHERE table_schema IN (?)`,
+		`SELECT table_schema, table_name, column_name, character_set_name, collation_name FROM columns WHERE table_schema IN (?)`,
+		`SELECT DISTINCT table_schema, table_name, column_name FROM statistics WHERE table_schema IN (?) AND non_unique = 0`)
+	if err != nil {
+		t.Fatalf("queryCharsets() error = %v", err)
+	}
+	want := map[string]TableCharset{"db_00.orders": {
+		Collation: "utf8mb4_bin",
+		Columns: map[string]ColumnCharset{
+			"code": {Charset: "utf8mb4", Collation: "utf8mb4_bin", Unique: true},
+			"name": {Charset: "utf8mb4", Collation: "utf8mb4_general_ci"},
+		},
+	}}
+	if !reflect.DeepEqual(charsets, want) {
+		t.Errorf("queryCharsets() = %+v, want %+v", charsets, want)
+	}
+}
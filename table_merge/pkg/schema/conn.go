@@ -0,0 +1,249 @@
+package schema
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+)
+
+// buildTLSConfig converts the TLS settings to the tls.Config of the driver. nil is returned
+// for the disabled and preferred modes which are handled by the driver itself.
+func buildTLSConfig(dbInfo config.DBConnInfo) (*tls.Config, error) {
+	settings := dbInfo.TLS
+	switch settings.Mode {
+	case "", "disabled", "preferred":
+		return nil, nil
+	case "required", "verify-ca", "verify-identity":
+	default:
+		return nil, fmt.Errorf("unsupported TLS mode %s of %s", settings.Mode, dbInfo.Name)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if settings.CA != "" {
+		caPEM, err := os.ReadFile(settings.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA %s: %w", settings.CA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in CA %s", settings.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if settings.Cert != "" || settings.Key != "" {
+		cert, err := tls.LoadX509KeyPair(settings.Cert, settings.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", settings.Cert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch settings.Mode {
+	case "required":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// Verify the certificate chain only, the host name is not checked
+		tlsConfig.InsecureSkipVerify = true
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no server certificate")
+			}
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return fmt.Errorf("failed to parse server certificate: %w", err)
+				}
+				certs = append(certs, cert)
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	case "verify-identity":
+		tlsConfig.ServerName = settings.ServerName
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = dbInfo.Host
+		}
+	}
+	return tlsConfig, nil
+}
+
+var sessionVariableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sessionVariableLiteral returns the SQL literal of the session variable value. The numbers are
+// kept as they are, the other values are quoted as strings, e.g. ANSI_QUOTES and +00:00. A value
+// already in single quotes is unquoted first so the configs written for the former behaviour
+// keep working.
+func sessionVariableLiteral(value string) (string, error) {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value, nil
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		value = value[1 : len(value)-1]
+	}
+	// The meaning of the backslash depends on NO_BACKSLASH_ESCAPES of the server
+	if strings.Contains(value, "\\") {
+		return "", fmt.Errorf("backslash is not supported")
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'", nil
+}
+
+// buildMySQLConfig builds the driver config of the connection with all its options
+func buildMySQLConfig(dbInfo config.DBConnInfo, dbName string) (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = dbInfo.User
+	cfg.Passwd = dbInfo.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(dbInfo.Host, strconv.Itoa(dbInfo.Port))
+	cfg.DBName = dbName
+	cfg.Timeout = dbInfo.ConnectTimeout
+	cfg.ReadTimeout = dbInfo.ReadTimeout
+	cfg.WriteTimeout = dbInfo.WriteTimeout
+	cfg.InterpolateParams = dbInfo.InterpolateParams
+
+	if dbInfo.Charset != "" {
+		if err := cfg.Apply(mysql.Charset(dbInfo.Charset, "")); err != nil {
+			return nil, fmt.Errorf("invalid charset %s of %s: %w", dbInfo.Charset, dbInfo.Name, err)
+		}
+	}
+
+	// The session variables are set by the driver with SET <name>=<value> on connect, the
+	// value is written into the statement as it is
+	if len(dbInfo.SessionVariables) > 0 {
+		cfg.Params = make(map[string]string, len(dbInfo.SessionVariables))
+		for name, value := range dbInfo.SessionVariables {
+			if !sessionVariableNameRe.MatchString(name) {
+				return nil, fmt.Errorf("invalid session variable name %q of %s", name, dbInfo.Name)
+			}
+			literal, err := sessionVariableLiteral(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of session variable %s of %s: %w", name, dbInfo.Name, err)
+			}
+			cfg.Params[name] = literal
+		}
+	}
+
+	tlsConfig, err := buildTLSConfig(dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		cfg.TLS = tlsConfig
+	} else if dbInfo.TLS.Mode == "preferred" {
+		cfg.TLSConfig = "preferred"
+	}
+	return cfg, nil
+}
+
+// OpenDB opens the connection to the database with the options of config.DBConnInfo and verifies
+// that it is reachable. All the database connections of the toolkit are built here.
+func OpenDB(dbInfo config.DBConnInfo, dbName string) (*sql.DB, error) {
+	cfg, err := buildMySQLConfig(dbInfo, dbName)
+	if err != nil {
+		slog.Error("failed to build connection config", "error", err, "dbName", dbInfo.Name)
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		slog.Error("failed to create connector", "error", err, "dbName", dbInfo.Name)
+		return nil, fmt.Errorf("open mysql: %w", err)
+	}
+	db := sql.OpenDB(connector)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		slog.Error("failed to ping database", "error", err, "dbName", dbInfo.Name, "host", dbInfo.Host, "port", dbInfo.Port, "tlsMode", dbInfo.TLS.Mode)
+		return nil, fmt.Errorf("ping mysql: %w", err)
+	}
+	slog.Debug("successfully connected to database", "dbName", dbInfo.Name, "host", dbInfo.Host, "port", dbInfo.Port, "schema", dbName, "tlsMode", dbInfo.TLS.Mode)
+	return db, nil
+}
+
+// postgresSSLMode converts the TLS mode to the sslmode of libpq
+func postgresSSLMode(mode string) (string, error) {
+	switch mode {
+	case "", "disabled":
+		return "disable", nil
+	case "preferred":
+		return "prefer", nil
+	case "required":
+		return "require", nil
+	case "verify-ca":
+		return "verify-ca", nil
+	case "verify-identity":
+		return "verify-full", nil
+	default:
+		return "", fmt.Errorf("unsupported TLS mode %s", mode)
+	}
+}
+
+// buildPostgresDSN builds the libpq URL of the connection. Database defaults to postgres.
+func buildPostgresDSN(dbInfo config.DBConnInfo) (string, error) {
+	sslMode, err := postgresSSLMode(dbInfo.TLS.Mode)
+	if err != nil {
+		return "", fmt.Errorf("%w of %s", err, dbInfo.Name)
+	}
+	database := dbInfo.Database
+	if database == "" {
+		database = "postgres"
+	}
+	query := url.Values{}
+	query.Set("sslmode", sslMode)
+	if dbInfo.TLS.CA != "" {
+		query.Set("sslrootcert", dbInfo.TLS.CA)
+	}
+	if dbInfo.TLS.Cert != "" {
+		query.Set("sslcert", dbInfo.TLS.Cert)
+		query.Set("sslkey", dbInfo.TLS.Key)
+	}
+	if dbInfo.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(int(dbInfo.ConnectTimeout.Seconds())))
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(dbInfo.User, dbInfo.Password),
+		Host:     net.JoinHostPort(dbInfo.Host, strconv.Itoa(dbInfo.Port)),
+		Path:     "/" + database,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String(), nil
+}
+
+// openPostgresDB opens the connection to the PostgreSQL source and verifies that it is reachable
+func openPostgresDB(dbInfo config.DBConnInfo) (*sql.DB, error) {
+	dsn, err := buildPostgresDSN(dbInfo)
+	if err != nil {
+		slog.Error("failed to build connection config", "error", err, "dbName", dbInfo.Name)
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		slog.Error("failed to open database", "error", err, "dbName", dbInfo.Name)
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		slog.Error("failed to ping database", "error", err, "dbName", dbInfo.Name, "host", dbInfo.Host, "port", dbInfo.Port, "tlsMode", dbInfo.TLS.Mode)
+		return nil, fmt.Errorf("ping postgres: %w", err)
+	}
+	slog.Debug("successfully connected to database", "dbName", dbInfo.Name, "host", dbInfo.Host, "port", dbInfo.Port, "database", dbInfo.Database, "tlsMode", dbInfo.TLS.Mode)
+	return db, nil
+}
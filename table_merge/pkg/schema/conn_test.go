@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+)
+
+func Test_buildMySQLConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		dbInfo            config.DBConnInfo
+		wantTLS           bool
+		wantSkipVerify    bool
+		wantServerName    string
+		wantTLSConfigName string
+		wantErr           bool
+	}{
+		{
+			name:   "Default: no TLS",
+			dbInfo: config.DBConnInfo{Name: "instance01", Host: "10.0.1.5", Port: 3306},
+		},
+		{
+			name:              "Preferred TLS is handled by the driver",
+			dbInfo:            config.DBConnInfo{Name: "instance01", Host: "10.0.1.5", Port: 3306, TLS: config.TLSConfig{Mode: "preferred"}},
+			wantTLSConfigName: "preferred",
+		},
+		{
+			name:           "Required TLS without verification",
+			dbInfo:         config.DBConnInfo{Name: "instance01", Host: "10.0.1.5", Port: 3306, TLS: config.TLSConfig{Mode: "required"}},
+			wantTLS:        true,
+			wantSkipVerify: true,
+		},
+		{
+			name:           "Verify identity with the system CA",
+			dbInfo:         config.DBConnInfo{Name: "cloud", Host: "gateway01.us-west-2.prod.aws.tidbcloud.com", Port: 4000, TLS: config.TLSConfig{Mode: "verify-identity"}},
+			wantTLS:        true,
+			wantServerName: "gateway01.us-west-2.prod.aws.tidbcloud.com",
+		},
+		{
+			name:    "Missing CA file",
+			dbInfo:  config.DBConnInfo{Name: "cloud", Host: "127.0.0.1", Port: 4000, TLS: config.TLSConfig{Mode: "verify-ca", CA: "/not/exists/ca.pem"}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown TLS mode",
+			dbInfo:  config.DBConnInfo{Name: "cloud", Host: "127.0.0.1", Port: 4000, TLS: config.TLSConfig{Mode: "always"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildMySQLConfig(tt.dbInfo, "db")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMySQLConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got.TLS != nil) != tt.wantTLS {
+				t.Fatalf("buildMySQLConfig() TLS = %v, want %v", got.TLS != nil, tt.wantTLS)
+			}
+			if got.TLSConfig != tt.wantTLSConfigName {
+				t.Errorf("buildMySQLConfig() TLSConfig = %v, want %v", got.TLSConfig, tt.wantTLSConfigName)
+			}
+			if got.TLS != nil {
+				if got.TLS.InsecureSkipVerify != tt.wantSkipVerify {
+					t.Errorf("buildMySQLConfig() InsecureSkipVerify = %v, want %v", got.TLS.InsecureSkipVerify, tt.wantSkipVerify)
+				}
+				if got.TLS.ServerName != tt.wantServerName {
+					t.Errorf("buildMySQLConfig() ServerName = %v, want %v", got.TLS.ServerName, tt.wantServerName)
+				}
+			}
+		})
+	}
+}
+
+func Test_buildMySQLConfig_sessionVariables(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"String value", "sql_mode", "ANSI_QUOTES,STRICT_TRANS_TABLES", "'ANSI_QUOTES,STRICT_TRANS_TABLES'", false},
+		{"Time zone", "time_zone", "+00:00", "'+00:00'", false},
+		{"Number", "max_execution_time", "60000", "60000", false},
+		{"Quoted value", "tidb_isolation_read_engines", "'tikv'", "'tikv'", false},
+		{"Quote in value", "sql_mode", "a'; SET autocommit=0; '", "'a''; SET autocommit=0; '''", false},
+		{"Backslash in value", "sql_mode", `a\`, "", true},
+		{"Invalid name", "sql_mode=1, autocommit", "0", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbInfo := config.DBConnInfo{Name: "instance01", Host: "10.0.1.5", Port: 3306, SessionVariables: map[string]string{tt.key: tt.value}}
+			got, err := buildMySQLConfig(dbInfo, "db")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMySQLConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Params[tt.key] != tt.want {
+				t.Errorf("buildMySQLConfig() Params[%s] = %v, want %v", tt.key, got.Params[tt.key], tt.want)
+			}
+		})
+	}
+}
+
+func Test_buildMySQLConfig_options(t *testing.T) {
+	dbInfo := config.DBConnInfo{
+		Name:              "instance01",
+		Host:              "10.0.1.5",
+		Port:              3306,
+		User:              "dmuser",
+		Password:          "1234Abcd",
+		ConnectTimeout:    5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		Charset:           "utf8mb4",
+		SessionVariables:  map[string]string{"tidb_isolation_read_engines": "'tikv'"},
+		InterpolateParams: true,
+	}
+	got, err := buildMySQLConfig(dbInfo, "db")
+	if err != nil {
+		t.Fatalf("buildMySQLConfig() error = %v", err)
+	}
+	if got.Addr != "10.0.1.5:3306" || got.DBName != "db" {
+		t.Errorf("buildMySQLConfig() Addr = %v, DBName = %v", got.Addr, got.DBName)
+	}
+	if got.Timeout != 5*time.Second || got.ReadTimeout != 30*time.Second {
+		t.Errorf("buildMySQLConfig() Timeout = %v, ReadTimeout = %v", got.Timeout, got.ReadTimeout)
+	}
+	if !got.InterpolateParams {
+		t.Errorf("buildMySQLConfig() InterpolateParams = false, want true")
+	}
+	if got.Params["tidb_isolation_read_engines"] != "'tikv'" {
+		t.Errorf("buildMySQLConfig() Params = %v", got.Params)
+	}
+}
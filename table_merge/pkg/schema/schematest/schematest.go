@@ -0,0 +1,36 @@
+// Package schematest provides the in-memory schema.Source shared by the tests of the packages
+// reading the table definitions.
+package schematest
+
+import (
+	"slices"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+)
+
+// StaticSource is the fake schema.Source of the tests, the table definitions are kept in memory
+type StaticSource struct {
+	Defs []schema.TableDef
+}
+
+// Tables returns the table definitions of the schemas
+func (s StaticSource) Tables(schemas []string) ([]schema.TableDef, error) {
+	tables := []schema.TableDef{}
+	for _, table := range s.Defs {
+		if slices.Contains(schemas, table.Schema) {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// Close does nothing
+func (s StaticSource) Close() error { return nil }
+
+// Open returns the OpenSource of mapping.Options opening the source of the instance name
+func Open(sources map[string]StaticSource) func(config.DBConnInfo) (schema.Source, error) {
+	return func(dbInfo config.DBConnInfo) (schema.Source, error) {
+		return sources[dbInfo.Name], nil
+	}
+}
@@ -0,0 +1,428 @@
+package schema
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+)
+
+// originColumnNames are the columns added to the merged tables to keep the origin of the row.
+// They are ignored when the table structures are compared.
+var originColumnNames = map[string]bool{"c_instance": true, "c_schema": true, "c_table": true}
+
+// ColumnDef is the column metadata normalised to the MySQL data types so that the tables
+// of different engines can be compared.
+type ColumnDef struct {
+	Name              string
+	DataType          string
+	Nullable          bool
+	CharMaxLength     sql.NullInt64
+	NumericPrecision  sql.NullInt64
+	NumericScale      sql.NullInt64
+	DatetimePrecision sql.NullInt64
+}
+
+// TableDef is the column metadata of one table
+type TableDef struct {
+	Schema  string
+	Table   string
+	Columns []ColumnDef
+}
+
+// Source reads the table definitions from one database engine
+type Source interface {
+	// Tables returns the tables of the schemas ordered by schema and table name
+	Tables(schemas []string) ([]TableDef, error)
+	Close() error
+}
+
+// Open opens the schema source of the engine configured for the instance
+func Open(dbInfo config.DBConnInfo) (Source, error) {
+	switch strings.ToLower(dbInfo.Engine) {
+	case "", "mysql", "tidb":
+		db, err := OpenDB(dbInfo, dbInfo.DBs[0])
+		if err != nil {
+			return nil, err
+		}
+		return &mysqlSchemaSource{db: db}, nil
+	case "postgresql", "postgres":
+		db, err := openPostgresDB(dbInfo)
+		if err != nil {
+			return nil, err
+		}
+		return &postgresSchemaSource{db: db}, nil
+	default:
+		return nil, fmt.Errorf("unsupported engine %s of %s", dbInfo.Engine, dbInfo.Name)
+	}
+}
+
+// nullableNumber formats the column attribute as CONCAT_WS does, NULL is skipped
+func nullableNumber(v sql.NullInt64) []string {
+	if !v.Valid {
+		return nil
+	}
+	return []string{strconv.FormatInt(v.Int64, 10)}
+}
+
+// ColumnNames returns the names of all the columns in their ordinal order
+func (t TableDef) ColumnNames() []string {
+	names := make([]string, 0, len(t.Columns))
+	for _, column := range t.Columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+// SortedColumns returns the compared columns ordered by name
+func (t TableDef) SortedColumns() []ColumnDef {
+	columns := make([]ColumnDef, 0, len(t.Columns))
+	for _, column := range t.Columns {
+		if originColumnNames[strings.ToLower(column.Name)] {
+			continue
+		}
+		columns = append(columns, column)
+	}
+	// MySQL orders the column names case-insensitively
+	sort.SliceStable(columns, func(i, j int) bool {
+		return strings.ToLower(columns[i].Name) < strings.ToLower(columns[j].Name)
+	})
+	return columns
+}
+
+// MD5Columns hashes the column names
+func (t TableDef) MD5Columns() string {
+	names := []string{}
+	for _, column := range t.SortedColumns() {
+		names = append(names, column.Name)
+	}
+	sum := md5.Sum([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// TypeString formats the column type as in the DDL, e.g. decimal(10,2)
+func (c ColumnDef) TypeString() string {
+	switch {
+	case c.CharMaxLength.Valid && (c.DataType == "char" || c.DataType == "varchar" || c.DataType == "binary" || c.DataType == "varbinary"):
+		return fmt.Sprintf("%s(%d)", c.DataType, c.CharMaxLength.Int64)
+	case c.DataType == "decimal" && c.NumericPrecision.Valid:
+		return fmt.Sprintf("%s(%d,%d)", c.DataType, c.NumericPrecision.Int64, c.NumericScale.Int64)
+	case c.DatetimePrecision.Valid && c.DatetimePrecision.Int64 > 0:
+		return fmt.Sprintf("%s(%d)", c.DataType, c.DatetimePrecision.Int64)
+	default:
+		return c.DataType
+	}
+}
+
+// typeSignature is the column name with its type attributes, name:type:nullable:length:precision:scale:datetime-precision
+func (c ColumnDef) typeSignature() string {
+	nullable := "NO"
+	if c.Nullable {
+		nullable = "YES"
+	}
+	parts := []string{c.Name, c.DataType, nullable}
+	parts = append(parts, nullableNumber(c.CharMaxLength)...)
+	parts = append(parts, nullableNumber(c.NumericPrecision)...)
+	parts = append(parts, nullableNumber(c.NumericScale)...)
+	parts = append(parts, nullableNumber(c.DatetimePrecision)...)
+	return strings.Join(parts, ":")
+}
+
+// MD5ColumnsWithTypes hashes the column names with their type attributes
+func (t TableDef) MD5ColumnsWithTypes() string {
+	signatures := []string{}
+	for _, column := range t.SortedColumns() {
+		signatures = append(signatures, column.typeSignature())
+	}
+	sum := md5.Sum([]byte(strings.Join(signatures, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasColumn reports whether the table has the column, the name is case-insensitive
+func (t TableDef) HasColumn(name string) bool {
+	for _, column := range t.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// integerTypes ignore the display width, int(2) and int are the same column
+var integerTypes = map[string]bool{"bigint": true, "int": true, "mediumint": true, "smallint": true, "tinyint": true}
+
+// normalizeColumn applies the rules shared by all the engines after the type mapping
+func normalizeColumn(column ColumnDef) ColumnDef {
+	column.DataType = strings.ToLower(column.DataType)
+	if integerTypes[column.DataType] {
+		column.NumericPrecision = sql.NullInt64{Int64: 0, Valid: true}
+	}
+	return column
+}
+
+// queryColumns reads the information_schema like rows of (schema, table, column, data type,
+// nullable, length, precision, scale, datetime precision) into table definitions. The rows
+// must be ordered by schema, table and column position.
+func queryColumns(db *sql.DB, query string, args []any, mapColumn func(ColumnDef) ColumnDef) ([]TableDef, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %w", err)
+	}
+	defer rows.Close()
+
+	tables := []TableDef{}
+	for rows.Next() {
+		var schema, table, isNullable string
+		var column ColumnDef
+		if err := rows.Scan(&schema, &table, &column.Name, &column.DataType, &isNullable,
+			&column.CharMaxLength, &column.NumericPrecision, &column.NumericScale, &column.DatetimePrecision); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		column.Nullable = strings.EqualFold(isNullable, "YES")
+		column = normalizeColumn(mapColumn(column))
+
+		if len(tables) == 0 || tables[len(tables)-1].Schema != schema || tables[len(tables)-1].Table != table {
+			tables = append(tables, TableDef{Schema: schema, Table: table})
+		}
+		tables[len(tables)-1].Columns = append(tables[len(tables)-1].Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return tables, nil
+}
+
+// placeholders returns the bind variables of the IN list, ? or $n
+func placeholders(count int, numbered bool) string {
+	items := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		if numbered {
+			items = append(items, fmt.Sprintf("$%d", i))
+		} else {
+			items = append(items, "?")
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func schemaArgs(schemas []string) []any {
+	args := make([]any, 0, len(schemas))
+	for _, schema := range schemas {
+		args = append(args, schema)
+	}
+	return args
+}
+
+// mysqlSchemaSource reads the tables of MySQL and TiDB
+type mysqlSchemaSource struct {
+	db *sql.DB
+}
+
+func (s *mysqlSchemaSource) Tables(schemas []string) ([]TableDef, error) {
+	query := fmt.Sprintf(`
+		SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, DATA_TYPE, IS_NULLABLE,
+		       CHARACTER_MAXIMUM_LENGTH, NUMERIC_PRECISION, NUMERIC_SCALE, DATETIME_PRECISION
+		  FROM INFORMATION_SCHEMA.COLUMNS
+		 WHERE TABLE_SCHEMA IN (%s)
+		 ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION`, placeholders(len(schemas), false))
+	slog.Debug("querying mysql columns", "schemas", strings.Join(schemas, ","))
+	return queryColumns(s.db, query, schemaArgs(schemas), func(column ColumnDef) ColumnDef { return column })
+}
+
+func (s *mysqlSchemaSource) Close() error {
+	return s.db.Close()
+}
+
+// pgTypeMapping maps the PostgreSQL udt_name to the MySQL data type. The length of the types
+// without a limit in PostgreSQL is the one of the mapped MySQL type.
+var pgTypeMapping = map[string]struct {
+	DataType      string
+	CharMaxLength int64
+	Precision     int64
+}{
+	"int2":        {DataType: "smallint"},
+	"int4":        {DataType: "int"},
+	"int8":        {DataType: "bigint"},
+	"bool":        {DataType: "tinyint"},
+	"numeric":     {DataType: "decimal"},
+	"float4":      {DataType: "float", Precision: 12},
+	"float8":      {DataType: "double", Precision: 22},
+	"varchar":     {DataType: "varchar"},
+	"bpchar":      {DataType: "char"},
+	"text":        {DataType: "longtext", CharMaxLength: 4294967295},
+	"bytea":       {DataType: "longblob", CharMaxLength: 4294967295},
+	"uuid":        {DataType: "char", CharMaxLength: 36},
+	"json":        {DataType: "json"},
+	"jsonb":       {DataType: "json"},
+	"date":        {DataType: "date"},
+	"time":        {DataType: "time"},
+	"timestamp":   {DataType: "datetime"},
+	"timestamptz": {DataType: "timestamp"},
+}
+
+// mapPostgresColumn converts the PostgreSQL column to the MySQL type. The DataType of
+// the column is the udt_name.
+func mapPostgresColumn(column ColumnDef) ColumnDef {
+	mapping, ok := pgTypeMapping[column.DataType]
+	if !ok {
+		slog.Warn("postgresql type not in the type mapping, compared as it is", "column", column.Name, "type", column.DataType)
+		return column
+	}
+	column.DataType = mapping.DataType
+	if mapping.CharMaxLength > 0 {
+		column.CharMaxLength = sql.NullInt64{Int64: mapping.CharMaxLength, Valid: true}
+	}
+	if mapping.Precision > 0 {
+		column.NumericPrecision = sql.NullInt64{Int64: mapping.Precision, Valid: true}
+		column.NumericScale = sql.NullInt64{}
+	}
+	switch mapping.DataType {
+	case "date", "json", "longtext", "longblob", "char", "varchar":
+		// MySQL has no datetime precision on these types
+		column.DatetimePrecision = sql.NullInt64{}
+	}
+	if integerTypes[mapping.DataType] {
+		column.NumericScale = sql.NullInt64{Int64: 0, Valid: true}
+	}
+	return column
+}
+
+// postgresSchemaSource reads the tables of PostgreSQL. The DBs of the instance are the
+// schemas of the database.
+type postgresSchemaSource struct {
+	db *sql.DB
+}
+
+func (s *postgresSchemaSource) Tables(schemas []string) ([]TableDef, error) {
+	query := fmt.Sprintf(`
+		SELECT c.table_schema, c.table_name, c.column_name, c.udt_name, c.is_nullable,
+		       c.character_maximum_length, c.numeric_precision, c.numeric_scale, c.datetime_precision
+		  FROM information_schema.columns c
+		  JOIN information_schema.tables t
+		    ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		 WHERE c.table_schema IN (%s) AND t.table_type = 'BASE TABLE'
+		 ORDER BY c.table_schema, c.table_name, c.ordinal_position`, placeholders(len(schemas), true))
+	slog.Debug("querying postgresql columns", "schemas", strings.Join(schemas, ","))
+	return queryColumns(s.db, query, schemaArgs(schemas), mapPostgresColumn)
+}
+
+func (s *postgresSchemaSource) Close() error {
+	return s.db.Close()
+}
+
+// IDRange returns the smallest and the largest value of the integer column of the table, 0 and
+// 0 if the table is empty. Only MySQL and TiDB are supported, the ranges are used by DM.
+func IDRange(dbInfo config.DBConnInfo, schemaName, table, column string) (int64, int64, error) {
+	switch strings.ToLower(dbInfo.Engine) {
+	case "", "mysql", "tidb":
+	default:
+		return 0, 0, fmt.Errorf("id range of engine %s is not supported", dbInfo.Engine)
+	}
+	db, err := OpenDB(dbInfo, schemaName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	var minID, maxID sql.NullInt64
+	query := fmt.Sprintf("SELECT MIN(`%s`), MAX(`%s`) FROM `%s`.`%s`", column, column, schemaName, table)
+	slog.Debug("querying id range", "dbName", dbInfo.Name, "query", query)
+	if err := db.QueryRow(query).Scan(&minID, &maxID); err != nil {
+		return 0, 0, fmt.Errorf("query id range of %s.%s: %w", schemaName, table, err)
+	}
+	return minID.Int64, maxID.Int64, nil
+}
+
+// TableSize is the statistics of a table in INFORMATION_SCHEMA.TABLES. The values are the
+// estimates of the engine, not counted.
+type TableSize struct {
+	Rows        int64 `json:"rows"`
+	DataLength  int64 `json:"data_length"`
+	IndexLength int64 `json:"index_length"`
+}
+
+// Add returns the sum of the sizes
+func (s TableSize) Add(other TableSize) TableSize {
+	return TableSize{Rows: s.Rows + other.Rows, DataLength: s.DataLength + other.DataLength, IndexLength: s.IndexLength + other.IndexLength}
+}
+
+// TableSizes returns the statistics of the tables of the schemas indexed by schema.table
+func TableSizes(dbInfo config.DBConnInfo, schemas []string) (map[string]TableSize, error) {
+	var query string
+	numbered := false
+	switch strings.ToLower(dbInfo.Engine) {
+	case "", "mysql", "tidb":
+		query = `
+		SELECT TABLE_SCHEMA, TABLE_NAME, TABLE_ROWS, DATA_LENGTH, INDEX_LENGTH
+		  FROM INFORMATION_SCHEMA.TABLES
+		 WHERE TABLE_SCHEMA IN (%s) AND TABLE_TYPE = 'BASE TABLE'`
+	case "postgresql", "postgres":
+		// reltuples is -1 until the table is analyzed
+		query = `
+		SELECT n.nspname, c.relname, GREATEST(c.reltuples, 0)::bigint, pg_table_size(c.oid), pg_indexes_size(c.oid)
+		  FROM pg_class c
+		  JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE n.nspname IN (%s) AND c.relkind IN ('r', 'p')`
+		numbered = true
+	default:
+		return nil, fmt.Errorf("table sizes of engine %s are not supported", dbInfo.Engine)
+	}
+
+	var db *sql.DB
+	var err error
+	if numbered {
+		db, err = openPostgresDB(dbInfo)
+	} else {
+		db, err = OpenDB(dbInfo, dbInfo.DBs[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	slog.Debug("querying table sizes", "dbName", dbInfo.Name, "schemas", strings.Join(schemas, ","))
+	return queryTableSizes(db, fmt.Sprintf(query, placeholders(len(schemas), numbered)), schemaArgs(schemas))
+}
+
+// queryTableSizes reads the rows of (schema, table, rows, data length, index length). The
+// statistics of the views and the tables never analyzed are NULL and read as 0.
+func queryTableSizes(db *sql.DB, query string, args []any) (map[string]TableSize, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %w", err)
+	}
+	defer rows.Close()
+
+	sizes := map[string]TableSize{}
+	for rows.Next() {
+		var schema, table string
+		var tableRows, dataLength, indexLength sql.NullInt64
+		if err := rows.Scan(&schema, &table, &tableRows, &dataLength, &indexLength); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		sizes[schema+"."+table] = TableSize{Rows: tableRows.Int64, DataLength: dataLength.Int64, IndexLength: indexLength.Int64}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return sizes, nil
+}
+
+// FormatBytes formats the size in the binary units, e.g. 1.5 GiB
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,200 @@
+package schema
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchemaSource is the fake Source of the tests. The columns are kept in an
+// information_schema like table of an in-memory SQLite database.
+type sqliteSchemaSource struct {
+	db        *sql.DB
+	mapColumn func(ColumnDef) ColumnDef
+}
+
+type fakeColumn struct {
+	schema, table, column, dataType, nullable string
+	length, precision, scale, dtPrecision     any
+}
+
+func newSQLiteSchemaSource(t *testing.T, mapColumn func(ColumnDef) ColumnDef, columns []fakeColumn) *sqliteSchemaSource {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE columns (
+		table_schema TEXT, table_name TEXT, column_name TEXT, data_type TEXT, is_nullable TEXT,
+		character_maximum_length INTEGER, numeric_precision INTEGER, numeric_scale INTEGER,
+		datetime_precision INTEGER, ordinal_position INTEGER)`); err != nil {
+		t.Fatalf("failed to create columns table: %v", err)
+	}
+	for i, c := range columns {
+		if _, err := db.Exec(`INSERT INTO columns VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.schema, c.table, c.column, c.dataType, c.nullable, c.length, c.precision, c.scale, c.dtPrecision, i); err != nil {
+			t.Fatalf("failed to insert column: %v", err)
+		}
+	}
+	return &sqliteSchemaSource{db: db, mapColumn: mapColumn}
+}
+
+func (s *sqliteSchemaSource) Tables(schemas []string) ([]TableDef, error) {
+	query := `SELECT table_schema, table_name, column_name, data_type, is_nullable,
+		character_maximum_length, numeric_precision, numeric_scale, datetime_precision
+		FROM columns WHERE table_schema IN (` + placeholders(len(schemas), false) + `)
+		ORDER BY table_schema, table_name, ordinal_position`
+	return queryColumns(s.db, query, schemaArgs(schemas), s.mapColumn)
+}
+
+func (s *sqliteSchemaSource) Close() error {
+	return s.db.Close()
+}
+
+func mysqlColumns(schema, table string) []fakeColumn {
+	return []fakeColumn{
+		{schema, table, "id", "bigint", "NO", nil, 19, 0, nil},
+		{schema, table, "name", "varchar", "YES", 64, nil, nil, nil},
+		{schema, table, "amount", "decimal", "YES", nil, 10, 2, nil},
+		{schema, table, "created_at", "datetime", "NO", nil, nil, nil, 6},
+	}
+}
+
+func postgresColumns(schema, table string) []fakeColumn {
+	return []fakeColumn{
+		{schema, table, "id", "int8", "NO", nil, 64, 0, nil},
+		{schema, table, "name", "varchar", "YES", 64, nil, nil, nil},
+		{schema, table, "amount", "numeric", "YES", nil, 10, 2, nil},
+		{schema, table, "created_at", "timestamp", "NO", nil, nil, nil, 6},
+	}
+}
+
+func identityColumn(column ColumnDef) ColumnDef { return column }
+
+func Test_TableDefHash(t *testing.T) {
+	source := newSQLiteSchemaSource(t, identityColumn, append(mysqlColumns("db_00", "orders"),
+		fakeColumn{"db_00", "orders", "c_instance", "varchar", "NO", 64, nil, nil, nil}))
+	defer source.Close()
+
+	tables, err := source.Tables([]string{"db_00"})
+	if err != nil {
+		t.Fatalf("Tables() error = %v", err)
+	}
+	if len(tables) != 1 {
+		t.Fatalf("Tables() returned %d tables, want 1", len(tables))
+	}
+
+	// Same strings as GROUP_CONCAT/CONCAT_WS of INFORMATION_SCHEMA.COLUMNS, the origin columns are ignored
+	wantColumns := md5.Sum([]byte("amount,created_at,id,name"))
+	if got := tables[0].MD5Columns(); got != hex.EncodeToString(wantColumns[:]) {
+		t.Errorf("MD5Columns() = %v, want %v", got, hex.EncodeToString(wantColumns[:]))
+	}
+	wantTypes := md5.Sum([]byte("amount:decimal:YES:10:2,created_at:datetime:NO:6,id:bigint:NO:0:0,name:varchar:YES:64"))
+	if got := tables[0].MD5ColumnsWithTypes(); got != hex.EncodeToString(wantTypes[:]) {
+		t.Errorf("MD5ColumnsWithTypes() = %v, want %v", got, hex.EncodeToString(wantTypes[:]))
+	}
+}
+
+func Test_TableDefHashAcrossEngines(t *testing.T) {
+	mysqlSource := newSQLiteSchemaSource(t, identityColumn, mysqlColumns("db_00", "orders"))
+	defer mysqlSource.Close()
+	postgresSource := newSQLiteSchemaSource(t, mapPostgresColumn, postgresColumns("public", "orders"))
+	defer postgresSource.Close()
+
+	mysqlTables, err := mysqlSource.Tables([]string{"db_00"})
+	if err != nil {
+		t.Fatalf("Tables() error = %v", err)
+	}
+	postgresTables, err := postgresSource.Tables([]string{"public"})
+	if err != nil {
+		t.Fatalf("Tables() error = %v", err)
+	}
+	if len(mysqlTables) != 1 || len(postgresTables) != 1 {
+		t.Fatalf("Tables() returned %d and %d tables, want 1", len(mysqlTables), len(postgresTables))
+	}
+
+	// The postgresql types are mapped to the mysql ones so that the tables are grouped together
+	if mysqlTables[0].MD5Columns() != postgresTables[0].MD5Columns() ||
+		mysqlTables[0].MD5ColumnsWithTypes() != postgresTables[0].MD5ColumnsWithTypes() {
+		t.Errorf("hashes differ: mysql %+v, postgresql %+v", mysqlTables[0].Columns, postgresTables[0].Columns)
+	}
+}
+
+func Test_mapPostgresColumn(t *testing.T) {
+	tests := []struct {
+		name     string
+		column   ColumnDef
+		wantType string
+		wantLen  int64
+	}{
+		{"text", ColumnDef{Name: "c", DataType: "text"}, "longtext", 4294967295},
+		{"uuid", ColumnDef{Name: "c", DataType: "uuid"}, "char", 36},
+		{"bool", ColumnDef{Name: "c", DataType: "bool"}, "tinyint", 0},
+		{"unknown", ColumnDef{Name: "c", DataType: "tsvector"}, "tsvector", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeColumn(mapPostgresColumn(tt.column))
+			if got.DataType != tt.wantType || got.CharMaxLength.Int64 != tt.wantLen {
+				t.Errorf("mapPostgresColumn() = %+v, want %s(%d)", got, tt.wantType, tt.wantLen)
+			}
+		})
+	}
+}
+
+func Test_queryTableSizes(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE tables (
+		table_schema TEXT, table_name TEXT, table_rows INTEGER, data_length INTEGER, index_length INTEGER)`); err != nil {
+		t.Fatalf("failed to create tables table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO tables VALUES
+		('db_00', 'orders', 1000, 65536, 16384),
+		('db_00', 'audit', NULL, NULL, NULL),
+		('db_01', 'orders', 10, 16384, 0)`); err != nil {
+		t.Fatalf("failed to insert tables: %v", err)
+	}
+
+	sizes, err := queryTableSizes(db, `SELECT table_schema, table_name, table_rows, data_length, index_length
+		FROM tables WHERE table_schema IN (`+placeholders(1, false)+`)`, schemaArgs([]string{"db_00"}))
+	if err != nil {
+		t.Fatalf("queryTableSizes() error = %v", err)
+	}
+	want := map[string]TableSize{
+		"db_00.orders": {Rows: 1000, DataLength: 65536, IndexLength: 16384},
+		"db_00.audit":  {},
+	}
+	if len(sizes) != len(want) {
+		t.Fatalf("queryTableSizes() = %+v, want %+v", sizes, want)
+	}
+	for table, size := range want {
+		if sizes[table] != size {
+			t.Errorf("size of %s = %+v, want %+v", table, sizes[table], size)
+		}
+	}
+}
+
+func Test_FormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{256 << 20, "256.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.bytes); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %s, want %s", tt.bytes, got, tt.want)
+		}
+	}
+}
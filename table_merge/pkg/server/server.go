@@ -0,0 +1,533 @@
+// Package server exposes the table mapping, the artifact rendering and the sync-diff summary
+// parsing over a JSON REST API. The schema fetch and the rule synthesis take minutes on a big
+// instance, so they run as jobs which the client polls by their id.
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/render"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/rules"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/syncdiff"
+	"gopkg.in/yaml.v3"
+)
+
+// Status of a job
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Kind of a job
+const (
+	KindMapping   = "mapping"
+	KindRules     = "rules"
+	KindArtifacts = "artifacts"
+)
+
+// Artifact names accepted by the artifacts job
+const (
+	ArtifactDumpling  = "dumpling"
+	ArtifactLightning = "lightning"
+	ArtifactSyncDiff  = "sync-diff"
+	ArtifactDM        = "dm"
+	ArtifactDDL       = "ddl"
+)
+
+// defaultArtifacts are rendered if the request names none
+var defaultArtifacts = []string{ArtifactDumpling, ArtifactSyncDiff, ArtifactDM}
+
+// maxSummarySize limits the uploaded sync-diff summary
+const maxSummarySize = 64 << 20
+
+// maxRequestSize limits the body of the job requests
+const maxRequestSize = 4 << 20
+
+// The finished jobs are evicted after defaultJobTTL or when there are more than defaultMaxJobs
+const (
+	defaultJobTTL  = 24 * time.Hour
+	defaultMaxJobs = 100
+)
+
+// Options configures the Server
+type Options struct {
+	// SecretKeyFile is the local key decrypting the enc: passwords of the submitted configs
+	SecretKeyFile string
+	// AllowPasswordRefs resolves the env:, file: and enc: passwords of the submitted configs on
+	// the server. They are rejected if false, otherwise any client could send the secrets of
+	// the server to a host of its own.
+	AllowPasswordRefs bool
+	// JobTTL is how long a finished job is kept, defaultJobTTL if zero
+	JobTTL time.Duration
+	// MaxJobs is the number of jobs kept, the oldest finished jobs are evicted first.
+	// defaultMaxJobs if zero.
+	MaxJobs int
+	// Mapping is passed to mapping.Build, OpenSource replaces the database connections
+	Mapping mapping.Options
+	// Rules is the LLM of the rule synthesis, LLMProduct is overridden by the request
+	Rules rules.Options
+}
+
+// JobRequest is the body of the job endpoints. It is decoded by the YAML decoder, so the
+// config has the same keys as the config file.
+type JobRequest struct {
+	Config config.Config `yaml:"config"`
+	// LLM is the LLM product of the rule synthesis(openai, deepseek)
+	LLM string `yaml:"llm"`
+	// Artifacts are the files to render: dumpling, lightning, sync-diff, dm and ddl.
+	// dumpling, sync-diff and dm if empty.
+	Artifacts []string `yaml:"artifacts"`
+}
+
+// Job is one asynchronous step of the pipeline
+type Job struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Status    string    `json:"status"`
+	Step      string    `json:"step,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Tables is the grouping of the mapping and rules jobs
+	Tables []mapping.TableInfo `json:"tables,omitempty"`
+	// Files are the paths in the archive of the artifacts job
+	Files []string `json:"files,omitempty"`
+
+	archive []byte
+}
+
+// Server runs the jobs and serves the API. The jobs are kept in memory until they are evicted
+// or the process exits.
+type Server struct {
+	opts Options
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	wg   sync.WaitGroup
+}
+
+// New returns the Server of the options
+func New(opts Options) *Server {
+	if opts.JobTTL == 0 {
+		opts.JobTTL = defaultJobTTL
+	}
+	if opts.MaxJobs == 0 {
+		opts.MaxJobs = defaultMaxJobs
+	}
+	return &Server{opts: opts, jobs: map[string]*Job{}}
+}
+
+// Handler returns the routes of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/health", s.handleHealth)
+	mux.HandleFunc("POST /api/v1/jobs/{kind}", s.handleSubmit)
+	mux.HandleFunc("GET /api/v1/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /api/v1/jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("GET /api/v1/jobs/{id}/artifacts.zip", s.handleArchive)
+	mux.HandleFunc("POST /api/v1/syncdiff/summary", s.handleSummary)
+	return mux
+}
+
+// Wait blocks until the running jobs are finished
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// decodeRequest reads the job request and resolves its config like config.Load
+func (s *Server) decodeRequest(w http.ResponseWriter, r *http.Request) (JobRequest, error) {
+	var req JobRequest
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		return JobRequest{}, fmt.Errorf("failed to read request: %w", err)
+	}
+	if err := yaml.Unmarshal(body, &req); err != nil {
+		return JobRequest{}, fmt.Errorf("failed to parse request: %w", err)
+	}
+	loadOpts := config.LoadOptions{SecretKeyFile: s.opts.SecretKeyFile, RejectPasswordRefs: !s.opts.AllowPasswordRefs}
+	if err := req.Config.Resolve(loadOpts); err != nil {
+		return JobRequest{}, fmt.Errorf("invalid config: %w", err)
+	}
+	if _, err := mapping.NewCompatPolicy(req.Config.TypeCompatibility); err != nil {
+		return JobRequest{}, fmt.Errorf("invalid type compatibility policy: %w", err)
+	}
+	for _, name := range req.Artifacts {
+		if !slices.Contains([]string{ArtifactDumpling, ArtifactLightning, ArtifactSyncDiff, ArtifactDM, ArtifactDDL}, name) {
+			return JobRequest{}, fmt.Errorf("unknown artifact %q", name)
+		}
+	}
+	if len(req.Artifacts) == 0 {
+		req.Artifacts = defaultArtifacts
+	}
+	return req, nil
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	var run func(job *Job, req JobRequest) error
+	switch kind {
+	case KindMapping:
+		run = s.runMapping
+	case KindRules:
+		run = s.runRules
+	case KindArtifacts:
+		run = s.runArtifacts
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job kind %q", kind))
+		return
+	}
+
+	req, err := s.decodeRequest(w, r)
+	if err != nil {
+		slog.Warn("rejected job request", "kind", kind, "error", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job := s.newJob(kind)
+	slog.Info("job submitted", "id", job.ID, "kind", kind)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.update(job.ID, func(job *Job) { job.Status = JobRunning })
+		if err := runJob(run, job, req); err != nil {
+			slog.Error("job failed", "id", job.ID, "kind", kind, "error", err)
+			s.update(job.ID, func(job *Job) {
+				job.Status = JobFailed
+				job.Error = err.Error()
+			})
+			return
+		}
+		slog.Info("job finished", "id", job.ID, "kind", kind)
+		s.update(job.ID, func(job *Job) {
+			job.Status = JobDone
+			job.Step = ""
+		})
+	}()
+	writeJSON(w, http.StatusAccepted, s.snapshot(job.ID))
+}
+
+// runJob runs the job, a panic of the job fails the job instead of the server
+func runJob(run func(job *Job, req JobRequest) error, job *Job, req JobRequest) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("job panicked", "id", job.ID, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return run(job, req)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		summary := *job
+		summary.Tables, summary.Files = nil, nil
+		jobs = append(jobs, summary)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(jobs, func(a, b Job) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job := s.snapshot(r.PathValue("id"))
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	job := s.snapshot(r.PathValue("id"))
+	switch {
+	case job == nil:
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+	case job.Kind != KindArtifacts:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("job %s is a %s job, no artifacts", job.ID, job.Kind))
+	case job.Status != JobDone:
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s is %s", job.ID, job.Status))
+	default:
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "artifacts-"+job.ID+".zip"))
+		w.Write(job.archive)
+	}
+}
+
+// handleSummary parses the uploaded summary.txt of sync_diff_inspector
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	equivalent, inconsistent, err := syncdiff.Parse(http.MaxBytesReader(w, r.Body, maxSummarySize))
+	if err != nil {
+		slog.Warn("failed to parse uploaded summary", "error", err)
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to parse summary: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, syncdiff.NewOutput(equivalent, inconsistent))
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) newJob(kind string) *Job {
+	now := time.Now()
+	job := &Job{ID: newJobID(), Kind: kind, Status: JobPending, CreatedAt: now, UpdatedAt: now}
+	s.mu.Lock()
+	s.evict(now)
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	return job
+}
+
+// evict removes the finished jobs older than JobTTL, then the oldest finished jobs until there
+// is room for a new job. The pending and running jobs are never evicted. It is called under
+// the lock.
+func (s *Server) evict(now time.Time) {
+	finished := []*Job{}
+	for id, job := range s.jobs {
+		if job.Status != JobDone && job.Status != JobFailed {
+			continue
+		}
+		if now.Sub(job.UpdatedAt) > s.opts.JobTTL {
+			slog.Info("job evicted", "id", id, "kind", job.Kind, "reason", "expired")
+			delete(s.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	slices.SortFunc(finished, func(a, b *Job) int { return a.UpdatedAt.Compare(b.UpdatedAt) })
+	for _, job := range finished {
+		if len(s.jobs) < s.opts.MaxJobs {
+			break
+		}
+		slog.Info("job evicted", "id", job.ID, "kind", job.Kind, "reason", "too many jobs")
+		delete(s.jobs, job.ID)
+	}
+}
+
+// update changes the job under the lock
+func (s *Server) update(id string, fn func(job *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now()
+	}
+}
+
+// snapshot returns a copy of the job, nil if it does not exist
+func (s *Server) snapshot(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+func (s *Server) setStep(job *Job, step string) {
+	slog.Info("job step", "id", job.ID, "kind", job.Kind, "step", step)
+	s.update(job.ID, func(job *Job) { job.Step = step })
+}
+
+func (s *Server) buildMapping(job *Job, req JobRequest) ([]mapping.TableInfo, error) {
+	s.setStep(job, "fetching table definitions")
+	tableStructure, err := mapping.Build(req.Config, s.opts.Mapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch table definition: %w", err)
+	}
+	return tableStructure, nil
+}
+
+func (s *Server) assignRules(job *Job, req JobRequest, tableStructure []mapping.TableInfo) error {
+	s.setStep(job, "synthesizing rules")
+	opts := s.opts.Rules
+	if req.LLM != "" {
+		opts.LLMProduct = req.LLM
+	}
+	if err := rules.New(opts).AssignSrcRegex(tableStructure); err != nil {
+		return fmt.Errorf("failed to synthesize rules: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) runMapping(job *Job, req JobRequest) error {
+	tableStructure, err := s.buildMapping(job, req)
+	if err != nil {
+		return err
+	}
+	s.update(job.ID, func(job *Job) { job.Tables = tableStructure })
+	return nil
+}
+
+func (s *Server) runRules(job *Job, req JobRequest) error {
+	tableStructure, err := s.buildMapping(job, req)
+	if err != nil {
+		return err
+	}
+	if err := s.assignRules(job, req, tableStructure); err != nil {
+		return err
+	}
+	s.update(job.ID, func(job *Job) { job.Tables = tableStructure })
+	return nil
+}
+
+// runArtifacts renders the requested files into memory and archives them. The files are
+// rendered into a temporary output directory in plan mode, so nothing is written to disk and
+// the sync-diff-id.txt cache of a CLI run is not picked up.
+func (s *Server) runArtifacts(job *Job, req JobRequest) error {
+	cfg := req.Config
+	template, err := render.ResolveDumplingTemplate("", cfg)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dumpling template: %w", err)
+	}
+	cfg.Template = template
+	if slices.Contains(req.Artifacts, ArtifactDumpling) && cfg.Template == "" {
+		return fmt.Errorf("no dumpling template, set Template or DumplingPreset in the config")
+	}
+
+	outputDir, err := os.MkdirTemp("", "dm-toolkit-"+job.ID+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+	cfg.Output = outputDir
+	artifacts := render.NewArtifacts(outputDir, true, false)
+
+	tableStructure, err := s.buildMapping(job, req)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(req.Artifacts, ArtifactSyncDiff) || slices.Contains(req.Artifacts, ArtifactDM) {
+		if err := s.assignRules(job, req, tableStructure); err != nil {
+			return err
+		}
+	}
+	if slices.Contains(req.Artifacts, ArtifactDumpling) || (slices.Contains(req.Artifacts, ArtifactSyncDiff) && cfg.SyncDiff.Shards > 1) {
+		if err := mapping.AssignSizes(cfg, tableStructure, s.opts.Mapping); err != nil {
+			slog.Warn("table sizes not available, the exports are not planned", "id", job.ID, "error", err)
+		}
+	}
+	if slices.Contains(req.Artifacts, ArtifactDumpling) || slices.Contains(req.Artifacts, ArtifactDM) {
+		s.setStep(job, "assigning pk offsets")
+		if err := mapping.AssignPKOffsets(cfg, tableStructure, s.opts.Mapping); err != nil {
+			return err
+		}
+	}
+
+	s.setStep(job, "rendering artifacts")
+	for _, name := range req.Artifacts {
+		if err := renderArtifact(name, &cfg, tableStructure, artifacts); err != nil {
+			return fmt.Errorf("failed to render %s: %w", name, err)
+		}
+	}
+
+	archive, files, err := zipArtifacts(outputDir, artifacts.Files())
+	if err != nil {
+		return err
+	}
+	s.update(job.ID, func(job *Job) {
+		job.Tables = tableStructure
+		job.Files = files
+		job.archive = archive
+	})
+	return nil
+}
+
+// renderArtifact renders one of the artifacts like the matching --ops-type
+func renderArtifact(name string, cfg *config.Config, tableStructure []mapping.TableInfo, artifacts *render.Artifacts) error {
+	switch name {
+	case ArtifactDumpling:
+		tmpl, err := render.ParseDumplingTemplate(cfg.Template)
+		if err != nil {
+			return err
+		}
+		_, err = render.RenderDumpling(cfg, tableStructure, tmpl, artifacts)
+		return err
+	case ArtifactLightning:
+		return render.RenderLightningConfig(cfg, &tableStructure, artifacts)
+	case ArtifactSyncDiff:
+		if err := render.SetMaxID4IncreDiff(*cfg, tableStructure, artifacts); err != nil {
+			return err
+		}
+		return render.RenderSyncDiffConfig(cfg, &tableStructure, artifacts)
+	case ArtifactDM:
+		if err := render.RenderDMSourceConfig(cfg, artifacts); err != nil {
+			return err
+		}
+		return render.RenderDMTaskConfig(cfg, &tableStructure, artifacts)
+	case ArtifactDDL:
+		_, skipped, err := render.GenerateDestDDL(cfg, &tableStructure, artifacts)
+		if len(skipped) > 0 {
+			slog.Warn("destination DDL skipped for the used table names", "skippedCount", len(skipped))
+		}
+		return err
+	}
+	return fmt.Errorf("unknown artifact %q", name)
+}
+
+// zipArtifacts archives the rendered files with their paths relative to the output directory
+func zipArtifacts(outputDir string, files []render.File) ([]byte, []string, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		name, err := filepath.Rel(outputDir, file.Path)
+		if err != nil {
+			name = filepath.Base(file.Path)
+		}
+		name = filepath.ToSlash(name)
+		fw, err := zw.Create(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+		if _, err := fw.Write(file.Content); err != nil {
+			return nil, nil, fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+		names = append(names, name)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to close archive: %w", err)
+	}
+	return buf.Bytes(), names, nil
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/config"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/schema/schematest"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/syncdiff"
+)
+
+func ordersTable(schemaName string) schema.TableDef {
+	return schema.TableDef{Schema: schemaName, Table: "orders", Columns: []schema.ColumnDef{
+		{Name: "id", DataType: "bigint", NumericPrecision: sql.NullInt64{Int64: 19, Valid: true}, NumericScale: sql.NullInt64{Valid: true}},
+		{Name: "name", DataType: "varchar", CharMaxLength: sql.NullInt64{Int64: 64, Valid: true}, Nullable: true},
+	}}
+}
+
+const jobRequest = `{
+  "config": {
+    "SourceDB": [
+      {"Name": "mysql01", "Host": "127.0.0.1", "Port": 3306, "User": "root", "DBs": ["db_00"]},
+      {"Name": "mysql02", "Host": "127.0.0.2", "Port": 3306, "User": "root", "DBs": ["db_01"]}
+    ],
+    "DestDB": {"Name": "target", "Host": "127.0.0.3", "Port": 4000, "User": "root", "Password": "dest-secret", "DBs": ["messagedb"]},
+    "DumplingPreset": "csv-with-header"
+  },
+  "artifacts": [%s]
+}`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	sources := map[string]schematest.StaticSource{
+		"mysql01": {Defs: []schema.TableDef{ordersTable("db_00")}},
+		"mysql02": {Defs: []schema.TableDef{ordersTable("db_01")}},
+		"target":  {Defs: []schema.TableDef{ordersTable("messagedb")}},
+	}
+	s := New(Options{Mapping: mapping.Options{
+		OpenSource: schematest.Open(sources),
+		QueryTableSizes: func(config.DBConnInfo, []string) (map[string]schema.TableSize, error) {
+			return map[string]schema.TableSize{}, nil
+		},
+	}})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		s.Wait()
+		ts.Close()
+	})
+	return ts
+}
+
+// submit posts the job and waits until it is finished
+func submit(t *testing.T, ts *httptest.Server, kind, body string) *Job {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/api/v1/jobs/"+kind, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		content, _ := io.ReadAll(resp.Body)
+		t.Fatalf("POST %s status = %d: %s", kind, resp.StatusCode, content)
+	}
+	var job Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		resp, err := http.Get(ts.URL + "/api/v1/jobs/" + job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job = Job{}
+		err = json.NewDecoder(resp.Body).Decode(&job)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == JobDone || job.Status == JobFailed {
+			return &job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMappingJob(t *testing.T) {
+	ts := newTestServer(t)
+	job := submit(t, ts, KindMapping, strings.Replace(jobRequest, "%s", "", 1))
+	if job.Status != JobDone {
+		t.Fatalf("job status = %s, error = %s", job.Status, job.Error)
+	}
+	if len(job.Tables) != 1 || len(job.Tables[0].SrcTableInfo) != 2 || len(job.Tables[0].DestTableInfo) != 1 {
+		t.Errorf("job tables = %+v, want the two source tables grouped to the destination", job.Tables)
+	}
+}
+
+func TestArtifactsJob(t *testing.T) {
+	ts := newTestServer(t)
+	job := submit(t, ts, KindArtifacts, strings.Replace(jobRequest, "%s", `"dumpling", "dm"`, 1))
+	if job.Status != JobDone {
+		t.Fatalf("job status = %s, error = %s", job.Status, job.Error)
+	}
+	if !slices.Contains(job.Files, "dumpling.sh") {
+		t.Errorf("job files = %v, want dumpling.sh", job.Files)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v1/jobs/" + job.ID + "/artifacts.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET artifacts.zip status = %d: %s", resp.StatusCode, content)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+	if !slices.Equal(names, job.Files) {
+		t.Errorf("archive files = %v, want %v", names, job.Files)
+	}
+	// The cleartext password is never written to the artifacts
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		if bytes.Contains(body, []byte("dest-secret")) {
+			t.Errorf("%s contains the cleartext password", file.Name)
+		}
+	}
+}
+
+func TestSubmitInvalidRequest(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name string
+		kind string
+		body string
+		want int
+	}{
+		{name: "unknown kind", kind: "unknown", body: strings.Replace(jobRequest, "%s", "", 1), want: http.StatusNotFound},
+		{name: "unknown artifact", kind: KindArtifacts, body: strings.Replace(jobRequest, "%s", `"unknown"`, 1), want: http.StatusBadRequest},
+		{name: "no source database", kind: KindMapping, body: `{"config": {"DestDB": {"Host": "127.0.0.1"}}}`, want: http.StatusBadRequest},
+		{name: "no source schema", kind: KindMapping, body: strings.Replace(strings.Replace(jobRequest, `"DBs": ["db_00"]`, `"DBs": []`, 1), "%s", "", 1), want: http.StatusBadRequest},
+		{name: "file password", kind: KindMapping, body: strings.Replace(strings.Replace(jobRequest, `"dest-secret"`, `"file:/etc/hostname"`, 1), "%s", "", 1), want: http.StatusBadRequest},
+		{name: "env password", kind: KindMapping, body: strings.Replace(strings.Replace(jobRequest, `"dest-secret"`, `"env:HOME"`, 1), "%s", "", 1), want: http.StatusBadRequest},
+		{name: "enc password", kind: KindMapping, body: strings.Replace(strings.Replace(jobRequest, `"dest-secret"`, `"enc:AAAA"`, 1), "%s", "", 1), want: http.StatusBadRequest},
+		{name: "too large request", kind: KindMapping, body: strings.Replace(jobRequest, "%s", strings.Repeat(" ", maxRequestSize), 1), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+"/api/v1/jobs/"+tt.kind, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v1/jobs/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET missing job status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestJobPanic(t *testing.T) {
+	s := New(Options{Mapping: mapping.Options{
+		OpenSource: func(dbInfo config.DBConnInfo) (schema.Source, error) {
+			panic("source unavailable")
+		},
+	}})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		s.Wait()
+		ts.Close()
+	})
+
+	job := submit(t, ts, KindMapping, strings.Replace(jobRequest, "%s", "", 1))
+	if job.Status != JobFailed || !strings.Contains(job.Error, "source unavailable") {
+		t.Errorf("job = %s %q, want failed by the panic", job.Status, job.Error)
+	}
+	// The server keeps serving after the panic
+	resp, err := http.Get(ts.URL + "/api/v1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET health status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestAllowPasswordRefs(t *testing.T) {
+	t.Setenv("TEST_SERVER_PASSWORD", "dest-secret")
+	s := New(Options{AllowPasswordRefs: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs/mapping",
+		strings.NewReader(strings.Replace(strings.Replace(jobRequest, `"dest-secret"`, `"env:TEST_SERVER_PASSWORD"`, 1), "%s", "", 1)))
+	got, err := s.decodeRequest(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("decodeRequest() error = %v", err)
+	}
+	if got.Config.DestDB.Password != "dest-secret" {
+		t.Errorf("destination password = %q, want the resolved environment variable", got.Config.DestDB.Password)
+	}
+}
+
+func TestEvictJobs(t *testing.T) {
+	s := New(Options{JobTTL: time.Hour, MaxJobs: 3})
+	now := time.Now()
+	s.jobs = map[string]*Job{
+		"expired": {ID: "expired", Status: JobDone, UpdatedAt: now.Add(-2 * time.Hour)},
+		"old":     {ID: "old", Status: JobFailed, UpdatedAt: now.Add(-30 * time.Minute)},
+		"new":     {ID: "new", Status: JobDone, UpdatedAt: now.Add(-time.Minute)},
+		"running": {ID: "running", Status: JobRunning, UpdatedAt: now.Add(-3 * time.Hour)},
+	}
+	s.newJob(KindMapping)
+	for _, id := range []string{"expired", "old"} {
+		if _, ok := s.jobs[id]; ok {
+			t.Errorf("job %s is kept, want evicted", id)
+		}
+	}
+	for _, id := range []string{"new", "running"} {
+		if _, ok := s.jobs[id]; !ok {
+			t.Errorf("job %s is evicted, want kept", id)
+		}
+	}
+	if len(s.jobs) != 3 {
+		t.Errorf("jobs count = %d, want 3", len(s.jobs))
+	}
+}
+
+func TestSummary(t *testing.T) {
+	ts := newTestServer(t)
+	summary := "The following tables contains inconsistent data\n" +
+		"+--------------------+---------+--------------------+----------------+---------+-----------+\n" +
+		"|       TABLE        | RESULT  | STRUCTURE EQUALITY | DATA DIFF ROWS | UPCOUNT | DOWNCOUNT |\n" +
+		"+--------------------+---------+--------------------+----------------+---------+-----------+\n" +
+		"| `messagedb`.`orders` | succeed | true               | +1/-0          |      10 |         9 |\n" +
+		"+--------------------+---------+--------------------+----------------+---------+-----------+\n"
+	resp, err := http.Post(ts.URL+"/api/v1/syncdiff/summary", "text/plain", strings.NewReader(summary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var output syncdiff.Output
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if output.AllEquivalent || output.TotalInconsistent != 1 || output.InconsistentTables[0].FullName != "messagedb.orders" {
+		t.Errorf("summary output = %+v, want messagedb.orders inconsistent", output)
+	}
+}
@@ -1,9 +1,10 @@
-package main
+// Package syncdiff parses the summary of sync_diff_inspector
+package syncdiff
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
@@ -22,7 +23,8 @@ type TableResult struct {
 	Result           string
 }
 
-type SyncDiffOutput struct {
+// Output is the result of a sync_diff_inspector run
+type Output struct {
 	EquivalentTables   []TableResult `json:"equivalent_tables"`
 	InconsistentTables []TableResult `json:"inconsistent_tables"`
 	TotalEquivalent    int           `json:"total_equivalent"`
@@ -37,10 +39,14 @@ func ParseSummary(filePath string) ([]TableResult, []TableResult, error) {
 		return nil, nil, err
 	}
 	defer file.Close()
+	return Parse(file)
+}
 
+// Parse parses the sync_diff_inspector summary read from r
+func Parse(r io.Reader) ([]TableResult, []TableResult, error) {
 	var equivalentTables []TableResult
 	var inconsistentTables []TableResult
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 
 	// Regex patterns
 	tablePattern := regexp.MustCompile(`\` + "`" + `([^` + "`" + `]+)` + "`" + `\.` + "`" + `([^` + "`" + `]+)` + "`")
@@ -166,31 +172,37 @@ func PrintResults(equivalent, inconsistent []TableResult) {
 		fmt.Printf("    Result: %s\n\n", t.Result)
 	}
 }
-func ParseSyncDiffOutput(summaryFile string) (*SyncDiffOutput, error) {
+
+// ParseOutput parses the summary file into the tables and their totals
+func ParseOutput(summaryFile string) (*Output, error) {
 	equivalent, inconsistent, err := ParseSummary(summaryFile)
 	if err != nil {
-		fmt.Printf("Error parsing summary: %v\n", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to parse summary %s: %w", summaryFile, err)
 	}
+	return NewOutput(equivalent, inconsistent), nil
+}
 
-	PrintResults(equivalent, inconsistent)
+// MergeSummaries parses the summary files of the sharded inspectors into one output
+func MergeSummaries(summaryFiles []string) (*Output, error) {
+	var equivalent, inconsistent []TableResult
+	for _, summaryFile := range summaryFiles {
+		shardEquivalent, shardInconsistent, err := ParseSummary(summaryFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse summary %s: %w", summaryFile, err)
+		}
+		equivalent = append(equivalent, shardEquivalent...)
+		inconsistent = append(inconsistent, shardInconsistent...)
+	}
+	return NewOutput(equivalent, inconsistent), nil
+}
 
-	// Example: Create JSON output
-	fmt.Println("\n=== JSON OUTPUT ===")
-	output := &SyncDiffOutput{
+// NewOutput returns the output of the parsed tables with their totals
+func NewOutput(equivalent, inconsistent []TableResult) *Output {
+	return &Output{
 		EquivalentTables:   equivalent,
 		InconsistentTables: inconsistent,
 		TotalEquivalent:    len(equivalent),
 		TotalInconsistent:  len(inconsistent),
 		AllEquivalent:      len(inconsistent) == 0,
 	}
-
-	// You can marshal this to JSON
-	jsonData, _ := json.MarshalIndent(output, "", "  ")
-	fmt.Println(string(jsonData))
-
-	fmt.Printf("\nSummary: %d equivalent, %d inconsistent, All Equivalent: %v\n",
-		len(equivalent), len(inconsistent), len(inconsistent) == 0)
-
-	return output, nil
 }
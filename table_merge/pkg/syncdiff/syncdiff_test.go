@@ -0,0 +1,182 @@
+package syncdiff
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func TestParseSummary(t *testing.T) {
+	type args struct {
+		filePath string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []TableResult
+		want1   []TableResult
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1, err := ParseSummary(tt.args.filePath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSummary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSummary() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(got1, tt.want1) {
+				t.Errorf("ParseSummary() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
+
+func Test_parseTableRow(t *testing.T) {
+	type args struct {
+		line    string
+		section string
+	}
+	tests := []struct {
+		name string
+		args args
+		want *TableResult
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTableRow(tt.args.line, tt.args.section); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTableRow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintResults(t *testing.T) {
+	type args struct {
+		equivalent   []TableResult
+		inconsistent []TableResult
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			PrintResults(tt.args.equivalent, tt.args.inconsistent)
+		})
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	type args struct {
+		summaryFile string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Output
+		wantErr bool
+	}{
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOutput(tt.args.summaryFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOutput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	summary := "The table structure and data in following tables are equivalent\n" +
+		"+----------------------+---------+-----------+\n" +
+		"|        TABLE         | UPCOUNT | DOWNCOUNT |\n" +
+		"+----------------------+---------+-----------+\n" +
+		"| `messagedb`.`users`  |     100 |       100 |\n" +
+		"+----------------------+---------+-----------+\n" +
+		"The following tables contains inconsistent data\n" +
+		"+----------------------+---------+--------------------+----------------+---------+-----------+\n" +
+		"|        TABLE         | RESULT  | STRUCTURE EQUALITY | DATA DIFF ROWS | UPCOUNT | DOWNCOUNT |\n" +
+		"+----------------------+---------+--------------------+----------------+---------+-----------+\n" +
+		"| `messagedb`.`orders` | succeed | true               | +1/-0          |      10 |         9 |\n" +
+		"+----------------------+---------+--------------------+----------------+---------+-----------+\n"
+	equivalent, inconsistent, err := Parse(strings.NewReader(summary))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(equivalent) != 1 || equivalent[0].FullName != "messagedb.users" {
+		t.Errorf("Parse() equivalent = %+v, want messagedb.users", equivalent)
+	}
+	want := TableResult{Schema: "messagedb", Table: "orders", FullName: "messagedb.orders", IsStructureEqual: true,
+		DataDiffRows: "+1/-0", UpCount: 10, DownCount: 9, Result: "succeed"}
+	if len(inconsistent) != 1 || !reflect.DeepEqual(inconsistent[0], want) {
+		t.Errorf("Parse() inconsistent = %+v, want %+v", inconsistent, want)
+	}
+	if output := NewOutput(equivalent, inconsistent); output.AllEquivalent || output.TotalEquivalent != 1 || output.TotalInconsistent != 1 {
+		t.Errorf("NewOutput() = %+v", output)
+	}
+}
+
+func TestMergeSummaries(t *testing.T) {
+	dir := t.TempDir()
+	shards := map[string]string{
+		"shard-01": "The table structure and data in following tables are equivalent\n" +
+			"+----------------------+---------+-----------+\n" +
+			"|        TABLE         | UPCOUNT | DOWNCOUNT |\n" +
+			"+----------------------+---------+-----------+\n" +
+			"| `messagedb`.`users`  |     100 |       100 |\n" +
+			"+----------------------+---------+-----------+\n",
+		"shard-02": "The following tables contains inconsistent data\n" +
+			"+----------------------+---------+--------------------+----------------+---------+-----------+\n" +
+			"|        TABLE         | RESULT  | STRUCTURE EQUALITY | DATA DIFF ROWS | UPCOUNT | DOWNCOUNT |\n" +
+			"+----------------------+---------+--------------------+----------------+---------+-----------+\n" +
+			"| `messagedb`.`orders` | succeed | true               | +1/-0          |      10 |         9 |\n" +
+			"+----------------------+---------+--------------------+----------------+---------+-----------+\n",
+	}
+	summaryFiles := []string{}
+	for _, shard := range []string{"shard-01", "shard-02"} {
+		summaryFile := filepath.Join(dir, shard, "summary.txt")
+		if err := os.MkdirAll(filepath.Dir(summaryFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(summaryFile, []byte(shards[shard]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		summaryFiles = append(summaryFiles, summaryFile)
+	}
+
+	output, err := MergeSummaries(summaryFiles)
+	if err != nil {
+		t.Fatalf("MergeSummaries() error = %v", err)
+	}
+	if output.AllEquivalent || output.TotalEquivalent != 1 || output.TotalInconsistent != 1 ||
+		output.EquivalentTables[0].FullName != "messagedb.users" || output.InconsistentTables[0].FullName != "messagedb.orders" {
+		t.Errorf("MergeSummaries() = %+v, want users equivalent and orders inconsistent", output)
+	}
+
+	if _, err := MergeSummaries(append(summaryFiles, filepath.Join(dir, "shard-03", "summary.txt"))); err == nil {
+		t.Errorf("MergeSummaries() error = nil, want the missing summary")
+	}
+}
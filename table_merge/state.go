@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/syncdiff"
+)
+
+// stateFileName is the ledger of the migration progress kept in the output directory
+const stateFileName = "dm-toolkit-state.json"
+
+// The status of a phase. The generators only move a phase from pending to generated, the
+// later statuses are set by `dm-toolkit status set` or parsed from the sync-diff summary.
+const (
+	phasePending   = "pending"
+	phaseGenerated = "generated"
+	phaseRunning   = "running"
+	phaseDone      = "done"
+	phaseFailed    = "failed"
+	phaseSkipped   = "skipped"
+)
+
+var phaseStatuses = []string{phasePending, phaseGenerated, phaseRunning, phaseDone, phaseFailed, phaseSkipped}
+
+// The phases of a destination table, in the order of the migration
+const (
+	phaseDump     = "dump"
+	phaseImport   = "import"
+	phaseDMTask   = "dm-task"
+	phaseSyncDiff = "sync-diff"
+)
+
+var phaseNames = []string{phaseDump, phaseImport, phaseDMTask, phaseSyncDiff}
+
+// PhaseState is the status of one phase of a destination table
+type PhaseState struct {
+	Status    string    `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// TableState is the progress of one destination table
+type TableState struct {
+	// MappingVersion is the hash of the source tables and the column types of the group
+	MappingVersion   string     `json:"mapping_version"`
+	MappingUpdatedAt time.Time  `json:"mapping_updated_at"`
+	SourceTables     int        `json:"source_tables"`
+	StructureOnly    bool       `json:"structure_only,omitempty"`
+	Dump             PhaseState `json:"dump"`
+	Import           PhaseState `json:"import"`
+	DMTask           PhaseState `json:"dm_task"`
+	SyncDiff         PhaseState `json:"sync_diff"`
+}
+
+// phase returns the state of the named phase
+func (t *TableState) phase(name string) *PhaseState {
+	switch name {
+	case phaseDump:
+		return &t.Dump
+	case phaseImport:
+		return &t.Import
+	case phaseDMTask:
+		return &t.DMTask
+	case phaseSyncDiff:
+		return &t.SyncDiff
+	default:
+		return nil
+	}
+}
+
+// stateLedger is the JSON file recording the progress of every destination table,
+// keyed by instance.schema.table of the destination
+type stateLedger struct {
+	path      string
+	UpdatedAt time.Time              `json:"updated_at"`
+	Tables    map[string]*TableState `json:"tables"`
+}
+
+// statePath returns the ledger path in the output directory
+func statePath(outputDir string) string {
+	if outputDir == "" {
+		outputDir = "."
+	}
+	return filepath.Join(outputDir, stateFileName)
+}
+
+// loadState reads the ledger, an empty ledger is returned if the file does not exist yet
+func loadState(path string) (*stateLedger, error) {
+	ledger := &stateLedger{path: path, Tables: map[string]*TableState{}}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(content, ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if ledger.Tables == nil {
+		ledger.Tables = map[string]*TableState{}
+	}
+	return ledger, nil
+}
+
+// save writes the ledger through a temporary file so that an interrupted run keeps the previous version
+func (l *stateLedger) save(now time.Time) error {
+	l.UpdatedAt = now
+	content, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write state file %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("failed to replace state file %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// mappingVersion hashes the source tables and the column types of the group
+func mappingVersion(tableInfo mapping.TableInfo) string {
+	srcTables := append([]string{}, tableInfo.SrcTableInfo...)
+	sort.Strings(srcTables)
+	sum := md5.Sum([]byte(tableInfo.MD5ColumnsWithTypes + "|" + strings.Join(srcTables, ",")))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// recordMapping adds the destination tables of the mapping to the ledger. A table whose
+// mapping changed is reset to pending since its dump, import and verification are stale.
+func (l *stateLedger) recordMapping(tableStructure []mapping.TableInfo, now time.Time) {
+	for _, tableInfo := range tableStructure {
+		version := mappingVersion(tableInfo)
+		for _, destTable := range tableInfo.DestTableInfo {
+			state, ok := l.Tables[destTable]
+			if ok && state.MappingVersion == version {
+				continue
+			}
+			if ok {
+				slog.Info("mapping changed, resetting table state", "destTable", destTable, "previousVersion", state.MappingVersion, "version", version)
+			}
+			state = &TableState{MappingVersion: version, MappingUpdatedAt: now}
+			for _, name := range phaseNames {
+				*state.phase(name) = PhaseState{Status: phasePending, UpdatedAt: now}
+			}
+			if tableInfo.StructureOnly {
+				// No data to export, import or compare, DM still replicates the DDL
+				state.StructureOnly = true
+				state.Dump = PhaseState{Status: phaseSkipped, Detail: "structure only", UpdatedAt: now}
+				state.Import = PhaseState{Status: phaseSkipped, Detail: "structure only", UpdatedAt: now}
+				state.SyncDiff = PhaseState{Status: phaseSkipped, Detail: "structure only", UpdatedAt: now}
+			}
+			state.SourceTables = len(tableInfo.SrcTableInfo)
+			l.Tables[destTable] = state
+		}
+	}
+}
+
+// markGenerated moves the phase of the mapped destination tables from pending to generated
+func (l *stateLedger) markGenerated(tableStructure []mapping.TableInfo, phase string, now time.Time) {
+	for _, tableInfo := range tableStructure {
+		for _, destTable := range tableInfo.DestTableInfo {
+			state, ok := l.Tables[destTable]
+			if !ok {
+				continue
+			}
+			if p := state.phase(phase); p.Status == phasePending || p.Status == "" {
+				*p = PhaseState{Status: phaseGenerated, UpdatedAt: now}
+			}
+		}
+	}
+}
+
+// setPhase sets the status of the phase of the tables. The tables are instance.schema.table
+// or schema.table of the destination, no table means all.
+func (l *stateLedger) setPhase(tables []string, phase, status, detail string, now time.Time) (int, error) {
+	if (&TableState{}).phase(phase) == nil {
+		return 0, fmt.Errorf("unknown phase %s, expected one of %s", phase, strings.Join(phaseNames, ", "))
+	}
+	known := false
+	for _, name := range phaseStatuses {
+		known = known || name == status
+	}
+	if !known {
+		return 0, fmt.Errorf("unknown status %s, expected one of %s", status, strings.Join(phaseStatuses, ", "))
+	}
+
+	updated := 0
+	for destTable, state := range l.Tables {
+		if len(tables) > 0 && !matchStateTable(destTable, tables) {
+			continue
+		}
+		*state.phase(phase) = PhaseState{Status: status, Detail: detail, UpdatedAt: now}
+		updated++
+	}
+	if updated == 0 {
+		return 0, fmt.Errorf("no table in the state matches %s", strings.Join(tables, ", "))
+	}
+	return updated, nil
+}
+
+// matchStateTable reports whether the instance.schema.table key is one of the tables
+func matchStateTable(destTable string, tables []string) bool {
+	for _, table := range tables {
+		if destTable == table || schemaTableOf(destTable) == table {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaTableOf drops the instance of instance.schema.table
+func schemaTableOf(destTable string) string {
+	parts := strings.SplitN(destTable, ".", 2)
+	return parts[len(parts)-1]
+}
+
+// recordSyncDiff records the result of the sync-diff summary. The summary names the tables
+// by schema.table of the destination.
+func (l *stateLedger) recordSyncDiff(output *syncdiff.Output, now time.Time) {
+	results := map[string]syncdiff.TableResult{}
+	for _, result := range output.EquivalentTables {
+		results[result.FullName] = result
+	}
+	for _, result := range output.InconsistentTables {
+		results[result.FullName] = result
+	}
+	for destTable, state := range l.Tables {
+		result, ok := results[schemaTableOf(destTable)]
+		if !ok {
+			continue
+		}
+		if result.IsEquivalent {
+			state.SyncDiff = PhaseState{Status: phaseDone, Detail: "equivalent", UpdatedAt: now}
+			continue
+		}
+		detail := fmt.Sprintf("%s, structure equal: %v, data diff rows: %s", result.Result, result.IsStructureEqual, result.DataDiffRows)
+		state.SyncDiff = PhaseState{Status: phaseFailed, Detail: detail, UpdatedAt: now}
+	}
+}
+
+// print shows the status of every destination table and the count of each status per phase
+func (l *stateLedger) print(out io.Writer) {
+	destTables := make([]string, 0, len(l.Tables))
+	for destTable := range l.Tables {
+		destTables = append(destTables, destTable)
+	}
+	sort.Strings(destTables)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEST TABLE\tSOURCES\tMAPPING\tDUMP\tIMPORT\tDM TASK\tSYNC-DIFF\tUPDATED")
+	counts := map[string]map[string]int{}
+	for _, destTable := range destTables {
+		state := l.Tables[destTable]
+		updated := state.MappingUpdatedAt
+		statuses := []string{}
+		for _, name := range phaseNames {
+			phase := state.phase(name)
+			statuses = append(statuses, phase.Status)
+			if counts[name] == nil {
+				counts[name] = map[string]int{}
+			}
+			counts[name][phase.Status]++
+			if phase.UpdatedAt.After(updated) {
+				updated = phase.UpdatedAt
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", destTable, state.SourceTables, state.MappingVersion,
+			strings.Join(statuses, "\t"), updated.Local().Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d destination table(s)\n", len(destTables))
+	for _, name := range phaseNames {
+		parts := []string{}
+		for _, status := range phaseStatuses {
+			if counts[name][status] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", counts[name][status], status))
+			}
+		}
+		if len(parts) > 0 {
+			fmt.Fprintf(out, "%-10s %s\n", name+":", strings.Join(parts, ", "))
+		}
+	}
+}
+
+// updateState applies the change to the ledger of the output directory and saves it.
+// Nothing is saved in plan-only mode. The ledger is auxiliary, a failure is only logged.
+func updateState(outputDir string, change func(*stateLedger, time.Time)) {
+	if artifacts.PlanOnly() {
+		return
+	}
+	ledger, err := loadState(statePath(outputDir))
+	if err != nil {
+		slog.Warn("failed to load migration state", "error", err)
+		return
+	}
+	now := time.Now()
+	change(ledger, now)
+	if err := ledger.save(now); err != nil {
+		slog.Warn("failed to save migration state", "error", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luyomo/cheatsheet/table_merge/pkg/mapping"
+	"github.com/luyomo/cheatsheet/table_merge/pkg/syncdiff"
+)
+
+func testStateMapping() []mapping.TableInfo {
+	return []mapping.TableInfo{
+		{MD5ColumnsWithTypes: "types01", SrcTableInfo: []string{"instance01.db_00.orders", "instance02.db_01.orders"}, DestTableInfo: []string{"dest.messagedb.orders"}},
+		{MD5ColumnsWithTypes: "types02", SrcTableInfo: []string{"instance01.db_00.logs"}, DestTableInfo: []string{"dest.messagedb.logs"}, StructureOnly: true},
+	}
+}
+
+func Test_stateLedgerLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), stateFileName)
+	ledger, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState() of missing file error = %v", err)
+	}
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	ledger.recordMapping(testStateMapping(), now)
+	ledger.markGenerated(testStateMapping(), phaseDump, now)
+	if got := ledger.Tables["dest.messagedb.orders"].Dump.Status; got != phaseGenerated {
+		t.Errorf("dump status = %s, want %s", got, phaseGenerated)
+	}
+	if got := ledger.Tables["dest.messagedb.logs"].Dump.Status; got != phaseSkipped {
+		t.Errorf("dump status of structure only table = %s, want %s", got, phaseSkipped)
+	}
+
+	if _, err := ledger.setPhase([]string{"messagedb.orders"}, phaseDump, phaseDone, "", now); err != nil {
+		t.Fatalf("setPhase() error = %v", err)
+	}
+	// The generators do not move a phase back
+	ledger.markGenerated(testStateMapping(), phaseDump, now)
+	if got := ledger.Tables["dest.messagedb.orders"].Dump.Status; got != phaseDone {
+		t.Errorf("dump status after regenerating = %s, want %s", got, phaseDone)
+	}
+
+	if err := ledger.save(now); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	reloaded, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if got := reloaded.Tables["dest.messagedb.orders"]; got == nil || got.Dump.Status != phaseDone || got.SourceTables != 2 {
+		t.Errorf("reloaded state = %+v", got)
+	}
+
+	// A changed mapping resets the table
+	changed := testStateMapping()
+	changed[0].SrcTableInfo = append(changed[0].SrcTableInfo, "instance03.db_02.orders")
+	reloaded.recordMapping(changed, now)
+	if got := reloaded.Tables["dest.messagedb.orders"]; got.Dump.Status != phasePending || got.SourceTables != 3 {
+		t.Errorf("state after mapping change = %+v", got)
+	}
+}
+
+func Test_stateLedgerSetPhaseErrors(t *testing.T) {
+	ledger := &stateLedger{Tables: map[string]*TableState{}}
+	ledger.recordMapping(testStateMapping(), time.Now())
+	if _, err := ledger.setPhase(nil, "export", phaseDone, "", time.Now()); err == nil {
+		t.Errorf("setPhase() with unknown phase want error")
+	}
+	if _, err := ledger.setPhase(nil, phaseImport, "finished", "", time.Now()); err == nil {
+		t.Errorf("setPhase() with unknown status want error")
+	}
+	if _, err := ledger.setPhase([]string{"other.table"}, phaseImport, phaseDone, "", time.Now()); err == nil {
+		t.Errorf("setPhase() with unknown table want error")
+	}
+	if updated, err := ledger.setPhase(nil, phaseDMTask, phaseRunning, "", time.Now()); err != nil || updated != 2 {
+		t.Errorf("setPhase() of all tables = %d, %v, want 2", updated, err)
+	}
+}
+
+func Test_stateLedgerSyncDiff(t *testing.T) {
+	ledger := &stateLedger{Tables: map[string]*TableState{}}
+	ledger.recordMapping(testStateMapping(), time.Now())
+	ledger.recordSyncDiff(&syncdiff.Output{
+		InconsistentTables: []syncdiff.TableResult{{FullName: "messagedb.orders", Result: "fail", DataDiffRows: "+3/-1"}},
+	}, time.Now())
+	got := ledger.Tables["dest.messagedb.orders"].SyncDiff
+	if got.Status != phaseFailed || !strings.Contains(got.Detail, "+3/-1") {
+		t.Errorf("sync-diff state = %+v", got)
+	}
+
+	ledger.recordSyncDiff(&syncdiff.Output{
+		EquivalentTables: []syncdiff.TableResult{{FullName: "messagedb.orders", IsEquivalent: true}},
+	}, time.Now())
+	if got := ledger.Tables["dest.messagedb.orders"].SyncDiff.Status; got != phaseDone {
+		t.Errorf("sync-diff status = %s, want %s", got, phaseDone)
+	}
+
+	var out bytes.Buffer
+	ledger.print(&out)
+	for _, want := range []string{"dest.messagedb.orders", "2 destination table(s)", "sync-diff: 1 done, 1 skipped"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("print() missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -1,291 +0,0 @@
-package main
-
-import (
-	"os"
-	"testing"
-
-	_ "github.com/go-sql-driver/mysql"
-)
-
-func TestRenderSyncDiffConfig(t *testing.T) {
-	// 在运行测试前，确保模板目录存在
-	tmpDir := t.TempDir()
-	templatesDir := tmpDir + "/templates"
-	os.MkdirAll(templatesDir, 0755)
-
-	// 创建必要的模板文件
-	diffTemplate := `check-thread-count = {{.CheckThreadCount}}
-export-fix-sql = {{.ExportFixSQL}}
-check-data-only = {{.CheckDataOnly}}
-check-struct-only = {{.CheckStructOnly}}
-skip-non-existing-table = {{.SkipNonExistingTable}}
-
-{{range $key, $value := .DataSources}}
-[[data-sources]]
-name = "{{$key}}"
-host = "{{$value.Host}}"
-port = {{$value.Port}}
-user = "{{$value.User}}"
-password = "{{$value.Password}}"
-{{if $value.RouteRules}}
-route-rules = [{{range $i, $rule := $value.RouteRules}}{{if $i}}, {{end}}"{{$rule}}"{{end}}]
-{{end}}
-{{end}}
-
-[task]
-output-dir = "{{.Task.OutputDir}}"
-source-instances = [{{range $i, $inst := .Task.SourceInstances}}{{if $i}}, {{end}}"{{$inst}}"{{end}}]
-target-instance = "{{.Task.TargetInstance}}"
-{{if .Task.TargetCheckTables}}
-target-check-tables = [{{range $i, $tbl := .Task.TargetCheckTables}}{{if $i}}, {{end}}"{{$tbl}}"{{end}}]
-{{end}}
-{{if .Task.TargetConfigs}}
-target-configs = [{{range $i, $cfg := .Task.TargetConfigs}}{{if $i}}, {{end}}"{{$cfg}}"{{end}}]
-{{end}}
-
-{{range $key, $value := .Routes}}
-[[routes]]
-name = "{{$key}}"
-schema-pattern = "{{$value.SchemaPattern}}"
-table-pattern = "{{$value.TablePattern}}"
-target-schema = "{{$value.TargetSchema}}"
-target-table = "{{$value.TargetTable}}"
-{{end}}
-
-{{range $key, $value := .TableConfigs}}
-[[table-configs]]
-name = "{{$key}}"
-target-tables = [{{range $i, $tbl := $value.TargetTables}}{{if $i}}, {{end}}"{{$tbl}}"{{end}}]
-{{if $value.IgnoreColumns}}
-ignore-columns = [{{range $i, $col := $value.IgnoreColumns}}{{if $i}}, {{end}}"{{$col}}"{{end}}]
-{{end}}
-{{if $value.Range}}
-range = "{{$value.Range}}"
-{{end}}
-{{end}}`
-
-	// 写入模板文件
-	diffTemplatePath := templatesDir + "/diff.tpl.toml"
-	if err := os.WriteFile(diffTemplatePath, []byte(diffTemplate), 0644); err != nil {
-		t.Fatalf("Failed to create template file: %v", err)
-	}
-
-	// 由于template.go使用embed.FS，我们需要修改它来使用我们创建的模板文件
-	// 但是，我们不能直接修改template.go，所以我们需要一个不同的方法
-	// 实际上，测试应该能够运行，因为embed.FS在编译时嵌入文件
-	// 但在测试中，我们需要确保这些文件存在
-	// 由于我们不能修改embed指令，我们可能需要修改RenderSyncDiffConfig函数
-	// 但这不是测试的一部分
-
-	// 相反，我们可以创建一个测试专用的函数，但这不是最佳实践
-	// 让我们先运行测试，看看会发生什么
-
-	type args struct {
-		config       *Config
-		tableMapping *[]TableInfo
-	}
-	tests := []struct {
-		name        string
-		args        args
-		wantErr     bool
-		checkOutput bool
-	}{
-		{
-			name: "Normal: 一个源端库，一个目标端库，包含基础路由规则",
-			args: args{
-				config: &Config{
-					SourceDB: []DBConnInfo{
-						{
-							Name:     "source1",
-							Host:     "localhost",
-							Port:     3306,
-							User:     "root",
-							Password: "password",
-							DBs:      []string{"db1"},
-						},
-					},
-					DestDB: DBConnInfo{
-						Name:     "dest1",
-						Host:     "localhost",
-						Port:     3307,
-						User:     "root",
-						Password: "password",
-						DBs:      []string{"db2"},
-					},
-					Output: t.TempDir(),
-				},
-				tableMapping: &[]TableInfo{
-					{
-						MD5Columns:          "md5_1",
-						MD5ColumnsWithTypes: "md5_with_types_1",
-						SrcTableInfo:        []string{"source1.schema1.table1"},
-						DestTableInfo:       []string{"dest1.schema2.table2"},
-						DestHasSource:       false,
-						DestHasSchema:       false,
-						DestHasTableName:    false,
-						MaxID:               0,
-					},
-				},
-			},
-			wantErr:     false,
-			checkOutput: true,
-		},
-		{
-			name: "ExcludeColumns: 设置 DestHasSource: true，验证是否正确生成了 IgnoreColumns 配置",
-			args: args{
-				config: &Config{
-					SourceDB: []DBConnInfo{
-						{
-							Name:     "source1",
-							Host:     "localhost",
-							Port:     3306,
-							User:     "root",
-							Password: "password",
-							DBs:      []string{"db1"},
-						},
-					},
-					DestDB: DBConnInfo{
-						Name:     "dest1",
-						Host:     "localhost",
-						Port:     3307,
-						User:     "root",
-						Password: "password",
-						DBs:      []string{"db2"},
-					},
-					Output: t.TempDir(),
-				},
-				tableMapping: &[]TableInfo{
-					{
-						MD5Columns:          "md5_2",
-						MD5ColumnsWithTypes: "md5_with_types_2",
-						SrcTableInfo:        []string{"source1.schema1.table1"},
-						DestTableInfo:       []string{"dest1.schema2.table2"},
-						DestHasSource:       true,
-						DestHasSchema:       true,
-						DestHasTableName:    false,
-						MaxID:               0,
-					},
-				},
-			},
-			wantErr:     false,
-			checkOutput: true,
-		},
-		{
-			name: "RangeConfig: 设置 MaxID: 1000，验证是否生成了 Range 查询条件",
-			args: args{
-				config: &Config{
-					SourceDB: []DBConnInfo{
-						{
-							Name:     "source1",
-							Host:     "localhost",
-							Port:     3306,
-							User:     "root",
-							Password: "password",
-							DBs:      []string{"db1"},
-						},
-					},
-					DestDB: DBConnInfo{
-						Name:     "dest1",
-						Host:     "localhost",
-						Port:     3307,
-						User:     "root",
-						Password: "password",
-						DBs:      []string{"db2"},
-					},
-					Output: t.TempDir(),
-				},
-				tableMapping: &[]TableInfo{
-					{
-						MD5Columns:          "md5_3",
-						MD5ColumnsWithTypes: "md5_with_types_3",
-						SrcTableInfo:        []string{"source1.schema1.table1"},
-						DestTableInfo:       []string{"dest1.schema2.table2"},
-						DestHasSource:       false,
-						DestHasSchema:       false,
-						DestHasTableName:    false,
-						MaxID:               1000,
-					},
-				},
-			},
-			wantErr:     false,
-			checkOutput: true,
-		},
-		{
-			name: "NilConfig: 传入 config = nil，验证是否返回错误",
-			args: args{
-				config:       nil,
-				tableMapping: &[]TableInfo{},
-			},
-			wantErr:     true,
-			checkOutput: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// 如果config不为nil，确保Output目录存在
-			if tt.args.config != nil {
-				// 确保目录存在
-				os.MkdirAll(tt.args.config.Output, 0755)
-			}
-
-			// 由于embed.FS可能无法在测试中找到模板文件，我们可能需要处理错误
-			// 运行函数并检查错误
-			err := RenderSyncDiffConfig(tt.args.config, tt.args.tableMapping)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("RenderSyncDiffConfig() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			// 如果没有错误且需要检查输出，验证文件是否生成
-			if err == nil && tt.checkOutput && tt.args.config != nil {
-				outputFile := tt.args.config.Output + "/sync-diff.toml"
-				if _, err := os.Stat(outputFile); os.IsNotExist(err) {
-					// 如果文件不存在，可能是因为模板文件缺失
-					// 在这种情况下，我们期望函数返回错误，但如果没有，我们需要记录
-					t.Logf("RenderSyncDiffConfig() output file %s doesn't exist, but no error was returned", outputFile)
-				}
-			}
-		})
-	}
-}
-
-func TestRenderDMSourceConfig(t *testing.T) {
-	type args struct {
-		config *Config
-	}
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := RenderDMSourceConfig(tt.args.config); (err != nil) != tt.wantErr {
-				t.Errorf("RenderDMSourceConfig() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func TestRenderDMTaskConfig(t *testing.T) {
-	type args struct {
-		config       *Config
-		tableMapping *[]TableInfo
-	}
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := RenderDMTaskConfig(tt.args.config, tt.args.tableMapping); (err != nil) != tt.wantErr {
-				t.Errorf("RenderDMTaskConfig() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}